@@ -0,0 +1,233 @@
+package aggregation
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+)
+
+var ErrWalletFrozen = errors.New("wallet is frozen")
+var ErrWalletSuspended = errors.New("wallet is suspended")
+var ErrWalletClosed = errors.New("wallet is closed")
+var ErrAccountClosed = errors.New("account is closed")
+var ErrInvalidWalletStatus = errors.New("invalid wallet status")
+var ErrInvalidReasonCode = errors.New("invalid reason code")
+var ErrBalanceNotZero = errors.New("account still holds money")
+
+// Account changes the status of wallets and users for compliance, every
+// change is written to the audit log.
+type Account struct {
+	walletRepo    *repository.Wallet
+	userRepo      *repository.User
+	userTokenRepo *repository.UserToken
+	auditLogRepo  *repository.AuditLog
+	db            *db.Instance
+}
+
+func NewAccount(
+	walletRepo *repository.Wallet,
+	userRepo *repository.User,
+	userTokenRepo *repository.UserToken,
+	db *db.Instance,
+) *Account {
+	return &Account{
+		walletRepo:    walletRepo,
+		userRepo:      userRepo,
+		userTokenRepo: userTokenRepo,
+		auditLogRepo:  repository.NewAuditLog(db),
+		db:            db,
+	}
+}
+
+// SetWalletStatus freezes, suspends or reactivates a wallet. Closed wallets
+// stay closed.
+func (a *Account) SetWalletStatus(actorID, walletID string, status entity.WalletStatus, reasonCode, note string) (entity.Wallet, error) {
+	switch status {
+	case entity.WalletStatusActive, entity.WalletStatusFrozen, entity.WalletStatusSuspended:
+	default:
+		return entity.Wallet{}, ErrInvalidWalletStatus
+	}
+	if !entity.ValidReasonCode(reasonCode) {
+		return entity.Wallet{}, ErrInvalidReasonCode
+	}
+
+	var wallet entity.Wallet
+	err := a.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		wallet, err = a.walletRepo.FindById(walletID, trx)
+		if err == db.ErrNotFound {
+			return ErrWalletNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if wallet.Status == entity.WalletStatusClosed {
+			return ErrWalletClosed
+		}
+
+		from := walletStatus(wallet)
+		if from == status {
+			return nil
+		}
+
+		wallet.Status = status
+		if err := a.walletRepo.Put(wallet, trx); err != nil {
+			return err
+		}
+
+		return a.audit(trx, actorID, entity.AuditSubjectWallet, wallet.ID, string(from), string(status), reasonCode, note)
+	})
+	if err != nil {
+		return entity.Wallet{}, err
+	}
+
+	return wallet, nil
+}
+
+// Close closes the account of a user whose wallets are all empty. The
+// wallets are closed with it, the user's tokens are revoked and they can't
+// sign in anymore.
+func (a *Account) Close(actorID, userID, reasonCode, note string) (entity.User, error) {
+	if !entity.ValidReasonCode(reasonCode) {
+		return entity.User{}, ErrInvalidReasonCode
+	}
+
+	var user entity.User
+	err := a.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		user, err = a.userRepo.FindById(userID, trx)
+		if err == db.ErrNotFound {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if user.IsClosed() {
+			return ErrAccountClosed
+		}
+
+		wallets, err := a.walletRepo.GetByUserID(user.ID, trx)
+		if err != nil && err != db.ErrNotFound {
+			return err
+		}
+
+		for _, wallet := range wallets {
+			if wallet.Balance != 0 || wallet.Held != 0 {
+				return ErrBalanceNotZero
+			}
+		}
+
+		for _, wallet := range wallets {
+			from := walletStatus(wallet)
+			wallet.Status = entity.WalletStatusClosed
+			if err := a.walletRepo.Put(wallet, trx); err != nil {
+				return err
+			}
+
+			if err := a.audit(trx, actorID, entity.AuditSubjectWallet, wallet.ID, string(from), string(wallet.Status), reasonCode, note); err != nil {
+				return err
+			}
+		}
+
+		if err := a.userTokenRepo.DeleteByUserID(user.ID, trx); err != nil {
+			return err
+		}
+
+		user.Status = entity.UserStatusClosed
+		user.ClosedAt = a.db.Now()
+		if err := a.userRepo.Put(user, trx); err != nil {
+			return err
+		}
+
+		return a.audit(trx, actorID, entity.AuditSubjectUser, user.ID, string(entity.UserStatusActive), string(user.Status), reasonCode, note)
+	})
+	if err != nil {
+		return entity.User{}, err
+	}
+
+	return user, nil
+}
+
+// Promote gives a user the admin role, it is how the first admins are
+// bootstrapped since nothing else sets the role.
+func (a *Account) Promote(actorID, userID, note string) (entity.User, error) {
+	var user entity.User
+	err := a.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		user, err = a.userRepo.FindById(userID, trx)
+		if err == db.ErrNotFound {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if user.IsAdmin() {
+			return nil
+		}
+
+		from := user.Role
+		user.Role = entity.UserRoleAdmin
+		if err := a.userRepo.Put(user, trx); err != nil {
+			return err
+		}
+
+		return a.audit(trx, actorID, entity.AuditSubjectUser, user.ID, from, user.Role, "", note)
+	})
+	if err != nil {
+		return entity.User{}, err
+	}
+
+	return user, nil
+}
+
+func (a *Account) audit(trx *db.Transaction, actorID, subjectType, subjectID, from, to, reasonCode, note string) error {
+	return a.auditLogRepo.Put(entity.AuditLog{
+		ID:          uuid.New().String(),
+		ActorID:     actorID,
+		SubjectType: subjectType,
+		SubjectID:   subjectID,
+		From:        from,
+		To:          to,
+		ReasonCode:  reasonCode,
+		Note:        note,
+		CreatedAt:   a.db.Now(),
+	}, trx)
+}
+
+func walletStatus(wallet entity.Wallet) entity.WalletStatus {
+	if wallet.Status == "" {
+		return entity.WalletStatusActive
+	}
+	return wallet.Status
+}
+
+// canSend and canReceive tell why money can't leave or land in a wallet.
+func canSend(wallet entity.Wallet) error {
+	if wallet.CanSend() {
+		return nil
+	}
+	return walletStatusError(wallet)
+}
+
+func canReceive(wallet entity.Wallet) error {
+	if wallet.CanReceive() {
+		return nil
+	}
+	return walletStatusError(wallet)
+}
+
+func walletStatusError(wallet entity.Wallet) error {
+	switch wallet.Status {
+	case entity.WalletStatusFrozen:
+		return ErrWalletFrozen
+	case entity.WalletStatusClosed:
+		return ErrWalletClosed
+	}
+	return ErrWalletSuspended
+}
@@ -0,0 +1,189 @@
+package aggregation_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalletStatus(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	auditLogRepo := repository.NewAuditLog(dbInstance)
+
+	sourceUserID := uuid.New().String()
+	targetUserID := uuid.New().String()
+	sourceWalletID := uuid.New().String()
+	userRepo.Put(entity.User{ID: sourceUserID, Email: "source@example.com"})
+	userRepo.Put(entity.User{ID: targetUserID, Email: "target@example.com"})
+	walletRepo.Put(entity.Wallet{ID: sourceWalletID, UserID: sourceUserID, Balance: 1000})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: targetUserID, Balance: 1000})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+	account := aggregation.NewAccount(walletRepo, userRepo, repository.NewUserToken(dbInstance), dbInstance)
+
+	paid, err := transaction.Transfer(targetUserID, sourceUserID, 100)
+	assert.NoError(t, err)
+
+	_, err = account.SetWalletStatus("admin", sourceWalletID, entity.WalletStatusFrozen, "not a reason", "")
+	assert.ErrorIs(t, err, aggregation.ErrInvalidReasonCode)
+	_, err = account.SetWalletStatus("admin", sourceWalletID, entity.WalletStatusClosed, entity.ReasonFraudSuspected, "")
+	assert.ErrorIs(t, err, aggregation.ErrInvalidWalletStatus)
+	_, err = account.SetWalletStatus("admin", uuid.New().String(), entity.WalletStatusFrozen, entity.ReasonFraudSuspected, "")
+	assert.ErrorIs(t, err, aggregation.ErrWalletNotFound)
+
+	t.Run("frozen", func(t *testing.T) {
+		wallet, err := account.SetWalletStatus("admin", sourceWalletID, entity.WalletStatusFrozen, entity.ReasonFraudSuspected, "chargebacks")
+		assert.NoError(t, err)
+		assert.Equal(t, entity.WalletStatusFrozen, wallet.Status)
+
+		_, err = transaction.Transfer(sourceUserID, targetUserID, 10)
+		assert.ErrorIs(t, err, aggregation.ErrWalletFrozen)
+		_, err = transaction.Hold(sourceUserID, 10, time.Hour, aggregation.WithHoldTarget(targetUserID))
+		assert.ErrorIs(t, err, aggregation.ErrWalletFrozen)
+		_, err = transaction.Refund(paid.ID, 10)
+		assert.ErrorIs(t, err, aggregation.ErrWalletFrozen)
+
+		// money still comes in
+		_, err = transaction.Transfer(targetUserID, sourceUserID, 10)
+		assert.NoError(t, err)
+		_, err = transaction.TopUp(sourceUserID, 10)
+		assert.NoError(t, err)
+
+		// admins reverse anyway
+		_, err = transaction.Reverse(paid.ID, "chargeback")
+		assert.NoError(t, err)
+	})
+
+	t.Run("suspended", func(t *testing.T) {
+		_, err := account.SetWalletStatus("admin", sourceWalletID, entity.WalletStatusSuspended, entity.ReasonLegalOrder, "")
+		assert.NoError(t, err)
+
+		_, err = transaction.Transfer(sourceUserID, targetUserID, 10)
+		assert.ErrorIs(t, err, aggregation.ErrWalletSuspended)
+		_, err = transaction.Transfer(targetUserID, sourceUserID, 10)
+		assert.ErrorIs(t, err, aggregation.ErrWalletSuspended)
+		_, err = transaction.TopUp(sourceUserID, 10)
+		assert.ErrorIs(t, err, aggregation.ErrWalletSuspended)
+	})
+
+	t.Run("reactivated", func(t *testing.T) {
+		_, err := account.SetWalletStatus("admin", sourceWalletID, entity.WalletStatusActive, entity.ReasonResolved, "")
+		assert.NoError(t, err)
+
+		_, err = transaction.Transfer(sourceUserID, targetUserID, 10)
+		assert.NoError(t, err)
+	})
+
+	logs, err := auditLogRepo.GetBySubjectID(sourceWalletID)
+	assert.NoError(t, err)
+	assert.Len(t, logs, 3)
+
+	transitions := [][2]string{}
+	for _, log := range logs {
+		assert.Equal(t, "admin", log.ActorID)
+		assert.Equal(t, entity.AuditSubjectWallet, log.SubjectType)
+		transitions = append(transitions, [2]string{log.From, log.To})
+	}
+	assert.ElementsMatch(t, [][2]string{
+		{"active", "frozen"},
+		{"frozen", "suspended"},
+		{"suspended", "active"},
+	}, transitions)
+}
+
+func TestCloseAccount(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	userTokenRepo := repository.NewUserToken(dbInstance)
+
+	authorization := aggregation.NewAuthorization(walletRepo, userRepo, userTokenRepo, dbInstance)
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+	account := aggregation.NewAccount(walletRepo, userRepo, userTokenRepo, dbInstance)
+
+	assert.NoError(t, authorization.Register("closing@example.com", "secret"))
+	assert.NoError(t, authorization.Register("other@example.com", "secret"))
+	user, _ := userRepo.FindByEmail("closing@example.com")
+	other, _ := userRepo.FindByEmail("other@example.com")
+
+	token, err := authorization.SignIn("closing@example.com", "secret")
+	assert.NoError(t, err)
+
+	_, err = transaction.TopUp(user.ID, 100)
+	assert.NoError(t, err)
+
+	_, err = account.Close("admin", user.ID, entity.ReasonCustomerRequest, "")
+	assert.ErrorIs(t, err, aggregation.ErrBalanceNotZero)
+
+	_, err = transaction.Transfer(user.ID, other.ID, 100)
+	assert.NoError(t, err)
+
+	closed, err := account.Close("admin", user.ID, entity.ReasonCustomerRequest, "moving abroad")
+	assert.NoError(t, err)
+	assert.True(t, closed.IsClosed())
+
+	_, err = account.Close("admin", user.ID, entity.ReasonCustomerRequest, "")
+	assert.ErrorIs(t, err, aggregation.ErrAccountClosed)
+
+	_, err = userTokenRepo.FindByToken(token)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+
+	_, err = authorization.SignIn("closing@example.com", "secret")
+	assert.ErrorIs(t, err, aggregation.ErrAccountClosed)
+
+	_, err = transaction.Transfer(other.ID, user.ID, 10)
+	assert.ErrorIs(t, err, aggregation.ErrWalletClosed)
+	_, err = transaction.CreatePocket(user.ID, "savings", "IDR")
+	assert.ErrorIs(t, err, aggregation.ErrAccountClosed)
+
+	wallet, _ := walletRepo.FindByUserID(user.ID)
+	_, err = account.SetWalletStatus("admin", wallet.ID, entity.WalletStatusActive, entity.ReasonResolved, "")
+	assert.ErrorIs(t, err, aggregation.ErrWalletClosed)
+
+	logs, err := repository.NewAuditLog(dbInstance).GetBySubjectID(user.ID)
+	assert.NoError(t, err)
+	assert.Len(t, logs, 1)
+	assert.Equal(t, "moving abroad", logs[0].Note)
+}
+
+func TestPromote(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	account := aggregation.NewAccount(walletRepo, userRepo, repository.NewUserToken(dbInstance), dbInstance)
+
+	userID := uuid.New().String()
+	userRepo.Put(entity.User{ID: userID, Email: "admin@example.com"})
+
+	_, err := account.Promote("system", uuid.New().String(), "")
+	assert.ErrorIs(t, err, aggregation.ErrUserNotFound)
+
+	user, err := account.Promote("system", userID, "ADMIN_USER_IDS")
+	assert.NoError(t, err)
+	assert.True(t, user.IsAdmin())
+
+	// promoting an admin again changes nothing
+	_, err = account.Promote("system", userID, "ADMIN_USER_IDS")
+	assert.NoError(t, err)
+
+	stored, _ := userRepo.FindById(userID)
+	assert.True(t, stored.IsAdmin())
+
+	logs, err := repository.NewAuditLog(dbInstance).GetBySubjectID(userID)
+	assert.NoError(t, err)
+	assert.Len(t, logs, 1)
+	assert.Equal(t, entity.UserRoleAdmin, logs[0].To)
+}
@@ -0,0 +1,60 @@
+package aggregation
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+// MaxAmount is the largest amount a single top up or transfer can move.
+var MaxAmount = 1_000_000_000
+
+var ErrAmountNotPositive = errors.New("must be greater than zero")
+var ErrAmountOverLimit = errors.New("is over the limit")
+var ErrBalanceOverflow = errors.New("would overflow the balance")
+
+// AmountError is an amount the request can't move, Field is the request
+// field it came from.
+type AmountError struct {
+	Field  string
+	Amount int
+	Reason error
+}
+
+func (e *AmountError) Error() string {
+	return fmt.Sprintf("%s %v", e.Field, e.Reason)
+}
+
+func (e *AmountError) Unwrap() error {
+	return e.Reason
+}
+
+func validateAmount(amount int) error {
+	if amount <= 0 {
+		return &AmountError{Field: "amount", Amount: amount, Reason: ErrAmountNotPositive}
+	}
+	if amount > MaxAmount {
+		return &AmountError{Field: "amount", Amount: amount, Reason: ErrAmountOverLimit}
+	}
+	return nil
+}
+
+// credit adds amount to a balance or a held amount, debit takes it out.
+// Every change to either goes through them, they use entity.Money so they
+// never wrap around.
+func credit(balance, amount int) (int, error) {
+	sum, err := entity.NewMoney(int64(balance), "").Add(entity.NewMoney(int64(amount), ""))
+	if err != nil {
+		return 0, &AmountError{Field: "amount", Amount: amount, Reason: ErrBalanceOverflow}
+	}
+	return int(sum.Amount), nil
+}
+
+func debit(balance, amount int) (int, error) {
+	diff, err := entity.NewMoney(int64(balance), "").Sub(entity.NewMoney(int64(amount), ""))
+	if err != nil {
+		return 0, &AmountError{Field: "amount", Amount: amount, Reason: ErrBalanceOverflow}
+	}
+	return int(diff.Amount), nil
+}
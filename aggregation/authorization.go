@@ -38,26 +38,48 @@ func NewAuthorization(
 }
 
 func (a *Authorization) Register(email, password string) error {
+	return a.register(entity.User{Email: email}, password)
+}
+
+// RegisterMerchant signs up a merchant account, it takes payments through
+// payment intents and is told about them at the callback URL, which has to
+// pass ValidateCallbackURL.
+func (a *Authorization) RegisterMerchant(email, password, name, callbackURL string) error {
+	if callbackURL != "" {
+		if err := ValidateCallbackURL(callbackURL); err != nil {
+			return err
+		}
+	}
+
+	return a.register(entity.User{
+		Email:        email,
+		Type:         entity.UserTypeMerchant,
+		MerchantName: name,
+		CallbackURL:  callbackURL,
+	}, password)
+}
+
+func (a *Authorization) register(user entity.User, password string) error {
 	return a.db.Transaction(func(t *db.Transaction) error {
-		_, err := a.userRepo.FindByEmail(email, t)
+		_, err := a.userRepo.FindByEmail(user.Email, t)
 		if err != db.ErrNotFound {
 			return ErrUserAlreadyExists
 		}
 
-		userID := uuid.New().String()
-		err = a.userRepo.Put(entity.User{
-			ID:       userID,
-			Password: aurelia.Hash(password, encryptionKey),
-			Email:    email,
-		}, t)
+		user.ID = uuid.New().String()
+		user.Password = aurelia.Hash(password, encryptionKey)
+		err = a.userRepo.Put(user, t)
 		if err != nil {
 			return err
 		}
 
 		err = a.walletRepo.Put(entity.Wallet{
-			ID:      uuid.New().String(),
-			UserID:  userID,
-			Balance: 0,
+			ID:       uuid.New().String(),
+			UserID:   user.ID,
+			Name:     entity.PrimaryWalletName,
+			Balance:  0,
+			Currency: entity.DefaultCurrency,
+			Status:   entity.WalletStatusActive,
 		}, t)
 		if err != nil {
 			return err
@@ -77,6 +99,10 @@ func (a *Authorization) SignIn(email, password string) (string, error) {
 		return "", ErrAuthFailed
 	}
 
+	if existingUser.IsClosed() {
+		return "", ErrAccountClosed
+	}
+
 	token := aurelia.Hash(uuid.New().String(), encryptionKey)
 	if err := a.userTokenRepo.Put(entity.UserToken{
 		UserID: existingUser.ID,
@@ -86,3 +112,25 @@ func (a *Authorization) SignIn(email, password string) (string, error) {
 	}
 	return token, nil
 }
+
+// ChangePassword replaces the password after checking the current one, the
+// change time feeds PasswordChangeRule.
+func (a *Authorization) ChangePassword(userID, currentPassword, newPassword string) error {
+	return a.db.Transaction(func(t *db.Transaction) error {
+		user, err := a.userRepo.FindById(userID, t)
+		if err == db.ErrNotFound {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if !aurelia.Authenticate(currentPassword, encryptionKey, user.Password) {
+			return ErrAuthFailed
+		}
+
+		user.Password = aurelia.Hash(newPassword, encryptionKey)
+		user.PasswordChangedAt = a.db.Now()
+		return a.userRepo.Put(user, t)
+	})
+}
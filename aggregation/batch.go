@@ -0,0 +1,257 @@
+package aggregation
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+// MaxBatchItems caps a batch, it runs as a single operation on the event
+// loop and holds it up for every other request meanwhile.
+var MaxBatchItems = 1000
+
+var ErrBatchNotFound = errors.New("batch not found")
+var ErrEmptyBatch = errors.New("batch has no items")
+var ErrBatchTooLarge = errors.New("batch has too many items")
+var ErrInvalidBatchMode = errors.New("batch mode is atomic or best_effort")
+
+// BatchItemError tells which item of a batch failed. Field is set when the
+// item is invalid, it is empty when its transfer failed.
+type BatchItemError struct {
+	Index int
+	Field string
+	Err   error
+}
+
+func (e *BatchItemError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchItemError) Unwrap() error {
+	return e.Err
+}
+
+// BatchTransfer pays every item out of one wallet of the user, picked like
+// the source wallet of a transfer. Every item is validated before any money
+// moves. An atomic batch pays every item in one transaction or none of them
+// and fails with the first item that failed, the risk rules holding an item
+// block it. A best effort batch pays each item on its own, keeps the
+// result of every item and queues held items for review, a held item is
+// completed or failed once its review is decided. Batches run on the
+// background lane. With an idempotency key a retry returns the stored
+// batch.
+func (t Transaction) BatchTransfer(userID string, mode entity.BatchMode, items []entity.BatchItem, opts ...TransactionOption) (entity.Batch, error) {
+	o := newTransactionOptions(opts)
+	if mode == "" {
+		mode = entity.BatchModeAtomic
+	}
+	if mode != entity.BatchModeAtomic && mode != entity.BatchModeBestEffort {
+		return entity.Batch{}, ErrInvalidBatchMode
+	}
+
+	fingerprint := requestFingerprint("batch", mode, entity.NormalizeCurrency(o.currency), o.sourceWalletID, items)
+
+	var batch entity.Batch
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		batch, err = idempotent(t, trx, userID, o, fingerprint, batchResult, func() (entity.Batch, error) {
+			return t.batchTransfer(trx, userID, mode, items, o)
+		})
+		return err
+	}, db.OnLane(db.LaneBackground))
+
+	// an atomic batch is rolled back, the decision on its item goes in on
+	// its own
+	var itemErr *BatchItemError
+	var riskErr *RiskError
+	if errors.As(err, &itemErr) && errors.As(err, &riskErr) {
+		item := items[itemErr.Index]
+		if err := t.db.Transaction(func(trx *db.Transaction) error {
+			return t.recordRisk(trx, riskErr, userID, item.RecipientID, item.Amount, o, "")
+		}); err != nil {
+			return entity.Batch{}, err
+		}
+	}
+	if err != nil {
+		return entity.Batch{}, err
+	}
+
+	return batch, nil
+}
+
+func (t Transaction) batchTransfer(trx *db.Transaction, userID string, mode entity.BatchMode, items []entity.BatchItem, o transactionOptions) (entity.Batch, error) {
+	wallet, err := t.sourceWallet(trx, userID, o)
+	if err != nil {
+		return entity.Batch{}, err
+	}
+
+	if err := t.validateBatch(trx, userID, items); err != nil {
+		return entity.Batch{}, err
+	}
+
+	batch := entity.Batch{
+		ID:             uuid.New().String(),
+		UserID:         userID,
+		Mode:           mode,
+		Currency:       entity.NormalizeCurrency(wallet.Currency),
+		SourceWalletID: o.sourceWalletID,
+		Items:          make([]entity.BatchItem, 0, len(items)),
+		CreatedAt:      t.db.Now(),
+	}
+
+	for i, item := range items {
+		itemOptions := o
+		itemOptions.idempotencyKey = ""
+		itemOptions.reference = item.Reference
+		itemOptions.note = item.Note
+		itemOptions.batchID = batch.ID
+		itemOptions.batchItem = i
+
+		item = entity.BatchItem{
+			RecipientID: item.RecipientID,
+			Amount:      item.Amount,
+			Reference:   item.Reference,
+			Note:        item.Note,
+		}
+
+		if mode == entity.BatchModeAtomic {
+			record, err := t.assessedTransfer(trx, userID, item.RecipientID, item.Amount, itemOptions)
+			if err != nil {
+				return entity.Batch{}, &BatchItemError{Index: i, Err: unreviewable(err)}
+			}
+			item.Status = entity.BatchItemStatusCompleted
+			item.TransactionID = record.ID
+		} else {
+			item, err = t.batchItem(trx, userID, item, itemOptions)
+			if err != nil {
+				return entity.Batch{}, err
+			}
+		}
+
+		batch.Total += item.Amount
+		if item.Status == entity.BatchItemStatusCompleted {
+			batch.Paid += item.Amount
+		}
+		batch.Items = append(batch.Items, item)
+	}
+
+	batch.Status = batchStatus(batch)
+	if err := t.batchRepo.Put(batch, trx); err != nil {
+		return entity.Batch{}, err
+	}
+
+	return batch, nil
+}
+
+// batchStatus settles a batch once every item is final, it stays pending
+// while an item waits for its review.
+func batchStatus(batch entity.Batch) entity.BatchStatus {
+	for _, item := range batch.Items {
+		if item.Status == entity.BatchItemStatusHeld {
+			return entity.BatchStatusPending
+		}
+	}
+
+	switch batch.Paid {
+	case batch.Total:
+		return entity.BatchStatusCompleted
+	case 0:
+		return entity.BatchStatusFailed
+	default:
+		return entity.BatchStatusPartial
+	}
+}
+
+// decideBatchItem applies the decided review of a held batch item, the item
+// is completed by the approved transfer or failed by the rejection.
+func (t Transaction) decideBatchItem(trx *db.Transaction, review entity.RiskReview, transactionID string) error {
+	batch, err := t.batchRepo.FindById(review.BatchID, trx)
+	if err != nil {
+		return err
+	}
+	if review.BatchItem >= len(batch.Items) || batch.Items[review.BatchItem].ReviewID != review.ID {
+		return ErrBatchNotFound
+	}
+
+	// the stored row shares its backing array until the commit
+	batch.Items = slices.Clone(batch.Items)
+	item := &batch.Items[review.BatchItem]
+	if transactionID != "" {
+		item.Status = entity.BatchItemStatusCompleted
+		item.TransactionID = transactionID
+		batch.Paid += item.Amount
+	} else {
+		item.Status = entity.BatchItemStatusFailed
+		item.Error = ErrTransferBlocked.Error()
+	}
+
+	batch.Status = batchStatus(batch)
+	return t.batchRepo.Put(batch, trx)
+}
+
+// batchItem pays one item of a best effort batch in a savepoint, a failed
+// item only rolls back itself. The error is only set when the batch can't
+// go on.
+func (t Transaction) batchItem(trx *db.Transaction, userID string, item entity.BatchItem, o transactionOptions) (entity.BatchItem, error) {
+	var record entity.Transaction
+	transferErr := trx.Savepoint(func(sp *db.Transaction) error {
+		var err error
+		record, err = t.assessedTransfer(sp, userID, item.RecipientID, item.Amount, o)
+		return err
+	})
+
+	var riskErr *RiskError
+	if errors.As(transferErr, &riskErr) {
+		if err := t.recordRisk(trx, riskErr, userID, item.RecipientID, item.Amount, o, ""); err != nil {
+			return entity.BatchItem{}, err
+		}
+	}
+
+	switch {
+	case transferErr == nil:
+		item.Status = entity.BatchItemStatusCompleted
+		item.TransactionID = record.ID
+	case riskErr != nil && riskErr.Action == entity.RiskActionReview:
+		item.Status = entity.BatchItemStatusHeld
+		item.ReviewID = riskErr.Review.ID
+	default:
+		item.Status = entity.BatchItemStatusFailed
+		item.Error = transferErr.Error()
+	}
+
+	return item, nil
+}
+
+// validateBatch checks every item before the first one is paid, so a typo
+// in the last item doesn't leave a best effort batch half paid.
+func (t Transaction) validateBatch(trx *db.Transaction, userID string, items []entity.BatchItem) error {
+	if len(items) == 0 {
+		return ErrEmptyBatch
+	}
+	if len(items) > MaxBatchItems {
+		return ErrBatchTooLarge
+	}
+
+	for i, item := range items {
+		if err := validateAmount(item.Amount); err != nil {
+			return &BatchItemError{Index: i, Field: "amount", Err: err}
+		}
+		if item.RecipientID == userID {
+			return &BatchItemError{Index: i, Field: "recipient", Err: ErrSameWallet}
+		}
+
+		_, err := t.userRepo.FindById(item.RecipientID, trx)
+		if err == db.ErrNotFound {
+			return &BatchItemError{Index: i, Field: "recipient", Err: ErrUserNotFound}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package aggregation_test
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchTransfer(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	reviewRepo := repository.NewRiskReview(dbInstance)
+	batchRepo := repository.NewBatch(dbInstance)
+
+	payerID := uuid.New().String()
+	userRepo.Put(entity.User{ID: payerID, Email: "payroll@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: payerID})
+
+	recipients := make([]string, 3)
+	for i := range recipients {
+		recipients[i] = uuid.New().String()
+		userRepo.Put(entity.User{ID: recipients[i]})
+		walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: recipients[i]})
+	}
+
+	// payouts from 50 up go to review
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance, aggregation.WithRisk(aggregation.RiskPolicy{
+		Rules:       []aggregation.RiskRule{aggregation.NewRecipientRule{Amount: 50, Points: 10}},
+		ReviewScore: 10,
+	}))
+
+	_, err := transaction.TopUp(payerID, 100)
+	assert.NoError(t, err)
+
+	balances := func() []int {
+		result := []int{}
+		for _, id := range append([]string{payerID}, recipients...) {
+			wallet, _ := walletRepo.FindByUserID(id)
+			result = append(result, wallet.Balance)
+		}
+		return result
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		_, err := transaction.BatchTransfer(payerID, entity.BatchModeAtomic, nil)
+		assert.ErrorIs(t, err, aggregation.ErrEmptyBatch)
+		_, err = transaction.BatchTransfer(payerID, "sometimes", []entity.BatchItem{{RecipientID: recipients[0], Amount: 10}})
+		assert.ErrorIs(t, err, aggregation.ErrInvalidBatchMode)
+
+		// the last item is checked before the first one is paid
+		_, err = transaction.BatchTransfer(payerID, entity.BatchModeBestEffort, []entity.BatchItem{
+			{RecipientID: recipients[0], Amount: 10},
+			{RecipientID: "nobody", Amount: 10},
+		})
+		var itemErr *aggregation.BatchItemError
+		assert.ErrorAs(t, err, &itemErr)
+		assert.Equal(t, 1, itemErr.Index)
+		assert.Equal(t, "recipient", itemErr.Field)
+		assert.ErrorIs(t, err, aggregation.ErrUserNotFound)
+
+		_, err = transaction.BatchTransfer(payerID, entity.BatchModeAtomic, []entity.BatchItem{{RecipientID: recipients[0], Amount: -1}})
+		assert.ErrorAs(t, err, &itemErr)
+		assert.Equal(t, "amount", itemErr.Field)
+
+		assert.Equal(t, []int{100, 0, 0, 0}, balances())
+	})
+
+	t.Run("Atomic", func(t *testing.T) {
+		_, err := transaction.BatchTransfer(payerID, entity.BatchModeAtomic, []entity.BatchItem{
+			{RecipientID: recipients[0], Amount: 10},
+			{RecipientID: recipients[1], Amount: 10},
+			{RecipientID: recipients[2], Amount: 90},
+		})
+		var itemErr *aggregation.BatchItemError
+		assert.ErrorAs(t, err, &itemErr)
+		assert.Equal(t, 2, itemErr.Index)
+		assert.ErrorIs(t, err, aggregation.ErrInsuficientFound)
+		assert.Equal(t, []int{100, 0, 0, 0}, balances())
+
+		batch, err := transaction.BatchTransfer(payerID, entity.BatchModeAtomic, []entity.BatchItem{
+			{RecipientID: recipients[0], Amount: 10, Reference: "march"},
+			{RecipientID: recipients[1], Amount: 20},
+		}, aggregation.WithIdempotencyKey("payroll-march"))
+		assert.NoError(t, err)
+		assert.Equal(t, entity.BatchStatusCompleted, batch.Status)
+		assert.Equal(t, 30, batch.Paid)
+		assert.Equal(t, []int{70, 10, 20, 0}, balances())
+
+		mutations, _ := mutationRepo.GetByUserID(recipients[0])
+		assert.Equal(t, batch.Items[0].TransactionID, mutations[0].TransactionID)
+
+		// a retry pays nobody twice
+		replayed, err := transaction.BatchTransfer(payerID, entity.BatchModeAtomic, []entity.BatchItem{
+			{RecipientID: recipients[0], Amount: 10, Reference: "march"},
+			{RecipientID: recipients[1], Amount: 20},
+		}, aggregation.WithIdempotencyKey("payroll-march"))
+		assert.NoError(t, err)
+		assert.Equal(t, batch.ID, replayed.ID)
+		assert.Equal(t, []int{70, 10, 20, 0}, balances())
+
+		_, err = transaction.BatchTransfer(payerID, entity.BatchModeAtomic, []entity.BatchItem{
+			{RecipientID: recipients[0], Amount: 15},
+		}, aggregation.WithIdempotencyKey("payroll-march"))
+		assert.ErrorIs(t, err, aggregation.ErrIdempotencyKeyReused)
+	})
+
+	t.Run("Best effort", func(t *testing.T) {
+		batch, err := transaction.BatchTransfer(payerID, entity.BatchModeBestEffort, []entity.BatchItem{
+			{RecipientID: recipients[0], Amount: 80},
+			{RecipientID: recipients[2], Amount: 50},
+			{RecipientID: recipients[1], Amount: 20},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, entity.BatchStatusPending, batch.Status)
+		assert.Equal(t, 150, batch.Total)
+		assert.Equal(t, 20, batch.Paid)
+
+		assert.Equal(t, entity.BatchItemStatusFailed, batch.Items[0].Status)
+		assert.Equal(t, aggregation.ErrInsuficientFound.Error(), batch.Items[0].Error)
+		assert.Equal(t, entity.BatchItemStatusHeld, batch.Items[1].Status)
+		assert.Equal(t, entity.BatchItemStatusCompleted, batch.Items[2].Status)
+		assert.Equal(t, []int{50, 10, 40, 0}, balances())
+
+		review, err := reviewRepo.FindById(batch.Items[1].ReviewID)
+		assert.NoError(t, err)
+		assert.Equal(t, recipients[2], review.TargetUserID)
+		assert.Equal(t, batch.ID, review.BatchID)
+		assert.Equal(t, 1, review.BatchItem)
+
+		rejected, err := transaction.BatchTransfer(payerID, entity.BatchModeBestEffort, []entity.BatchItem{
+			{RecipientID: recipients[2], Amount: 50},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, entity.BatchItemStatusHeld, rejected.Items[0].Status)
+		assert.Equal(t, entity.BatchStatusPending, rejected.Status)
+
+		_, err = transaction.RejectReview(rejected.Items[0].ReviewID, "admin", "")
+		assert.NoError(t, err)
+		rejected, _ = batchRepo.FindById(rejected.ID)
+		assert.Equal(t, entity.BatchItemStatusFailed, rejected.Items[0].Status)
+		assert.Equal(t, aggregation.ErrTransferBlocked.Error(), rejected.Items[0].Error)
+		assert.Equal(t, entity.BatchStatusFailed, rejected.Status)
+
+		record, err := transaction.ApproveReview(review.ID, "admin")
+		assert.NoError(t, err)
+		approved, _ := batchRepo.FindById(batch.ID)
+		assert.Equal(t, entity.BatchItemStatusCompleted, approved.Items[1].Status)
+		assert.Equal(t, record.ID, approved.Items[1].TransactionID)
+		assert.Equal(t, 70, approved.Paid)
+		assert.Equal(t, entity.BatchStatusPartial, approved.Status)
+		assert.Equal(t, []int{0, 10, 40, 50}, balances())
+	})
+}
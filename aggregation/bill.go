@@ -0,0 +1,227 @@
+package aggregation
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+var ErrBillNotFound = errors.New("bill not found")
+var ErrBillNotOpen = errors.New("bill is no longer open")
+var ErrInvalidShares = errors.New("shares don't add up to the bill")
+var ErrShareNotFound = errors.New("no share to pay in this bill")
+
+// SplitEqually gives every participant the same share of amount, the cents
+// that don't divide go one each to the first participants.
+func SplitEqually(amount int, participants []string) []entity.BillShare {
+	if len(participants) == 0 {
+		return nil
+	}
+
+	shares := make([]entity.BillShare, len(participants))
+	for i, userID := range participants {
+		shares[i] = entity.BillShare{UserID: userID, Amount: amount / len(participants)}
+		if i < amount%len(participants) {
+			shares[i].Amount++
+		}
+	}
+	return shares
+}
+
+// CreateBill splits amount among the shares, which have to add up to it.
+// Every participant but the organizer gets a payment request for their
+// share that expires with the expiry, the organizer's own share counts as
+// paid.
+func (t Transaction) CreateBill(organizerID string, amount int, shares []entity.BillShare, expiry time.Duration, opts ...TransactionOption) (entity.Bill, error) {
+	o := newTransactionOptions(opts)
+	if err := validateAmount(amount); err != nil {
+		return entity.Bill{}, err
+	}
+	if err := validateShares(amount, shares); err != nil {
+		return entity.Bill{}, err
+	}
+
+	now := t.db.Now()
+	bill := entity.Bill{
+		ID:          uuid.New().String(),
+		OrganizerID: organizerID,
+		Amount:      amount,
+		Currency:    entity.NormalizeCurrency(o.currency),
+		Note:        o.note,
+		Status:      entity.BillStatusOpen,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	o.billID = bill.ID
+
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		bill.Shares = make([]entity.BillShare, 0, len(shares))
+		for _, share := range shares {
+			share.Status = entity.PaymentRequestStatusPaid
+			if share.UserID != organizerID {
+				request, err := t.requestPayment(trx, organizerID, share.UserID, share.Amount, expiry, o)
+				if err != nil {
+					return err
+				}
+				share.Status = request.Status
+				share.PaymentRequestID = request.ID
+			}
+			bill.Shares = append(bill.Shares, share)
+		}
+
+		// a bill of the organizer alone has nobody to pay it
+		if len(bill.Shares) == 1 && bill.Shares[0].UserID == organizerID {
+			return ErrInvalidShares
+		}
+
+		settleBill(&bill)
+		return t.billRepo.Put(bill, trx)
+	})
+	if err != nil {
+		return entity.Bill{}, err
+	}
+
+	return bill, nil
+}
+
+// PayBillShare pays the pending share of the user through its payment
+// request, so the transfer and the share turning paid commit together.
+func (t Transaction) PayBillShare(userID, billID string, opts ...TransactionOption) (entity.Transaction, error) {
+	bill, err := t.billRepo.FindById(billID)
+	if err == db.ErrNotFound || (err == nil && !bill.HasParticipant(userID)) {
+		return entity.Transaction{}, ErrBillNotFound
+	}
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	for _, share := range bill.Shares {
+		if share.UserID == userID && share.PaymentRequestID != "" && share.Status == entity.PaymentRequestStatusPending {
+			return t.AcceptPaymentRequest(userID, share.PaymentRequestID, opts...)
+		}
+	}
+
+	return entity.Transaction{}, ErrShareNotFound
+}
+
+// CancelBill cancels the pending shares of an open bill. Paid shares stay
+// paid, the organizer refunds them like any other transfer.
+func (t Transaction) CancelBill(organizerID, billID string) (entity.Bill, error) {
+	var bill entity.Bill
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		bill, err = t.billRepo.FindById(billID, trx)
+		if err == db.ErrNotFound || (err == nil && bill.OrganizerID != organizerID) {
+			return ErrBillNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if bill.Status != entity.BillStatusOpen {
+			return ErrBillNotOpen
+		}
+
+		bill.Status = entity.BillStatusCancelled
+		if err := t.billRepo.Put(bill, trx); err != nil {
+			return err
+		}
+
+		for _, share := range bill.Shares {
+			if share.PaymentRequestID == "" || share.Status != entity.PaymentRequestStatusPending {
+				continue
+			}
+
+			request, err := t.paymentRequestRepo.FindById(share.PaymentRequestID, trx)
+			if err != nil {
+				return err
+			}
+			if _, err := t.updatePaymentRequest(trx, request, entity.PaymentRequestStatusCancelled, ""); err != nil {
+				return err
+			}
+		}
+
+		bill, err = t.billRepo.FindById(billID, trx)
+		return err
+	})
+	if err != nil {
+		return entity.Bill{}, err
+	}
+
+	return bill, nil
+}
+
+// updateBillShare follows the payment request of a share, the bill is
+// closed once no share is pending anymore.
+func (t Transaction) updateBillShare(trx *db.Transaction, request entity.PaymentRequest) error {
+	bill, err := t.billRepo.FindById(request.BillID, trx)
+	if err != nil {
+		return err
+	}
+
+	// the stored row shares its backing array until the commit
+	bill.Shares = slices.Clone(bill.Shares)
+	for i, share := range bill.Shares {
+		if share.PaymentRequestID == request.ID {
+			bill.Shares[i].Status = request.Status
+			bill.Shares[i].TransactionID = request.TransactionID
+		}
+	}
+
+	settleBill(&bill)
+	bill.UpdatedAt = t.db.Now()
+	return t.billRepo.Put(bill, trx)
+}
+
+// settleBill closes an open bill once no share is pending anymore, it is
+// settled when every share is paid and incomplete when one was declined or
+// expired.
+func settleBill(bill *entity.Bill) {
+	bill.Paid = 0
+	pending := false
+	for _, share := range bill.Shares {
+		switch share.Status {
+		case entity.PaymentRequestStatusPaid:
+			bill.Paid += share.Amount
+		case entity.PaymentRequestStatusPending:
+			pending = true
+		}
+	}
+
+	if bill.Status != entity.BillStatusOpen || pending {
+		return
+	}
+	if bill.Paid == bill.Amount {
+		bill.Status = entity.BillStatusSettled
+	} else {
+		bill.Status = entity.BillStatusIncomplete
+	}
+}
+
+func validateShares(amount int, shares []entity.BillShare) error {
+	if len(shares) == 0 {
+		return ErrInvalidShares
+	}
+
+	sum := 0
+	seen := map[string]bool{}
+	for _, share := range shares {
+		if share.UserID == "" || seen[share.UserID] {
+			return ErrInvalidShares
+		}
+		seen[share.UserID] = true
+
+		if share.Amount <= 0 {
+			return ErrInvalidShares
+		}
+		sum += share.Amount
+	}
+
+	if sum != amount {
+		return ErrInvalidShares
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package aggregation_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitEqually(t *testing.T) {
+	shares := aggregation.SplitEqually(100, []string{"a", "b", "c"})
+	assert.Equal(t, []entity.BillShare{
+		{UserID: "a", Amount: 34},
+		{UserID: "b", Amount: 33},
+		{UserID: "c", Amount: 33},
+	}, shares)
+
+	assert.Nil(t, aggregation.SplitEqually(100, nil))
+}
+
+func TestBill(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	dbInstance := setupDB(db.WithClock(clock))
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	billRepo := repository.NewBill(dbInstance)
+	requestRepo := repository.NewPaymentRequest(dbInstance)
+
+	organizerID := uuid.New().String()
+	friends := []string{uuid.New().String(), uuid.New().String()}
+	for _, userID := range append([]string{organizerID}, friends...) {
+		userRepo.Put(entity.User{ID: userID, Email: userID + "@example.com"})
+		walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: userID})
+	}
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+
+	for _, userID := range friends {
+		_, err := transaction.TopUp(userID, 100)
+		assert.NoError(t, err)
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		_, err := transaction.CreateBill(organizerID, 90, []entity.BillShare{{UserID: friends[0], Amount: 50}, {UserID: friends[1], Amount: 30}}, time.Hour)
+		assert.ErrorIs(t, err, aggregation.ErrInvalidShares)
+
+		_, err = transaction.CreateBill(organizerID, 90, []entity.BillShare{{UserID: friends[0], Amount: 45}, {UserID: friends[0], Amount: 45}}, time.Hour)
+		assert.ErrorIs(t, err, aggregation.ErrInvalidShares)
+
+		_, err = transaction.CreateBill(organizerID, 90, []entity.BillShare{{UserID: organizerID, Amount: 90}}, time.Hour)
+		assert.ErrorIs(t, err, aggregation.ErrInvalidShares)
+
+		// nothing is requested when one participant doesn't exist
+		_, err = transaction.CreateBill(organizerID, 90, aggregation.SplitEqually(90, []string{friends[0], "unknown"}), time.Hour)
+		assert.ErrorIs(t, err, aggregation.ErrUserNotFound)
+		requests, _ := requestRepo.GetByRequesterID(organizerID, "")
+		assert.Len(t, requests, 0)
+	})
+
+	t.Run("Equal split", func(t *testing.T) {
+		bill, err := transaction.CreateBill(organizerID, 90, aggregation.SplitEqually(90, append([]string{organizerID}, friends...)), time.Hour, aggregation.WithNote("pizza"))
+		assert.NoError(t, err)
+		assert.Equal(t, entity.BillStatusOpen, bill.Status)
+		assert.Equal(t, 30, bill.Paid) // the organizer's share
+
+		_, err = transaction.PayBillShare(organizerID, bill.ID)
+		assert.ErrorIs(t, err, aggregation.ErrShareNotFound)
+		_, err = transaction.PayBillShare("stranger", bill.ID)
+		assert.ErrorIs(t, err, aggregation.ErrBillNotFound)
+
+		before, _ := billRepo.FindById(bill.ID)
+
+		trx, err := transaction.PayBillShare(friends[0], bill.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "pizza", trx.Note)
+
+		// a row read earlier isn't changed underneath
+		for _, share := range before.Shares {
+			if share.UserID == friends[0] {
+				assert.Equal(t, entity.PaymentRequestStatusPending, share.Status)
+			}
+		}
+
+		stored, _ := billRepo.FindById(bill.ID)
+		assert.Equal(t, 60, stored.Paid)
+		assert.Equal(t, entity.PaymentRequestStatusPaid, stored.Shares[1].Status)
+		assert.Equal(t, trx.ID, stored.Shares[1].TransactionID)
+		assert.Equal(t, entity.PaymentRequestStatusPending, stored.Shares[2].Status)
+
+		// paying the request itself settles the share too
+		_, err = transaction.AcceptPaymentRequest(friends[1], stored.Shares[2].PaymentRequestID)
+		assert.NoError(t, err)
+
+		stored, _ = billRepo.FindById(bill.ID)
+		assert.Equal(t, 90, stored.Paid)
+		assert.Equal(t, entity.BillStatusSettled, stored.Status)
+
+		_, err = transaction.CancelBill(organizerID, bill.ID)
+		assert.ErrorIs(t, err, aggregation.ErrBillNotOpen)
+
+		organizer, _ := walletRepo.FindByUserID(organizerID)
+		assert.Equal(t, 60, organizer.Balance)
+	})
+
+	t.Run("Custom shares and cancel", func(t *testing.T) {
+		bill, err := transaction.CreateBill(organizerID, 50, []entity.BillShare{{UserID: friends[0], Amount: 40}, {UserID: friends[1], Amount: 10}}, time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, bill.Paid)
+
+		_, err = transaction.PayBillShare(friends[1], bill.ID)
+		assert.NoError(t, err)
+
+		_, err = transaction.CancelBill(friends[0], bill.ID)
+		assert.ErrorIs(t, err, aggregation.ErrBillNotFound)
+
+		cancelled, err := transaction.CancelBill(organizerID, bill.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, entity.BillStatusCancelled, cancelled.Status)
+		assert.Equal(t, entity.PaymentRequestStatusCancelled, cancelled.Shares[0].Status)
+		assert.Equal(t, entity.PaymentRequestStatusPaid, cancelled.Shares[1].Status)
+		assert.Equal(t, 10, cancelled.Paid)
+	})
+
+	t.Run("Declined share", func(t *testing.T) {
+		bill, err := transaction.CreateBill(organizerID, 50, []entity.BillShare{{UserID: friends[0], Amount: 40}, {UserID: friends[1], Amount: 10}}, time.Hour)
+		assert.NoError(t, err)
+
+		_, err = transaction.DeclinePaymentRequest(friends[0], bill.Shares[0].PaymentRequestID, "")
+		assert.NoError(t, err)
+
+		// the other share can still be paid
+		stored, _ := billRepo.FindById(bill.ID)
+		assert.Equal(t, entity.BillStatusOpen, stored.Status)
+
+		_, err = transaction.PayBillShare(friends[1], bill.ID)
+		assert.NoError(t, err)
+
+		stored, _ = billRepo.FindById(bill.ID)
+		assert.Equal(t, entity.BillStatusIncomplete, stored.Status)
+		assert.Equal(t, entity.PaymentRequestStatusDeclined, stored.Shares[0].Status)
+		assert.Equal(t, 10, stored.Paid)
+
+		_, err = transaction.CancelBill(organizerID, bill.ID)
+		assert.ErrorIs(t, err, aggregation.ErrBillNotOpen)
+	})
+
+	t.Run("Expired shares", func(t *testing.T) {
+		bill, err := transaction.CreateBill(organizerID, 20, aggregation.SplitEqually(20, friends), 15*time.Minute)
+		assert.NoError(t, err)
+
+		_, err = transaction.PayBillShare(friends[0], bill.ID)
+		assert.NoError(t, err)
+
+		clock.Advance(15 * time.Minute)
+		_, err = dbInstance.RunDueSchedules()
+		assert.NoError(t, err)
+
+		stored, _ := billRepo.FindById(bill.ID)
+		assert.Equal(t, entity.BillStatusIncomplete, stored.Status)
+		assert.Equal(t, entity.PaymentRequestStatusExpired, stored.Shares[1].Status)
+		assert.Equal(t, 10, stored.Paid)
+	})
+
+	t.Run("Cancel cancels pending requests", func(t *testing.T) {
+		bill, err := transaction.CreateBill(organizerID, 20, aggregation.SplitEqually(20, friends), time.Hour)
+		assert.NoError(t, err)
+
+		cancelled, err := transaction.CancelBill(organizerID, bill.ID)
+		assert.NoError(t, err)
+		for _, share := range cancelled.Shares {
+			assert.Equal(t, entity.PaymentRequestStatusCancelled, share.Status)
+		}
+
+		_, err = transaction.AcceptPaymentRequest(friends[0], bill.Shares[0].PaymentRequestID)
+		assert.ErrorIs(t, err, aggregation.ErrPaymentRequestNotPending)
+	})
+}
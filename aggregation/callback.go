@@ -0,0 +1,125 @@
+package aggregation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+// PaymentCompletedEvent is the body posted to a merchant's callback URL.
+const PaymentCompletedEvent = "payment_intent.paid"
+
+var ErrInvalidCallbackURL = errors.New("callback url must be a public https url")
+var ErrCallbackAddressNotAllowed = errors.New("callback url resolves to a non public address")
+
+// HTTPPaymentCallback posts paid payment intents to the callback URL of the
+// merchant as JSON, any answer but a 2xx fails the callback. Merchants
+// without a callback URL aren't told. Without a Client the callback only
+// connects to public addresses, whatever the host name resolves to.
+type HTTPPaymentCallback struct {
+	Client *http.Client
+}
+
+var callbackClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(_, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+					return ErrCallbackAddressNotAllowed
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	// a redirect could lead anywhere, it fails the callback instead
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func (c HTTPPaymentCallback) PaymentCompleted(merchant entity.User, intent entity.PaymentIntent) error {
+	if merchant.CallbackURL == "" {
+		return nil
+	}
+	// merchants registered before the URL was checked
+	if err := ValidateCallbackURL(merchant.CallbackURL); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"event": PaymentCompletedEvent,
+		"data":  intent,
+	})
+	if err != nil {
+		return err
+	}
+
+	client := c.Client
+	if client == nil {
+		client = callbackClient
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, merchant.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback answered %s", resp.Status)
+	}
+	return nil
+}
+
+// ValidateCallbackURL accepts https URLs whose host isn't a loopback,
+// private or link local address. Host names are checked again once they
+// resolve, when the callback connects.
+func ValidateCallbackURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" || u.User != nil {
+		return ErrInvalidCallbackURL
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrInvalidCallbackURL
+	}
+	if ip := net.ParseIP(host); ip != nil && !publicIP(ip) {
+		return ErrInvalidCallbackURL
+	}
+	return nil
+}
+
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified()
+}
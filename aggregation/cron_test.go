@@ -0,0 +1,48 @@
+package aggregation_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCron(t *testing.T) {
+	from := time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC) // a wednesday
+
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 31, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 45, 0, 0, time.UTC)},
+		{"0 9 1 * *", time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"30 10 31 * *", time.Date(2024, 3, 31, 10, 30, 0, 0, time.UTC)},
+		{"0 12 1,15 * *", time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expr, func(t *testing.T) {
+			cron, err := aggregation.ParseCron(c.expr)
+			assert.NoError(t, err)
+			assert.Equal(t, c.want, cron.Next(from))
+		})
+	}
+
+	t.Run("never", func(t *testing.T) {
+		cron, err := aggregation.ParseCron("0 0 31 2 *")
+		assert.NoError(t, err)
+		assert.True(t, cron.Next(from).IsZero())
+	})
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@often"} {
+		_, err := aggregation.ParseCron(expr)
+		assert.ErrorIs(t, err, aggregation.ErrInvalidCron, expr)
+	}
+}
@@ -0,0 +1,347 @@
+package aggregation
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+var ErrEscrowNotFound = errors.New("escrow not found")
+var ErrEscrowNotHeld = errors.New("escrow is no longer held")
+var ErrEscrowNotDisputed = errors.New("escrow is not disputed")
+
+// escrowReleaseSchedule releases every held escrow past its release time, a
+// missed schedule is caught up by the next one like holdExpirySchedule.
+const escrowReleaseSchedule = "escrow.release"
+
+// EscrowRetryInterval is the wait before an escrow that couldn't be released
+// is tried again, it doubles with every failed attempt up to a day.
+var EscrowRetryInterval = 5 * time.Minute
+
+const maxEscrowRetryInterval = 24 * time.Hour
+
+// OpenEscrow moves amount of the buyer's wallet into the escrow account for
+// the seller. It is released to the seller after timeout unless the buyer
+// confirms earlier, the seller cancels or either of them disputes it. The
+// source wallet is picked like a transfer and limits, fees and the risk
+// rules apply, the rules can block an escrow but not hold it for review.
+func (t Transaction) OpenEscrow(buyerID, sellerID string, amount int, timeout time.Duration, opts ...TransactionOption) (entity.Escrow, error) {
+	o := newTransactionOptions(opts)
+	if buyerID == sellerID {
+		return entity.Escrow{}, ErrSameWallet
+	}
+
+	var escrow entity.Escrow
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		if err := validateAmount(amount); err != nil {
+			return err
+		}
+
+		seller, err := t.userRepo.FindById(sellerID, trx)
+		if err == db.ErrNotFound {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if seller.IsClosed() {
+			return ErrAccountClosed
+		}
+
+		wallet, err := t.sourceWallet(trx, buyerID, o)
+		if err != nil {
+			return err
+		}
+
+		escrowWallet, err := t.escrowWallet(trx, wallet.Currency)
+		if err != nil {
+			return err
+		}
+
+		record, err := t.settle(trx, entity.Transaction{
+			Type:      entity.TransactionTypeEscrow,
+			Reference: o.reference,
+			Note:      o.note,
+		}, wallet, escrowWallet, amount)
+		if err != nil {
+			return err
+		}
+		if err := unreviewable(t.assessRisk(trx, record, sellerID)); err != nil {
+			return err
+		}
+
+		now := t.db.Now()
+		escrow = entity.Escrow{
+			ID:                   uuid.New().String(),
+			BuyerID:              buyerID,
+			SellerID:             sellerID,
+			Amount:               amount,
+			Currency:             record.Currency,
+			Reference:            o.reference,
+			Note:                 o.note,
+			Status:               entity.EscrowStatusHeld,
+			FundingTransactionID: record.ID,
+			ReleaseAt:            now.Add(timeout),
+			CreatedAt:            now,
+			UpdatedAt:            now,
+		}
+		if err := t.escrowRepo.Put(escrow, trx); err != nil {
+			return err
+		}
+
+		trx.Schedule(escrow.ReleaseAt, escrowReleaseSchedule, t.releaseDueEscrows)
+		return nil
+	})
+
+	// the escrow is rolled back, its decision goes in on its own
+	var riskErr *RiskError
+	if errors.As(err, &riskErr) {
+		if err := t.db.Transaction(func(trx *db.Transaction) error {
+			return t.recordRisk(trx, riskErr, buyerID, sellerID, amount, o, "")
+		}); err != nil {
+			return entity.Escrow{}, err
+		}
+	}
+	if err != nil {
+		return entity.Escrow{}, err
+	}
+
+	return escrow, nil
+}
+
+// ConfirmEscrow releases the money to the seller, the buyer confirms they
+// got what they paid for. It ends a dispute the buyer opened too.
+func (t Transaction) ConfirmEscrow(buyerID, escrowID string) (entity.Escrow, error) {
+	return t.settleEscrow(escrowID, func(escrow entity.Escrow) error {
+		if escrow.BuyerID != buyerID {
+			return ErrEscrowNotFound
+		}
+		return nil
+	}, entity.EscrowStatusReleased)
+}
+
+// CancelEscrow refunds the buyer, the seller calls the deal off.
+func (t Transaction) CancelEscrow(sellerID, escrowID string) (entity.Escrow, error) {
+	return t.settleEscrow(escrowID, func(escrow entity.Escrow) error {
+		if escrow.SellerID != sellerID {
+			return ErrEscrowNotFound
+		}
+		return nil
+	}, entity.EscrowStatusRefunded)
+}
+
+// ResolveEscrow settles a disputed escrow for an admin, releasing it to the
+// seller or refunding the buyer.
+func (t Transaction) ResolveEscrow(escrowID string, release bool) (entity.Escrow, error) {
+	status := entity.EscrowStatusRefunded
+	if release {
+		status = entity.EscrowStatusReleased
+	}
+
+	return t.settleEscrow(escrowID, func(escrow entity.Escrow) error {
+		if escrow.Status != entity.EscrowStatusDisputed {
+			return ErrEscrowNotDisputed
+		}
+		return nil
+	}, status)
+}
+
+// DisputeEscrow stops the release clock of a held escrow until the dispute
+// is withdrawn or resolved.
+func (t Transaction) DisputeEscrow(userID, escrowID, reason string) (entity.Escrow, error) {
+	return t.updateEscrow(escrowID, func(trx *db.Transaction, escrow entity.Escrow) (entity.Escrow, error) {
+		if !escrow.IsParty(userID) {
+			return entity.Escrow{}, ErrEscrowNotFound
+		}
+		if escrow.Status != entity.EscrowStatusHeld {
+			return entity.Escrow{}, ErrEscrowNotHeld
+		}
+
+		escrow.Status = entity.EscrowStatusDisputed
+		escrow.Remaining = escrow.ReleaseAt.Sub(t.db.Now())
+		escrow.ReleaseAt = time.Time{}
+		escrow.DisputedBy = userID
+		escrow.DisputeReason = reason
+		return escrow, nil
+	})
+}
+
+// WithdrawDispute lets the user who opened a dispute take it back, the
+// release clock goes on where it stopped.
+func (t Transaction) WithdrawDispute(userID, escrowID string) (entity.Escrow, error) {
+	return t.updateEscrow(escrowID, func(trx *db.Transaction, escrow entity.Escrow) (entity.Escrow, error) {
+		if !escrow.IsParty(userID) {
+			return entity.Escrow{}, ErrEscrowNotFound
+		}
+		if escrow.Status != entity.EscrowStatusDisputed || escrow.DisputedBy != userID {
+			return entity.Escrow{}, ErrEscrowNotDisputed
+		}
+
+		escrow.Status = entity.EscrowStatusHeld
+		escrow.ReleaseAt = t.db.Now().Add(escrow.Remaining)
+		escrow.Remaining = 0
+		escrow.DisputedBy = ""
+		escrow.DisputeReason = ""
+
+		trx.Schedule(escrow.ReleaseAt, escrowReleaseSchedule, t.releaseDueEscrows)
+		return escrow, nil
+	})
+}
+
+func (t Transaction) settleEscrow(escrowID string, allowed func(entity.Escrow) error, status entity.EscrowStatus) (entity.Escrow, error) {
+	return t.updateEscrow(escrowID, func(trx *db.Transaction, escrow entity.Escrow) (entity.Escrow, error) {
+		if err := allowed(escrow); err != nil {
+			return entity.Escrow{}, err
+		}
+		return t.payOutEscrow(trx, escrow, status)
+	})
+}
+
+func (t Transaction) updateEscrow(escrowID string, f func(*db.Transaction, entity.Escrow) (entity.Escrow, error)) (entity.Escrow, error) {
+	var escrow entity.Escrow
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		escrow, err = t.escrowRepo.FindById(escrowID, trx)
+		if err == db.ErrNotFound {
+			return ErrEscrowNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		escrow, err = f(trx, escrow)
+		if err != nil {
+			return err
+		}
+
+		escrow.UpdatedAt = t.db.Now()
+		return t.escrowRepo.Put(escrow, trx)
+	})
+	if err != nil {
+		return entity.Escrow{}, err
+	}
+
+	return escrow, nil
+}
+
+// payOutEscrow moves the money of a held or disputed escrow out of the
+// escrow account, to the seller on release and back to the wallet it came
+// from on refund. The caller stores the escrow.
+func (t Transaction) payOutEscrow(trx *db.Transaction, escrow entity.Escrow, status entity.EscrowStatus) (entity.Escrow, error) {
+	if escrow.Status != entity.EscrowStatusHeld && escrow.Status != entity.EscrowStatusDisputed {
+		return entity.Escrow{}, ErrEscrowNotHeld
+	}
+
+	escrowWallet, err := t.escrowWallet(trx, escrow.Currency)
+	if err != nil {
+		return entity.Escrow{}, err
+	}
+
+	var target entity.Wallet
+	transactionType := entity.TransactionTypeEscrowRelease
+	if status == entity.EscrowStatusReleased {
+		target, err = t.targetWallet(trx, escrow.SellerID, escrowWallet, transactionOptions{})
+	} else {
+		transactionType = entity.TransactionTypeEscrowRefund
+
+		var funding entity.Transaction
+		funding, err = t.transactionRepo.FindById(escrow.FundingTransactionID, trx)
+		if err == nil {
+			target, err = t.walletRepo.FindById(funding.SourceWalletID, trx)
+		}
+	}
+	if err != nil {
+		return entity.Escrow{}, err
+	}
+
+	record, err := t.settle(trx, entity.Transaction{
+		Type:                  transactionType,
+		Reference:             escrow.Reference,
+		Note:                  escrow.Note,
+		OriginalTransactionID: escrow.FundingTransactionID,
+	}, escrowWallet, target, escrow.Amount)
+	if err != nil {
+		return entity.Escrow{}, err
+	}
+
+	escrow.Status = status
+	escrow.SettlementTransactionID = record.ID
+	escrow.ReleaseAt = time.Time{}
+	escrow.Remaining = 0
+	return escrow, nil
+}
+
+// releaseDueEscrows releases every held escrow past its release time, it
+// runs as a schedule. An escrow that can't be released, say to a suspended
+// wallet, stays held without holding up the others, and is tried again with
+// a backoff.
+func (t Transaction) releaseDueEscrows(trx *db.Transaction) error {
+	now := t.db.Now()
+	escrows, err := t.escrowRepo.GetDue(now, trx)
+	if err != nil {
+		return err
+	}
+
+	var retryAt time.Time
+	for _, escrow := range escrows {
+		if escrow.RetryAt.After(now) {
+			continue
+		}
+
+		err := trx.Savepoint(func(sp *db.Transaction) error {
+			released, err := t.payOutEscrow(sp, escrow, entity.EscrowStatusReleased)
+			if err != nil {
+				return err
+			}
+
+			released.UpdatedAt = now
+			return t.escrowRepo.Put(released, sp)
+		})
+		if err == nil {
+			continue
+		}
+
+		// the release rolled back, the failure is kept on the escrow
+		escrow.ReleaseAttempts++
+		escrow.ReleaseError = err.Error()
+		escrow.RetryAt = now.Add(retryBackoff(EscrowRetryInterval, maxEscrowRetryInterval, escrow.ReleaseAttempts))
+		escrow.UpdatedAt = now
+		if err := t.escrowRepo.Put(escrow, trx); err != nil {
+			return err
+		}
+
+		if retryAt.IsZero() || escrow.RetryAt.Before(retryAt) {
+			retryAt = escrow.RetryAt
+		}
+	}
+
+	if !retryAt.IsZero() {
+		trx.Schedule(retryAt, escrowReleaseSchedule, t.releaseDueEscrows)
+	}
+
+	return nil
+}
+
+func (t Transaction) handleEscrowRelease(trx *db.Transaction, _ db.Schedule) error {
+	return t.releaseDueEscrows(trx)
+}
+
+// escrowWallet loads the escrow account of the currency, it is opened with
+// the first escrow.
+func (t Transaction) escrowWallet(trx *db.Transaction, currency string) (entity.Wallet, error) {
+	walletID := entity.EscrowAccount(currency)
+
+	wallet, err := t.walletRepo.FindById(walletID, trx)
+	if err == db.ErrNotFound {
+		return entity.Wallet{
+			ID:       walletID,
+			UserID:   entity.SystemUserID,
+			Name:     entity.PrimaryWalletName,
+			Currency: entity.NormalizeCurrency(currency),
+		}, nil
+	}
+	return wallet, err
+}
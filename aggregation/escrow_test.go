@@ -0,0 +1,210 @@
+package aggregation_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscrow(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	dbInstance := setupDB(db.WithClock(clock))
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	escrowRepo := repository.NewEscrow(dbInstance)
+
+	buyerID := uuid.New().String()
+	sellerID := uuid.New().String()
+	userRepo.Put(entity.User{ID: buyerID, Email: "buyer@example.com"})
+	userRepo.Put(entity.User{ID: sellerID, Email: "seller@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: buyerID})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: sellerID})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+
+	_, err := transaction.TopUp(buyerID, 100)
+	assert.NoError(t, err)
+
+	balances := func() (int, int, int) {
+		buyer, _ := walletRepo.FindByUserID(buyerID)
+		seller, _ := walletRepo.FindByUserID(sellerID)
+		escrow, _ := walletRepo.FindById(entity.EscrowAccount(entity.DefaultCurrency))
+		return buyer.Balance, seller.Balance, escrow.Balance
+	}
+
+	_, err = transaction.OpenEscrow(buyerID, buyerID, 10, time.Hour)
+	assert.ErrorIs(t, err, aggregation.ErrSameWallet)
+	_, err = transaction.OpenEscrow(buyerID, sellerID, 500, time.Hour)
+	assert.ErrorIs(t, err, aggregation.ErrInsuficientFound)
+
+	t.Run("Confirm", func(t *testing.T) {
+		escrow, err := transaction.OpenEscrow(buyerID, sellerID, 30, time.Hour, aggregation.WithReference("order-1"))
+		assert.NoError(t, err)
+		assert.Equal(t, entity.EscrowStatusHeld, escrow.Status)
+
+		buyer, seller, held := balances()
+		assert.Equal(t, []int{70, 0, 30}, []int{buyer, seller, held})
+
+		// only the buyer confirms
+		_, err = transaction.ConfirmEscrow(sellerID, escrow.ID)
+		assert.ErrorIs(t, err, aggregation.ErrEscrowNotFound)
+
+		released, err := transaction.ConfirmEscrow(buyerID, escrow.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, entity.EscrowStatusReleased, released.Status)
+
+		buyer, seller, held = balances()
+		assert.Equal(t, []int{70, 30, 0}, []int{buyer, seller, held})
+
+		_, err = transaction.CancelEscrow(sellerID, escrow.ID)
+		assert.ErrorIs(t, err, aggregation.ErrEscrowNotHeld)
+
+		mutations, _ := mutationRepo.GetByUserID(sellerID)
+		assert.Len(t, mutations, 1)
+		assert.Equal(t, released.SettlementTransactionID, mutations[0].TransactionID)
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		escrow, err := transaction.OpenEscrow(buyerID, sellerID, 20, time.Hour)
+		assert.NoError(t, err)
+
+		refunded, err := transaction.CancelEscrow(sellerID, escrow.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, entity.EscrowStatusRefunded, refunded.Status)
+
+		buyer, seller, held := balances()
+		assert.Equal(t, []int{70, 30, 0}, []int{buyer, seller, held})
+	})
+
+	t.Run("Auto release and disputes", func(t *testing.T) {
+		escrow, err := transaction.OpenEscrow(buyerID, sellerID, 40, time.Hour)
+		assert.NoError(t, err)
+		disputed, err := transaction.OpenEscrow(buyerID, sellerID, 10, time.Hour)
+		assert.NoError(t, err)
+
+		clock.Advance(20 * time.Minute)
+		disputed, err = transaction.DisputeEscrow(buyerID, disputed.ID, "item never arrived")
+		assert.NoError(t, err)
+		assert.Equal(t, 40*time.Minute, disputed.Remaining)
+
+		_, err = transaction.WithdrawDispute(sellerID, disputed.ID)
+		assert.ErrorIs(t, err, aggregation.ErrEscrowNotDisputed)
+
+		// the disputed escrow stays held past its release time
+		clock.Advance(time.Hour)
+		_, err = dbInstance.RunDueSchedules()
+		assert.NoError(t, err)
+
+		stored, _ := escrowRepo.FindById(escrow.ID)
+		assert.Equal(t, entity.EscrowStatusReleased, stored.Status)
+		stored, _ = escrowRepo.FindById(disputed.ID)
+		assert.Equal(t, entity.EscrowStatusDisputed, stored.Status)
+
+		// the clock goes on where it stopped
+		resumed, err := transaction.WithdrawDispute(buyerID, disputed.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, clock.Now().Add(40*time.Minute), resumed.ReleaseAt)
+
+		_, err = transaction.DisputeEscrow(sellerID, disputed.ID, "buyer won't confirm")
+		assert.NoError(t, err)
+
+		_, err = transaction.ResolveEscrow(escrow.ID, true)
+		assert.ErrorIs(t, err, aggregation.ErrEscrowNotDisputed)
+
+		resolved, err := transaction.ResolveEscrow(disputed.ID, false)
+		assert.NoError(t, err)
+		assert.Equal(t, entity.EscrowStatusRefunded, resolved.Status)
+
+		buyer, seller, held := balances()
+		assert.Equal(t, []int{30, 70, 0}, []int{buyer, seller, held})
+	})
+
+	discrepancies, err := aggregation.NewLedger(walletRepo, dbInstance).Reconcile()
+	assert.NoError(t, err)
+	assert.Empty(t, discrepancies)
+}
+
+func TestEscrowReleaseFailure(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	dbInstance := setupDB(db.WithClock(clock))
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	escrowRepo := repository.NewEscrow(dbInstance)
+
+	buyerID := uuid.New().String()
+	sellerID := uuid.New().String()
+	sellerWalletID := uuid.New().String()
+	userRepo.Put(entity.User{ID: buyerID, Email: "buyer@example.com"})
+	userRepo.Put(entity.User{ID: sellerID, Email: "seller@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: buyerID})
+	walletRepo.Put(entity.Wallet{ID: sellerWalletID, UserID: sellerID})
+
+	// the escrow account pays out what it holds, fees on its payouts
+	// aren't charged
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance,
+		aggregation.WithFees(aggregation.FeeSchedule{
+			entity.TransactionTypeEscrowRelease: {Flat: 3},
+			entity.TransactionTypeEscrowRefund:  {Flat: 3},
+		}),
+	)
+
+	_, err := transaction.TopUp(buyerID, 100)
+	assert.NoError(t, err)
+
+	blocked, err := transaction.OpenEscrow(buyerID, sellerID, 30, time.Hour)
+	assert.NoError(t, err)
+	cancelled, err := transaction.OpenEscrow(buyerID, sellerID, 20, time.Hour)
+	assert.NoError(t, err)
+
+	seller, _ := walletRepo.FindById(sellerWalletID)
+	seller.Status = entity.WalletStatusSuspended
+	walletRepo.Put(seller)
+
+	clock.Advance(time.Hour)
+	_, err = dbInstance.RunDueSchedules()
+	assert.NoError(t, err)
+
+	stored, _ := escrowRepo.FindById(blocked.ID)
+	assert.Equal(t, entity.EscrowStatusHeld, stored.Status)
+	assert.Equal(t, 1, stored.ReleaseAttempts)
+	assert.NotEmpty(t, stored.ReleaseError)
+	assert.Equal(t, clock.Now().Add(aggregation.EscrowRetryInterval), stored.RetryAt)
+
+	// the seller can still give the money back
+	_, err = transaction.CancelEscrow(sellerID, cancelled.ID)
+	assert.NoError(t, err)
+
+	buyer, _ := walletRepo.FindByUserID(buyerID)
+	assert.Equal(t, 70, buyer.Balance)
+
+	// the next try backs off further
+	clock.Advance(aggregation.EscrowRetryInterval)
+	_, err = dbInstance.RunDueSchedules()
+	assert.NoError(t, err)
+	stored, _ = escrowRepo.FindById(blocked.ID)
+	assert.Equal(t, 2, stored.ReleaseAttempts)
+	assert.Equal(t, clock.Now().Add(2*aggregation.EscrowRetryInterval), stored.RetryAt)
+
+	seller.Status = entity.WalletStatusActive
+	walletRepo.Put(seller)
+
+	clock.Advance(2 * aggregation.EscrowRetryInterval)
+	_, err = dbInstance.RunDueSchedules()
+	assert.NoError(t, err)
+
+	stored, _ = escrowRepo.FindById(blocked.ID)
+	assert.Equal(t, entity.EscrowStatusReleased, stored.Status)
+
+	seller, _ = walletRepo.FindById(sellerWalletID)
+	assert.Equal(t, 30, seller.Balance)
+}
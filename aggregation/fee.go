@@ -0,0 +1,170 @@
+package aggregation
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+var ErrAmountBelowFee = errors.New("doesn't cover the fee")
+
+// errQuote rolls a quoted transfer back once it has been computed.
+var errQuote = errors.New("quote")
+
+// FeeTier replaces the flat and percentage part of a rule for amounts up to
+// UpTo, zero means no upper bound.
+type FeeTier struct {
+	UpTo        int `json:"up_to"`
+	Flat        int `json:"flat"`
+	BasisPoints int `json:"basis_points"`
+}
+
+func (t FeeTier) upper() int {
+	if t.UpTo == 0 {
+		return math.MaxInt
+	}
+	return t.UpTo
+}
+
+// FeeRule is the fee of a transaction type: a flat part plus a percentage in
+// basis points, picked from the first matching tier when there are tiers,
+// then kept between Min and Max. A zero Max means no cap.
+type FeeRule struct {
+	Flat        int       `json:"flat"`
+	BasisPoints int       `json:"basis_points"`
+	Tiers       []FeeTier `json:"tiers"`
+	Min         int       `json:"min"`
+	Max         int       `json:"max"`
+}
+
+// FeeSchedule holds the rule of every transaction type that is charged.
+type FeeSchedule map[entity.TransactionType]FeeRule
+
+// Fee returns the fee of amount, in the same currency.
+func (s FeeSchedule) Fee(transactionType entity.TransactionType, amount int) int {
+	rule, ok := s[transactionType]
+	if !ok {
+		return 0
+	}
+
+	flat, basisPoints := rule.Flat, rule.BasisPoints
+	tiers := append([]FeeTier{}, rule.Tiers...)
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].upper() < tiers[j].upper()
+	})
+	for _, tier := range tiers {
+		if amount <= tier.upper() {
+			flat, basisPoints = tier.Flat, tier.BasisPoints
+			break
+		}
+	}
+
+	fee := flat + proportion(amount, basisPoints, 10000)
+	if fee < rule.Min {
+		fee = rule.Min
+	}
+	if rule.Max > 0 && fee > rule.Max {
+		fee = rule.Max
+	}
+	return fee
+}
+
+// WithFees charges fees by transaction type.
+func WithFees(schedule FeeSchedule) Option {
+	return func(t *Transaction) {
+		t.fees = schedule
+	}
+}
+
+// Quote is what a transfer would cost without making it.
+type Quote struct {
+	Amount         int    `json:"amount"`
+	Fee            int    `json:"fee"`
+	Total          int    `json:"total"` // taken out of the source wallet
+	Currency       string `json:"currency"`
+	TargetAmount   int    `json:"target_amount"`
+	TargetCurrency string `json:"target_currency"`
+	Rate           string `json:"rate,omitempty"`
+}
+
+// QuoteTransfer runs the transfer and rolls it back, so the quote is exactly
+// what Transfer would do at that moment.
+func (t Transaction) QuoteTransfer(userID, targetID string, amount int, opts ...TransactionOption) (Quote, error) {
+	o := newTransactionOptions(opts)
+
+	var quote Quote
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		record, err := t.transfer(trx, userID, targetID, amount, o)
+		if err != nil {
+			return err
+		}
+
+		quote = Quote{
+			Amount:         record.Amount,
+			Fee:            record.Fee,
+			Total:          record.Amount + record.Fee,
+			Currency:       record.Currency,
+			TargetAmount:   record.TargetAmount,
+			TargetCurrency: record.TargetCurrency,
+			Rate:           record.Rate,
+		}
+		return errQuote
+	})
+	if err != nil && err != errQuote {
+		return Quote{}, err
+	}
+
+	return quote, nil
+}
+
+// collectFee credits the fee wallet of the record currency and returns its
+// leg of the posting, the payer's leg is up to the caller.
+func (t Transaction) collectFee(trx *db.Transaction, record entity.Transaction) (Leg, error) {
+	walletID := entity.FeeRevenueAccount(record.Currency)
+
+	wallet, err := t.walletRepo.FindById(walletID, trx)
+	if err == db.ErrNotFound {
+		wallet = entity.Wallet{
+			ID:       walletID,
+			UserID:   entity.SystemUserID,
+			Name:     entity.PrimaryWalletName,
+			Currency: record.Currency,
+		}
+	} else if err != nil {
+		return Leg{}, err
+	}
+
+	wallet.Balance, err = credit(wallet.Balance, record.Fee)
+	if err != nil {
+		return Leg{}, err
+	}
+
+	if err := t.walletRepo.Put(wallet, trx); err != nil {
+		return Leg{}, err
+	}
+
+	payerWalletID, payerUserID := record.SourceWalletID, record.SourceUserID
+	if record.Type == entity.TransactionTypeTopUp {
+		payerWalletID, payerUserID = record.TargetWalletID, record.TargetUserID
+	}
+
+	if err := t.mutationRepo.Put(entity.Mutation{
+		ID:                   uuid.New().String(),
+		TransactionID:        record.ID,
+		WalletID:             wallet.ID,
+		UserID:               wallet.UserID,
+		CounterpartyWalletID: payerWalletID,
+		CounterpartyUserID:   payerUserID,
+		Type:                 entity.MutationTypeCredit, // topup
+		Amount:               record.Fee,
+		CreatedAt:            record.CreatedAt,
+	}, trx); err != nil {
+		return Leg{}, err
+	}
+
+	return Leg{AccountID: wallet.ID, Amount: record.Fee}, nil
+}
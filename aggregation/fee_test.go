@@ -0,0 +1,100 @@
+package aggregation_test
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeeSchedule(t *testing.T) {
+	fees := aggregation.FeeSchedule{
+		entity.TransactionTypeTransfer: {Flat: 100, BasisPoints: 50, Max: 1000},
+		entity.TransactionTypeTopUp: {
+			Tiers: []aggregation.FeeTier{
+				{Flat: 500},                     // anything bigger
+				{UpTo: 10000, BasisPoints: 100}, // 1% up to 10000
+			},
+			Min: 20,
+		},
+	}
+
+	assert.Equal(t, 150, fees.Fee(entity.TransactionTypeTransfer, 10000)) // 100 + 0.5%
+	assert.Equal(t, 1000, fees.Fee(entity.TransactionTypeTransfer, 1e6))  // capped
+	assert.Equal(t, 20, fees.Fee(entity.TransactionTypeTopUp, 1000))      // 10, raised to the minimum
+	assert.Equal(t, 100, fees.Fee(entity.TransactionTypeTopUp, 10000))    // first tier
+	assert.Equal(t, 500, fees.Fee(entity.TransactionTypeTopUp, 10001))    // second tier
+	assert.Equal(t, 0, fees.Fee(entity.TransactionTypeMove, 10000))       // no rule, no fee
+	assert.Equal(t, 0, aggregation.FeeSchedule(nil).Fee(entity.TransactionTypeTransfer, 10000))
+}
+
+func TestTransferWithFees(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+
+	sourceUserID := uuid.New().String()
+	targetUserID := uuid.New().String()
+	userRepo.Put(entity.User{ID: sourceUserID, Email: "source@example.com"})
+	userRepo.Put(entity.User{ID: targetUserID, Email: "target@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: sourceUserID})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: targetUserID})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance,
+		aggregation.WithFees(aggregation.FeeSchedule{
+			entity.TransactionTypeTopUp:    {Flat: 5},
+			entity.TransactionTypeTransfer: {Flat: 2, BasisPoints: 1000},
+		}),
+	)
+
+	_, err := transaction.TopUp(sourceUserID, 5)
+	assert.ErrorIs(t, err, aggregation.ErrAmountBelowFee)
+
+	topUp, err := transaction.TopUp(sourceUserID, 105)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, topUp.Fee)
+	assert.Equal(t, 100, topUp.TargetAmount)
+
+	quote, err := transaction.QuoteTransfer(sourceUserID, targetUserID, 50)
+	assert.NoError(t, err)
+	assert.Equal(t, aggregation.Quote{Amount: 50, Fee: 7, Total: 57, Currency: "IDR", TargetAmount: 50, TargetCurrency: "IDR"}, quote)
+
+	// the quote didn't move anything
+	source, _ := walletRepo.FindByUserID(sourceUserID)
+	assert.Equal(t, 100, source.Balance)
+
+	// the fee has to be covered too
+	_, err = transaction.Transfer(sourceUserID, targetUserID, 95)
+	assert.ErrorIs(t, err, aggregation.ErrInsuficientFound)
+
+	trx, err := transaction.Transfer(sourceUserID, targetUserID, 50)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, trx.Fee)
+
+	source, _ = walletRepo.FindByUserID(sourceUserID)
+	target, _ := walletRepo.FindByUserID(targetUserID)
+	revenue, err := walletRepo.FindById(entity.FeeRevenueAccount("IDR"))
+	assert.NoError(t, err)
+	assert.Equal(t, 43, source.Balance)
+	assert.Equal(t, 50, target.Balance)
+	assert.Equal(t, 12, revenue.Balance)
+
+	mutations, _ := mutationRepo.GetByUserID(sourceUserID)
+	for _, mutation := range mutations {
+		if mutation.TransactionID == trx.ID {
+			assert.Equal(t, 7, mutation.Fee)
+		}
+	}
+
+	ledger := aggregation.NewLedger(walletRepo, dbInstance)
+	_, err = ledger.TrialBalance()
+	assert.NoError(t, err)
+	discrepancies, err := ledger.Reconcile()
+	assert.NoError(t, err)
+	assert.Empty(t, discrepancies)
+}
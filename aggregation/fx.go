@@ -0,0 +1,92 @@
+package aggregation
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+var ErrRateNotFound = errors.New("conversion rate not found")
+
+// RateProvider quotes how many units of one currency a unit of another is
+// worth, both in minor units.
+type RateProvider interface {
+	Rate(from, to string) (*big.Rat, error)
+}
+
+// StaticRateProvider is a fixed rate table keyed by "FROM/TO". A missing
+// pair is answered with the inverse of the opposite pair when there is one.
+type StaticRateProvider map[string]*big.Rat
+
+func (p StaticRateProvider) Rate(from, to string) (*big.Rat, error) {
+	from, to = entity.NormalizeCurrency(from), entity.NormalizeCurrency(to)
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+
+	if rate, ok := p[from+"/"+to]; ok {
+		return new(big.Rat).Set(rate), nil
+	}
+
+	if rate, ok := p[to+"/"+from]; ok && rate.Sign() != 0 {
+		return new(big.Rat).Inv(rate), nil
+	}
+
+	return nil, fmt.Errorf("%w: %s/%s", ErrRateNotFound, from, to)
+}
+
+// Option configures the transaction aggregator.
+type Option func(*Transaction)
+
+// WithRateProvider enables cross currency transfers.
+func WithRateProvider(provider RateProvider) Option {
+	return func(t *Transaction) {
+		t.rateProvider = provider
+	}
+}
+
+// WithFXSpread takes a cut of every converted amount, 0.01 keeps 1%.
+func WithFXSpread(spread *big.Rat) Option {
+	return func(t *Transaction) {
+		t.spread = new(big.Rat).Set(spread)
+	}
+}
+
+type conversion struct {
+	amount int
+	rate   *big.Rat
+	spread *big.Rat
+}
+
+// convert rounds down, the rounding and the spread stay in the FX accounts.
+func (t Transaction) convert(amount int, from, to string) (conversion, error) {
+	if entity.NormalizeCurrency(from) == entity.NormalizeCurrency(to) {
+		return conversion{amount: amount}, nil
+	}
+
+	if t.rateProvider == nil {
+		return conversion{}, fmt.Errorf("%w: %s/%s", ErrRateNotFound, entity.NormalizeCurrency(from), entity.NormalizeCurrency(to))
+	}
+
+	rate, err := t.rateProvider.Rate(from, to)
+	if err != nil {
+		return conversion{}, err
+	}
+
+	spread := t.spread
+	if spread == nil {
+		spread = new(big.Rat)
+	}
+
+	converted := new(big.Rat).Mul(big.NewRat(int64(amount), 1), rate)
+	converted.Mul(converted, new(big.Rat).Sub(big.NewRat(1, 1), spread))
+
+	result := new(big.Int).Quo(converted.Num(), converted.Denom())
+	if !result.IsInt64() {
+		return conversion{}, &AmountError{Field: "amount", Amount: amount, Reason: ErrBalanceOverflow}
+	}
+
+	return conversion{amount: int(result.Int64()), rate: rate, spread: spread}, nil
+}
@@ -0,0 +1,103 @@
+package aggregation_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticRateProvider(t *testing.T) {
+	rates := aggregation.StaticRateProvider{"USD/IDR": big.NewRat(155, 1)}
+
+	rate, err := rates.Rate("usd", "IDR")
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewRat(155, 1), rate)
+
+	rate, err = rates.Rate("IDR", "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewRat(1, 155), rate)
+
+	_, err = rates.Rate("SGD", "USD")
+	assert.ErrorIs(t, err, aggregation.ErrRateNotFound)
+}
+
+func TestTransferAcrossCurrencies(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	transactionRepo := repository.NewTransaction(dbInstance)
+
+	sourceUserID := uuid.New().String()
+	targetUserID := uuid.New().String()
+	userRepo.Put(entity.User{ID: sourceUserID, Email: "source@example.com"})
+	userRepo.Put(entity.User{ID: targetUserID, Email: "target@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: sourceUserID, Currency: "IDR"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: targetUserID, Currency: "IDR"})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance,
+		aggregation.WithRateProvider(aggregation.StaticRateProvider{"USD/IDR": big.NewRat(155, 1)}),
+		aggregation.WithFXSpread(big.NewRat(1, 100)),
+	)
+
+	// one wallet per currency
+	usdWallet, err := transaction.OpenWallet(sourceUserID, "usd")
+	assert.NoError(t, err)
+	assert.Equal(t, "USD", usdWallet.Currency)
+	_, err = transaction.OpenWallet(sourceUserID, "USD")
+	assert.ErrorIs(t, err, aggregation.ErrWalletAlreadyExists)
+	_, err = transaction.OpenWallet(sourceUserID, "dollar")
+	assert.ErrorIs(t, err, entity.ErrInvalidCurrency)
+
+	// the default wallet is still the IDR one
+	wallet, err := walletRepo.FindByUserID(sourceUserID)
+	assert.NoError(t, err)
+	assert.Equal(t, "IDR", wallet.Currency)
+
+	_, err = transaction.TopUp(sourceUserID, 100, aggregation.WithCurrency("USD"))
+	assert.NoError(t, err)
+
+	// the target has no USD wallet, the money lands in their IDR wallet
+	trx, err := transaction.Transfer(sourceUserID, targetUserID, 10, aggregation.WithCurrency("USD"))
+	assert.NoError(t, err)
+
+	stored, err := transactionRepo.FindById(trx.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, stored.Amount)
+	assert.Equal(t, "USD", stored.Currency)
+	assert.Equal(t, 1534, stored.TargetAmount) // 10 * 155 * 0.99, rounded down
+	assert.Equal(t, "IDR", stored.TargetCurrency)
+	assert.Equal(t, "155.000000", stored.Rate)
+	assert.Equal(t, "0.010000", stored.Spread)
+
+	source, _ := walletRepo.FindByUserIDAndCurrency(sourceUserID, "USD")
+	target, _ := walletRepo.FindByUserID(targetUserID)
+	assert.Equal(t, 90, source.Balance)
+	assert.Equal(t, 1534, target.Balance)
+
+	balance, err := aggregation.NewLedger(walletRepo, dbInstance).TrialBalance()
+	assert.NoError(t, err)
+	assert.Equal(t, 10, balance.Accounts[entity.FXAccount("USD")])
+	assert.Equal(t, -1534, balance.Accounts[entity.FXAccount("IDR")])
+
+	// too small to be worth a cent after conversion
+	_, err = transaction.Transfer(targetUserID, sourceUserID, 100, aggregation.WithTargetCurrency("USD"))
+	assert.ErrorIs(t, err, aggregation.ErrAmountNotPositive)
+
+	// the inverse rate is used the other way around
+	trx, err = transaction.Transfer(targetUserID, sourceUserID, 1000, aggregation.WithTargetCurrency("USD"))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, trx.TargetAmount)
+
+	// no rate, no transfer
+	_, err = transaction.OpenWallet(targetUserID, "SGD")
+	assert.NoError(t, err)
+	_, err = transaction.Transfer(sourceUserID, targetUserID, 10, aggregation.WithCurrency("USD"), aggregation.WithTargetCurrency("SGD"))
+	assert.ErrorIs(t, err, aggregation.ErrRateNotFound)
+}
@@ -0,0 +1,252 @@
+package aggregation
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+var ErrHoldNotFound = errors.New("hold not found")
+var ErrHoldNotActive = errors.New("hold is not active")
+var ErrHoldTargetRequired = errors.New("hold target is required")
+var ErrCaptureOverHold = errors.New("capture is over the held amount")
+var ErrWithdrawalHold = errors.New("hold belongs to a withdrawal")
+
+// holdExpirySchedule releases every expired hold when it runs, so a missed
+// schedule is caught up by the next one.
+const holdExpirySchedule = "hold.expire"
+
+// WithHoldTarget sets who can capture a hold, it is required by Hold.
+func WithHoldTarget(userID string) TransactionOption {
+	return func(o *transactionOptions) {
+		o.holdTargetID = userID
+	}
+}
+
+// Hold reserves amount of the user's wallet for the hold target until it is
+// captured, voided or expires. The source wallet is picked like a transfer.
+func (t Transaction) Hold(userID string, amount int, expiry time.Duration, opts ...TransactionOption) (entity.Hold, error) {
+	o := newTransactionOptions(opts)
+	if o.holdTargetID == "" {
+		return entity.Hold{}, ErrHoldTargetRequired
+	}
+
+	var hold entity.Hold
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		if err := validateAmount(amount); err != nil {
+			return err
+		}
+
+		if _, err := t.userRepo.FindById(o.holdTargetID, trx); err != nil {
+			return err
+		}
+
+		wallet, err := t.sourceWallet(trx, userID, o)
+		if err != nil {
+			return err
+		}
+
+		if err := canSend(wallet); err != nil {
+			return err
+		}
+
+		if wallet.Available()-amount < 0 {
+			return ErrInsuficientFound
+		}
+
+		wallet.Held, err = credit(wallet.Held, amount)
+		if err != nil {
+			return err
+		}
+		if err := t.walletRepo.Put(wallet, trx); err != nil {
+			return err
+		}
+
+		now := t.db.Now()
+		hold = entity.Hold{
+			ID:           uuid.New().String(),
+			UserID:       userID,
+			WalletID:     wallet.ID,
+			TargetUserID: o.holdTargetID,
+			Amount:       amount,
+			Currency:     entity.NormalizeCurrency(wallet.Currency),
+			Status:       entity.HoldStatusActive,
+			Reference:    o.reference,
+			ExpiresAt:    now.Add(expiry),
+			CreatedAt:    now,
+		}
+
+		// kept only when the hold commits
+		trx.Schedule(hold.ExpiresAt, holdExpirySchedule, t.expireHolds)
+
+		return t.holdRepo.Put(hold, trx)
+	})
+	if err != nil {
+		return entity.Hold{}, err
+	}
+
+	return hold, nil
+}
+
+// Capture settles amount of an active hold to the target's wallet and
+// releases the rest, a hold is captured at most once. The risk rules can
+// block a capture but not hold it for review.
+func (t Transaction) Capture(holdID string, amount int, opts ...TransactionOption) (entity.Transaction, error) {
+	o := newTransactionOptions(opts)
+
+	var result entity.Transaction
+	var hold entity.Hold
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var wallet entity.Wallet
+		var err error
+		hold, wallet, err = t.activeHold(trx, holdID)
+		if err != nil {
+			return err
+		}
+		if hold.WithdrawalID != "" {
+			return ErrWithdrawalHold
+		}
+
+		if err := validateAmount(amount); err != nil {
+			return err
+		}
+		if amount > hold.Amount {
+			return &AmountError{Field: "amount", Amount: amount, Reason: ErrCaptureOverHold}
+		}
+
+		wallet.Held, err = debit(wallet.Held, hold.Amount)
+		if err != nil {
+			return err
+		}
+
+		targetWallet, err := t.targetWallet(trx, hold.TargetUserID, wallet, o)
+		if err != nil {
+			return err
+		}
+
+		result, err = t.settle(trx, entity.Transaction{
+			Type:      entity.TransactionTypeCapture,
+			Reference: hold.Reference,
+			Note:      o.note,
+		}, wallet, targetWallet, amount)
+		if err != nil {
+			return err
+		}
+		if err := unreviewable(t.assessRisk(trx, result, hold.TargetUserID)); err != nil {
+			return err
+		}
+
+		hold.Status = entity.HoldStatusCaptured
+		hold.Captured = amount
+		hold.TransactionID = result.ID
+		return t.holdRepo.Put(hold, trx)
+	})
+
+	// the capture is rolled back, its decision goes in on its own
+	var riskErr *RiskError
+	if errors.As(err, &riskErr) {
+		if err := t.db.Transaction(func(trx *db.Transaction) error {
+			return t.recordRisk(trx, riskErr, hold.UserID, hold.TargetUserID, amount, o, "")
+		}); err != nil {
+			return entity.Transaction{}, err
+		}
+	}
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	return result, nil
+}
+
+// Void releases an active hold without moving money. The hold of a
+// withdrawal is only released by its payout failing.
+func (t Transaction) Void(holdID string) (entity.Hold, error) {
+	var hold entity.Hold
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		hold, err = t.releaseHold(trx, holdID, entity.HoldStatusVoided)
+		if err == nil && hold.WithdrawalID != "" {
+			return ErrWithdrawalHold
+		}
+		return err
+	})
+	if err != nil {
+		return entity.Hold{}, err
+	}
+
+	return hold, nil
+}
+
+// expireHolds releases every hold past its expiry, it runs as a schedule.
+func (t Transaction) expireHolds(trx *db.Transaction) error {
+	holds, err := t.holdRepo.GetExpired(t.db.Now(), trx)
+	if err != nil {
+		return err
+	}
+
+	for _, hold := range holds {
+		if _, err := t.releaseHold(trx, hold.ID, entity.HoldStatusExpired); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t Transaction) handleHoldExpiry(trx *db.Transaction, _ db.Schedule) error {
+	return t.expireHolds(trx)
+}
+
+func (t Transaction) releaseHold(trx *db.Transaction, holdID string, status entity.HoldStatus) (entity.Hold, error) {
+	hold, err := t.holdRepo.FindById(holdID, trx)
+	if err == db.ErrNotFound {
+		return entity.Hold{}, ErrHoldNotFound
+	}
+	if err != nil {
+		return entity.Hold{}, err
+	}
+	if hold.Status != entity.HoldStatusActive {
+		return entity.Hold{}, ErrHoldNotActive
+	}
+
+	wallet, err := t.walletRepo.FindById(hold.WalletID, trx)
+	if err != nil {
+		return entity.Hold{}, err
+	}
+
+	wallet.Held, err = debit(wallet.Held, hold.Amount)
+	if err != nil {
+		return entity.Hold{}, err
+	}
+	if err := t.walletRepo.Put(wallet, trx); err != nil {
+		return entity.Hold{}, err
+	}
+
+	hold.Status = status
+	return hold, t.holdRepo.Put(hold, trx)
+}
+
+// activeHold loads a hold that can still be captured, together with its
+// wallet. Expired holds waiting for the expiry schedule can't.
+func (t Transaction) activeHold(trx *db.Transaction, holdID string) (entity.Hold, entity.Wallet, error) {
+	hold, err := t.holdRepo.FindById(holdID, trx)
+	if err == db.ErrNotFound {
+		return entity.Hold{}, entity.Wallet{}, ErrHoldNotFound
+	}
+	if err != nil {
+		return entity.Hold{}, entity.Wallet{}, err
+	}
+	if hold.Status != entity.HoldStatusActive || (!hold.ExpiresAt.IsZero() && !hold.ExpiresAt.After(t.db.Now())) {
+		return entity.Hold{}, entity.Wallet{}, ErrHoldNotActive
+	}
+
+	wallet, err := t.walletRepo.FindById(hold.WalletID, trx)
+	if err != nil {
+		return entity.Hold{}, entity.Wallet{}, err
+	}
+
+	return hold, wallet, nil
+}
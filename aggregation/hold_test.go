@@ -0,0 +1,126 @@
+package aggregation_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHoldCapture(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	holdRepo := repository.NewHold(dbInstance)
+
+	payerID := uuid.New().String()
+	merchantID := uuid.New().String()
+	userRepo.Put(entity.User{ID: payerID, Email: "payer@example.com"})
+	userRepo.Put(entity.User{ID: merchantID, Email: "merchant@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: payerID})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: merchantID})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+
+	_, err := transaction.TopUp(payerID, 100)
+	assert.NoError(t, err)
+
+	_, err = transaction.Hold(payerID, 60, time.Hour)
+	assert.ErrorIs(t, err, aggregation.ErrHoldTargetRequired)
+
+	hold, err := transaction.Hold(payerID, 60, time.Hour, aggregation.WithHoldTarget(merchantID))
+	assert.NoError(t, err)
+
+	wallet, _ := walletRepo.FindByUserID(payerID)
+	assert.Equal(t, 100, wallet.Balance)
+	assert.Equal(t, 60, wallet.Held)
+	assert.Equal(t, 40, wallet.Available())
+
+	// held money can't be spent twice
+	_, err = transaction.Transfer(payerID, merchantID, 50)
+	assert.ErrorIs(t, err, aggregation.ErrInsuficientFound)
+	_, err = transaction.Hold(payerID, 50, time.Hour, aggregation.WithHoldTarget(merchantID))
+	assert.ErrorIs(t, err, aggregation.ErrInsuficientFound)
+
+	_, err = transaction.Capture(hold.ID, 70)
+	assert.ErrorIs(t, err, aggregation.ErrCaptureOverHold)
+
+	// partial capture releases the rest
+	trx, err := transaction.Capture(hold.ID, 45)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.TransactionTypeCapture, trx.Type)
+
+	wallet, _ = walletRepo.FindByUserID(payerID)
+	assert.Equal(t, 55, wallet.Balance)
+	assert.Equal(t, 0, wallet.Held)
+	merchant, _ := walletRepo.FindByUserID(merchantID)
+	assert.Equal(t, 45, merchant.Balance)
+
+	stored, _ := holdRepo.FindById(hold.ID)
+	assert.Equal(t, entity.HoldStatusCaptured, stored.Status)
+	assert.Equal(t, 45, stored.Captured)
+	assert.Equal(t, trx.ID, stored.TransactionID)
+
+	_, err = transaction.Capture(hold.ID, 10)
+	assert.ErrorIs(t, err, aggregation.ErrHoldNotActive)
+	_, err = transaction.Void(hold.ID)
+	assert.ErrorIs(t, err, aggregation.ErrHoldNotActive)
+
+	// void gives everything back
+	hold, err = transaction.Hold(payerID, 30, time.Hour, aggregation.WithHoldTarget(merchantID))
+	assert.NoError(t, err)
+	voided, err := transaction.Void(hold.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.HoldStatusVoided, voided.Status)
+
+	wallet, _ = walletRepo.FindByUserID(payerID)
+	assert.Equal(t, 55, wallet.Available())
+
+	discrepancies, err := aggregation.NewLedger(walletRepo, dbInstance).Reconcile()
+	assert.NoError(t, err)
+	assert.Empty(t, discrepancies)
+}
+
+func TestHoldExpiry(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	dbInstance := setupDB(db.WithClock(clock))
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	holdRepo := repository.NewHold(dbInstance)
+
+	payerID := uuid.New().String()
+	merchantID := uuid.New().String()
+	userRepo.Put(entity.User{ID: payerID, Email: "payer@example.com"})
+	userRepo.Put(entity.User{ID: merchantID, Email: "merchant@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: payerID, Balance: 100})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: merchantID})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+
+	hold, err := transaction.Hold(payerID, 80, 15*time.Minute, aggregation.WithHoldTarget(merchantID))
+	assert.NoError(t, err)
+
+	clock.Advance(15 * time.Minute)
+
+	// expired holds can't be captured even before the schedule ran
+	_, err = transaction.Capture(hold.ID, 80)
+	assert.ErrorIs(t, err, aggregation.ErrHoldNotActive)
+
+	_, err = dbInstance.RunDueSchedules()
+	assert.NoError(t, err)
+
+	stored, _ := holdRepo.FindById(hold.ID)
+	assert.Equal(t, entity.HoldStatusExpired, stored.Status)
+
+	wallet, _ := walletRepo.FindByUserID(payerID)
+	assert.Equal(t, 100, wallet.Available())
+}
@@ -0,0 +1,92 @@
+package aggregation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+var ErrIdempotencyKeyReused = errors.New("idempotency key already used with a different payload")
+
+// idempotencyKind is what an idempotency key remembers for a result of type
+// T: the field keeping its ID and how to load it back.
+type idempotencyKind[T any] struct {
+	ref  func(*entity.IdempotencyKey) *string
+	id   func(T) string
+	find func(t Transaction, trx *db.Transaction, id string) (T, error)
+}
+
+var transactionResult = idempotencyKind[entity.Transaction]{
+	ref: func(k *entity.IdempotencyKey) *string { return &k.TransactionID },
+	id:  func(record entity.Transaction) string { return record.ID },
+	find: func(t Transaction, trx *db.Transaction, id string) (entity.Transaction, error) {
+		return t.transactionRepo.FindById(id, trx)
+	},
+}
+
+var batchResult = idempotencyKind[entity.Batch]{
+	ref: func(k *entity.IdempotencyKey) *string { return &k.BatchID },
+	id:  func(batch entity.Batch) string { return batch.ID },
+	find: func(t Transaction, trx *db.Transaction, id string) (entity.Batch, error) {
+		return t.batchRepo.FindById(id, trx)
+	},
+}
+
+var withdrawalResult = idempotencyKind[entity.Withdrawal]{
+	ref: func(k *entity.IdempotencyKey) *string { return &k.WithdrawalID },
+	id:  func(withdrawal entity.Withdrawal) string { return withdrawal.ID },
+	find: func(t Transaction, trx *db.Transaction, id string) (entity.Withdrawal, error) {
+		return t.withdrawalRepo.FindById(id, trx)
+	},
+}
+
+// idempotent runs f once per idempotency key. The lookup runs in the same
+// transaction as the money movement, so two retries racing each other are
+// serialized by the event loop, and a replay returns the original result. A
+// key remembering another kind of result was used for another request.
+func idempotent[T any](t Transaction, trx *db.Transaction, userID string, o transactionOptions, fingerprint string, kind idempotencyKind[T], f func() (T, error)) (T, error) {
+	var zero T
+	if o.idempotencyKey == "" {
+		return f()
+	}
+
+	existing, err := t.idempotencyKeyRepo.FindByKey(userID, o.idempotencyKey, trx)
+	if err == nil {
+		id := *kind.ref(&existing)
+		if existing.Fingerprint != fingerprint || id == "" {
+			return zero, ErrIdempotencyKeyReused
+		}
+		return kind.find(t, trx, id)
+	}
+	if err != db.ErrNotFound {
+		return zero, err
+	}
+
+	result, err := f()
+	if err != nil {
+		return zero, err
+	}
+
+	key := entity.IdempotencyKey{
+		ID:          entity.IdempotencyKeyID(userID, o.idempotencyKey),
+		UserID:      userID,
+		Key:         o.idempotencyKey,
+		Fingerprint: fingerprint,
+		CreatedAt:   t.db.Now(),
+	}
+	*kind.ref(&key) = kind.id(result)
+	if err := t.idempotencyKeyRepo.Put(key, trx); err != nil {
+		return zero, err
+	}
+
+	return result, nil
+}
+
+func requestFingerprint(operation string, payload ...any) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s%v", operation, payload)))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,149 @@
+package aggregation
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+)
+
+var ErrUnbalancedPosting = errors.New("ledger posting doesn't balance")
+var ErrLedgerUnbalanced = errors.New("ledger doesn't balance")
+
+// Ledger is the double entry book behind the wallets. Every money movement
+// posts entries that sum to zero, wallet balances are kept next to it and
+// can be reconciled against the postings.
+type Ledger struct {
+	walletRepo      *repository.Wallet
+	ledgerEntryRepo *repository.LedgerEntry
+	db              *db.Instance
+}
+
+// Leg is one side of a posting, see entity.LedgerEntry for the sign.
+type Leg struct {
+	AccountID string
+	Amount    int
+}
+
+type TrialBalance struct {
+	Accounts map[string]int `json:"accounts"`
+	Total    int            `json:"total"`
+}
+
+// Discrepancy is a wallet whose balance doesn't match its postings.
+type Discrepancy struct {
+	WalletID      string `json:"wallet_id"`
+	WalletBalance int    `json:"wallet_balance"`
+	LedgerBalance int    `json:"ledger_balance"`
+}
+
+func NewLedger(walletRepo *repository.Wallet, db *db.Instance) *Ledger {
+	return &Ledger{
+		walletRepo:      walletRepo,
+		ledgerEntryRepo: repository.NewLedgerEntry(db),
+		db:              db,
+	}
+}
+
+// post writes a balanced posting inside an open transaction.
+func (l *Ledger) post(trx *db.Transaction, record entity.Transaction, legs ...Leg) error {
+	sum := 0
+	for _, leg := range legs {
+		sum += leg.Amount
+	}
+	if sum != 0 {
+		return fmt.Errorf("%w: transaction %s is off by %d", ErrUnbalancedPosting, record.ID, sum)
+	}
+
+	for _, leg := range legs {
+		if leg.Amount == 0 {
+			continue
+		}
+
+		if err := l.ledgerEntryRepo.Put(entity.LedgerEntry{
+			ID:            uuid.New().String(),
+			TransactionID: record.ID,
+			AccountID:     leg.AccountID,
+			Amount:        leg.Amount,
+			CreatedAt:     record.CreatedAt,
+		}, trx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TrialBalance sums every account, the total has to be zero. It scans the
+// whole ledger, so it runs in the background lane.
+func (l *Ledger) TrialBalance() (TrialBalance, error) {
+	result := TrialBalance{Accounts: map[string]int{}}
+
+	err := l.db.Transaction(func(trx *db.Transaction) error {
+		entries, err := l.ledgerEntryRepo.All(trx)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			result.Accounts[entry.AccountID] += entry.Amount
+			result.Total += entry.Amount
+		}
+		return nil
+	}, db.OnLane(db.LaneBackground))
+	if err != nil {
+		return TrialBalance{}, err
+	}
+
+	if result.Total != 0 {
+		return result, fmt.Errorf("%w: total is %d", ErrLedgerUnbalanced, result.Total)
+	}
+
+	return result, nil
+}
+
+// Reconcile lists the wallets whose balance doesn't match the sum of their
+// postings.
+func (l *Ledger) Reconcile() ([]Discrepancy, error) {
+	discrepancies := []Discrepancy{}
+
+	err := l.db.Transaction(func(trx *db.Transaction) error {
+		entries, err := l.ledgerEntryRepo.All(trx)
+		if err != nil {
+			return err
+		}
+
+		balances := map[string]int{}
+		for _, entry := range entries {
+			balances[entry.AccountID] += entry.Amount
+		}
+
+		wallets, err := l.walletRepo.All(trx)
+		if err != nil {
+			return err
+		}
+
+		for _, wallet := range wallets {
+			if wallet.Balance != balances[wallet.ID] {
+				discrepancies = append(discrepancies, Discrepancy{
+					WalletID:      wallet.ID,
+					WalletBalance: wallet.Balance,
+					LedgerBalance: balances[wallet.ID],
+				})
+			}
+		}
+		return nil
+	}, db.OnLane(db.LaneBackground))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(discrepancies, func(i, j int) bool {
+		return discrepancies[i].WalletID < discrepancies[j].WalletID
+	})
+	return discrepancies, nil
+}
@@ -0,0 +1,79 @@
+package aggregation_test
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLedgerTrialBalance(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+
+	sourceUserID := uuid.New().String()
+	targetUserID := uuid.New().String()
+	userRepo.Put(entity.User{ID: sourceUserID, Email: "source@example.com"})
+	userRepo.Put(entity.User{ID: targetUserID, Email: "target@example.com"})
+
+	sourceWallet := entity.Wallet{ID: uuid.New().String(), UserID: sourceUserID}
+	targetWallet := entity.Wallet{ID: uuid.New().String(), UserID: targetUserID}
+	walletRepo.Put(sourceWallet)
+	walletRepo.Put(targetWallet)
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+	ledger := aggregation.NewLedger(walletRepo, dbInstance)
+
+	_, err := transaction.TopUp(sourceUserID, 300)
+	assert.NoError(t, err)
+	_, err = transaction.Transfer(sourceUserID, targetUserID, 120)
+	assert.NoError(t, err)
+
+	// a failed transfer posts nothing
+	_, err = transaction.Transfer(targetUserID, sourceUserID, 1000)
+	assert.ErrorIs(t, err, aggregation.ErrInsuficientFound)
+
+	balance, err := ledger.TrialBalance()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, balance.Total)
+	assert.Equal(t, -300, balance.Accounts[entity.AccountTopUpClearing])
+	assert.Equal(t, 180, balance.Accounts[sourceWallet.ID])
+	assert.Equal(t, 120, balance.Accounts[targetWallet.ID])
+
+	discrepancies, err := ledger.Reconcile()
+	assert.NoError(t, err)
+	assert.Empty(t, discrepancies)
+}
+
+func TestLedgerReconcile(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+
+	userID := uuid.New().String()
+	userRepo.Put(entity.User{ID: userID, Email: "test@example.com"})
+
+	walletID := uuid.New().String()
+	walletRepo.Put(entity.Wallet{ID: walletID, UserID: userID})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+	ledger := aggregation.NewLedger(walletRepo, dbInstance)
+
+	_, err := transaction.TopUp(userID, 50)
+	assert.NoError(t, err)
+
+	// balance changed behind the ledger's back
+	walletRepo.Put(entity.Wallet{ID: walletID, UserID: userID, Balance: 80})
+
+	discrepancies, err := ledger.Reconcile()
+	assert.NoError(t, err)
+	assert.Equal(t, []aggregation.Discrepancy{{WalletID: walletID, WalletBalance: 80, LedgerBalance: 50}}, discrepancies)
+}
@@ -0,0 +1,202 @@
+package aggregation
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+const (
+	LimitPerTransaction  = "per_transaction"
+	LimitDailyOutgoing   = "daily_outgoing"
+	LimitMonthlyOutgoing = "monthly_outgoing"
+	LimitHourlyTransfers = "hourly_transfers"
+	LimitMaxBalance      = "max_balance"
+)
+
+// Limits caps what a user can do, amounts are in the currency of the wallet
+// they apply to. Zero means no limit.
+type Limits struct {
+	PerTransaction  int `json:"per_transaction"`
+	DailyOutgoing   int `json:"daily_outgoing"`   // calendar day in UTC
+	MonthlyOutgoing int `json:"monthly_outgoing"` // calendar month in UTC
+	HourlyTransfers int `json:"hourly_transfers"` // outgoing transfers in the last hour
+	MaxBalance      int `json:"max_balance"`      // all wallets of the currency together
+}
+
+// LimitPolicy picks the limits of a user: their own when set, else the ones
+// of their tier, else the default.
+type LimitPolicy struct {
+	Default Limits            `json:"default"`
+	Tiers   map[string]Limits `json:"tiers"`
+	Users   map[string]Limits `json:"users"`
+}
+
+func (p LimitPolicy) For(user entity.User) Limits {
+	if limits, ok := p.Users[user.ID]; ok {
+		return limits
+	}
+	if limits, ok := p.Tiers[user.Tier]; ok {
+		return limits
+	}
+	return p.Default
+}
+
+func (p LimitPolicy) isZero() bool {
+	return p.Default == Limits{} && len(p.Tiers) == 0 && len(p.Users) == 0
+}
+
+// LimitError tells which limit was hit and when it resets, ResetsAt is zero
+// for limits that don't reset with time.
+type LimitError struct {
+	Limit    string
+	Max      int
+	ResetsAt time.Time
+}
+
+func (e *LimitError) Error() string {
+	if e.ResetsAt.IsZero() {
+		return fmt.Sprintf("%s: %s of %d", ErrLimitExceeded, e.Limit, e.Max)
+	}
+	return fmt.Sprintf("%s: %s of %d, resets at %s", ErrLimitExceeded, e.Limit, e.Max, e.ResetsAt.Format(time.RFC3339))
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// WithLimits enforces spending limits and velocity controls.
+func WithLimits(policy LimitPolicy) Option {
+	return func(t *Transaction) {
+		t.limits = policy
+	}
+}
+
+// checkOutgoing runs inside the transaction moving the money, so the
+// history it sums can't change underneath. System wallets like the escrow
+// accounts have no limits.
+func (t Transaction) checkOutgoing(trx *db.Transaction, wallet entity.Wallet, amount int) error {
+	if t.limits.isZero() || wallet.UserID == entity.SystemUserID {
+		return nil
+	}
+
+	user, err := t.userRepo.FindById(wallet.UserID, trx)
+	if err != nil {
+		return err
+	}
+
+	limits := t.limits.For(user)
+	if limits.PerTransaction > 0 && amount > limits.PerTransaction {
+		return &LimitError{Limit: LimitPerTransaction, Max: limits.PerTransaction}
+	}
+
+	if limits.DailyOutgoing == 0 && limits.MonthlyOutgoing == 0 && limits.HourlyTransfers == 0 {
+		return nil
+	}
+
+	wallets, err := t.walletsInCurrency(trx, user.ID, wallet.Currency)
+	if err != nil {
+		return err
+	}
+
+	mutations, err := t.mutationRepo.GetByUserID(user.ID, trx)
+	if err != nil && err != db.ErrNotFound {
+		return err
+	}
+
+	now := t.db.Now().UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	hourAgo := now.Add(-time.Hour)
+
+	daily, monthly, hourly := amount, amount, 1
+	oldestInHour := now
+	for _, mutation := range mutations {
+		// moves between own pockets don't leave the user
+		if mutation.Type != entity.MutationTypeDebit || mutation.CounterpartyUserID == user.ID {
+			continue
+		}
+		if _, ok := wallets[mutation.WalletID]; !ok {
+			continue
+		}
+
+		if !mutation.CreatedAt.Before(dayStart) {
+			daily += mutation.Amount
+		}
+		if !mutation.CreatedAt.Before(monthStart) {
+			monthly += mutation.Amount
+		}
+		if mutation.CreatedAt.After(hourAgo) {
+			hourly++
+			if mutation.CreatedAt.Before(oldestInHour) {
+				oldestInHour = mutation.CreatedAt
+			}
+		}
+	}
+
+	if limits.DailyOutgoing > 0 && daily > limits.DailyOutgoing {
+		return &LimitError{Limit: LimitDailyOutgoing, Max: limits.DailyOutgoing, ResetsAt: dayStart.AddDate(0, 0, 1)}
+	}
+	if limits.MonthlyOutgoing > 0 && monthly > limits.MonthlyOutgoing {
+		return &LimitError{Limit: LimitMonthlyOutgoing, Max: limits.MonthlyOutgoing, ResetsAt: monthStart.AddDate(0, 1, 0)}
+	}
+	if limits.HourlyTransfers > 0 && hourly > limits.HourlyTransfers {
+		return &LimitError{Limit: LimitHourlyTransfers, Max: limits.HourlyTransfers, ResetsAt: oldestInHour.Add(time.Hour).UTC()}
+	}
+
+	return nil
+}
+
+// checkIncoming keeps the user under their max balance once incoming lands
+// in a wallet of the currency.
+func (t Transaction) checkIncoming(trx *db.Transaction, wallet entity.Wallet, incoming int) error {
+	if t.limits.isZero() || wallet.UserID == entity.SystemUserID {
+		return nil
+	}
+
+	user, err := t.userRepo.FindById(wallet.UserID, trx)
+	if err != nil {
+		return err
+	}
+
+	limits := t.limits.For(user)
+	if limits.MaxBalance == 0 {
+		return nil
+	}
+
+	wallets, err := t.walletsInCurrency(trx, user.ID, wallet.Currency)
+	if err != nil {
+		return err
+	}
+
+	total := incoming
+	for _, w := range wallets {
+		total += w.Balance
+	}
+
+	if total > limits.MaxBalance {
+		return &LimitError{Limit: LimitMaxBalance, Max: limits.MaxBalance}
+	}
+
+	return nil
+}
+
+func (t Transaction) walletsInCurrency(trx *db.Transaction, userID, currency string) (map[string]entity.Wallet, error) {
+	wallets, err := t.walletRepo.GetByUserID(userID, trx)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := map[string]entity.Wallet{}
+	for _, wallet := range wallets {
+		if wallet.InCurrency(currency) {
+			filtered[wallet.ID] = wallet
+		}
+	}
+	return filtered, nil
+}
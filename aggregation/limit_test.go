@@ -0,0 +1,138 @@
+package aggregation_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimits(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 31, 22, 0, 0, 0, time.UTC))
+	dbInstance := setupDB(db.WithClock(clock))
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+
+	sourceUserID := uuid.New().String()
+	targetUserID := uuid.New().String()
+	vipUserID := uuid.New().String()
+	userRepo.Put(entity.User{ID: sourceUserID, Email: "source@example.com"})
+	userRepo.Put(entity.User{ID: targetUserID, Email: "target@example.com"})
+	userRepo.Put(entity.User{ID: vipUserID, Email: "vip@example.com", Tier: "vip"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: sourceUserID, Balance: 10000})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: targetUserID})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: vipUserID, Balance: 10000})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance,
+		aggregation.WithLimits(aggregation.LimitPolicy{
+			Default: aggregation.Limits{
+				PerTransaction:  500,
+				DailyOutgoing:   700,
+				MonthlyOutgoing: 900,
+				HourlyTransfers: 3,
+			},
+			Tiers: map[string]aggregation.Limits{
+				"vip": {PerTransaction: 5000},
+			},
+			Users: map[string]aggregation.Limits{
+				targetUserID: {MaxBalance: 1000},
+			},
+		}),
+	)
+
+	var limitErr *aggregation.LimitError
+
+	_, err := transaction.Transfer(sourceUserID, targetUserID, 600)
+	assert.ErrorIs(t, err, aggregation.ErrLimitExceeded)
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, aggregation.LimitPerTransaction, limitErr.Limit)
+	assert.True(t, limitErr.ResetsAt.IsZero())
+
+	_, err = transaction.Transfer(sourceUserID, targetUserID, 400)
+	assert.NoError(t, err)
+
+	_, err = transaction.Transfer(sourceUserID, targetUserID, 400)
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, aggregation.LimitDailyOutgoing, limitErr.Limit)
+	assert.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), limitErr.ResetsAt)
+
+	// moves between own pockets don't count
+	savings, err := transaction.CreatePocket(sourceUserID, "savings", "IDR")
+	assert.NoError(t, err)
+	source, _ := walletRepo.FindByUserID(sourceUserID)
+	_, err = transaction.Move(sourceUserID, source.ID, savings.ID, 450)
+	assert.NoError(t, err)
+
+	_, err = transaction.Transfer(sourceUserID, targetUserID, 100)
+	assert.NoError(t, err)
+	_, err = transaction.Transfer(sourceUserID, targetUserID, 100)
+	assert.NoError(t, err)
+
+	// three transfers in the hour
+	_, err = transaction.Transfer(sourceUserID, targetUserID, 50)
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, aggregation.LimitHourlyTransfers, limitErr.Limit)
+	assert.Equal(t, time.Date(2024, 1, 31, 23, 0, 0, 0, time.UTC), limitErr.ResetsAt)
+
+	// next day, still the same month
+	clock.Advance(3 * time.Hour)
+	_, err = transaction.Transfer(sourceUserID, targetUserID, 500)
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, aggregation.LimitMaxBalance, limitErr.Limit, "the target holds 600")
+
+	// the vip tier has its own limits, the target's max balance still holds
+	_, err = transaction.Transfer(vipUserID, targetUserID, 401)
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, aggregation.LimitMaxBalance, limitErr.Limit)
+	_, err = transaction.Transfer(vipUserID, targetUserID, 400)
+	assert.NoError(t, err)
+
+	_, err = transaction.TopUp(targetUserID, 1)
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, aggregation.LimitMaxBalance, limitErr.Limit)
+}
+
+func TestMonthlyLimit(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 30, 12, 0, 0, 0, time.UTC))
+	dbInstance := setupDB(db.WithClock(clock))
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+
+	sourceUserID := uuid.New().String()
+	targetUserID := uuid.New().String()
+	userRepo.Put(entity.User{ID: sourceUserID, Email: "source@example.com"})
+	userRepo.Put(entity.User{ID: targetUserID, Email: "target@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: sourceUserID, Balance: 10000})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: targetUserID})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance,
+		aggregation.WithLimits(aggregation.LimitPolicy{
+			Default: aggregation.Limits{DailyOutgoing: 600, MonthlyOutgoing: 1000},
+		}),
+	)
+
+	_, err := transaction.Transfer(sourceUserID, targetUserID, 600)
+	assert.NoError(t, err)
+	clock.Advance(24 * time.Hour)
+	_, err = transaction.Transfer(sourceUserID, targetUserID, 400)
+	assert.NoError(t, err)
+
+	var limitErr *aggregation.LimitError
+	_, err = transaction.Transfer(sourceUserID, targetUserID, 1)
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, aggregation.LimitMonthlyOutgoing, limitErr.Limit)
+	assert.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), limitErr.ResetsAt)
+
+	clock.Advance(24 * time.Hour)
+	_, err = transaction.Transfer(sourceUserID, targetUserID, 1)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,467 @@
+package aggregation
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+var ErrNotMerchant = errors.New("user is not a merchant")
+var ErrPaymentIntentNotFound = errors.New("payment intent not found")
+var ErrPaymentIntentNotPending = errors.New("payment intent is no longer pending")
+var ErrDuplicateReference = errors.New("reference already used by another payment intent")
+
+// paymentIntentExpirySchedule expires every pending intent past its expiry,
+// a missed schedule is caught up by the next one like holdExpirySchedule.
+const paymentIntentExpirySchedule = "payment_intent.expire"
+
+// paymentCallbackSchedule tells merchants about their paid intents, it is
+// kept with the payment so a restart doesn't lose the callback.
+const paymentCallbackSchedule = "payment_intent.callback"
+
+// CallbackRetryInterval is the wait before a callback that failed is sent
+// again, it doubles with every attempt up to an hour. After
+// MaxCallbackAttempts the callback is given up, ResendPaymentCallback
+// queues it again.
+var CallbackRetryInterval = 30 * time.Second
+var MaxCallbackAttempts = 8
+
+const maxCallbackRetryInterval = time.Hour
+
+// PaymentCallback tells a merchant one of its payment intents was paid. It
+// runs after the payment committed, outside the event loop, and fails when
+// the merchant couldn't be told. A callback can be sent more than once.
+type PaymentCallback interface {
+	PaymentCompleted(merchant entity.User, intent entity.PaymentIntent) error
+}
+
+// WithPaymentCallback tells merchants about paid payment intents.
+func WithPaymentCallback(callback PaymentCallback) Option {
+	return func(t *Transaction) {
+		t.paymentCallback = callback
+	}
+}
+
+// Settlement is what a merchant holds in one currency and what it was paid
+// through payment intents.
+type Settlement struct {
+	Currency  string `json:"currency"`
+	Balance   int    `json:"balance"`
+	Available int    `json:"available"`
+	Payments  int    `json:"payments"` // paid intents
+	Paid      int    `json:"paid"`     // amount of the paid intents
+}
+
+// CreatePaymentIntent opens a checkout of the merchant for amount in the
+// currency, customers can pay it until the expiry.
+func (t Transaction) CreatePaymentIntent(merchantID string, amount int, expiry time.Duration, opts ...TransactionOption) (entity.PaymentIntent, error) {
+	o := newTransactionOptions(opts)
+	if err := validateAmount(amount); err != nil {
+		return entity.PaymentIntent{}, err
+	}
+
+	var intent entity.PaymentIntent
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		merchant, err := t.merchant(trx, merchantID)
+		if err != nil {
+			return err
+		}
+		if merchant.IsClosed() {
+			return ErrAccountClosed
+		}
+
+		// the merchant looks its orders up by reference
+		if o.reference != "" {
+			intents, err := t.paymentIntentRepo.GetByMerchantID(merchantID, "", trx)
+			if err != nil {
+				return err
+			}
+			for _, existing := range intents {
+				if existing.Reference == o.reference {
+					return ErrDuplicateReference
+				}
+			}
+		}
+
+		now := t.db.Now()
+		intent = entity.PaymentIntent{
+			ID:          uuid.New().String(),
+			MerchantID:  merchantID,
+			Amount:      amount,
+			Currency:    entity.NormalizeCurrency(o.currency),
+			Reference:   o.reference,
+			Description: o.note,
+			Status:      entity.PaymentIntentStatusPending,
+			ExpiresAt:   now.Add(expiry),
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		if err := t.paymentIntentRepo.Put(intent, trx); err != nil {
+			return err
+		}
+
+		trx.Schedule(intent.ExpiresAt, paymentIntentExpirySchedule, t.expirePaymentIntents)
+		return nil
+	})
+	if err != nil {
+		return entity.PaymentIntent{}, err
+	}
+
+	return intent, nil
+}
+
+// PayPaymentIntent pays a pending intent from the customer's wallet. The
+// payment, the intent turning paid and its callback being queued commit
+// together, the merchant is told afterwards. A payment held by the risk rules pays the intent once the
+// review is approved.
+func (t Transaction) PayPaymentIntent(customerID, intentID string, opts ...TransactionOption) (entity.Transaction, error) {
+	o := newTransactionOptions(opts)
+
+	var intent entity.PaymentIntent
+	var result entity.Transaction
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		intent, err = t.pendingPaymentIntent(trx, intentID)
+		if err != nil {
+			return err
+		}
+		if intent.MerchantID == customerID {
+			return ErrSameWallet
+		}
+
+		o = paymentIntentOptions(intent, o)
+		result, err = t.assessedTransfer(trx, customerID, intent.MerchantID, intent.Amount, o)
+		if err != nil {
+			return err
+		}
+
+		intent, err = t.payPaymentIntent(trx, intent.ID, customerID, result)
+		return err
+	})
+
+	var riskErr *RiskError
+	if errors.As(err, &riskErr) {
+		if err := t.db.Transaction(func(trx *db.Transaction) error {
+			return t.recordRisk(trx, riskErr, customerID, intent.MerchantID, intent.Amount, o, "")
+		}); err != nil {
+			return entity.Transaction{}, err
+		}
+	}
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	return result, nil
+}
+
+// CancelPaymentIntent withdraws a pending intent of the merchant.
+func (t Transaction) CancelPaymentIntent(merchantID, intentID string) (entity.PaymentIntent, error) {
+	var intent entity.PaymentIntent
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		intent, err = t.pendingPaymentIntent(trx, intentID)
+		if err != nil {
+			return err
+		}
+		if intent.MerchantID != merchantID {
+			return ErrPaymentIntentNotFound
+		}
+
+		intent.Status = entity.PaymentIntentStatusCancelled
+		intent.UpdatedAt = t.db.Now()
+		return t.paymentIntentRepo.Put(intent, trx)
+	})
+	if err != nil {
+		return entity.PaymentIntent{}, err
+	}
+
+	return intent, nil
+}
+
+// ResendPaymentCallback queues the callback of a paid intent again, say
+// after the merchant's endpoint was down for longer than the retries.
+func (t Transaction) ResendPaymentCallback(merchantID, intentID string) (entity.PaymentIntent, error) {
+	var intent entity.PaymentIntent
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		intent, err = t.paymentIntentRepo.FindById(intentID, trx)
+		if err == db.ErrNotFound || (err == nil && intent.MerchantID != merchantID) {
+			return ErrPaymentIntentNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if intent.Status != entity.PaymentIntentStatusPaid {
+			return ErrPaymentIntentNotPending
+		}
+
+		t.queueCallback(trx, &intent)
+		intent.UpdatedAt = t.db.Now()
+		return t.paymentIntentRepo.Put(intent, trx)
+	})
+	if err != nil {
+		return entity.PaymentIntent{}, err
+	}
+
+	return intent, nil
+}
+
+// MerchantSettlement sums up the merchant's main wallets and the intents
+// paid into them, one entry per currency.
+func (t Transaction) MerchantSettlement(merchantID string) ([]Settlement, error) {
+	settlements := []Settlement{}
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		if _, err := t.merchant(trx, merchantID); err != nil {
+			return err
+		}
+
+		wallets, err := t.walletRepo.GetByUserID(merchantID, trx)
+		if err != nil {
+			return err
+		}
+		intents, err := t.paymentIntentRepo.GetByMerchantID(merchantID, entity.PaymentIntentStatusPaid, trx)
+		if err != nil {
+			return err
+		}
+
+		for _, wallet := range wallets {
+			if wallet.Name != entity.PrimaryWalletName {
+				continue
+			}
+
+			settlement := Settlement{
+				Currency:  entity.NormalizeCurrency(wallet.Currency),
+				Balance:   wallet.Balance,
+				Available: wallet.Available(),
+			}
+			for _, intent := range intents {
+				if intent.Currency == settlement.Currency {
+					settlement.Payments++
+					settlement.Paid += intent.Amount
+				}
+			}
+			settlements = append(settlements, settlement)
+		}
+		return nil
+	}, db.OnLane(db.LaneBackground))
+	if err != nil {
+		return nil, err
+	}
+
+	return settlements, nil
+}
+
+// payPaymentIntent marks a pending intent paid by the customer's
+// transaction.
+func (t Transaction) payPaymentIntent(trx *db.Transaction, intentID, customerID string, record entity.Transaction) (entity.PaymentIntent, error) {
+	intent, err := t.pendingPaymentIntent(trx, intentID)
+	if err != nil {
+		return entity.PaymentIntent{}, err
+	}
+
+	now := t.db.Now()
+	intent.Status = entity.PaymentIntentStatusPaid
+	intent.CustomerID = customerID
+	intent.TransactionID = record.ID
+	intent.PaidAt = now
+	intent.UpdatedAt = now
+	t.queueCallback(trx, &intent)
+	if err := t.paymentIntentRepo.Put(intent, trx); err != nil {
+		return entity.PaymentIntent{}, err
+	}
+
+	return intent, nil
+}
+
+// queueCallback schedules telling the merchant about the paid intent, kept
+// only when the caller's transaction commits.
+func (t Transaction) queueCallback(trx *db.Transaction, intent *entity.PaymentIntent) {
+	if t.paymentCallback == nil {
+		return
+	}
+
+	now := t.db.Now()
+	intent.CallbackAttempts = 0
+	intent.NextCallbackAt = now
+	trx.Schedule(now, paymentCallbackSchedule, t.dispatchCallbacks)
+}
+
+// dispatchCallbacks runs as a schedule. It claims every callback due by
+// counting the attempt and pushing NextCallbackAt back, then sends it
+// outside of the event loop. A claim whose outcome never gets recorded runs
+// out and the callback is sent again. A callback out of attempts is given
+// up, its last error stays on the intent.
+func (t Transaction) dispatchCallbacks(trx *db.Transaction) error {
+	if t.paymentCallback == nil {
+		return nil
+	}
+
+	now := t.db.Now()
+	intents, err := t.paymentIntentRepo.GetCallbackQueued(trx)
+	if err != nil {
+		return err
+	}
+
+	var retryAt time.Time
+	for _, intent := range intents {
+		if intent.NextCallbackAt.After(now) {
+			if retryAt.IsZero() || intent.NextCallbackAt.Before(retryAt) {
+				retryAt = intent.NextCallbackAt
+			}
+			continue
+		}
+
+		if intent.CallbackAttempts >= MaxCallbackAttempts {
+			intent.NextCallbackAt = time.Time{}
+		} else {
+			intent.CallbackAttempts++
+			intent.NextCallbackAt = now.Add(retryBackoff(CallbackRetryInterval, maxCallbackRetryInterval, intent.CallbackAttempts))
+		}
+		intent.UpdatedAt = now
+		if err := t.paymentIntentRepo.Put(intent, trx); err != nil {
+			return err
+		}
+		if intent.NextCallbackAt.IsZero() {
+			continue
+		}
+
+		if retryAt.IsZero() || intent.NextCallbackAt.Before(retryAt) {
+			retryAt = intent.NextCallbackAt
+		}
+
+		// what sendCallback can't record is sent again once the claim runs out
+		go t.sendCallback(intent.ID, intent.CallbackAttempts)
+	}
+
+	if !retryAt.IsZero() {
+		trx.Schedule(retryAt, paymentCallbackSchedule, t.dispatchCallbacks)
+	}
+
+	return nil
+}
+
+func (t Transaction) handlePaymentCallback(trx *db.Transaction, _ db.Schedule) error {
+	return t.dispatchCallbacks(trx)
+}
+
+// sendCallback runs the payment callback of a claimed intent and keeps its
+// outcome on the intent. Its first transaction is queued behind the one
+// claiming it, so nothing is sent when the claim rolled back or was taken
+// over.
+func (t Transaction) sendCallback(intentID string, attempt int) error {
+	var intent entity.PaymentIntent
+	var merchant entity.User
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		intent, err = t.paymentIntentRepo.FindById(intentID, trx)
+		if err != nil {
+			return err
+		}
+		if intent.NextCallbackAt.IsZero() || intent.CallbackAttempts != attempt {
+			return errCallbackNotClaimed
+		}
+
+		merchant, err = t.userRepo.FindById(intent.MerchantID, trx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	callbackErr := t.paymentCallback.PaymentCompleted(merchant, intent)
+
+	return t.db.Transaction(func(trx *db.Transaction) error {
+		stored, err := t.paymentIntentRepo.FindById(intentID, trx)
+		if err != nil {
+			return err
+		}
+		// queued again by ResendPaymentCallback meanwhile
+		if stored.CallbackAttempts != attempt {
+			return nil
+		}
+
+		if callbackErr != nil {
+			stored.CallbackError = callbackErr.Error()
+		} else {
+			stored.CallbackDeliveredAt = t.db.Now()
+			stored.CallbackError = ""
+			stored.NextCallbackAt = time.Time{}
+		}
+		stored.UpdatedAt = t.db.Now()
+		return t.paymentIntentRepo.Put(stored, trx)
+	})
+}
+
+var errCallbackNotClaimed = errors.New("callback is not claimed")
+
+// pendingPaymentIntent loads an intent that can still be paid. Expired
+// intents waiting for the expiry schedule can't.
+func (t Transaction) pendingPaymentIntent(trx *db.Transaction, intentID string) (entity.PaymentIntent, error) {
+	intent, err := t.paymentIntentRepo.FindById(intentID, trx)
+	if err == db.ErrNotFound {
+		return entity.PaymentIntent{}, ErrPaymentIntentNotFound
+	}
+	if err != nil {
+		return entity.PaymentIntent{}, err
+	}
+
+	if intent.Status != entity.PaymentIntentStatusPending || !intent.ExpiresAt.After(t.db.Now()) {
+		return entity.PaymentIntent{}, ErrPaymentIntentNotPending
+	}
+
+	return intent, nil
+}
+
+// merchant loads a user that takes payments.
+func (t Transaction) merchant(trx *db.Transaction, merchantID string) (entity.User, error) {
+	merchant, err := t.userRepo.FindById(merchantID, trx)
+	if err == db.ErrNotFound {
+		return entity.User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return entity.User{}, err
+	}
+	if !merchant.IsMerchant() {
+		return entity.User{}, ErrNotMerchant
+	}
+	return merchant, nil
+}
+
+// expirePaymentIntents expires every pending intent past its expiry, it
+// runs as a schedule.
+func (t Transaction) expirePaymentIntents(trx *db.Transaction) error {
+	intents, err := t.paymentIntentRepo.GetExpired(t.db.Now(), trx)
+	if err != nil {
+		return err
+	}
+
+	for _, intent := range intents {
+		intent.Status = entity.PaymentIntentStatusExpired
+		intent.UpdatedAt = t.db.Now()
+		if err := t.paymentIntentRepo.Put(intent, trx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t Transaction) handlePaymentIntentExpiry(trx *db.Transaction, _ db.Schedule) error {
+	return t.expirePaymentIntents(trx)
+}
+
+// paymentIntentOptions pays the intent in its currency, only the source
+// wallet is up to the customer.
+func paymentIntentOptions(intent entity.PaymentIntent, o transactionOptions) transactionOptions {
+	return transactionOptions{
+		reference:       intent.Reference,
+		note:            intent.Description,
+		currency:        intent.Currency,
+		targetCurrency:  intent.Currency,
+		sourceWalletID:  o.sourceWalletID,
+		paymentIntentID: intent.ID,
+	}
+}
@@ -0,0 +1,248 @@
+package aggregation
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+var ErrPaymentRequestNotFound = errors.New("payment request not found")
+var ErrPaymentRequestNotPending = errors.New("payment request is no longer pending")
+
+// paymentRequestExpirySchedule expires every pending request past its
+// expiry, a missed schedule is caught up by the next one like
+// holdExpirySchedule.
+const paymentRequestExpirySchedule = "payment_request.expire"
+
+// RequestPayment asks the payer to send amount to the requester before the
+// expiry. The currency is the one the requester gets paid in.
+func (t Transaction) RequestPayment(requesterID, payerID string, amount int, expiry time.Duration, opts ...TransactionOption) (entity.PaymentRequest, error) {
+	o := newTransactionOptions(opts)
+	if err := validateAmount(amount); err != nil {
+		return entity.PaymentRequest{}, err
+	}
+	if requesterID == payerID {
+		return entity.PaymentRequest{}, ErrSameWallet
+	}
+
+	var request entity.PaymentRequest
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		request, err = t.requestPayment(trx, requesterID, payerID, amount, expiry, o)
+		return err
+	})
+	if err != nil {
+		return entity.PaymentRequest{}, err
+	}
+
+	return request, nil
+}
+
+// requestPayment runs inside an open transaction.
+func (t Transaction) requestPayment(trx *db.Transaction, requesterID, payerID string, amount int, expiry time.Duration, o transactionOptions) (entity.PaymentRequest, error) {
+	requester, err := t.userRepo.FindById(requesterID, trx)
+	if err != nil {
+		return entity.PaymentRequest{}, err
+	}
+	if requester.IsClosed() {
+		return entity.PaymentRequest{}, ErrAccountClosed
+	}
+
+	payer, err := t.userRepo.FindById(payerID, trx)
+	if err == db.ErrNotFound {
+		return entity.PaymentRequest{}, ErrUserNotFound
+	}
+	if err != nil {
+		return entity.PaymentRequest{}, err
+	}
+	if payer.IsClosed() {
+		return entity.PaymentRequest{}, ErrAccountClosed
+	}
+
+	now := t.db.Now()
+	request := entity.PaymentRequest{
+		ID:          uuid.New().String(),
+		RequesterID: requesterID,
+		PayerID:     payerID,
+		Amount:      amount,
+		Currency:    entity.NormalizeCurrency(o.currency),
+		Note:        o.note,
+		Reference:   o.reference,
+		Status:      entity.PaymentRequestStatusPending,
+		BillID:      o.billID,
+		ExpiresAt:   now.Add(expiry),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := t.paymentRequestRepo.Put(request, trx); err != nil {
+		return entity.PaymentRequest{}, err
+	}
+
+	trx.Schedule(request.ExpiresAt, paymentRequestExpirySchedule, t.expirePaymentRequests)
+	return request, nil
+}
+
+// AcceptPaymentRequest pays a pending request of the payer. The transfer
+// and the request turning paid commit together, a transfer that fails
+// leaves the request pending. A transfer held by the risk rules marks the
+// request paid once the review is approved.
+func (t Transaction) AcceptPaymentRequest(payerID, requestID string, opts ...TransactionOption) (entity.Transaction, error) {
+	o := newTransactionOptions(opts)
+
+	var request entity.PaymentRequest
+	var result entity.Transaction
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		request, err = t.pendingPaymentRequest(trx, requestID)
+		if err != nil {
+			return err
+		}
+		if request.PayerID != payerID {
+			return ErrPaymentRequestNotFound
+		}
+
+		o = paymentRequestOptions(request, o)
+		result, err = t.assessedTransfer(trx, request.PayerID, request.RequesterID, request.Amount, o)
+		if err != nil {
+			return err
+		}
+
+		return t.payPaymentRequest(trx, request.ID, result)
+	})
+
+	var riskErr *RiskError
+	if errors.As(err, &riskErr) {
+		if err := t.db.Transaction(func(trx *db.Transaction) error {
+			return t.recordRisk(trx, riskErr, request.PayerID, request.RequesterID, request.Amount, o, "")
+		}); err != nil {
+			return entity.Transaction{}, err
+		}
+	}
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	return result, nil
+}
+
+// DeclinePaymentRequest turns down a pending request of the payer.
+func (t Transaction) DeclinePaymentRequest(payerID, requestID, reason string) (entity.PaymentRequest, error) {
+	return t.closePaymentRequest(requestID, func(request entity.PaymentRequest) bool {
+		return request.PayerID == payerID
+	}, entity.PaymentRequestStatusDeclined, reason)
+}
+
+// CancelPaymentRequest withdraws a pending request of the requester.
+func (t Transaction) CancelPaymentRequest(requesterID, requestID string) (entity.PaymentRequest, error) {
+	return t.closePaymentRequest(requestID, func(request entity.PaymentRequest) bool {
+		return request.RequesterID == requesterID
+	}, entity.PaymentRequestStatusCancelled, "")
+}
+
+func (t Transaction) closePaymentRequest(requestID string, allowed func(entity.PaymentRequest) bool, status entity.PaymentRequestStatus, reason string) (entity.PaymentRequest, error) {
+	var request entity.PaymentRequest
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		request, err = t.pendingPaymentRequest(trx, requestID)
+		if err != nil {
+			return err
+		}
+		if !allowed(request) {
+			return ErrPaymentRequestNotFound
+		}
+
+		request.DeclineReason = reason
+		request, err = t.updatePaymentRequest(trx, request, status, "")
+		return err
+	})
+	if err != nil {
+		return entity.PaymentRequest{}, err
+	}
+
+	return request, nil
+}
+
+// payPaymentRequest marks a pending request paid by the transaction.
+func (t Transaction) payPaymentRequest(trx *db.Transaction, requestID string, record entity.Transaction) error {
+	request, err := t.pendingPaymentRequest(trx, requestID)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.updatePaymentRequest(trx, request, entity.PaymentRequestStatusPaid, record.ID)
+	return err
+}
+
+// updatePaymentRequest moves a request to the status, and its bill share
+// along with it.
+func (t Transaction) updatePaymentRequest(trx *db.Transaction, request entity.PaymentRequest, status entity.PaymentRequestStatus, transactionID string) (entity.PaymentRequest, error) {
+	request.Status = status
+	request.TransactionID = transactionID
+	request.UpdatedAt = t.db.Now()
+	if err := t.paymentRequestRepo.Put(request, trx); err != nil {
+		return entity.PaymentRequest{}, err
+	}
+
+	if request.BillID != "" {
+		if err := t.updateBillShare(trx, request); err != nil {
+			return entity.PaymentRequest{}, err
+		}
+	}
+
+	return request, nil
+}
+
+// pendingPaymentRequest loads a request that can still be paid. Expired
+// requests waiting for the expiry schedule can't.
+func (t Transaction) pendingPaymentRequest(trx *db.Transaction, requestID string) (entity.PaymentRequest, error) {
+	request, err := t.paymentRequestRepo.FindById(requestID, trx)
+	if err == db.ErrNotFound {
+		return entity.PaymentRequest{}, ErrPaymentRequestNotFound
+	}
+	if err != nil {
+		return entity.PaymentRequest{}, err
+	}
+
+	if request.Status != entity.PaymentRequestStatusPending || !request.ExpiresAt.After(t.db.Now()) {
+		return entity.PaymentRequest{}, ErrPaymentRequestNotPending
+	}
+
+	return request, nil
+}
+
+// expirePaymentRequests expires every pending request past its expiry, it
+// runs as a schedule.
+func (t Transaction) expirePaymentRequests(trx *db.Transaction) error {
+	requests, err := t.paymentRequestRepo.GetExpired(t.db.Now(), trx)
+	if err != nil {
+		return err
+	}
+
+	for _, request := range requests {
+		if _, err := t.updatePaymentRequest(trx, request, entity.PaymentRequestStatusExpired, ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t Transaction) handlePaymentRequestExpiry(trx *db.Transaction, _ db.Schedule) error {
+	return t.expirePaymentRequests(trx)
+}
+
+// paymentRequestOptions pays the request in its currency, only the source
+// wallet is up to the payer.
+func paymentRequestOptions(request entity.PaymentRequest, o transactionOptions) transactionOptions {
+	return transactionOptions{
+		reference:        request.Reference,
+		note:             request.Note,
+		currency:         request.Currency,
+		targetCurrency:   request.Currency,
+		sourceWalletID:   o.sourceWalletID,
+		paymentRequestID: request.ID,
+	}
+}
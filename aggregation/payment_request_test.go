@@ -0,0 +1,175 @@
+package aggregation_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentRequest(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	dbInstance := setupDB(db.WithClock(clock))
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	requestRepo := repository.NewPaymentRequest(dbInstance)
+
+	requesterID := uuid.New().String()
+	payerID := uuid.New().String()
+	userRepo.Put(entity.User{ID: requesterID, Email: "requester@example.com"})
+	userRepo.Put(entity.User{ID: payerID, Email: "payer@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: requesterID})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: payerID})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+
+	_, err := transaction.TopUp(payerID, 100)
+	assert.NoError(t, err)
+
+	_, err = transaction.RequestPayment(requesterID, requesterID, 10, time.Hour)
+	assert.ErrorIs(t, err, aggregation.ErrSameWallet)
+	_, err = transaction.RequestPayment(requesterID, "unknown", 10, time.Hour)
+	assert.ErrorIs(t, err, aggregation.ErrUserNotFound)
+
+	t.Run("Accept", func(t *testing.T) {
+		request, err := transaction.RequestPayment(requesterID, payerID, 30, time.Hour, aggregation.WithNote("dinner"))
+		assert.NoError(t, err)
+		assert.Equal(t, entity.PaymentRequestStatusPending, request.Status)
+
+		incoming, _ := requestRepo.GetByPayerID(payerID, entity.PaymentRequestStatusPending)
+		assert.Len(t, incoming, 1)
+
+		// only the payer pays it
+		_, err = transaction.AcceptPaymentRequest(requesterID, request.ID)
+		assert.ErrorIs(t, err, aggregation.ErrPaymentRequestNotFound)
+
+		trx, err := transaction.AcceptPaymentRequest(payerID, request.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "dinner", trx.Note)
+
+		stored, _ := requestRepo.FindById(request.ID)
+		assert.Equal(t, entity.PaymentRequestStatusPaid, stored.Status)
+		assert.Equal(t, trx.ID, stored.TransactionID)
+
+		_, err = transaction.AcceptPaymentRequest(payerID, request.ID)
+		assert.ErrorIs(t, err, aggregation.ErrPaymentRequestNotPending)
+
+		requester, _ := walletRepo.FindByUserID(requesterID)
+		assert.Equal(t, 30, requester.Balance)
+	})
+
+	t.Run("Failed transfer stays pending", func(t *testing.T) {
+		request, err := transaction.RequestPayment(requesterID, payerID, 500, time.Hour)
+		assert.NoError(t, err)
+
+		_, err = transaction.AcceptPaymentRequest(payerID, request.ID)
+		assert.ErrorIs(t, err, aggregation.ErrInsuficientFound)
+
+		stored, _ := requestRepo.FindById(request.ID)
+		assert.Equal(t, entity.PaymentRequestStatusPending, stored.Status)
+
+		declined, err := transaction.DeclinePaymentRequest(payerID, request.ID, "too much")
+		assert.NoError(t, err)
+		assert.Equal(t, entity.PaymentRequestStatusDeclined, declined.Status)
+		assert.Equal(t, "too much", declined.DeclineReason)
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		request, err := transaction.RequestPayment(requesterID, payerID, 10, time.Hour)
+		assert.NoError(t, err)
+
+		_, err = transaction.CancelPaymentRequest(payerID, request.ID)
+		assert.ErrorIs(t, err, aggregation.ErrPaymentRequestNotFound)
+
+		cancelled, err := transaction.CancelPaymentRequest(requesterID, request.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, entity.PaymentRequestStatusCancelled, cancelled.Status)
+
+		_, err = transaction.AcceptPaymentRequest(payerID, request.ID)
+		assert.ErrorIs(t, err, aggregation.ErrPaymentRequestNotPending)
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		request, err := transaction.RequestPayment(requesterID, payerID, 10, 15*time.Minute)
+		assert.NoError(t, err)
+
+		clock.Advance(15 * time.Minute)
+
+		// expired requests can't be paid even before the schedule ran
+		_, err = transaction.AcceptPaymentRequest(payerID, request.ID)
+		assert.ErrorIs(t, err, aggregation.ErrPaymentRequestNotPending)
+
+		_, err = dbInstance.RunDueSchedules()
+		assert.NoError(t, err)
+
+		stored, _ := requestRepo.FindById(request.ID)
+		assert.Equal(t, entity.PaymentRequestStatusExpired, stored.Status)
+	})
+
+	payer, _ := walletRepo.FindByUserID(payerID)
+	assert.Equal(t, 70, payer.Balance)
+}
+
+func TestPaymentRequestReview(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	requestRepo := repository.NewPaymentRequest(dbInstance)
+	reviewRepo := repository.NewRiskReview(dbInstance)
+
+	requesterID := uuid.New().String()
+	payerID := uuid.New().String()
+	userRepo.Put(entity.User{ID: requesterID, Email: "requester@example.com"})
+	userRepo.Put(entity.User{ID: payerID, Email: "payer@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: requesterID})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: payerID})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance,
+		aggregation.WithRisk(aggregation.RiskPolicy{
+			Rules:       []aggregation.RiskRule{aggregation.NewRecipientRule{Amount: 50, Points: 30}},
+			ReviewScore: 30,
+			BlockScore:  100,
+		}),
+	)
+
+	_, err := transaction.TopUp(payerID, 100)
+	assert.NoError(t, err)
+
+	request, err := transaction.RequestPayment(requesterID, payerID, 60, time.Hour)
+	assert.NoError(t, err)
+
+	// accepted twice while held, approving pays it once
+	_, err = transaction.AcceptPaymentRequest(payerID, request.ID)
+	assert.ErrorIs(t, err, aggregation.ErrTransferInReview)
+	_, err = transaction.AcceptPaymentRequest(payerID, request.ID)
+	assert.ErrorIs(t, err, aggregation.ErrTransferInReview)
+
+	reviews, _ := reviewRepo.GetByStatus(entity.RiskReviewStatusPending)
+	assert.Len(t, reviews, 2)
+	assert.Equal(t, request.ID, reviews[0].PaymentRequestID)
+
+	stored, _ := requestRepo.FindById(request.ID)
+	assert.Equal(t, entity.PaymentRequestStatusPending, stored.Status)
+
+	trx, err := transaction.ApproveReview(reviews[0].ID, "admin")
+	assert.NoError(t, err)
+
+	stored, _ = requestRepo.FindById(request.ID)
+	assert.Equal(t, entity.PaymentRequestStatusPaid, stored.Status)
+	assert.Equal(t, trx.ID, stored.TransactionID)
+
+	_, err = transaction.ApproveReview(reviews[1].ID, "admin")
+	assert.ErrorIs(t, err, aggregation.ErrPaymentRequestNotPending)
+
+	payer, _ := walletRepo.FindByUserID(payerID)
+	assert.Equal(t, 40, payer.Balance)
+}
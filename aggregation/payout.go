@@ -0,0 +1,74 @@
+package aggregation
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+// ErrPayoutRejected is wrapped by a PayoutProvider refusing a payout for
+// good, like a bank account that doesn't exist.
+var ErrPayoutRejected = errors.New("payout rejected")
+
+// PayoutProvider sends withdrawals to bank accounts. Payout only submits the
+// payout and returns the provider's reference for it, the provider reports
+// the outcome later and it is applied with SettleWithdrawal or
+// FailWithdrawal. An error wrapping ErrPayoutRejected fails the withdrawal,
+// other errors are retried. A payout can be submitted more than once when
+// its outcome couldn't be recorded, providers dedupe it by withdrawal ID.
+type PayoutProvider interface {
+	Payout(withdrawal entity.Withdrawal, account entity.BankAccount) (string, error)
+}
+
+// WithPayoutProvider enables withdrawals, without a provider Withdraw fails
+// with ErrPayoutsUnavailable.
+func WithPayoutProvider(provider PayoutProvider) Option {
+	return func(t *Transaction) {
+		t.payoutProvider = provider
+	}
+}
+
+// FakePayoutProvider accepts every payout without sending money anywhere,
+// for tests and local runs. Its payouts stay pending until someone settles
+// or fails them, the way a provider callback would.
+type FakePayoutProvider struct {
+	mu      sync.Mutex
+	err     error
+	payouts []entity.Withdrawal
+}
+
+// SetErr makes every payout fail with err until it is set back to nil.
+func (p *FakePayoutProvider) SetErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.err = err
+}
+
+func (p *FakePayoutProvider) Payout(withdrawal entity.Withdrawal, _ entity.BankAccount) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return "", p.err
+	}
+
+	// a payout submitted again is the same payout
+	for _, payout := range p.payouts {
+		if payout.ID == withdrawal.ID {
+			return "fake-" + withdrawal.ID, nil
+		}
+	}
+
+	p.payouts = append(p.payouts, withdrawal)
+	return "fake-" + withdrawal.ID, nil
+}
+
+// Payouts returns the withdrawals submitted so far, oldest first.
+func (p *FakePayoutProvider) Payouts() []entity.Withdrawal {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]entity.Withdrawal{}, p.payouts...)
+}
@@ -0,0 +1,115 @@
+package aggregation
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+var ErrWalletNotFound = errors.New("wallet not found")
+var ErrSameWallet = errors.New("source and target wallet are the same")
+var ErrInvalidWalletName = errors.New("invalid wallet name")
+
+// MaxWalletNameLength is the longest pocket name.
+const MaxWalletNameLength = 32
+
+// WithSourceWallet picks the pocket a transfer is paid from, it has to
+// belong to the sender.
+func WithSourceWallet(walletID string) TransactionOption {
+	return func(o *transactionOptions) {
+		o.sourceWalletID = walletID
+	}
+}
+
+// CreatePocket gives the user a named sub wallet next to the main one, names
+// are unique per currency.
+func (t Transaction) CreatePocket(userID, name, currency string) (entity.Wallet, error) {
+	if name == "" || len(name) > MaxWalletNameLength {
+		return entity.Wallet{}, ErrInvalidWalletName
+	}
+
+	return t.openWallet(userID, name, currency)
+}
+
+// Move moves money between two pockets of the same user, converting it when
+// they hold different currencies.
+func (t Transaction) Move(userID, fromWalletID, toWalletID string, amount int, opts ...TransactionOption) (entity.Transaction, error) {
+	o := newTransactionOptions(opts)
+	o.sourceWalletID = fromWalletID
+	o.targetWalletID = toWalletID
+	fingerprint := requestFingerprint("move", fromWalletID, toWalletID, amount, o.reference, o.note)
+
+	var result entity.Transaction
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		result, err = idempotent(t, trx, userID, o, fingerprint, transactionResult, func() (entity.Transaction, error) {
+			return t.transfer(trx, userID, userID, amount, o)
+		})
+		return err
+	})
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	return result, nil
+}
+
+func (t Transaction) openWallet(userID, name, currency string) (entity.Wallet, error) {
+	if err := entity.ValidateCurrency(currency); err != nil {
+		return entity.Wallet{}, err
+	}
+
+	wallet := entity.Wallet{
+		ID:       uuid.New().String(),
+		UserID:   userID,
+		Name:     name,
+		Currency: entity.NormalizeCurrency(currency),
+		Status:   entity.WalletStatusActive,
+	}
+
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		user, err := t.userRepo.FindById(userID, trx)
+		if err != nil {
+			return err
+		}
+
+		if user.IsClosed() {
+			return ErrAccountClosed
+		}
+
+		_, err = t.walletRepo.FindByUserIDAndName(userID, name, currency, trx)
+		if err == nil {
+			return ErrWalletAlreadyExists
+		}
+		if err != db.ErrNotFound {
+			return err
+		}
+
+		return t.walletRepo.Put(wallet, trx)
+	})
+	if err != nil {
+		return entity.Wallet{}, err
+	}
+
+	return wallet, nil
+}
+
+// ownWallet finds a wallet by ID, wallets of other users are not found.
+func (t Transaction) ownWallet(trx *db.Transaction, userID, walletID string) (entity.Wallet, error) {
+	wallet, err := t.walletRepo.FindById(walletID, trx)
+	if err == db.ErrNotFound || (err == nil && wallet.UserID != userID) {
+		return entity.Wallet{}, ErrWalletNotFound
+	}
+	return wallet, err
+}
+
+// sourceWallet picks the wallet paying a transfer, see WithSourceWallet and
+// WithCurrency.
+func (t Transaction) sourceWallet(trx *db.Transaction, userID string, o transactionOptions) (entity.Wallet, error) {
+	if o.sourceWalletID != "" {
+		return t.ownWallet(trx, userID, o.sourceWalletID)
+	}
+	return t.walletRepo.FindByUserIDAndCurrency(userID, o.currency, trx)
+}
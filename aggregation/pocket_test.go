@@ -0,0 +1,80 @@
+package aggregation_test
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPockets(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+
+	userID := uuid.New().String()
+	otherUserID := uuid.New().String()
+	userRepo.Put(entity.User{ID: userID, Email: "test@example.com"})
+	userRepo.Put(entity.User{ID: otherUserID, Email: "other@example.com"})
+
+	mainWallet := entity.Wallet{ID: uuid.New().String(), UserID: userID, Name: entity.PrimaryWalletName, Currency: "IDR"}
+	walletRepo.Put(mainWallet)
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: otherUserID})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+
+	savings, err := transaction.CreatePocket(userID, "savings", "IDR")
+	assert.NoError(t, err)
+	bills, err := transaction.CreatePocket(userID, "bills", "IDR")
+	assert.NoError(t, err)
+
+	_, err = transaction.CreatePocket(userID, "savings", "IDR")
+	assert.ErrorIs(t, err, aggregation.ErrWalletAlreadyExists)
+	_, err = transaction.CreatePocket(userID, "", "IDR")
+	assert.ErrorIs(t, err, aggregation.ErrInvalidWalletName)
+
+	// top ups and the default wallet stay on the main pocket
+	_, err = transaction.TopUp(userID, 300)
+	assert.NoError(t, err)
+	wallet, err := walletRepo.FindByUserID(userID)
+	assert.NoError(t, err)
+	assert.Equal(t, mainWallet.ID, wallet.ID)
+	assert.Equal(t, 300, wallet.Balance)
+
+	trx, err := transaction.Move(userID, mainWallet.ID, savings.ID, 200)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.TransactionTypeMove, trx.Type)
+
+	// pay from a pocket
+	_, err = transaction.Transfer(userID, otherUserID, 50, aggregation.WithSourceWallet(savings.ID))
+	assert.NoError(t, err)
+
+	savings, _ = walletRepo.FindById(savings.ID)
+	assert.Equal(t, 150, savings.Balance)
+	other, _ := walletRepo.FindByUserID(otherUserID)
+	assert.Equal(t, 50, other.Balance)
+
+	_, err = transaction.Move(userID, bills.ID, savings.ID, 10)
+	assert.ErrorIs(t, err, aggregation.ErrInsuficientFound)
+	_, err = transaction.Move(userID, savings.ID, savings.ID, 10)
+	assert.ErrorIs(t, err, aggregation.ErrSameWallet)
+
+	// pockets of someone else can't be used
+	_, err = transaction.Move(otherUserID, other.ID, savings.ID, 10)
+	assert.ErrorIs(t, err, aggregation.ErrWalletNotFound)
+	_, err = transaction.Transfer(otherUserID, userID, 10, aggregation.WithSourceWallet(savings.ID))
+	assert.ErrorIs(t, err, aggregation.ErrWalletNotFound)
+
+	wallets, err := walletRepo.GetByUserID(userID)
+	assert.NoError(t, err)
+	assert.Len(t, wallets, 3)
+
+	discrepancies, err := aggregation.NewLedger(walletRepo, dbInstance).Reconcile()
+	assert.NoError(t, err)
+	assert.Empty(t, discrepancies)
+}
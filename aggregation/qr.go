@@ -0,0 +1,408 @@
+package aggregation
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+var ErrQRChecksum = errors.New("qr payload checksum doesn't match")
+var ErrQRMalformed = errors.New("qr payload is malformed")
+var ErrQRUnsupported = errors.New("qr payload isn't for this wallet")
+var ErrQRAmountMismatch = errors.New("amount differs from the qr payload")
+
+// QRGlobalID identifies this wallet in the merchant account template of a
+// payload, codes of other providers are refused.
+const QRGlobalID = "COM.INSOMNIUS.WALLET"
+
+// QRMerchantCity and QRCountryCode fill the location fields of generated
+// payloads, merchants don't have an address.
+var QRMerchantCity = "JAKARTA"
+var QRCountryCode = "ID"
+
+// Tags of the EMVCo merchant presented mode used here.
+const (
+	qrTagFormat          = "00"
+	qrTagInitiation      = "01"
+	qrTagMerchantAccount = "26"
+	qrTagCategory        = "52"
+	qrTagCurrency        = "53"
+	qrTagAmount          = "54"
+	qrTagCountry         = "58"
+	qrTagMerchantName    = "59"
+	qrTagMerchantCity    = "60"
+	qrTagAdditionalData  = "62"
+	qrTagCRC             = "63"
+
+	qrSubTagGlobalID   = "00"
+	qrSubTagMerchantID = "01"
+	qrSubTagReference  = "05"
+)
+
+const (
+	qrStatic  = "11" // the customer enters the amount, the code is reused
+	qrDynamic = "12" // for one payment
+)
+
+// qrCurrency is the ISO 4217 numeric code and minor unit digits of a
+// currency, a payload carries the amount in major units.
+type qrCurrency struct {
+	numeric  string
+	exponent int
+}
+
+var qrCurrencies = map[string]qrCurrency{
+	"IDR": {"360", 2},
+	"USD": {"840", 2},
+	"SGD": {"702", 2},
+	"MYR": {"458", 2},
+	"EUR": {"978", 2},
+	"JPY": {"392", 0},
+}
+
+// QRPayload is what a merchant presented code carries. A zero Amount makes a
+// static code the customer enters the amount for. Reference is the payment
+// intent a dynamic code pays.
+type QRPayload struct {
+	MerchantID   string `json:"merchant_id"`
+	MerchantName string `json:"merchant_name"`
+	MerchantCity string `json:"merchant_city"`
+	CountryCode  string `json:"country_code"`
+	CategoryCode string `json:"category_code"`
+	Currency     string `json:"currency"`
+	Amount       int    `json:"amount,omitempty"`
+	Reference    string `json:"reference,omitempty"`
+}
+
+// EncodeQR builds the EMVCo payload of p, checksum included. Merchant name
+// and city are cut to the length the format allows.
+func EncodeQR(p QRPayload) (string, error) {
+	currency, ok := qrCurrencies[entity.NormalizeCurrency(p.Currency)]
+	if !ok {
+		return "", fmt.Errorf("%w: currency %s", ErrQRUnsupported, entity.NormalizeCurrency(p.Currency))
+	}
+	if p.MerchantID == "" {
+		return "", fmt.Errorf("%w: no merchant", ErrQRMalformed)
+	}
+
+	initiation := qrStatic
+	if p.Amount > 0 {
+		initiation = qrDynamic
+	}
+
+	category := p.CategoryCode
+	if category == "" {
+		category = "0000"
+	}
+
+	var b strings.Builder
+	fields := [][2]string{
+		{qrTagFormat, "01"},
+		{qrTagInitiation, initiation},
+		{qrTagMerchantAccount, qrField(qrSubTagGlobalID, QRGlobalID) + qrField(qrSubTagMerchantID, p.MerchantID)},
+		{qrTagCategory, category},
+		{qrTagCurrency, currency.numeric},
+	}
+	if p.Amount > 0 {
+		fields = append(fields, [2]string{qrTagAmount, formatQRAmount(p.Amount, currency.exponent)})
+	}
+	fields = append(fields,
+		[2]string{qrTagCountry, p.CountryCode},
+		[2]string{qrTagMerchantName, truncate(p.MerchantName, 25)},
+		[2]string{qrTagMerchantCity, truncate(p.MerchantCity, 15)},
+	)
+	if p.Reference != "" {
+		fields = append(fields, [2]string{qrTagAdditionalData, qrField(qrSubTagReference, p.Reference)})
+	}
+
+	for _, f := range fields {
+		if len(f[1]) == 0 || len(f[1]) > 99 {
+			return "", fmt.Errorf("%w: field %s is empty or too long", ErrQRMalformed, f[0])
+		}
+		b.WriteString(qrField(f[0], f[1]))
+	}
+
+	// the checksum covers its own tag and length
+	b.WriteString(qrTagCRC + "04")
+	b.WriteString(fmt.Sprintf("%04X", crc16(b.String())))
+	return b.String(), nil
+}
+
+// ParseQR reads an EMVCo payload of this wallet. A checksum that doesn't
+// match fails with ErrQRChecksum, the payload was cut or tampered with.
+func ParseQR(payload string) (QRPayload, error) {
+	payload = strings.TrimSpace(payload)
+	if len(payload) < 8 || payload[len(payload)-8:len(payload)-4] != qrTagCRC+"04" {
+		return QRPayload{}, fmt.Errorf("%w: no checksum", ErrQRMalformed)
+	}
+
+	checksum, err := strconv.ParseUint(payload[len(payload)-4:], 16, 16)
+	if err != nil {
+		return QRPayload{}, fmt.Errorf("%w: checksum", ErrQRMalformed)
+	}
+	if uint16(checksum) != crc16(payload[:len(payload)-4]) {
+		return QRPayload{}, ErrQRChecksum
+	}
+
+	fields, err := parseQRFields(payload[:len(payload)-8])
+	if err != nil {
+		return QRPayload{}, err
+	}
+	if fields[qrTagFormat] != "01" {
+		return QRPayload{}, fmt.Errorf("%w: payload format", ErrQRMalformed)
+	}
+
+	p := QRPayload{
+		MerchantName: fields[qrTagMerchantName],
+		MerchantCity: fields[qrTagMerchantCity],
+		CountryCode:  fields[qrTagCountry],
+		CategoryCode: fields[qrTagCategory],
+	}
+
+	// the merchant can have accounts with other providers in 26 to 51
+	for tag := 26; tag <= 51; tag++ {
+		template, ok := fields[strconv.Itoa(tag)]
+		if !ok {
+			continue
+		}
+		account, err := parseQRFields(template)
+		if err != nil {
+			return QRPayload{}, err
+		}
+		if account[qrSubTagGlobalID] == QRGlobalID {
+			p.MerchantID = account[qrSubTagMerchantID]
+			break
+		}
+	}
+	if p.MerchantID == "" {
+		return QRPayload{}, ErrQRUnsupported
+	}
+
+	var exponent int
+	for code, currency := range qrCurrencies {
+		if currency.numeric == fields[qrTagCurrency] {
+			p.Currency, exponent = code, currency.exponent
+		}
+	}
+	if p.Currency == "" {
+		return QRPayload{}, fmt.Errorf("%w: currency %s", ErrQRUnsupported, fields[qrTagCurrency])
+	}
+
+	if amount, ok := fields[qrTagAmount]; ok {
+		p.Amount, err = parseQRAmount(amount, exponent)
+		if err != nil {
+			return QRPayload{}, err
+		}
+	}
+
+	if data, ok := fields[qrTagAdditionalData]; ok {
+		additional, err := parseQRFields(data)
+		if err != nil {
+			return QRPayload{}, err
+		}
+		p.Reference = additional[qrSubTagReference]
+	}
+
+	return p, nil
+}
+
+// MerchantQR is the static code of the merchant, or a code for a fixed
+// amount when amount isn't zero.
+func (t Transaction) MerchantQR(merchantID string, amount int, currency string) (string, error) {
+	if amount != 0 {
+		if err := validateAmount(amount); err != nil {
+			return "", err
+		}
+	}
+
+	var merchant entity.User
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		merchant, err = t.merchant(trx, merchantID)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return EncodeQR(t.qrPayload(merchant, amount, currency, ""))
+}
+
+// PaymentIntentQR is the code paying a pending intent of the merchant.
+func (t Transaction) PaymentIntentQR(merchantID, intentID string) (string, error) {
+	var merchant entity.User
+	var intent entity.PaymentIntent
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		merchant, err = t.merchant(trx, merchantID)
+		if err != nil {
+			return err
+		}
+
+		intent, err = t.pendingPaymentIntent(trx, intentID)
+		if err == nil && intent.MerchantID != merchantID {
+			err = ErrPaymentIntentNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return EncodeQR(t.qrPayload(merchant, intent.Amount, intent.Currency, intent.ID))
+}
+
+// PayQR pays a scanned code. A code carrying a payment intent pays that
+// intent, any other code transfers to the merchant, amount is what the
+// customer entered for a static code and has to match a code with one.
+func (t Transaction) PayQR(customerID, payload string, amount int, opts ...TransactionOption) (entity.Transaction, error) {
+	p, err := ParseQR(payload)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+	if p.Amount != 0 {
+		if amount != 0 && amount != p.Amount {
+			return entity.Transaction{}, ErrQRAmountMismatch
+		}
+		amount = p.Amount
+	}
+
+	if p.Reference != "" {
+		intent, err := t.paymentIntentRepo.FindById(p.Reference)
+		if err == db.ErrNotFound || (err == nil && intent.MerchantID != p.MerchantID) {
+			return entity.Transaction{}, ErrPaymentIntentNotFound
+		}
+		if err != nil {
+			return entity.Transaction{}, err
+		}
+		if intent.Amount != amount || intent.Currency != p.Currency {
+			return entity.Transaction{}, ErrQRAmountMismatch
+		}
+		return t.PayPaymentIntent(customerID, intent.ID, opts...)
+	}
+
+	err = t.db.Transaction(func(trx *db.Transaction) error {
+		_, err := t.merchant(trx, p.MerchantID)
+		return err
+	})
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	// the merchant gets paid in the currency of the code
+	return t.Transfer(customerID, p.MerchantID, amount, append(opts,
+		WithCurrency(p.Currency),
+		WithTargetCurrency(p.Currency),
+	)...)
+}
+
+func (t Transaction) qrPayload(merchant entity.User, amount int, currency, reference string) QRPayload {
+	name := merchant.MerchantName
+	if name == "" {
+		name = merchant.Email
+	}
+
+	return QRPayload{
+		MerchantID:   merchant.ID,
+		MerchantName: name,
+		MerchantCity: QRMerchantCity,
+		CountryCode:  QRCountryCode,
+		Currency:     currency,
+		Amount:       amount,
+		Reference:    reference,
+	}
+}
+
+func qrField(tag, value string) string {
+	return fmt.Sprintf("%s%02d%s", tag, len(value), value)
+}
+
+// parseQRFields splits tag, two digit length and value triples.
+func parseQRFields(s string) (map[string]string, error) {
+	fields := map[string]string{}
+	for len(s) > 0 {
+		if len(s) < 4 {
+			return nil, fmt.Errorf("%w: truncated field", ErrQRMalformed)
+		}
+
+		// the length is exactly two digits, Atoi alone takes signs
+		if !isQRDigit(s[2]) || !isQRDigit(s[3]) {
+			return nil, fmt.Errorf("%w: field %s", ErrQRMalformed, s[:2])
+		}
+		length := int(s[2]-'0')*10 + int(s[3]-'0')
+		if len(s) < 4+length {
+			return nil, fmt.Errorf("%w: field %s", ErrQRMalformed, s[:2])
+		}
+
+		fields[s[:2]] = s[4 : 4+length]
+		s = s[4+length:]
+	}
+	return fields, nil
+}
+
+func isQRDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// formatQRAmount writes minor units as major units, 4500 with two digits is
+// 45.00.
+func formatQRAmount(amount, exponent int) string {
+	if exponent == 0 {
+		return strconv.Itoa(amount)
+	}
+
+	unit := 1
+	for i := 0; i < exponent; i++ {
+		unit *= 10
+	}
+	return fmt.Sprintf("%d.%0*d", amount/unit, exponent, amount%unit)
+}
+
+func parseQRAmount(s string, exponent int) (int, error) {
+	whole, fraction, _ := strings.Cut(s, ".")
+	if len(fraction) > exponent {
+		return 0, fmt.Errorf("%w: amount %s", ErrQRMalformed, s)
+	}
+	fraction += strings.Repeat("0", exponent-len(fraction))
+
+	// Atoi takes a sign too, an amount is digits only
+	digits := whole + fraction
+	for i := 0; i < len(digits); i++ {
+		if !isQRDigit(digits[i]) {
+			return 0, fmt.Errorf("%w: amount %s", ErrQRMalformed, s)
+		}
+	}
+
+	amount, err := strconv.Atoi(digits)
+	if err != nil || amount <= 0 || amount > MaxAmount {
+		return 0, fmt.Errorf("%w: amount %s", ErrQRMalformed, s)
+	}
+	return amount, nil
+}
+
+// crc16 is CRC-16/CCITT-FALSE, the checksum EMVCo payloads end with.
+func crc16(s string) uint16 {
+	crc := uint16(0xFFFF)
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
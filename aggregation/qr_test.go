@@ -0,0 +1,175 @@
+package aggregation_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQRPayload(t *testing.T) {
+	payload := aggregation.QRPayload{
+		MerchantID:   "m-1",
+		MerchantName: "Corner Shop",
+		MerchantCity: "JAKARTA",
+		CountryCode:  "ID",
+		CategoryCode: "0000",
+		Currency:     "IDR",
+		Amount:       4550,
+		Reference:    "intent-1",
+	}
+
+	encoded, err := aggregation.EncodeQR(payload)
+	assert.NoError(t, err)
+	assert.Equal(t, "00020101021226310020COM.INSOMNIUS.WALLET0103m-1520400005303360540545.505802ID5911Corner Shop6007JAKARTA62120508intent-163049238", encoded)
+
+	parsed, err := aggregation.ParseQR(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, parsed)
+
+	t.Run("Static", func(t *testing.T) {
+		static := payload
+		static.Amount = 0
+		static.Reference = ""
+		static.Currency = "JPY"
+
+		encoded, err := aggregation.EncodeQR(static)
+		assert.NoError(t, err)
+		assert.Contains(t, encoded, "010211")
+
+		parsed, err := aggregation.ParseQR(encoded)
+		assert.NoError(t, err)
+		assert.Equal(t, static, parsed)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		// 45.50 turned into 95.50
+		tampered := strings.Replace(encoded, "540545.50", "540595.50", 1)
+		_, err := aggregation.ParseQR(tampered)
+		assert.ErrorIs(t, err, aggregation.ErrQRChecksum)
+
+		_, err = aggregation.ParseQR(encoded[:len(encoded)-10])
+		assert.ErrorIs(t, err, aggregation.ErrQRMalformed)
+
+		// a valid checksum over a bad length, anyone can compute one
+		for _, length := range []string{"-1", "+1", " 1", "1x"} {
+			_, err = aggregation.ParseQR(withChecksum("000201010212" + "26" + length + "X6304"))
+			assert.ErrorIs(t, err, aggregation.ErrQRMalformed, length)
+		}
+
+		// and over a signed amount
+		for _, amount := range []string{"5406+45.50", "5406-45.50", "540545.+5"} {
+			signed := strings.Replace(encoded[:len(encoded)-4], "540545.50", amount, 1)
+			_, err = aggregation.ParseQR(withChecksum(signed))
+			assert.ErrorIs(t, err, aggregation.ErrQRMalformed, amount)
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		_, err := aggregation.EncodeQR(aggregation.QRPayload{MerchantID: "m-1", MerchantName: "Shop", MerchantCity: "X", CountryCode: "ID", Currency: "XYZ"})
+		assert.ErrorIs(t, err, aggregation.ErrQRUnsupported)
+
+		foreign := strings.Replace(encoded[:len(encoded)-4], aggregation.QRGlobalID, "ID.CO.OTHER.WALLET..", 1)
+		_, err = aggregation.ParseQR(withChecksum(foreign))
+		assert.ErrorIs(t, err, aggregation.ErrQRUnsupported)
+	})
+}
+
+// withChecksum signs a payload ending in 6304 the way a tamperer who knows
+// the format would.
+func withChecksum(payload string) string {
+	crc := uint16(0xFFFF)
+	for i := 0; i < len(payload); i++ {
+		crc ^= uint16(payload[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return payload + fmt.Sprintf("%04X", crc)
+}
+
+func TestPayQR(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	intentRepo := repository.NewPaymentIntent(dbInstance)
+
+	merchantID := uuid.New().String()
+	customerID := uuid.New().String()
+	userRepo.Put(entity.User{ID: merchantID, Email: "shop@example.com", Type: entity.UserTypeMerchant, MerchantName: "Corner Shop"})
+	userRepo.Put(entity.User{ID: customerID, Email: "customer@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: merchantID})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: customerID})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+
+	_, err := transaction.TopUp(customerID, 100)
+	assert.NoError(t, err)
+
+	_, err = transaction.MerchantQR(customerID, 0, "")
+	assert.ErrorIs(t, err, aggregation.ErrNotMerchant)
+
+	t.Run("Static", func(t *testing.T) {
+		code, err := transaction.MerchantQR(merchantID, 0, "")
+		assert.NoError(t, err)
+
+		_, err = transaction.PayQR(customerID, code, 0)
+		assert.ErrorIs(t, err, aggregation.ErrAmountNotPositive)
+
+		record, err := transaction.PayQR(customerID, code, 25)
+		assert.NoError(t, err)
+		assert.Equal(t, merchantID, record.TargetUserID)
+		assert.Equal(t, 25, record.Amount)
+
+		fixed, err := transaction.MerchantQR(merchantID, 10, "")
+		assert.NoError(t, err)
+		_, err = transaction.PayQR(customerID, fixed, 15)
+		assert.ErrorIs(t, err, aggregation.ErrQRAmountMismatch)
+		_, err = transaction.PayQR(customerID, fixed, 0)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Payment intent", func(t *testing.T) {
+		intent, err := transaction.CreatePaymentIntent(merchantID, 40, time.Hour)
+		assert.NoError(t, err)
+
+		code, err := transaction.PaymentIntentQR(merchantID, intent.ID)
+		assert.NoError(t, err)
+
+		record, err := transaction.PayQR(customerID, code, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, entity.TransactionTypePayment, record.Type)
+
+		stored, _ := intentRepo.FindById(intent.ID)
+		assert.Equal(t, entity.PaymentIntentStatusPaid, stored.Status)
+
+		_, err = transaction.PayQR(customerID, code, 0)
+		assert.ErrorIs(t, err, aggregation.ErrPaymentIntentNotPending)
+		_, err = transaction.PaymentIntentQR(merchantID, intent.ID)
+		assert.ErrorIs(t, err, aggregation.ErrPaymentIntentNotPending)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		code, err := transaction.MerchantQR(merchantID, 10, "")
+		assert.NoError(t, err)
+
+		_, err = transaction.PayQR(customerID, strings.Replace(code, "54040.10", "54040.90", 1), 0)
+		assert.ErrorIs(t, err, aggregation.ErrQRChecksum)
+	})
+
+	customer, _ := walletRepo.FindByUserID(customerID)
+	merchant, _ := walletRepo.FindByUserID(merchantID)
+	assert.Equal(t, []int{25, 75}, []int{customer.Balance, merchant.Balance})
+}
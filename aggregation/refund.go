@@ -0,0 +1,228 @@
+package aggregation
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+var ErrTransactionNotFound = errors.New("transaction not found")
+var ErrNotRefundable = errors.New("transaction can't be refunded")
+var ErrAlreadyReversed = errors.New("transaction is already reversed or refunded")
+var ErrRefundOverAmount = errors.New("is over the refundable amount")
+
+// Refund gives part of a transaction back to the payer, out of the wallet
+// that received it. Refunds add up until the whole amount is given back,
+// amount is in the currency the payer paid in.
+func (t Transaction) Refund(transactionID string, amount int, opts ...TransactionOption) (entity.Transaction, error) {
+	o := newTransactionOptions(opts)
+	fingerprint := requestFingerprint("refund", transactionID, amount, o.reference, o.note)
+
+	var result entity.Transaction
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		original, err := t.refundable(trx, transactionID)
+		if err != nil {
+			return err
+		}
+
+		// the recipient gives the money back, retries are scoped to them
+		result, err = idempotent(t, trx, original.TargetUserID, o, fingerprint, transactionResult, func() (entity.Transaction, error) {
+			return t.refund(trx, original, amount, entity.TransactionTypeRefund, o.note)
+		})
+		return err
+	})
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	return result, nil
+}
+
+// Reverse gives back whatever is left of a transaction, a transaction is
+// reversed at most once.
+func (t Transaction) Reverse(transactionID, reason string) (entity.Transaction, error) {
+	var result entity.Transaction
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		original, err := t.refundable(trx, transactionID)
+		if err != nil {
+			return err
+		}
+
+		result, err = t.refund(trx, original, original.Amount-original.Refunded, entity.TransactionTypeReversal, reason)
+		return err
+	})
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	return result, nil
+}
+
+func (t Transaction) refundable(trx *db.Transaction, transactionID string) (entity.Transaction, error) {
+	original, err := t.transactionRepo.FindById(transactionID, trx)
+	if err == db.ErrNotFound {
+		return entity.Transaction{}, ErrTransactionNotFound
+	}
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	// payments come back out of the merchant's wallet like any transfer
+	switch original.Type {
+	case entity.TransactionTypeTransfer, entity.TransactionTypeMove, entity.TransactionTypeCapture, entity.TransactionTypePayment:
+	default:
+		return entity.Transaction{}, ErrNotRefundable
+	}
+
+	if original.Status != entity.TransactionStatusCompleted || original.Refunded >= original.Amount {
+		return entity.Transaction{}, ErrAlreadyReversed
+	}
+
+	return original, nil
+}
+
+// refund posts the compensating movement, from the wallet that received the
+// original to the wallet that paid it.
+func (t Transaction) refund(trx *db.Transaction, original entity.Transaction, amount int, transactionType entity.TransactionType, note string) (entity.Transaction, error) {
+	if err := validateAmount(amount); err != nil {
+		return entity.Transaction{}, err
+	}
+	if amount > original.Amount-original.Refunded {
+		return entity.Transaction{}, &AmountError{Field: "amount", Amount: amount, Reason: ErrRefundOverAmount}
+	}
+
+	// the received side is given back in proportion, summing the parts of
+	// every refund so the rounding can't drift from what was received
+	received := original.TargetAmount
+	if received == 0 {
+		// recorded before target amounts
+		received = original.Amount
+	}
+	returned := proportion(received, original.Refunded+amount, original.Amount) - proportion(received, original.Refunded, original.Amount)
+
+	payer, err := t.walletRepo.FindById(original.TargetWalletID, trx)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	payee, err := t.walletRepo.FindById(original.SourceWalletID, trx)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	// admins reverse whatever the status of the wallets
+	if transactionType == entity.TransactionTypeRefund {
+		if err := canSend(payer); err != nil {
+			return entity.Transaction{}, err
+		}
+		if err := canReceive(payee); err != nil {
+			return entity.Transaction{}, err
+		}
+	}
+
+	if payer.Available()-returned < 0 {
+		return entity.Transaction{}, ErrInsuficientFound
+	}
+
+	payer.Balance, err = debit(payer.Balance, returned)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+	payee.Balance, err = credit(payee.Balance, amount)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	if err := t.walletRepo.Put(payer, trx); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	if err := t.walletRepo.Put(payee, trx); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	record := entity.Transaction{
+		ID:                    uuid.New().String(),
+		Type:                  transactionType,
+		Status:                entity.TransactionStatusCompleted,
+		SourceUserID:          payer.UserID,
+		SourceWalletID:        payer.ID,
+		TargetUserID:          payee.UserID,
+		TargetWalletID:        payee.ID,
+		Amount:                returned,
+		Currency:              entity.NormalizeCurrency(payer.Currency),
+		TargetAmount:          amount,
+		TargetCurrency:        entity.NormalizeCurrency(payee.Currency),
+		Rate:                  original.Rate,
+		Note:                  note,
+		OriginalTransactionID: original.ID,
+		CreatedAt:             t.db.Now(),
+	}
+	if err := t.transactionRepo.Put(record, trx); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	legs := []Leg{
+		{AccountID: payer.ID, Amount: -returned},
+		{AccountID: payee.ID, Amount: amount},
+	}
+	if record.Currency != record.TargetCurrency {
+		legs = append(legs,
+			Leg{AccountID: entity.FXAccount(record.Currency), Amount: returned},
+			Leg{AccountID: entity.FXAccount(record.TargetCurrency), Amount: -amount},
+		)
+	}
+	if err := t.ledger.post(trx, record, legs...); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	if err := t.mutationRepo.Put(entity.Mutation{
+		ID:                   uuid.New().String(),
+		TransactionID:        record.ID,
+		WalletID:             payer.ID,
+		UserID:               payer.UserID,
+		CounterpartyWalletID: payee.ID,
+		CounterpartyUserID:   payee.UserID,
+		Type:                 entity.MutationTypeDebit, // down
+		Amount:               returned,
+		CreatedAt:            record.CreatedAt,
+	}, trx); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	if err := t.mutationRepo.Put(entity.Mutation{
+		ID:                   uuid.New().String(),
+		TransactionID:        record.ID,
+		WalletID:             payee.ID,
+		UserID:               payee.UserID,
+		CounterpartyWalletID: payer.ID,
+		CounterpartyUserID:   payer.UserID,
+		Type:                 entity.MutationTypeCredit, // topup
+		Amount:               amount,
+		CreatedAt:            record.CreatedAt,
+	}, trx); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	original.Refunded += amount
+	if original.Refunded == original.Amount {
+		original.Status = entity.TransactionStatusRefunded
+		if transactionType == entity.TransactionTypeReversal {
+			original.Status = entity.TransactionStatusReversed
+		}
+	}
+	if err := t.transactionRepo.Put(original, trx); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	return record, nil
+}
+
+// proportion is total * part / whole rounded down, without overflowing.
+func proportion(total, part, whole int) int {
+	result := new(big.Int).Mul(big.NewInt(int64(total)), big.NewInt(int64(part)))
+	return int(result.Quo(result, big.NewInt(int64(whole))).Int64())
+}
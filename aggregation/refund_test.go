@@ -0,0 +1,135 @@
+package aggregation_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefund(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	transactionRepo := repository.NewTransaction(dbInstance)
+
+	sourceUserID := uuid.New().String()
+	targetUserID := uuid.New().String()
+	userRepo.Put(entity.User{ID: sourceUserID, Email: "source@example.com"})
+	userRepo.Put(entity.User{ID: targetUserID, Email: "target@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: sourceUserID})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: targetUserID})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+
+	topUp, err := transaction.TopUp(sourceUserID, 100)
+	assert.NoError(t, err)
+	_, err = transaction.Refund(topUp.ID, 10)
+	assert.ErrorIs(t, err, aggregation.ErrNotRefundable)
+
+	original, err := transaction.Transfer(sourceUserID, targetUserID, 80)
+	assert.NoError(t, err)
+
+	refund, err := transaction.Refund(original.ID, 30)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.TransactionTypeRefund, refund.Type)
+	assert.Equal(t, original.ID, refund.OriginalTransactionID)
+
+	_, err = transaction.Refund(original.ID, 60)
+	assert.ErrorIs(t, err, aggregation.ErrRefundOverAmount)
+
+	stored, _ := transactionRepo.FindById(original.ID)
+	assert.Equal(t, 30, stored.Refunded)
+	assert.Equal(t, entity.TransactionStatusCompleted, stored.Status)
+
+	// the recipient spent part of it, the reversal can't be covered
+	_, err = transaction.Transfer(targetUserID, sourceUserID, 40)
+	assert.NoError(t, err)
+	_, err = transaction.Reverse(original.ID, "fraud")
+	assert.ErrorIs(t, err, aggregation.ErrInsuficientFound)
+
+	_, err = transaction.TopUp(targetUserID, 40)
+	assert.NoError(t, err)
+	reversal, err := transaction.Reverse(original.ID, "fraud")
+	assert.NoError(t, err)
+	assert.Equal(t, 50, reversal.Amount)
+	assert.Equal(t, "fraud", reversal.Note)
+
+	stored, _ = transactionRepo.FindById(original.ID)
+	assert.Equal(t, entity.TransactionStatusReversed, stored.Status)
+	assert.Equal(t, 80, stored.Refunded)
+
+	_, err = transaction.Reverse(original.ID, "again")
+	assert.ErrorIs(t, err, aggregation.ErrAlreadyReversed)
+	_, err = transaction.Refund(original.ID, 1)
+	assert.ErrorIs(t, err, aggregation.ErrAlreadyReversed)
+
+	// 100 topped up, 80 sent and given back, 40 sent back by the target
+	source, _ := walletRepo.FindByUserID(sourceUserID)
+	target, _ := walletRepo.FindByUserID(targetUserID)
+	assert.Equal(t, 140, source.Balance)
+	assert.Equal(t, 0, target.Balance)
+
+	mutations, err := mutationRepo.GetByUserID(targetUserID)
+	assert.NoError(t, err)
+	refunds := 0
+	for _, mutation := range mutations {
+		if mutation.TransactionID == refund.ID || mutation.TransactionID == reversal.ID {
+			assert.Equal(t, entity.MutationTypeDebit, mutation.Type)
+			refunds++
+		}
+	}
+	assert.Equal(t, 2, refunds)
+
+	discrepancies, err := aggregation.NewLedger(walletRepo, dbInstance).Reconcile()
+	assert.NoError(t, err)
+	assert.Empty(t, discrepancies)
+}
+
+func TestRefundPayment(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	transactionRepo := repository.NewTransaction(dbInstance)
+
+	merchantID := uuid.New().String()
+	customerID := uuid.New().String()
+	userRepo.Put(entity.User{ID: merchantID, Email: "shop@example.com", Type: entity.UserTypeMerchant})
+	userRepo.Put(entity.User{ID: customerID, Email: "customer@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: merchantID, Name: entity.PrimaryWalletName})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: customerID})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+
+	_, err := transaction.TopUp(customerID, 100)
+	assert.NoError(t, err)
+
+	intent, err := transaction.CreatePaymentIntent(merchantID, 40, time.Hour)
+	assert.NoError(t, err)
+	payment, err := transaction.PayPaymentIntent(customerID, intent.ID)
+	assert.NoError(t, err)
+
+	refund, err := transaction.Refund(payment.ID, 15)
+	assert.NoError(t, err)
+	assert.Equal(t, payment.ID, refund.OriginalTransactionID)
+
+	reversal, err := transaction.Reverse(payment.ID, "chargeback")
+	assert.NoError(t, err)
+	assert.Equal(t, 25, reversal.Amount)
+
+	stored, _ := transactionRepo.FindById(payment.ID)
+	assert.Equal(t, entity.TransactionStatusReversed, stored.Status)
+
+	merchant, _ := walletRepo.FindByUserID(merchantID)
+	customer, _ := walletRepo.FindByUserID(customerID)
+	assert.Equal(t, 0, merchant.Balance)
+	assert.Equal(t, 100, customer.Balance)
+}
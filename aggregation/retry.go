@@ -0,0 +1,16 @@
+package aggregation
+
+import "time"
+
+// retryBackoff is the wait before the next attempt of something that
+// failed attempts times, interval doubling with every attempt up to max.
+func retryBackoff(interval, max time.Duration, attempts int) time.Duration {
+	backoff := interval
+	for i := 1; i < attempts && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
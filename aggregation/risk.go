@@ -0,0 +1,519 @@
+package aggregation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+var ErrTransferBlocked = errors.New("transfer blocked by risk rules")
+var ErrTransferInReview = errors.New("transfer held for review")
+var ErrReviewNotFound = errors.New("risk review not found")
+var ErrReviewDecided = errors.New("risk review already decided")
+
+// RiskRule scores an outgoing transfer, 0 when the rule doesn't apply.
+type RiskRule interface {
+	Name() string
+	Score(in RiskInput) int
+}
+
+// RiskInput is what the rules see of a transfer. History holds the user's
+// earlier transactions, sent and received.
+type RiskInput struct {
+	User     entity.User
+	Target   entity.User
+	Amount   int
+	Currency string
+	Now      time.Time
+	History  []entity.Transaction
+}
+
+// RiskPolicy adds up the scores of the rules, a transfer is held for review
+// from ReviewScore and blocked from BlockScore. Zero disables the action.
+type RiskPolicy struct {
+	Rules       []RiskRule
+	ReviewScore int
+	BlockScore  int
+}
+
+type RiskAssessment struct {
+	Action  entity.RiskAction `json:"action"`
+	Score   int               `json:"score"`
+	Reasons []string          `json:"reasons"` // names of the rules that scored
+}
+
+func (p RiskPolicy) Evaluate(in RiskInput) RiskAssessment {
+	assessment := RiskAssessment{Action: entity.RiskActionAllow, Reasons: []string{}}
+	for _, rule := range p.Rules {
+		if score := rule.Score(in); score > 0 {
+			assessment.Score += score
+			assessment.Reasons = append(assessment.Reasons, rule.Name())
+		}
+	}
+
+	switch {
+	case p.BlockScore > 0 && assessment.Score >= p.BlockScore:
+		assessment.Action = entity.RiskActionBlock
+	case p.ReviewScore > 0 && assessment.Score >= p.ReviewScore:
+		assessment.Action = entity.RiskActionReview
+	}
+	return assessment
+}
+
+// WithRisk evaluates the policy on every outgoing payment to another user
+// before it commits: transfers, payments, captures, escrows and batches.
+func WithRisk(policy RiskPolicy) Option {
+	return func(t *Transaction) {
+		t.risk = policy
+	}
+}
+
+// NewRecipientRule flags large transfers to someone the user never paid
+// before. Amount is in the transfer currency.
+type NewRecipientRule struct {
+	Amount int
+	Points int
+}
+
+func (r NewRecipientRule) Name() string { return "new_recipient" }
+
+func (r NewRecipientRule) Score(in RiskInput) int {
+	if in.Amount < r.Amount {
+		return 0
+	}
+	for _, trx := range in.History {
+		if isTransfer(trx, in.User.ID, in.Target.ID) {
+			return 0
+		}
+	}
+	return r.Points
+}
+
+// ManyRecipientsRule flags paying more than Recipients different users
+// within the window, this transfer included.
+type ManyRecipientsRule struct {
+	Recipients int
+	Window     time.Duration
+	Points     int
+}
+
+func (r ManyRecipientsRule) Name() string { return "many_recipients" }
+
+func (r ManyRecipientsRule) Score(in RiskInput) int {
+	since := in.Now.Add(-r.Window)
+	recipients := map[string]bool{in.Target.ID: true}
+	for _, trx := range in.History {
+		if isTransfer(trx, in.User.ID, "") && trx.CreatedAt.After(since) {
+			recipients[trx.TargetUserID] = true
+		}
+	}
+
+	if len(recipients) > r.Recipients {
+		return r.Points
+	}
+	return 0
+}
+
+// PasswordChangeRule flags transfers soon after the password changed, the
+// usual first move on a taken over account.
+type PasswordChangeRule struct {
+	Window time.Duration
+	Points int
+}
+
+func (r PasswordChangeRule) Name() string { return "recent_password_change" }
+
+func (r PasswordChangeRule) Score(in RiskInput) int {
+	changedAt := in.User.PasswordChangedAt
+	if changedAt.IsZero() || changedAt.Before(in.Now.Add(-r.Window)) {
+		return 0
+	}
+	return r.Points
+}
+
+// RoundTripRule flags sending money back to someone who paid the user
+// within the window.
+type RoundTripRule struct {
+	Window time.Duration
+	Points int
+}
+
+func (r RoundTripRule) Name() string { return "round_trip" }
+
+func (r RoundTripRule) Score(in RiskInput) int {
+	since := in.Now.Add(-r.Window)
+	for _, trx := range in.History {
+		if isTransfer(trx, in.Target.ID, in.User.ID) && trx.CreatedAt.After(since) {
+			return r.Points
+		}
+	}
+	return 0
+}
+
+// isTransfer tells whether trx is a transfer from one user to the other, to
+// anyone when to is empty.
+func isTransfer(trx entity.Transaction, from, to string) bool {
+	return trx.Type == entity.TransactionTypeTransfer &&
+		trx.SourceUserID == from &&
+		(to == "" || trx.TargetUserID == to)
+}
+
+// RiskError is returned by an outgoing payment the rules didn't allow.
+// Review is set when the transfer was held for review.
+type RiskError struct {
+	RiskAssessment
+	Review entity.RiskReview
+
+	currency string // of the rolled back transfer
+}
+
+func (e *RiskError) Error() string {
+	return fmt.Sprintf("%s: score %d (%s)", e.Unwrap(), e.Score, strings.Join(e.Reasons, ", "))
+}
+
+func (e *RiskError) Unwrap() error {
+	if e.Action == entity.RiskActionReview {
+		return ErrTransferInReview
+	}
+	return ErrTransferBlocked
+}
+
+// assessRisk runs inside the transaction of an outgoing payment to the
+// target, after the money moved, so the rules see a payment that would
+// otherwise commit. Allowed payments record their decision together with
+// the payment, anything else rolls it back and is recorded by recordRisk.
+func (t Transaction) assessRisk(trx *db.Transaction, record entity.Transaction, targetID string) error {
+	if len(t.risk.Rules) == 0 {
+		return nil
+	}
+
+	user, err := t.userRepo.FindById(record.SourceUserID, trx)
+	if err != nil {
+		return err
+	}
+
+	target, err := t.userRepo.FindById(targetID, trx)
+	if err != nil {
+		return err
+	}
+
+	transactions, err := t.transactionRepo.GetByUserID(user.ID, trx)
+	if err != nil && err != db.ErrNotFound {
+		return err
+	}
+
+	history := []entity.Transaction{}
+	for _, transaction := range transactions {
+		if transaction.ID != record.ID {
+			history = append(history, transaction)
+		}
+	}
+
+	assessment := t.risk.Evaluate(RiskInput{
+		User:     user,
+		Target:   target,
+		Amount:   record.Amount,
+		Currency: record.Currency,
+		Now:      record.CreatedAt,
+		History:  history,
+	})
+	if assessment.Action != entity.RiskActionAllow {
+		return &RiskError{RiskAssessment: assessment, currency: record.Currency}
+	}
+
+	return t.riskDecisionRepo.Put(entity.RiskDecision{
+		ID:            uuid.New().String(),
+		UserID:        user.ID,
+		TargetUserID:  target.ID,
+		Amount:        record.Amount,
+		Currency:      record.Currency,
+		Score:         assessment.Score,
+		Reasons:       assessment.Reasons,
+		Action:        assessment.Action,
+		TransactionID: record.ID,
+		CreatedAt:     record.CreatedAt,
+	}, trx)
+}
+
+// assessedTransfer is a transfer checked by the risk rules, a RiskError
+// means it has to be rolled back.
+func (t Transaction) assessedTransfer(trx *db.Transaction, userID, targetID string, amount int, o transactionOptions) (entity.Transaction, error) {
+	record, err := t.transfer(trx, userID, targetID, amount, o)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+	return record, t.assessRisk(trx, record, record.TargetUserID)
+}
+
+// unreviewable turns a payment the rules hold for review into a blocked
+// one, for payments ApproveReview can't make later: captures, escrows and
+// items of an atomic batch.
+func unreviewable(err error) error {
+	var riskErr *RiskError
+	if errors.As(err, &riskErr) && riskErr.Action == entity.RiskActionReview {
+		riskErr.Action = entity.RiskActionBlock
+	}
+	return err
+}
+
+// heldTransfer answers a retry of a request held for review, found by its
+// Idempotency-Key: the transfer once the review is approved, a block once
+// it is rejected, the review otherwise. found is false when no review holds
+// the key.
+func (t Transaction) heldTransfer(trx *db.Transaction, userID string, o transactionOptions, fingerprint string) (record entity.Transaction, found bool, err error) {
+	if o.idempotencyKey == "" {
+		return entity.Transaction{}, false, nil
+	}
+
+	review, err := t.riskReviewRepo.FindByIdempotencyKey(userID, o.idempotencyKey, trx)
+	if err == db.ErrNotFound {
+		return entity.Transaction{}, false, nil
+	}
+	if err != nil {
+		return entity.Transaction{}, true, err
+	}
+
+	if review.Fingerprint != fingerprint {
+		return entity.Transaction{}, true, ErrIdempotencyKeyReused
+	}
+	if review.TransactionID != "" {
+		record, err = t.transactionRepo.FindById(review.TransactionID, trx)
+		return record, true, err
+	}
+
+	action := entity.RiskActionReview
+	if review.Status == entity.RiskReviewStatusRejected {
+		action = entity.RiskActionBlock
+	}
+
+	return entity.Transaction{}, true, &RiskError{
+		RiskAssessment: RiskAssessment{Action: action, Score: review.Score, Reasons: review.Reasons},
+		Review:         review,
+		currency:       review.Currency,
+	}
+}
+
+// recordRisk writes the decision of a transfer that was rolled back, and
+// queues it for review when it was held. The review keeps the request's
+// Idempotency-Key and fingerprint, its retries get the same review.
+func (t Transaction) recordRisk(trx *db.Transaction, riskErr *RiskError, userID, targetID string, amount int, o transactionOptions, fingerprint string) error {
+	// a retry answered by heldTransfer, recorded the first time
+	if riskErr.Review.ID != "" {
+		return nil
+	}
+
+	now := t.db.Now()
+	decision := entity.RiskDecision{
+		ID:           uuid.New().String(),
+		UserID:       userID,
+		TargetUserID: targetID,
+		Amount:       amount,
+		Currency:     riskErr.currency,
+		Score:        riskErr.Score,
+		Reasons:      riskErr.Reasons,
+		Action:       riskErr.Action,
+		CreatedAt:    now,
+	}
+
+	if riskErr.Action == entity.RiskActionReview {
+		// a retry racing this one was held first
+		if o.idempotencyKey != "" {
+			existing, err := t.riskReviewRepo.FindByIdempotencyKey(userID, o.idempotencyKey, trx)
+			if err == nil {
+				if existing.Fingerprint != fingerprint {
+					return ErrIdempotencyKeyReused
+				}
+				riskErr.Review = existing
+				return nil
+			}
+			if err != db.ErrNotFound {
+				return err
+			}
+		}
+
+		riskErr.Review = entity.RiskReview{
+			ID:               uuid.New().String(),
+			UserID:           userID,
+			TargetUserID:     targetID,
+			Amount:           amount,
+			Currency:         riskErr.currency,
+			TargetCurrency:   o.targetCurrency,
+			SourceWalletID:   o.sourceWalletID,
+			PaymentRequestID: o.paymentRequestID,
+			PaymentIntentID:  o.paymentIntentID,
+			BatchID:          o.batchID,
+			BatchItem:        o.batchItem,
+			ScheduledRunID:   o.scheduledRunID,
+			Reference:        o.reference,
+			Note:             o.note,
+			Score:            riskErr.Score,
+			Reasons:          riskErr.Reasons,
+			Status:           entity.RiskReviewStatusPending,
+			IdempotencyKey:   o.idempotencyKey,
+			Fingerprint:      fingerprint,
+			CreatedAt:        now,
+		}
+		if err := t.riskReviewRepo.Put(riskErr.Review, trx); err != nil {
+			return err
+		}
+		decision.ReviewID = riskErr.Review.ID
+	}
+
+	return t.riskDecisionRepo.Put(decision, trx)
+}
+
+// ApproveReview makes the held transfer. It is checked again like any other
+// transfer except for the risk rules, a review whose transfer fails, say for
+// insufficient funds or a payment request no longer pending, stays pending.
+// The merchant of a payment intent it pays is told like for any payment,
+// the batch item it pays is completed.
+func (t Transaction) ApproveReview(reviewID, adminID string) (entity.Transaction, error) {
+	var result entity.Transaction
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		review, err := t.pendingReview(trx, reviewID)
+		if err != nil {
+			return err
+		}
+
+		// the payment request or intent it pays can't be paid twice
+		if review.PaymentRequestID != "" {
+			if _, err := t.pendingPaymentRequest(trx, review.PaymentRequestID); err != nil {
+				return err
+			}
+		}
+		if review.PaymentIntentID != "" {
+			if _, err := t.pendingPaymentIntent(trx, review.PaymentIntentID); err != nil {
+				return err
+			}
+		}
+
+		result, err = t.transfer(trx, review.UserID, review.TargetUserID, review.Amount, transactionOptions{
+			reference:      review.Reference,
+			note:           review.Note,
+			currency:       review.Currency,
+			targetCurrency: review.TargetCurrency,
+			sourceWalletID: review.SourceWalletID,
+
+			paymentIntentID: review.PaymentIntentID,
+		})
+		if err != nil {
+			return err
+		}
+
+		if review.PaymentRequestID != "" {
+			if err := t.payPaymentRequest(trx, review.PaymentRequestID, result); err != nil {
+				return err
+			}
+		}
+		if review.PaymentIntentID != "" {
+			if _, err := t.payPaymentIntent(trx, review.PaymentIntentID, review.UserID, result); err != nil {
+				return err
+			}
+		}
+
+		if review.BatchID != "" {
+			if err := t.decideBatchItem(trx, review, result.ID); err != nil {
+				return err
+			}
+		}
+		if review.ScheduledRunID != "" {
+			if err := t.decideScheduledRun(trx, review, result.ID); err != nil {
+				return err
+			}
+		}
+
+		review.TransactionID = result.ID
+		return t.decideReview(trx, review, entity.RiskReviewStatusApproved, adminID, "")
+	})
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	return result, nil
+}
+
+// RejectReview drops the held transfer, no money moves. The batch item it
+// would have paid fails.
+func (t Transaction) RejectReview(reviewID, adminID, reason string) (entity.RiskReview, error) {
+	var result entity.RiskReview
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		review, err := t.pendingReview(trx, reviewID)
+		if err != nil {
+			return err
+		}
+
+		if review.BatchID != "" {
+			if err := t.decideBatchItem(trx, review, ""); err != nil {
+				return err
+			}
+		}
+		if review.ScheduledRunID != "" {
+			if err := t.decideScheduledRun(trx, review, ""); err != nil {
+				return err
+			}
+		}
+
+		if err := t.decideReview(trx, review, entity.RiskReviewStatusRejected, adminID, reason); err != nil {
+			return err
+		}
+
+		result, err = t.riskReviewRepo.FindById(review.ID, trx)
+		return err
+	})
+	if err != nil {
+		return entity.RiskReview{}, err
+	}
+
+	return result, nil
+}
+
+func (t Transaction) pendingReview(trx *db.Transaction, reviewID string) (entity.RiskReview, error) {
+	review, err := t.riskReviewRepo.FindById(reviewID, trx)
+	if err == db.ErrNotFound {
+		return entity.RiskReview{}, ErrReviewNotFound
+	}
+	if err != nil {
+		return entity.RiskReview{}, err
+	}
+
+	if review.Status != entity.RiskReviewStatusPending {
+		return entity.RiskReview{}, ErrReviewDecided
+	}
+
+	return review, nil
+}
+
+func (t Transaction) decideReview(trx *db.Transaction, review entity.RiskReview, status entity.RiskReviewStatus, adminID, reason string) error {
+	review.Status = status
+	review.DecidedBy = adminID
+	review.DecisionReason = reason
+	review.DecidedAt = t.db.Now()
+	if err := t.riskReviewRepo.Put(review, trx); err != nil {
+		return err
+	}
+
+	action := entity.RiskActionAllow
+	if status == entity.RiskReviewStatusRejected {
+		action = entity.RiskActionBlock
+	}
+
+	return t.riskDecisionRepo.Put(entity.RiskDecision{
+		ID:            uuid.New().String(),
+		UserID:        review.UserID,
+		TargetUserID:  review.TargetUserID,
+		Amount:        review.Amount,
+		Currency:      review.Currency,
+		Score:         review.Score,
+		Reasons:       review.Reasons,
+		Action:        action,
+		ReviewID:      review.ID,
+		TransactionID: review.TransactionID,
+		DecidedBy:     adminID,
+		CreatedAt:     review.DecidedAt,
+	}, trx)
+}
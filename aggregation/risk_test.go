@@ -0,0 +1,355 @@
+package aggregation_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRiskRules(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	user := entity.User{ID: "user"}
+	target := entity.User{ID: "target"}
+	transfer := func(from, to string, ago time.Duration) entity.Transaction {
+		return entity.Transaction{
+			Type:         entity.TransactionTypeTransfer,
+			SourceUserID: from,
+			TargetUserID: to,
+			CreatedAt:    now.Add(-ago),
+		}
+	}
+
+	t.Run("new recipient", func(t *testing.T) {
+		rule := aggregation.NewRecipientRule{Amount: 1000, Points: 30}
+		in := aggregation.RiskInput{User: user, Target: target, Amount: 1000, Now: now}
+		assert.Equal(t, 30, rule.Score(in))
+
+		in.History = []entity.Transaction{transfer(user.ID, target.ID, 24*time.Hour)}
+		assert.Equal(t, 0, rule.Score(in))
+
+		// paid the other way doesn't count
+		in.History = []entity.Transaction{transfer(target.ID, user.ID, time.Hour)}
+		assert.Equal(t, 30, rule.Score(in))
+
+		in.Amount = 999
+		assert.Equal(t, 0, rule.Score(in))
+	})
+
+	t.Run("many recipients", func(t *testing.T) {
+		rule := aggregation.ManyRecipientsRule{Recipients: 2, Window: time.Hour, Points: 40}
+		in := aggregation.RiskInput{User: user, Target: target, Now: now, History: []entity.Transaction{
+			transfer(user.ID, "a", 10*time.Minute),
+			transfer(user.ID, target.ID, 20*time.Minute),
+		}}
+		assert.Equal(t, 0, rule.Score(in))
+
+		in.History = append(in.History, transfer(user.ID, "b", 2*time.Hour))
+		assert.Equal(t, 0, rule.Score(in))
+
+		in.History = append(in.History, transfer(user.ID, "b", 30*time.Minute))
+		assert.Equal(t, 40, rule.Score(in))
+	})
+
+	t.Run("recent password change", func(t *testing.T) {
+		rule := aggregation.PasswordChangeRule{Window: 24 * time.Hour, Points: 50}
+		in := aggregation.RiskInput{User: user, Target: target, Now: now}
+		assert.Equal(t, 0, rule.Score(in))
+
+		in.User.PasswordChangedAt = now.Add(-time.Hour)
+		assert.Equal(t, 50, rule.Score(in))
+
+		in.User.PasswordChangedAt = now.Add(-25 * time.Hour)
+		assert.Equal(t, 0, rule.Score(in))
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		rule := aggregation.RoundTripRule{Window: time.Hour, Points: 30}
+		in := aggregation.RiskInput{User: user, Target: target, Now: now, History: []entity.Transaction{
+			transfer(user.ID, target.ID, 10*time.Minute),
+		}}
+		assert.Equal(t, 0, rule.Score(in))
+
+		in.History = append(in.History, transfer(target.ID, user.ID, 2*time.Hour))
+		assert.Equal(t, 0, rule.Score(in))
+
+		in.History = append(in.History, transfer(target.ID, user.ID, 5*time.Minute))
+		assert.Equal(t, 30, rule.Score(in))
+	})
+
+	t.Run("policy", func(t *testing.T) {
+		policy := aggregation.RiskPolicy{
+			Rules: []aggregation.RiskRule{
+				aggregation.NewRecipientRule{Amount: 100, Points: 30},
+				aggregation.PasswordChangeRule{Window: time.Hour, Points: 50},
+			},
+			ReviewScore: 30,
+			BlockScore:  80,
+		}
+
+		in := aggregation.RiskInput{User: user, Target: target, Amount: 10, Now: now}
+		assert.Equal(t, aggregation.RiskAssessment{Action: entity.RiskActionAllow, Reasons: []string{}}, policy.Evaluate(in))
+
+		in.Amount = 100
+		assessment := policy.Evaluate(in)
+		assert.Equal(t, entity.RiskActionReview, assessment.Action)
+		assert.Equal(t, []string{"new_recipient"}, assessment.Reasons)
+
+		in.User.PasswordChangedAt = now
+		assessment = policy.Evaluate(in)
+		assert.Equal(t, entity.RiskActionBlock, assessment.Action)
+		assert.Equal(t, 80, assessment.Score)
+	})
+}
+
+func TestTransferRisk(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	riskReviewRepo := repository.NewRiskReview(dbInstance)
+	riskDecisionRepo := repository.NewRiskDecision(dbInstance)
+
+	sourceUserID := uuid.New().String()
+	targetUserID := uuid.New().String()
+	userRepo.Put(entity.User{ID: sourceUserID, Email: "source@example.com"})
+	userRepo.Put(entity.User{ID: targetUserID, Email: "target@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: sourceUserID, Balance: 1000})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: targetUserID})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance,
+		aggregation.WithRisk(aggregation.RiskPolicy{
+			Rules: []aggregation.RiskRule{
+				aggregation.NewRecipientRule{Amount: 100, Points: 30},
+				aggregation.RoundTripRule{Window: time.Hour, Points: 20},
+			},
+			ReviewScore: 30,
+			BlockScore:  50,
+		}),
+	)
+
+	// small amounts to a new recipient go through
+	trx, err := transaction.Transfer(sourceUserID, targetUserID, 50)
+	assert.NoError(t, err)
+
+	decisions, err := riskDecisionRepo.GetByUserID(sourceUserID)
+	assert.NoError(t, err)
+	assert.Len(t, decisions, 1)
+	assert.Equal(t, entity.RiskActionAllow, decisions[0].Action)
+	assert.Equal(t, trx.ID, decisions[0].TransactionID)
+
+	t.Run("review", func(t *testing.T) {
+		var riskErr *aggregation.RiskError
+
+		otherUserID := uuid.New().String()
+		userRepo.Put(entity.User{ID: otherUserID, Email: "other@example.com"})
+		walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: otherUserID})
+
+		_, err := transaction.Transfer(sourceUserID, otherUserID, 300, aggregation.WithReference("inv-1"), aggregation.WithIdempotencyKey("review-1"))
+		assert.ErrorIs(t, err, aggregation.ErrTransferInReview)
+		assert.ErrorAs(t, err, &riskErr)
+		assert.Equal(t, []string{"new_recipient"}, riskErr.Reasons)
+
+		// a retry gets the same review
+		var retryErr *aggregation.RiskError
+		_, err = transaction.Transfer(sourceUserID, otherUserID, 300, aggregation.WithReference("inv-1"), aggregation.WithIdempotencyKey("review-1"))
+		assert.ErrorIs(t, err, aggregation.ErrTransferInReview)
+		assert.ErrorAs(t, err, &retryErr)
+		assert.Equal(t, riskErr.Review.ID, retryErr.Review.ID)
+
+		_, err = transaction.Transfer(sourceUserID, otherUserID, 301, aggregation.WithReference("inv-1"), aggregation.WithIdempotencyKey("review-1"))
+		assert.ErrorIs(t, err, aggregation.ErrIdempotencyKeyReused)
+
+		// nothing moved
+		source, _ := walletRepo.FindByUserID(sourceUserID)
+		assert.Equal(t, 950, source.Balance)
+
+		reviews, err := riskReviewRepo.GetByStatus(entity.RiskReviewStatusPending)
+		assert.NoError(t, err)
+		assert.Len(t, reviews, 1)
+		assert.Equal(t, riskErr.Review.ID, reviews[0].ID)
+		assert.Equal(t, "inv-1", reviews[0].Reference)
+		assert.Equal(t, "IDR", reviews[0].Currency)
+
+		trx, err := transaction.ApproveReview(riskErr.Review.ID, "admin")
+		assert.NoError(t, err)
+		assert.Equal(t, 300, trx.Amount)
+		assert.Equal(t, "inv-1", trx.Reference)
+
+		source, _ = walletRepo.FindByUserID(sourceUserID)
+		assert.Equal(t, 650, source.Balance)
+
+		review, _ := riskReviewRepo.FindById(riskErr.Review.ID)
+		assert.Equal(t, entity.RiskReviewStatusApproved, review.Status)
+		assert.Equal(t, "admin", review.DecidedBy)
+		assert.Equal(t, trx.ID, review.TransactionID)
+
+		// once approved a retry gets the transfer
+		replay, err := transaction.Transfer(sourceUserID, otherUserID, 300, aggregation.WithReference("inv-1"), aggregation.WithIdempotencyKey("review-1"))
+		assert.NoError(t, err)
+		assert.Equal(t, trx.ID, replay.ID)
+
+		source, _ = walletRepo.FindByUserID(sourceUserID)
+		assert.Equal(t, 650, source.Balance)
+
+		_, err = transaction.ApproveReview(riskErr.Review.ID, "admin")
+		assert.ErrorIs(t, err, aggregation.ErrReviewDecided)
+		_, err = transaction.RejectReview(uuid.New().String(), "admin", "")
+		assert.ErrorIs(t, err, aggregation.ErrReviewNotFound)
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		var riskErr *aggregation.RiskError
+
+		otherUserID := uuid.New().String()
+		userRepo.Put(entity.User{ID: otherUserID, Email: "another@example.com"})
+		walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: otherUserID})
+
+		_, err := transaction.Transfer(sourceUserID, otherUserID, 100, aggregation.WithIdempotencyKey("reject-1"))
+		assert.ErrorAs(t, err, &riskErr)
+
+		review, err := transaction.RejectReview(riskErr.Review.ID, "admin", "mule account")
+		assert.NoError(t, err)
+		assert.Equal(t, entity.RiskReviewStatusRejected, review.Status)
+		assert.Equal(t, "mule account", review.DecisionReason)
+
+		// once rejected a retry is blocked
+		var retryErr *aggregation.RiskError
+		_, err = transaction.Transfer(sourceUserID, otherUserID, 100, aggregation.WithIdempotencyKey("reject-1"))
+		assert.ErrorIs(t, err, aggregation.ErrTransferBlocked)
+		assert.ErrorAs(t, err, &retryErr)
+		assert.Equal(t, review.ID, retryErr.Review.ID)
+
+		source, _ := walletRepo.FindByUserID(sourceUserID)
+		assert.Equal(t, 650, source.Balance)
+	})
+
+	t.Run("block", func(t *testing.T) {
+		// the target sent money back and forth and asks for a large amount
+		_, err := transaction.Transfer(targetUserID, sourceUserID, 10)
+		assert.NoError(t, err)
+
+		_, err = transaction.Transfer(sourceUserID, targetUserID, 10)
+		assert.NoError(t, err, "round trip alone only scores 20")
+
+		newUserID := uuid.New().String()
+		userRepo.Put(entity.User{ID: newUserID, Email: "new@example.com"})
+		walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: newUserID, Balance: 500})
+		_, err = transaction.Transfer(newUserID, sourceUserID, 10)
+		assert.NoError(t, err)
+
+		var riskErr *aggregation.RiskError
+		_, err = transaction.Transfer(sourceUserID, newUserID, 200)
+		assert.ErrorIs(t, err, aggregation.ErrTransferBlocked)
+		assert.ErrorAs(t, err, &riskErr)
+		assert.Empty(t, riskErr.Review.ID)
+		assert.Equal(t, []string{"new_recipient", "round_trip"}, riskErr.Reasons)
+	})
+
+	decisions, err = riskDecisionRepo.GetByUserID(sourceUserID)
+	assert.NoError(t, err)
+
+	actions := map[entity.RiskAction]int{}
+	for _, decision := range decisions {
+		actions[decision.Action]++
+	}
+	// allow: 50, 10 and the approval; review: 300 and 100; block: the
+	// rejection and 200
+	assert.Equal(t, map[entity.RiskAction]int{
+		entity.RiskActionAllow:  3,
+		entity.RiskActionReview: 2,
+		entity.RiskActionBlock:  2,
+	}, actions)
+}
+
+func TestPaymentRisk(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	holdRepo := repository.NewHold(dbInstance)
+	riskReviewRepo := repository.NewRiskReview(dbInstance)
+	riskDecisionRepo := repository.NewRiskDecision(dbInstance)
+
+	sourceUserID := uuid.New().String()
+	targetUserID := uuid.New().String()
+	userRepo.Put(entity.User{ID: sourceUserID, Email: "source@example.com"})
+	userRepo.Put(entity.User{ID: targetUserID, Email: "target@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: sourceUserID, Balance: 1000})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: targetUserID})
+
+	// anything from 100 to a new recipient would be held for review
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance,
+		aggregation.WithRisk(aggregation.RiskPolicy{
+			Rules:       []aggregation.RiskRule{aggregation.NewRecipientRule{Amount: 100, Points: 30}},
+			ReviewScore: 30,
+		}),
+	)
+
+	lastDecision := func() entity.RiskDecision {
+		decisions, _ := riskDecisionRepo.GetByUserID(sourceUserID)
+		return decisions[len(decisions)-1]
+	}
+
+	// none of these can wait for an admin, they are blocked instead
+	t.Run("capture", func(t *testing.T) {
+		hold, err := transaction.Hold(sourceUserID, 200, time.Hour, aggregation.WithHoldTarget(targetUserID))
+		assert.NoError(t, err)
+
+		_, err = transaction.Capture(hold.ID, 150)
+		assert.ErrorIs(t, err, aggregation.ErrTransferBlocked)
+		assert.Equal(t, entity.RiskActionBlock, lastDecision().Action)
+		assert.Equal(t, targetUserID, lastDecision().TargetUserID)
+		assert.Equal(t, 150, lastDecision().Amount)
+
+		stored, _ := holdRepo.FindById(hold.ID)
+		assert.Equal(t, entity.HoldStatusActive, stored.Status)
+
+		trx, err := transaction.Capture(hold.ID, 50)
+		assert.NoError(t, err)
+		assert.Equal(t, entity.RiskActionAllow, lastDecision().Action)
+		assert.Equal(t, trx.ID, lastDecision().TransactionID)
+	})
+
+	t.Run("escrow", func(t *testing.T) {
+		_, err := transaction.OpenEscrow(sourceUserID, targetUserID, 150, time.Hour)
+		assert.ErrorIs(t, err, aggregation.ErrTransferBlocked)
+		assert.Equal(t, entity.RiskActionBlock, lastDecision().Action)
+		assert.Equal(t, targetUserID, lastDecision().TargetUserID)
+
+		escrow, err := transaction.OpenEscrow(sourceUserID, targetUserID, 50, time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, entity.RiskActionAllow, lastDecision().Action)
+		assert.Equal(t, escrow.FundingTransactionID, lastDecision().TransactionID)
+	})
+
+	t.Run("atomic batch", func(t *testing.T) {
+		otherUserID := uuid.New().String()
+		userRepo.Put(entity.User{ID: otherUserID, Email: "other@example.com"})
+		walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: otherUserID})
+
+		_, err := transaction.BatchTransfer(sourceUserID, entity.BatchModeAtomic, []entity.BatchItem{
+			{RecipientID: targetUserID, Amount: 10},
+			{RecipientID: otherUserID, Amount: 150},
+		})
+		var itemErr *aggregation.BatchItemError
+		assert.ErrorAs(t, err, &itemErr)
+		assert.Equal(t, 1, itemErr.Index)
+		assert.ErrorIs(t, err, aggregation.ErrTransferBlocked)
+		assert.Equal(t, entity.RiskActionBlock, lastDecision().Action)
+		assert.Equal(t, otherUserID, lastDecision().TargetUserID)
+	})
+
+	reviews, err := riskReviewRepo.GetByStatus("")
+	assert.NoError(t, err)
+	assert.Empty(t, reviews)
+
+	source, _ := walletRepo.FindByUserID(sourceUserID)
+	assert.Equal(t, 900, source.Balance)
+}
@@ -0,0 +1,393 @@
+package aggregation
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+var ErrScheduledTransferNotFound = errors.New("scheduled transfer not found")
+var ErrScheduledTransferNotActive = errors.New("scheduled transfer is no longer active")
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// scheduledTransferSchedule runs every due scheduled transfer, a missed
+// schedule is caught up by the next one like holdExpirySchedule.
+const scheduledTransferSchedule = "transfer.scheduled"
+
+// ScheduledTransferRetryInterval is the wait before a run that couldn't be
+// paid is tried again.
+const ScheduledTransferRetryInterval = time.Hour
+
+// ScheduledTransferUpdate changes a scheduled transfer, nil fields are left
+// as they are. A new Cron starts from its next time.
+type ScheduledTransferUpdate struct {
+	Amount              *int
+	Reference           *string
+	Note                *string
+	Cron                *string
+	EndAt               *time.Time
+	OnInsufficientFunds *string
+	MaxRetries          *int
+}
+
+// ScheduleTransfer sets up a transfer of the user to be paid at start, or
+// at every time of the cron expression from start on. Runs are paid like
+// Transfer, from the source wallet or the wallet of the currency.
+func (t Transaction) ScheduleTransfer(scheduled entity.ScheduledTransfer, start time.Time) (entity.ScheduledTransfer, error) {
+	if err := validateAmount(scheduled.Amount); err != nil {
+		return entity.ScheduledTransfer{}, err
+	}
+	if err := validateRetryPolicy(scheduled.OnInsufficientFunds, scheduled.MaxRetries); err != nil {
+		return entity.ScheduledTransfer{}, err
+	}
+	if scheduled.UserID == scheduled.TargetUserID {
+		return entity.ScheduledTransfer{}, ErrSameWallet
+	}
+
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		user, err := t.userRepo.FindById(scheduled.UserID, trx)
+		if err != nil {
+			return err
+		}
+		if user.IsClosed() {
+			return ErrAccountClosed
+		}
+
+		_, err = t.userRepo.FindById(scheduled.TargetUserID, trx)
+		if err == db.ErrNotFound {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if scheduled.SourceWalletID != "" {
+			wallet, err := t.ownWallet(trx, user.ID, scheduled.SourceWalletID)
+			if err != nil {
+				return err
+			}
+			scheduled.Currency = wallet.Currency
+		}
+
+		now := t.db.Now()
+		dueAt, err := firstRun(scheduled.Cron, start, now)
+		if err != nil {
+			return err
+		}
+		if !scheduled.EndAt.IsZero() && scheduled.EndAt.Before(dueAt) {
+			return ErrInvalidSchedule
+		}
+
+		scheduled.ID = uuid.New().String()
+		scheduled.Currency = entity.NormalizeCurrency(scheduled.Currency)
+		if scheduled.OnInsufficientFunds == "" {
+			scheduled.OnInsufficientFunds = entity.OnInsufficientFundsSkip
+		}
+		scheduled.Status = entity.ScheduledTransferStatusActive
+		scheduled.DueAt = dueAt
+		scheduled.NextRunAt = dueAt
+		scheduled.Attempts = 0
+		scheduled.CreatedAt = now
+		scheduled.UpdatedAt = now
+		if err := t.scheduledTransferRepo.Put(scheduled, trx); err != nil {
+			return err
+		}
+
+		trx.Schedule(scheduled.NextRunAt, scheduledTransferSchedule, t.runScheduledTransfers)
+		return nil
+	})
+	if err != nil {
+		return entity.ScheduledTransfer{}, err
+	}
+
+	return scheduled, nil
+}
+
+// UpdateScheduledTransfer changes an active scheduled transfer of the user.
+func (t Transaction) UpdateScheduledTransfer(userID, id string, update ScheduledTransferUpdate) (entity.ScheduledTransfer, error) {
+	var scheduled entity.ScheduledTransfer
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		scheduled, err = t.activeScheduledTransfer(trx, userID, id)
+		if err != nil {
+			return err
+		}
+
+		now := t.db.Now()
+		if update.Amount != nil {
+			if err := validateAmount(*update.Amount); err != nil {
+				return err
+			}
+			scheduled.Amount = *update.Amount
+		}
+		if update.Reference != nil {
+			scheduled.Reference = *update.Reference
+		}
+		if update.Note != nil {
+			scheduled.Note = *update.Note
+		}
+		if update.OnInsufficientFunds != nil {
+			scheduled.OnInsufficientFunds = *update.OnInsufficientFunds
+		}
+		if update.MaxRetries != nil {
+			scheduled.MaxRetries = *update.MaxRetries
+		}
+		if err := validateRetryPolicy(scheduled.OnInsufficientFunds, scheduled.MaxRetries); err != nil {
+			return err
+		}
+		if update.Cron != nil && *update.Cron != scheduled.Cron {
+			// a one off can't become recurring and the other way round
+			if *update.Cron == "" || scheduled.Cron == "" {
+				return ErrInvalidSchedule
+			}
+
+			dueAt, err := firstRun(*update.Cron, time.Time{}, now)
+			if err != nil {
+				return err
+			}
+			scheduled.Cron = *update.Cron
+			scheduled.DueAt = dueAt
+			scheduled.NextRunAt = dueAt
+			scheduled.Attempts = 0
+		}
+		if update.EndAt != nil {
+			scheduled.EndAt = *update.EndAt
+		}
+		if !scheduled.EndAt.IsZero() && scheduled.EndAt.Before(scheduled.DueAt) {
+			return ErrInvalidSchedule
+		}
+
+		scheduled.UpdatedAt = now
+		if err := t.scheduledTransferRepo.Put(scheduled, trx); err != nil {
+			return err
+		}
+
+		trx.Schedule(scheduled.NextRunAt, scheduledTransferSchedule, t.runScheduledTransfers)
+		return nil
+	})
+	if err != nil {
+		return entity.ScheduledTransfer{}, err
+	}
+
+	return scheduled, nil
+}
+
+// CancelScheduledTransfer stops an active scheduled transfer of the user,
+// its history is kept.
+func (t Transaction) CancelScheduledTransfer(userID, id string) (entity.ScheduledTransfer, error) {
+	var scheduled entity.ScheduledTransfer
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		scheduled, err = t.activeScheduledTransfer(trx, userID, id)
+		if err != nil {
+			return err
+		}
+
+		scheduled.Status = entity.ScheduledTransferStatusCancelled
+		scheduled.NextRunAt = time.Time{}
+		scheduled.UpdatedAt = t.db.Now()
+		return t.scheduledTransferRepo.Put(scheduled, trx)
+	})
+	if err != nil {
+		return entity.ScheduledTransfer{}, err
+	}
+
+	return scheduled, nil
+}
+
+func (t Transaction) activeScheduledTransfer(trx *db.Transaction, userID, id string) (entity.ScheduledTransfer, error) {
+	scheduled, err := t.scheduledTransferRepo.FindById(id, trx)
+	if err == db.ErrNotFound || (err == nil && scheduled.UserID != userID) {
+		return entity.ScheduledTransfer{}, ErrScheduledTransferNotFound
+	}
+	if err != nil {
+		return entity.ScheduledTransfer{}, err
+	}
+
+	if scheduled.Status != entity.ScheduledTransferStatusActive {
+		return entity.ScheduledTransfer{}, ErrScheduledTransferNotActive
+	}
+
+	return scheduled, nil
+}
+
+// runScheduledTransfers pays every due run, it runs as a schedule. Each run
+// sits in a savepoint, a run that fails is rolled back alone and recorded.
+func (t Transaction) runScheduledTransfers(trx *db.Transaction) error {
+	due, err := t.scheduledTransferRepo.GetDue(t.db.Now(), trx)
+	if err != nil {
+		return err
+	}
+
+	for _, scheduled := range due {
+		if err := t.runScheduledTransfer(trx, scheduled); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t Transaction) handleScheduledTransfers(trx *db.Transaction, _ db.Schedule) error {
+	return t.runScheduledTransfers(trx)
+}
+
+func (t Transaction) runScheduledTransfer(trx *db.Transaction, scheduled entity.ScheduledTransfer) error {
+	now := t.db.Now()
+	run := entity.ScheduledTransferRun{
+		ID:                  uuid.New().String(),
+		ScheduledTransferID: scheduled.ID,
+		UserID:              scheduled.UserID,
+		DueAt:               scheduled.DueAt,
+		RanAt:               now,
+		Attempt:             scheduled.Attempts + 1,
+	}
+	o := transactionOptions{
+		reference:      scheduled.Reference,
+		note:           scheduled.Note,
+		currency:       scheduled.Currency,
+		sourceWalletID: scheduled.SourceWalletID,
+		scheduledRunID: run.ID,
+	}
+
+	var record entity.Transaction
+	transferErr := trx.Savepoint(func(sp *db.Transaction) error {
+		var err error
+		record, err = t.assessedTransfer(sp, scheduled.UserID, scheduled.TargetUserID, scheduled.Amount, o)
+		return err
+	})
+
+	var riskErr *RiskError
+	if errors.As(transferErr, &riskErr) {
+		if err := t.recordRisk(trx, riskErr, scheduled.UserID, scheduled.TargetUserID, scheduled.Amount, o, ""); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case transferErr == nil:
+		run.Status = entity.ScheduledTransferRunSucceeded
+		run.TransactionID = record.ID
+		t.nextRun(&scheduled, now)
+	case riskErr != nil && riskErr.Action == entity.RiskActionReview:
+		// the review pays or drops the run, it isn't a failed try
+		run.Status = entity.ScheduledTransferRunHeld
+		run.ReviewID = riskErr.Review.ID
+		t.nextRun(&scheduled, now)
+	case transferErr == ErrInsuficientFound &&
+		scheduled.OnInsufficientFunds == entity.OnInsufficientFundsRetry &&
+		scheduled.Attempts < scheduled.MaxRetries:
+		run.Status = entity.ScheduledTransferRunRetrying
+		run.Error = transferErr.Error()
+		scheduled.Attempts++
+		scheduled.NextRunAt = now.Add(ScheduledTransferRetryInterval)
+	case transferErr == ErrInsuficientFound:
+		run.Status = entity.ScheduledTransferRunSkipped
+		run.Error = transferErr.Error()
+		t.nextRun(&scheduled, now)
+	default:
+		run.Status = entity.ScheduledTransferRunFailed
+		run.Error = transferErr.Error()
+		t.nextRun(&scheduled, now)
+	}
+
+	if err := t.scheduledTransferRunRepo.Put(run, trx); err != nil {
+		return err
+	}
+
+	scheduled.UpdatedAt = now
+	if err := t.scheduledTransferRepo.Put(scheduled, trx); err != nil {
+		return err
+	}
+
+	if scheduled.Status == entity.ScheduledTransferStatusActive {
+		trx.Schedule(scheduled.NextRunAt, scheduledTransferSchedule, t.runScheduledTransfers)
+	}
+	return nil
+}
+
+// decideScheduledRun applies the decided review of a held run, the run
+// succeeds with the approved transfer or fails with the rejection.
+func (t Transaction) decideScheduledRun(trx *db.Transaction, review entity.RiskReview, transactionID string) error {
+	run, err := t.scheduledTransferRunRepo.FindById(review.ScheduledRunID, trx)
+	if err != nil {
+		return err
+	}
+	if run.ReviewID != review.ID {
+		return ErrScheduledTransferNotFound
+	}
+
+	if transactionID != "" {
+		run.Status = entity.ScheduledTransferRunSucceeded
+		run.TransactionID = transactionID
+	} else {
+		run.Status = entity.ScheduledTransferRunFailed
+		run.Error = ErrTransferBlocked.Error()
+	}
+
+	return t.scheduledTransferRunRepo.Put(run, trx)
+}
+
+// nextRun moves a scheduled transfer to its next time after now, runs
+// missed while the service was down are not paid late. One offs and
+// transfers past their end are completed.
+func (t Transaction) nextRun(scheduled *entity.ScheduledTransfer, now time.Time) {
+	scheduled.Attempts = 0
+
+	var next time.Time
+	if cron, err := ParseCron(scheduled.Cron); scheduled.Cron != "" && err == nil {
+		next = cron.Next(now)
+	}
+
+	if next.IsZero() || (!scheduled.EndAt.IsZero() && next.After(scheduled.EndAt)) {
+		scheduled.Status = entity.ScheduledTransferStatusCompleted
+		scheduled.NextRunAt = time.Time{}
+		return
+	}
+
+	scheduled.DueAt = next
+	scheduled.NextRunAt = next
+}
+
+// firstRun is start for a one off, the first time of the cron expression
+// from start on otherwise, and from now on without a start.
+func firstRun(expr string, start, now time.Time) (time.Time, error) {
+	if expr == "" {
+		if !start.After(now) {
+			return time.Time{}, ErrInvalidSchedule
+		}
+		return start, nil
+	}
+
+	cron, err := ParseCron(expr)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	from := now
+	if start.After(now) {
+		// start itself counts when it matches
+		from = start.Add(-time.Minute)
+	}
+
+	next := cron.Next(from)
+	if next.IsZero() {
+		return time.Time{}, ErrInvalidSchedule
+	}
+	return next, nil
+}
+
+func validateRetryPolicy(onInsufficientFunds string, maxRetries int) error {
+	switch onInsufficientFunds {
+	case "", entity.OnInsufficientFundsSkip, entity.OnInsufficientFundsRetry:
+	default:
+		return ErrInvalidSchedule
+	}
+	if maxRetries < 0 {
+		return ErrInvalidSchedule
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+package aggregation_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduledTransfer(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC))
+	dbInstance := setupDB(db.WithClock(clock))
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	scheduledRepo := repository.NewScheduledTransfer(dbInstance)
+	runRepo := repository.NewScheduledTransferRun(dbInstance)
+
+	payerID := uuid.New().String()
+	payeeID := uuid.New().String()
+	userRepo.Put(entity.User{ID: payerID, Email: "payer@example.com"})
+	userRepo.Put(entity.User{ID: payeeID, Email: "payee@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: payerID})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: payeeID})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+
+	_, err := transaction.TopUp(payerID, 150)
+	assert.NoError(t, err)
+
+	t.Run("Validation", func(t *testing.T) {
+		_, err := transaction.ScheduleTransfer(entity.ScheduledTransfer{UserID: payerID, TargetUserID: payeeID, Amount: 10, Cron: "0 9 32 * *"}, time.Time{})
+		assert.ErrorIs(t, err, aggregation.ErrInvalidCron)
+
+		// a one off needs a start in the future
+		_, err = transaction.ScheduleTransfer(entity.ScheduledTransfer{UserID: payerID, TargetUserID: payeeID, Amount: 10}, clock.Now())
+		assert.ErrorIs(t, err, aggregation.ErrInvalidSchedule)
+
+		_, err = transaction.ScheduleTransfer(entity.ScheduledTransfer{UserID: payerID, TargetUserID: payeeID, Amount: 10, Cron: "@daily", OnInsufficientFunds: "wait"}, time.Time{})
+		assert.ErrorIs(t, err, aggregation.ErrInvalidSchedule)
+
+		_, err = transaction.ScheduleTransfer(entity.ScheduledTransfer{UserID: payerID, TargetUserID: payerID, Amount: 10, Cron: "@daily"}, time.Time{})
+		assert.ErrorIs(t, err, aggregation.ErrSameWallet)
+
+		_, err = transaction.ScheduleTransfer(entity.ScheduledTransfer{UserID: payerID, TargetUserID: "unknown", Amount: 10, Cron: "@daily"}, time.Time{})
+		assert.ErrorIs(t, err, aggregation.ErrUserNotFound)
+	})
+
+	// rent on the first of every month, until april
+	monthly, err := transaction.ScheduleTransfer(entity.ScheduledTransfer{
+		UserID:       payerID,
+		TargetUserID: payeeID,
+		Amount:       60,
+		Reference:    "rent",
+		Cron:         "0 9 1 * *",
+		EndAt:        time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC),
+	}, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC), monthly.NextRunAt)
+	assert.Equal(t, entity.OnInsufficientFundsSkip, monthly.OnInsufficientFunds)
+
+	// nothing is due yet
+	clock.Set(time.Date(2024, 2, 1, 8, 59, 0, 0, time.UTC))
+	_, err = dbInstance.RunDueSchedules()
+	assert.NoError(t, err)
+	payee, _ := walletRepo.FindByUserID(payeeID)
+	assert.Equal(t, 0, payee.Balance)
+
+	clock.Set(time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC))
+	_, err = dbInstance.RunDueSchedules()
+	assert.NoError(t, err)
+
+	payee, _ = walletRepo.FindByUserID(payeeID)
+	assert.Equal(t, 60, payee.Balance)
+	stored, _ := scheduledRepo.FindById(monthly.ID)
+	assert.Equal(t, time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC), stored.NextRunAt)
+
+	runs, _ := runRepo.GetByScheduledTransferID(monthly.ID)
+	assert.Len(t, runs, 1)
+	assert.Equal(t, entity.ScheduledTransferRunSucceeded, runs[0].Status)
+	assert.NotEmpty(t, runs[0].TransactionID)
+
+	// march is paid, april can't be and is skipped, which ends the schedule
+	clock.Set(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC))
+	_, err = dbInstance.RunDueSchedules()
+	assert.NoError(t, err)
+	clock.Set(time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC))
+	_, err = dbInstance.RunDueSchedules()
+	assert.NoError(t, err)
+
+	payer, _ := walletRepo.FindByUserID(payerID)
+	assert.Equal(t, 30, payer.Balance)
+	payee, _ = walletRepo.FindByUserID(payeeID)
+	assert.Equal(t, 120, payee.Balance)
+
+	runs, _ = runRepo.GetByScheduledTransferID(monthly.ID)
+	assert.Len(t, runs, 3)
+	assert.Equal(t, entity.ScheduledTransferRunSkipped, runs[2].Status)
+	stored, _ = scheduledRepo.FindById(monthly.ID)
+	assert.Equal(t, entity.ScheduledTransferStatusCompleted, stored.Status)
+
+	// later runs never happen
+	clock.Set(time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC))
+	_, err = dbInstance.RunDueSchedules()
+	assert.NoError(t, err)
+	runs, _ = runRepo.GetByScheduledTransferID(monthly.ID)
+	assert.Len(t, runs, 3)
+}
+
+func TestScheduledTransferRetry(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	dbInstance := setupDB(db.WithClock(clock))
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	scheduledRepo := repository.NewScheduledTransfer(dbInstance)
+	runRepo := repository.NewScheduledTransferRun(dbInstance)
+
+	payerID := uuid.New().String()
+	payeeID := uuid.New().String()
+	userRepo.Put(entity.User{ID: payerID, Email: "payer@example.com"})
+	userRepo.Put(entity.User{ID: payeeID, Email: "payee@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: payerID})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: payeeID})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+
+	dueAt := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	oneOff, err := transaction.ScheduleTransfer(entity.ScheduledTransfer{
+		UserID:              payerID,
+		TargetUserID:        payeeID,
+		Amount:              40,
+		OnInsufficientFunds: entity.OnInsufficientFundsRetry,
+		MaxRetries:          2,
+	}, dueAt)
+	assert.NoError(t, err)
+
+	// the other transfer is empty-handed too, but it doesn't hold up the first
+	failing, err := transaction.ScheduleTransfer(entity.ScheduledTransfer{
+		UserID:       payerID,
+		TargetUserID: payeeID,
+		Amount:       1000,
+	}, dueAt)
+	assert.NoError(t, err)
+
+	clock.Set(dueAt)
+	_, err = dbInstance.RunDueSchedules()
+	assert.NoError(t, err)
+
+	stored, _ := scheduledRepo.FindById(oneOff.ID)
+	assert.Equal(t, entity.ScheduledTransferStatusActive, stored.Status)
+	assert.Equal(t, 1, stored.Attempts)
+	assert.Equal(t, dueAt.Add(aggregation.ScheduledTransferRetryInterval), stored.NextRunAt)
+
+	skipped, _ := scheduledRepo.FindById(failing.ID)
+	assert.Equal(t, entity.ScheduledTransferStatusCompleted, skipped.Status)
+
+	// money arrives before the retry
+	_, err = transaction.TopUp(payerID, 50)
+	assert.NoError(t, err)
+
+	clock.Advance(aggregation.ScheduledTransferRetryInterval)
+	_, err = dbInstance.RunDueSchedules()
+	assert.NoError(t, err)
+
+	stored, _ = scheduledRepo.FindById(oneOff.ID)
+	assert.Equal(t, entity.ScheduledTransferStatusCompleted, stored.Status)
+
+	runs, _ := runRepo.GetByScheduledTransferID(oneOff.ID)
+	assert.Len(t, runs, 2)
+	assert.Equal(t, entity.ScheduledTransferRunRetrying, runs[0].Status)
+	assert.Equal(t, entity.ScheduledTransferRunSucceeded, runs[1].Status)
+	assert.Equal(t, 2, runs[1].Attempt)
+
+	payee, _ := walletRepo.FindByUserID(payeeID)
+	assert.Equal(t, 40, payee.Balance)
+}
+
+func TestScheduledTransferRisk(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	dbInstance := setupDB(db.WithClock(clock))
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	scheduledRepo := repository.NewScheduledTransfer(dbInstance)
+	runRepo := repository.NewScheduledTransferRun(dbInstance)
+
+	payerID := uuid.New().String()
+	payees := []string{uuid.New().String(), uuid.New().String()}
+	userRepo.Put(entity.User{ID: payerID, Email: "payer@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: payerID, Balance: 1000})
+	for _, payeeID := range payees {
+		userRepo.Put(entity.User{ID: payeeID, Email: payeeID + "@example.com"})
+		walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: payeeID})
+	}
+
+	// anything from 100 to a new recipient is held for review
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance,
+		aggregation.WithRisk(aggregation.RiskPolicy{
+			Rules:       []aggregation.RiskRule{aggregation.NewRecipientRule{Amount: 100, Points: 30}},
+			ReviewScore: 30,
+		}),
+	)
+
+	scheduled := make([]entity.ScheduledTransfer, len(payees))
+	for i, payeeID := range payees {
+		var err error
+		scheduled[i], err = transaction.ScheduleTransfer(entity.ScheduledTransfer{
+			UserID:       payerID,
+			TargetUserID: payeeID,
+			Amount:       100,
+			Cron:         "@daily",
+		}, time.Time{})
+		assert.NoError(t, err)
+	}
+
+	clock.Set(scheduled[0].NextRunAt)
+	_, err := dbInstance.RunDueSchedules()
+	assert.NoError(t, err)
+
+	held := make([]entity.ScheduledTransferRun, len(payees))
+	for i := range scheduled {
+		runs, _ := runRepo.GetByScheduledTransferID(scheduled[i].ID)
+		assert.Len(t, runs, 1)
+		assert.Equal(t, entity.ScheduledTransferRunHeld, runs[0].Status)
+		assert.NotEmpty(t, runs[0].ReviewID)
+		held[i] = runs[0]
+
+		// a held run isn't a failed try, the schedule goes on
+		stored, _ := scheduledRepo.FindById(scheduled[i].ID)
+		assert.Equal(t, 0, stored.Attempts)
+		assert.Equal(t, scheduled[i].NextRunAt.Add(24*time.Hour), stored.NextRunAt)
+	}
+
+	record, err := transaction.ApproveReview(held[0].ReviewID, "admin")
+	assert.NoError(t, err)
+	approved, _ := runRepo.FindById(held[0].ID)
+	assert.Equal(t, entity.ScheduledTransferRunSucceeded, approved.Status)
+	assert.Equal(t, record.ID, approved.TransactionID)
+
+	_, err = transaction.RejectReview(held[1].ReviewID, "admin", "")
+	assert.NoError(t, err)
+	rejected, _ := runRepo.FindById(held[1].ID)
+	assert.Equal(t, entity.ScheduledTransferRunFailed, rejected.Status)
+	assert.Equal(t, aggregation.ErrTransferBlocked.Error(), rejected.Error)
+
+	payee, _ := walletRepo.FindByUserID(payees[0])
+	assert.Equal(t, 100, payee.Balance)
+	payee, _ = walletRepo.FindByUserID(payees[1])
+	assert.Equal(t, 0, payee.Balance)
+}
+
+func TestScheduledTransferUpdateAndCancel(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	dbInstance := setupDB(db.WithClock(clock))
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	runRepo := repository.NewScheduledTransferRun(dbInstance)
+
+	payerID := uuid.New().String()
+	payeeID := uuid.New().String()
+	userRepo.Put(entity.User{ID: payerID, Email: "payer@example.com"})
+	userRepo.Put(entity.User{ID: payeeID, Email: "payee@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: payerID})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: payeeID})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+
+	_, err := transaction.TopUp(payerID, 100)
+	assert.NoError(t, err)
+
+	weekly, err := transaction.ScheduleTransfer(entity.ScheduledTransfer{
+		UserID:       payerID,
+		TargetUserID: payeeID,
+		Amount:       10,
+		Cron:         "@weekly",
+	}, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC), weekly.NextRunAt)
+
+	_, err = transaction.UpdateScheduledTransfer(payeeID, weekly.ID, aggregation.ScheduledTransferUpdate{})
+	assert.ErrorIs(t, err, aggregation.ErrScheduledTransferNotFound)
+
+	amount := 25
+	cron := "0 8 * * *"
+	updated, err := transaction.UpdateScheduledTransfer(payerID, weekly.ID, aggregation.ScheduledTransferUpdate{Amount: &amount, Cron: &cron})
+	assert.NoError(t, err)
+	assert.Equal(t, 25, updated.Amount)
+	assert.Equal(t, time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC), updated.NextRunAt)
+
+	clock.Set(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	_, err = dbInstance.RunDueSchedules()
+	assert.NoError(t, err)
+
+	cancelled, err := transaction.CancelScheduledTransfer(payerID, weekly.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.ScheduledTransferStatusCancelled, cancelled.Status)
+
+	_, err = transaction.CancelScheduledTransfer(payerID, weekly.ID)
+	assert.ErrorIs(t, err, aggregation.ErrScheduledTransferNotActive)
+
+	clock.Set(time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC))
+	_, err = dbInstance.RunDueSchedules()
+	assert.NoError(t, err)
+
+	payee, _ := walletRepo.FindByUserID(payeeID)
+	assert.Equal(t, 25, payee.Balance)
+	runs, _ := runRepo.GetByScheduledTransferID(weekly.ID)
+	assert.Len(t, runs, 1)
+}
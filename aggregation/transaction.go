@@ -2,6 +2,7 @@ package aggregation
 
 import (
 	"errors"
+	"math/big"
 
 	"github.com/google/uuid"
 	"github.com/insomnius/wallet-event-loop/db"
@@ -10,112 +11,500 @@ import (
 )
 
 type Transaction struct {
-	walletRepo   *repository.Wallet
-	userRepo     *repository.User
-	mutationRepo *repository.Mutation
-	db           *db.Instance
+	walletRepo         *repository.Wallet
+	userRepo           *repository.User
+	mutationRepo       *repository.Mutation
+	transactionRepo    *repository.Transaction
+	idempotencyKeyRepo *repository.IdempotencyKey
+	holdRepo           *repository.Hold
+	ledger             *Ledger
+	rateProvider       RateProvider
+	spread             *big.Rat
+	fees               FeeSchedule
+	limits             LimitPolicy
+	risk               RiskPolicy
+	riskReviewRepo     *repository.RiskReview
+	riskDecisionRepo   *repository.RiskDecision
+
+	scheduledTransferRepo    *repository.ScheduledTransfer
+	scheduledTransferRunRepo *repository.ScheduledTransferRun
+	paymentRequestRepo       *repository.PaymentRequest
+	billRepo                 *repository.Bill
+	escrowRepo               *repository.Escrow
+	batchRepo                *repository.Batch
+	paymentIntentRepo        *repository.PaymentIntent
+	paymentCallback          PaymentCallback
+	bankAccountRepo          *repository.BankAccount
+	withdrawalRepo           *repository.Withdrawal
+	payoutProvider           PayoutProvider
+
+	db *db.Instance
 }
 
 var ErrInsuficientFound = errors.New("error insuficient found")
+var ErrWalletAlreadyExists = errors.New("wallet already exists")
+
+// TransactionOption configures a single TopUp or Transfer call.
+type TransactionOption func(*transactionOptions)
+
+type transactionOptions struct {
+	idempotencyKey string
+	reference      string
+	note           string
+	currency       string
+	targetCurrency string
+	sourceWalletID string
+	targetWalletID string // only set by Move
+	holdTargetID   string
+
+	paymentRequestID string // the request a transfer pays, kept on its review
+	paymentIntentID  string // the merchant checkout a transfer pays, kept on its review
+	billID           string // only set by CreateBill
+	batchID          string // the batch a transfer pays an item of, kept on its review
+	batchItem        int
+	scheduledRunID   string // the scheduled transfer run a transfer pays, kept on its review
+}
+
+// WithIdempotencyKey makes a retry with the same key a no-op instead of
+// moving the money twice.
+func WithIdempotencyKey(key string) TransactionOption {
+	return func(o *transactionOptions) {
+		o.idempotencyKey = key
+	}
+}
+
+// WithReference attaches the client's own reference to the transaction.
+func WithReference(reference string) TransactionOption {
+	return func(o *transactionOptions) {
+		o.reference = reference
+	}
+}
+
+// WithNote attaches a free text note to the transaction.
+func WithNote(note string) TransactionOption {
+	return func(o *transactionOptions) {
+		o.note = note
+	}
+}
+
+// WithCurrency picks the wallet in that currency, the source wallet for a
+// transfer. Default is entity.DefaultCurrency.
+func WithCurrency(currency string) TransactionOption {
+	return func(o *transactionOptions) {
+		o.currency = currency
+	}
+}
+
+// WithTargetCurrency picks the target wallet of a transfer by currency, the
+// amount is converted when it differs from the source currency. Without it
+// the target wallet in the source currency is used, or the target's default
+// wallet when they don't have one.
+func WithTargetCurrency(currency string) TransactionOption {
+	return func(o *transactionOptions) {
+		o.targetCurrency = currency
+	}
+}
+
+func newTransactionOptions(opts []TransactionOption) transactionOptions {
+	o := transactionOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
 
 func NewTransaction(
 	walletRepo *repository.Wallet,
 	userRepo *repository.User,
 	mutationRepo *repository.Mutation,
 	db *db.Instance,
+	opts ...Option,
 ) *Transaction {
-	return &Transaction{
-		walletRepo:   walletRepo,
-		userRepo:     userRepo,
-		mutationRepo: mutationRepo,
-		db:           db,
+	t := &Transaction{
+		walletRepo:         walletRepo,
+		userRepo:           userRepo,
+		mutationRepo:       mutationRepo,
+		transactionRepo:    repository.NewTransaction(db),
+		idempotencyKeyRepo: repository.NewIdempotencyKey(db),
+		holdRepo:           repository.NewHold(db),
+		riskReviewRepo:     repository.NewRiskReview(db),
+		riskDecisionRepo:   repository.NewRiskDecision(db),
+		ledger:             NewLedger(walletRepo, db),
+		db:                 db,
+
+		scheduledTransferRepo:    repository.NewScheduledTransfer(db),
+		scheduledTransferRunRepo: repository.NewScheduledTransferRun(db),
+		paymentRequestRepo:       repository.NewPaymentRequest(db),
+		billRepo:                 repository.NewBill(db),
+		escrowRepo:               repository.NewEscrow(db),
+		batchRepo:                repository.NewBatch(db),
+		paymentIntentRepo:        repository.NewPaymentIntent(db),
+		bankAccountRepo:          repository.NewBankAccount(db),
+		withdrawalRepo:           repository.NewWithdrawal(db),
 	}
-}
 
-func (t Transaction) TopUp(userID string, amount int) error {
-	return t.db.Transaction(func(trx *db.Transaction) error {
-		user, err := t.userRepo.FindById(userID, trx)
-		if err != nil {
-			return err
-		}
+	for _, opt := range opts {
+		opt(t)
+	}
 
-		wallet, err := t.walletRepo.FindByUserID(user.ID, trx)
-		if err != nil {
-			return err
-		}
+	// expiry schedules persisted before a restart
+	t.db.HandleSchedule(holdExpirySchedule, t.handleHoldExpiry)
+	t.db.HandleSchedule(scheduledTransferSchedule, t.handleScheduledTransfers)
+	t.db.HandleSchedule(paymentRequestExpirySchedule, t.handlePaymentRequestExpiry)
+	t.db.HandleSchedule(escrowReleaseSchedule, t.handleEscrowRelease)
+	t.db.HandleSchedule(paymentIntentExpirySchedule, t.handlePaymentIntentExpiry)
+	t.db.HandleSchedule(withdrawalPayoutSchedule, t.handleWithdrawalPayout)
+	t.db.HandleSchedule(paymentCallbackSchedule, t.handlePaymentCallback)
 
-		wallet.Balance += amount
-		if err := t.walletRepo.Put(wallet, trx); err != nil {
-			return err
-		}
+	return t
+}
 
-		return t.mutationRepo.Put(entity.Mutation{
-			ID:       uuid.New().String(),
-			WalletID: wallet.ID,
-			UserID:   userID,
-			Type:     1, // topup
-			Amount:   amount,
-		}, trx)
+// OpenWallet gives the user a main wallet in another currency, a user has
+// at most one main wallet per currency.
+func (t Transaction) OpenWallet(userID, currency string) (entity.Wallet, error) {
+	return t.openWallet(userID, entity.PrimaryWalletName, currency)
+}
+
+func (t Transaction) TopUp(userID string, amount int, opts ...TransactionOption) (entity.Transaction, error) {
+	o := newTransactionOptions(opts)
+	fingerprint := requestFingerprint("topup", amount, entity.NormalizeCurrency(o.currency), o.reference, o.note)
+
+	var result entity.Transaction
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		result, err = idempotent(t, trx, userID, o, fingerprint, transactionResult, func() (entity.Transaction, error) {
+			return t.topUp(trx, userID, amount, o)
+		})
+		return err
 	})
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	return result, nil
 }
 
-func (t Transaction) Transfer(userID, targetID string, amount int) error {
-	return t.db.Transaction(func(trx *db.Transaction) error {
-		user, err := t.userRepo.FindById(userID, trx)
-		if err != nil {
-			return err
-		}
+func (t Transaction) Transfer(userID, targetID string, amount int, opts ...TransactionOption) (entity.Transaction, error) {
+	o := newTransactionOptions(opts)
+	fingerprint := requestFingerprint("transfer", targetID, amount, entity.NormalizeCurrency(o.currency), o.targetCurrency, o.sourceWalletID, o.reference, o.note)
 
-		target, err := t.userRepo.FindById(targetID, trx)
-		if err != nil {
-			return err
+	var result entity.Transaction
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		result, err = idempotent(t, trx, userID, o, fingerprint, transactionResult, func() (entity.Transaction, error) {
+			if record, found, err := t.heldTransfer(trx, userID, o, fingerprint); found {
+				return record, err
+			}
+			return t.assessedTransfer(trx, userID, targetID, amount, o)
+		})
+		return err
+	})
+
+	// the transfer is rolled back, its decision goes in on its own
+	var riskErr *RiskError
+	if errors.As(err, &riskErr) {
+		if err := t.db.Transaction(func(trx *db.Transaction) error {
+			return t.recordRisk(trx, riskErr, userID, targetID, amount, o, fingerprint)
+		}); err != nil {
+			return entity.Transaction{}, err
 		}
+	}
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	return result, nil
+}
+
+// topUp runs inside an open transaction.
+func (t Transaction) topUp(trx *db.Transaction, userID string, amount int, o transactionOptions) (entity.Transaction, error) {
+	if err := validateAmount(amount); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	user, err := t.userRepo.FindById(userID, trx)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	wallet, err := t.walletRepo.FindByUserIDAndCurrency(user.ID, o.currency, trx)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	if err := canReceive(wallet); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	// the fee comes out of the money topped up
+	fee := t.fees.Fee(entity.TransactionTypeTopUp, amount)
+	if fee >= amount {
+		return entity.Transaction{}, &AmountError{Field: "amount", Amount: amount, Reason: ErrAmountBelowFee}
+	}
+
+	if err := t.checkIncoming(trx, wallet, amount-fee); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	wallet.Balance, err = credit(wallet.Balance, amount-fee)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	if err := t.walletRepo.Put(wallet, trx); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	record := entity.Transaction{
+		ID:             uuid.New().String(),
+		Type:           entity.TransactionTypeTopUp,
+		Status:         entity.TransactionStatusCompleted,
+		TargetUserID:   user.ID,
+		TargetWalletID: wallet.ID,
+		Amount:         amount,
+		Currency:       entity.NormalizeCurrency(wallet.Currency),
+		TargetAmount:   amount - fee,
+		TargetCurrency: entity.NormalizeCurrency(wallet.Currency),
+		Reference:      o.reference,
+		Note:           o.note,
+		Fee:            fee,
+		CreatedAt:      t.db.Now(),
+	}
+	if err := t.transactionRepo.Put(record, trx); err != nil {
+		return entity.Transaction{}, err
+	}
 
-		sourceWallet, err := t.walletRepo.FindByUserID(user.ID, trx)
+	// money comes from outside, the clearing account goes negative
+	legs := []Leg{
+		{AccountID: entity.AccountTopUpClearing, Amount: -amount},
+		{AccountID: wallet.ID, Amount: amount - fee},
+	}
+	if fee > 0 {
+		leg, err := t.collectFee(trx, record)
 		if err != nil {
-			return err
+			return entity.Transaction{}, err
 		}
+		legs = append(legs, leg)
+	}
+	if err := t.ledger.post(trx, record, legs...); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	if err := t.mutationRepo.Put(entity.Mutation{
+		ID:            uuid.New().String(),
+		TransactionID: record.ID,
+		WalletID:      wallet.ID,
+		UserID:        userID,
+		Type:          entity.MutationTypeCredit, // topup
+		Amount:        amount - fee,
+		Fee:           fee,
+		CreatedAt:     record.CreatedAt,
+	}, trx); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	return record, nil
+}
+
+// transfer runs inside an open transaction, so other flows can move money
+// atomically together with their own writes.
+func (t Transaction) transfer(trx *db.Transaction, userID, targetID string, amount int, o transactionOptions) (entity.Transaction, error) {
+	if err := validateAmount(amount); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	user, err := t.userRepo.FindById(userID, trx)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	target, err := t.userRepo.FindById(targetID, trx)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	sourceWallet, err := t.sourceWallet(trx, user.ID, o)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	if sourceWallet.Available()-amount < 0 {
+		return entity.Transaction{}, ErrInsuficientFound
+	}
+
+	targetWallet, err := t.targetWallet(trx, target.ID, sourceWallet, o)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	// both sides would be written over each other
+	if sourceWallet.ID == targetWallet.ID {
+		return entity.Transaction{}, ErrSameWallet
+	}
+
+	return t.settle(trx, entity.Transaction{
+		Type:      transferType(o),
+		Reference: o.reference,
+		Note:      o.note,
+	}, sourceWallet, targetWallet, amount)
+}
+
+// settle moves amount from one wallet to another and writes the record, its
+// postings and both mutations. The record comes in with the type and the
+// client fields set, the rest is filled in here.
+func (t Transaction) settle(trx *db.Transaction, record entity.Transaction, sourceWallet, targetWallet entity.Wallet, amount int) (entity.Transaction, error) {
+	if err := canSend(sourceWallet); err != nil {
+		return entity.Transaction{}, err
+	}
+	if err := canReceive(targetWallet); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	converted, err := t.convert(amount, sourceWallet.Currency, targetWallet.Currency)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+	if converted.amount <= 0 {
+		return entity.Transaction{}, &AmountError{Field: "amount", Amount: amount, Reason: ErrAmountNotPositive}
+	}
+
+	// the payer pays the fee on top of the amount, system accounts like the
+	// escrow account only hold what they pay out and don't pay fees
+	fee := t.fees.Fee(record.Type, amount)
+	if sourceWallet.UserID == entity.SystemUserID {
+		fee = 0
+	}
+	if sourceWallet.Available()-amount-fee < 0 {
+		return entity.Transaction{}, ErrInsuficientFound
+	}
 
-		if sourceWallet.Balance-amount < 0 {
-			return ErrInsuficientFound
+	// moves between own pockets don't count against limits
+	if sourceWallet.UserID != targetWallet.UserID {
+		if err := t.checkOutgoing(trx, sourceWallet, amount); err != nil {
+			return entity.Transaction{}, err
 		}
+		if err := t.checkIncoming(trx, targetWallet, converted.amount); err != nil {
+			return entity.Transaction{}, err
+		}
+	}
+
+	targetWallet.Balance, err = credit(targetWallet.Balance, converted.amount)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+	total, err := credit(amount, fee)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+	sourceWallet.Balance, err = debit(sourceWallet.Balance, total)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	if err := t.walletRepo.Put(targetWallet, trx); err != nil {
+		return entity.Transaction{}, err
+	}
 
-		targetWallet, err := t.walletRepo.FindByUserID(target.ID, trx)
+	if err := t.walletRepo.Put(sourceWallet, trx); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	record.ID = uuid.New().String()
+	record.Status = entity.TransactionStatusCompleted
+	record.SourceUserID = sourceWallet.UserID
+	record.SourceWalletID = sourceWallet.ID
+	record.TargetUserID = targetWallet.UserID
+	record.TargetWalletID = targetWallet.ID
+	record.Amount = amount
+	record.Currency = entity.NormalizeCurrency(sourceWallet.Currency)
+	record.TargetAmount = converted.amount
+	record.TargetCurrency = entity.NormalizeCurrency(targetWallet.Currency)
+	record.Fee = fee
+	record.CreatedAt = t.db.Now()
+	if converted.rate != nil {
+		record.Rate = converted.rate.FloatString(6)
+		record.Spread = converted.spread.FloatString(6)
+	}
+	if err := t.transactionRepo.Put(record, trx); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	legs := []Leg{
+		{AccountID: sourceWallet.ID, Amount: -amount - fee},
+		{AccountID: targetWallet.ID, Amount: converted.amount},
+	}
+	if converted.rate != nil {
+		legs = append(legs,
+			Leg{AccountID: entity.FXAccount(record.Currency), Amount: amount},
+			Leg{AccountID: entity.FXAccount(record.TargetCurrency), Amount: -converted.amount},
+		)
+	}
+	if fee > 0 {
+		leg, err := t.collectFee(trx, record)
 		if err != nil {
-			return err
+			return entity.Transaction{}, err
 		}
+		legs = append(legs, leg)
+	}
+	if err := t.ledger.post(trx, record, legs...); err != nil {
+		return entity.Transaction{}, err
+	}
 
-		targetWallet.Balance += amount
-		sourceWallet.Balance -= amount
+	if err := t.mutationRepo.Put(entity.Mutation{
+		ID:                   uuid.New().String(),
+		TransactionID:        record.ID,
+		WalletID:             sourceWallet.ID,
+		UserID:               sourceWallet.UserID,
+		CounterpartyWalletID: targetWallet.ID,
+		CounterpartyUserID:   targetWallet.UserID,
+		Type:                 entity.MutationTypeDebit, // down
+		Amount:               amount,
+		Fee:                  fee,
+		CreatedAt:            record.CreatedAt,
+	}, trx); err != nil {
+		return entity.Transaction{}, err
+	}
 
-		if err := t.walletRepo.Put(targetWallet, trx); err != nil {
-			return err
-		}
+	if err := t.mutationRepo.Put(entity.Mutation{
+		ID:                   uuid.New().String(),
+		TransactionID:        record.ID,
+		WalletID:             targetWallet.ID,
+		UserID:               targetWallet.UserID,
+		CounterpartyWalletID: sourceWallet.ID,
+		CounterpartyUserID:   sourceWallet.UserID,
+		Type:                 entity.MutationTypeCredit, // topup
+		Amount:               converted.amount,
+		CreatedAt:            record.CreatedAt,
+	}, trx); err != nil {
+		return entity.Transaction{}, err
+	}
 
-		if err := t.walletRepo.Put(sourceWallet, trx); err != nil {
-			return err
-		}
+	return record, nil
+}
 
-		if err := t.mutationRepo.Put(entity.Mutation{
-			ID:       uuid.New().String(),
-			WalletID: sourceWallet.ID,
-			UserID:   user.ID,
-			Type:     entity.MutationTypeDebit, // down
-			Amount:   amount,
-		}, trx); err != nil {
-			return err
-		}
+// targetWallet picks the wallet receiving a transfer, see WithTargetCurrency.
+func (t Transaction) targetWallet(trx *db.Transaction, targetID string, sourceWallet entity.Wallet, o transactionOptions) (entity.Wallet, error) {
+	if o.targetWalletID != "" {
+		return t.ownWallet(trx, targetID, o.targetWalletID)
+	}
 
-		if err := t.mutationRepo.Put(entity.Mutation{
-			ID:       uuid.New().String(),
-			WalletID: targetWallet.ID,
-			UserID:   target.ID,
-			Type:     entity.MutationTypeCredit, // topup
-			Amount:   amount,
-		}, trx); err != nil {
-			return err
-		}
+	if o.targetCurrency != "" {
+		return t.walletRepo.FindByUserIDAndCurrency(targetID, o.targetCurrency, trx)
+	}
 
-		return nil
-	})
+	wallet, err := t.walletRepo.FindByUserIDAndCurrency(targetID, sourceWallet.Currency, trx)
+	if err == db.ErrNotFound {
+		return t.walletRepo.FindByUserID(targetID, trx)
+	}
+	return wallet, err
+}
+
+func transferType(o transactionOptions) entity.TransactionType {
+	if o.targetWalletID != "" {
+		return entity.TransactionTypeMove
+	}
+	if o.paymentIntentID != "" {
+		return entity.TransactionTypePayment
+	}
+	return entity.TransactionTypeTransfer
 }
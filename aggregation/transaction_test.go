@@ -1,6 +1,7 @@
 package aggregation_test
 
 import (
+	"math"
 	"sync"
 	"testing"
 
@@ -12,14 +13,31 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func setupDB() *db.Instance {
-	dbInstance := db.NewInstance()
+func setupDB(opts ...db.Option) *db.Instance {
+	dbInstance := db.NewInstance(opts...)
 	go func() {
 		dbInstance.Start()
 	}()
 	dbInstance.CreateTable("users")
 	dbInstance.CreateTable("wallets")
 	dbInstance.CreateTable("mutations")
+	dbInstance.CreateTable("transactions")
+	dbInstance.CreateTable("ledger_entries")
+	dbInstance.CreateTable("holds")
+	dbInstance.CreateTable("idempotency_keys")
+	dbInstance.CreateTable("risk_reviews")
+	dbInstance.CreateTable("risk_decisions")
+	dbInstance.CreateTable("user_tokens")
+	dbInstance.CreateTable("audit_logs")
+	dbInstance.CreateTable("scheduled_transfers")
+	dbInstance.CreateTable("scheduled_transfer_runs")
+	dbInstance.CreateTable("payment_requests")
+	dbInstance.CreateTable("bills")
+	dbInstance.CreateTable("escrows")
+	dbInstance.CreateTable("batches")
+	dbInstance.CreateTable("payment_intents")
+	dbInstance.CreateTable("bank_accounts")
+	dbInstance.CreateTable("withdrawals")
 	return dbInstance
 }
 
@@ -44,7 +62,7 @@ func TestTopUp(t *testing.T) {
 	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
 
 	// Case: Successful top-up
-	err = transaction.TopUp(userID, 100)
+	_, err = transaction.TopUp(userID, 100)
 	assert.NoError(t, err)
 
 	// Verify wallet balance
@@ -60,14 +78,14 @@ func TestTopUp(t *testing.T) {
 	assert.Equal(t, entity.MutationTypeCredit, mutations[0].Type) // 1 for credit
 
 	// Case: Non-existent user
-	err = transaction.TopUp("non-existent-user", 50)
+	_, err = transaction.TopUp("non-existent-user", 50)
 	assert.Error(t, err)
 
 	// Case: Non-existent wallet
 	userWithoutWallet := uuid.New().String()
 	err = userRepo.Put(entity.User{ID: userWithoutWallet, Email: "nowallet@example.com"})
 	assert.NoError(t, err)
-	err = transaction.TopUp(userWithoutWallet, 50)
+	_, err = transaction.TopUp(userWithoutWallet, 50)
 	assert.Error(t, err)
 }
 
@@ -100,7 +118,7 @@ func TestTransfer(t *testing.T) {
 	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
 
 	// Case: Successful transfer
-	err = transaction.Transfer(sourceUserID, targetUserID, 100)
+	_, err = transaction.Transfer(sourceUserID, targetUserID, 100)
 	assert.NoError(t, err)
 
 	// Verify balances
@@ -125,21 +143,160 @@ func TestTransfer(t *testing.T) {
 	assert.Equal(t, entity.MutationTypeCredit, targetMutations[0].Type) // 1 for credit
 
 	// Case: Insufficient funds
-	err = transaction.Transfer(sourceUserID, targetUserID, 300)
+	_, err = transaction.Transfer(sourceUserID, targetUserID, 300)
 	assert.ErrorIs(t, err, aggregation.ErrInsuficientFound)
 
 	// Case: Non-existent user
-	err = transaction.Transfer("non-existent-user", targetUserID, 50)
+	_, err = transaction.Transfer("non-existent-user", targetUserID, 50)
 	assert.Error(t, err)
 
 	// Case: Non-existent wallet
 	userWithoutWallet := uuid.New().String()
 	err = userRepo.Put(entity.User{ID: userWithoutWallet, Email: "nowallet@example.com"})
 	assert.NoError(t, err)
-	err = transaction.Transfer(userWithoutWallet, targetUserID, 50)
+	_, err = transaction.Transfer(userWithoutWallet, targetUserID, 50)
 	assert.Error(t, err)
 }
 
+func TestTopUpIdempotencyKey(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+
+	userID := uuid.New().String()
+	userRepo.Put(entity.User{ID: userID, Email: "test@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: userID, Balance: 0})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+
+	// concurrent retries of the same request
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := transaction.TopUp(userID, 100, aggregation.WithIdempotencyKey("key-1"))
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	wallet, err := walletRepo.FindByUserID(userID)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, wallet.Balance)
+
+	mutations, err := mutationRepo.GetByUserID(userID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(mutations))
+
+	// a different operation can't reuse the key
+	_, err = transaction.Transfer(userID, userID, 100, aggregation.WithIdempotencyKey("key-1"))
+	assert.ErrorIs(t, err, aggregation.ErrIdempotencyKeyReused)
+
+	// keys are scoped per user
+	otherUserID := uuid.New().String()
+	userRepo.Put(entity.User{ID: otherUserID, Email: "other@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: otherUserID, Balance: 0})
+	_, err = transaction.TopUp(otherUserID, 50, aggregation.WithIdempotencyKey("key-1"))
+	assert.NoError(t, err)
+}
+
+func TestTransferRecordsTransaction(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	transactionRepo := repository.NewTransaction(dbInstance)
+
+	sourceUserID := uuid.New().String()
+	targetUserID := uuid.New().String()
+	userRepo.Put(entity.User{ID: sourceUserID, Email: "source@example.com"})
+	userRepo.Put(entity.User{ID: targetUserID, Email: "target@example.com"})
+
+	sourceWallet := entity.Wallet{ID: uuid.New().String(), UserID: sourceUserID, Balance: 200}
+	targetWallet := entity.Wallet{ID: uuid.New().String(), UserID: targetUserID, Balance: 0}
+	walletRepo.Put(sourceWallet)
+	walletRepo.Put(targetWallet)
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+
+	trx, err := transaction.Transfer(sourceUserID, targetUserID, 75, aggregation.WithNote("dinner"), aggregation.WithReference("inv-1"))
+	assert.NoError(t, err)
+
+	stored, err := transactionRepo.FindById(trx.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.TransactionTypeTransfer, stored.Type)
+	assert.Equal(t, entity.TransactionStatusCompleted, stored.Status)
+	assert.Equal(t, sourceWallet.ID, stored.SourceWalletID)
+	assert.Equal(t, targetWallet.ID, stored.TargetWalletID)
+	assert.Equal(t, 75, stored.Amount)
+	assert.Equal(t, "dinner", stored.Note)
+	assert.Equal(t, "inv-1", stored.Reference)
+	assert.False(t, stored.CreatedAt.IsZero())
+
+	// both legs point to the transaction and to each other
+	debit, _ := mutationRepo.GetByUserID(sourceUserID)
+	credit, _ := mutationRepo.GetByUserID(targetUserID)
+	assert.Equal(t, trx.ID, debit[0].TransactionID)
+	assert.Equal(t, trx.ID, credit[0].TransactionID)
+	assert.Equal(t, targetWallet.ID, debit[0].CounterpartyWalletID)
+	assert.Equal(t, sourceWallet.ID, credit[0].CounterpartyWalletID)
+
+	// a replay returns the original transaction
+	first, err := transaction.TopUp(targetUserID, 10, aggregation.WithIdempotencyKey("topup-1"))
+	assert.NoError(t, err)
+	replay, err := transaction.TopUp(targetUserID, 10, aggregation.WithIdempotencyKey("topup-1"))
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, replay.ID)
+	assert.Equal(t, entity.TransactionTypeTopUp, replay.Type)
+}
+
+func TestAmountValidation(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+
+	sourceUserID := uuid.New().String()
+	targetUserID := uuid.New().String()
+	userRepo.Put(entity.User{ID: sourceUserID, Email: "source@example.com"})
+	userRepo.Put(entity.User{ID: targetUserID, Email: "target@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: sourceUserID, Balance: 100})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: targetUserID, Balance: math.MaxInt - 10})
+
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance)
+
+	var amountErr *aggregation.AmountError
+
+	// a negative transfer would pull money out of the target
+	_, err := transaction.Transfer(sourceUserID, targetUserID, -50)
+	assert.ErrorIs(t, err, aggregation.ErrAmountNotPositive)
+	assert.ErrorAs(t, err, &amountErr)
+	assert.Equal(t, "amount", amountErr.Field)
+
+	_, err = transaction.TopUp(sourceUserID, 0)
+	assert.ErrorIs(t, err, aggregation.ErrAmountNotPositive)
+
+	_, err = transaction.TopUp(sourceUserID, aggregation.MaxAmount+1)
+	assert.ErrorIs(t, err, aggregation.ErrAmountOverLimit)
+
+	_, err = transaction.Transfer(sourceUserID, targetUserID, 20)
+	assert.ErrorIs(t, err, aggregation.ErrBalanceOverflow)
+
+	// nothing moved
+	source, _ := walletRepo.FindByUserID(sourceUserID)
+	target, _ := walletRepo.FindByUserID(targetUserID)
+	assert.Equal(t, 100, source.Balance)
+	assert.Equal(t, math.MaxInt-10, target.Balance)
+
+	_, err = mutationRepo.GetByUserID(sourceUserID)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+}
+
 func TestRaceCondition(t *testing.T) {
 	// Initialize the in-memory database instance
 	dbInstance := db.NewInstance()
@@ -153,6 +310,9 @@ func TestRaceCondition(t *testing.T) {
 	dbInstance.CreateTable("users")
 	dbInstance.CreateTable("wallets")
 	dbInstance.CreateTable("mutations")
+	dbInstance.CreateTable("transactions")
+	dbInstance.CreateTable("ledger_entries")
+	dbInstance.CreateTable("holds")
 
 	// Set up repositories
 	userRepo := repository.NewUser(dbInstance)
@@ -186,7 +346,7 @@ func TestRaceCondition(t *testing.T) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			err := transactionAggregator.TopUp(userID, topUpAmount)
+			_, err := transactionAggregator.TopUp(userID, topUpAmount)
 			assert.NoError(t, err)
 		}()
 	}
@@ -196,7 +356,7 @@ func TestRaceCondition(t *testing.T) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			err := transactionAggregator.Transfer(userID, targetID, transferAmount)
+			_, err := transactionAggregator.Transfer(userID, targetID, transferAmount)
 			if err != nil && err != aggregation.ErrInsuficientFound {
 				assert.NoError(t, err)
 			}
@@ -230,6 +390,9 @@ func BenchmarkTransfer(b *testing.B) {
 	dbInstance.CreateTable("users")
 	dbInstance.CreateTable("wallets")
 	dbInstance.CreateTable("mutations")
+	dbInstance.CreateTable("transactions")
+	dbInstance.CreateTable("ledger_entries")
+	dbInstance.CreateTable("holds")
 
 	// Set up repositories
 	userRepo := repository.NewUser(dbInstance)
@@ -256,7 +419,7 @@ func BenchmarkTransfer(b *testing.B) {
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		err := transactionAggregator.Transfer(sourceUserID, targetUserID, 100)
+		_, err := transactionAggregator.Transfer(sourceUserID, targetUserID, 100)
 		if err != nil && err != aggregation.ErrInsuficientFound {
 			b.Fatalf("unexpected error: %v", err)
 		}
@@ -0,0 +1,483 @@
+package aggregation
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+var ErrBankCodeRequired = errors.New("bank code is required")
+var ErrInvalidAccountNumber = errors.New("account number is 5 to 34 digits")
+var ErrHolderNameRequired = errors.New("holder name is required")
+var ErrBankAccountExists = errors.New("bank account already registered")
+var ErrBankAccountNotFound = errors.New("bank account not found")
+var ErrWithdrawalNotFound = errors.New("withdrawal not found")
+var ErrWithdrawalNotPending = errors.New("withdrawal is no longer pending")
+var ErrPayoutsUnavailable = errors.New("payouts are not available")
+
+// withdrawalPayoutSchedule submits the payouts the provider hasn't accepted
+// yet, it is kept with the withdrawal so a restart doesn't lose it.
+const withdrawalPayoutSchedule = "withdrawal.payout"
+
+// PayoutRetryInterval is the wait before a payout that wasn't accepted is
+// submitted again, it doubles with every attempt up to an hour. After
+// MaxPayoutAttempts the withdrawal fails and its hold is released.
+var PayoutRetryInterval = time.Minute
+var MaxPayoutAttempts = 10
+
+const maxPayoutRetryInterval = time.Hour
+
+// AddBankAccount registers a bank account the user can withdraw to.
+func (t Transaction) AddBankAccount(userID, bankCode, accountNumber, holderName string) (entity.BankAccount, error) {
+	bankCode = strings.ToUpper(strings.TrimSpace(bankCode))
+	accountNumber = strings.TrimSpace(accountNumber)
+	holderName = strings.TrimSpace(holderName)
+
+	if bankCode == "" {
+		return entity.BankAccount{}, ErrBankCodeRequired
+	}
+	if len(accountNumber) < 5 || len(accountNumber) > 34 || strings.Trim(accountNumber, "0123456789") != "" {
+		return entity.BankAccount{}, ErrInvalidAccountNumber
+	}
+	if holderName == "" {
+		return entity.BankAccount{}, ErrHolderNameRequired
+	}
+
+	var account entity.BankAccount
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		if _, err := t.userRepo.FindById(userID, trx); err != nil {
+			return err
+		}
+
+		accounts, err := t.bankAccountRepo.GetByUserID(userID, trx)
+		if err != nil {
+			return err
+		}
+		for _, existing := range accounts {
+			if existing.BankCode == bankCode && existing.AccountNumber == accountNumber {
+				return ErrBankAccountExists
+			}
+		}
+
+		account = entity.BankAccount{
+			ID:            uuid.New().String(),
+			UserID:        userID,
+			BankCode:      bankCode,
+			AccountNumber: accountNumber,
+			HolderName:    holderName,
+			CreatedAt:     t.db.Now(),
+		}
+		return t.bankAccountRepo.Put(account, trx)
+	})
+	if err != nil {
+		return entity.BankAccount{}, err
+	}
+
+	return account, nil
+}
+
+// Withdraw holds amount and its fee in the user's wallet, the payout to the
+// bank account is submitted by a schedule once the hold commits. The source
+// wallet is picked like a transfer and limits apply, pending withdrawals
+// count against them.
+func (t Transaction) Withdraw(userID, bankAccountID string, amount int, opts ...TransactionOption) (entity.Withdrawal, error) {
+	if t.payoutProvider == nil {
+		return entity.Withdrawal{}, ErrPayoutsUnavailable
+	}
+
+	o := newTransactionOptions(opts)
+	fingerprint := requestFingerprint("withdraw", bankAccountID, amount, entity.NormalizeCurrency(o.currency), o.sourceWalletID, o.reference)
+
+	var withdrawal entity.Withdrawal
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		withdrawal, err = idempotent(t, trx, userID, o, fingerprint, withdrawalResult, func() (entity.Withdrawal, error) {
+			return t.withdraw(trx, userID, bankAccountID, amount, o)
+		})
+		return err
+	})
+	if err != nil {
+		return entity.Withdrawal{}, err
+	}
+
+	return withdrawal, nil
+}
+
+func (t Transaction) withdraw(trx *db.Transaction, userID, bankAccountID string, amount int, o transactionOptions) (entity.Withdrawal, error) {
+	if err := validateAmount(amount); err != nil {
+		return entity.Withdrawal{}, err
+	}
+
+	account, err := t.bankAccountRepo.FindById(bankAccountID, trx)
+	if err == db.ErrNotFound || (err == nil && account.UserID != userID) {
+		return entity.Withdrawal{}, ErrBankAccountNotFound
+	}
+	if err != nil {
+		return entity.Withdrawal{}, err
+	}
+
+	wallet, err := t.sourceWallet(trx, userID, o)
+	if err != nil {
+		return entity.Withdrawal{}, err
+	}
+
+	if err := canSend(wallet); err != nil {
+		return entity.Withdrawal{}, err
+	}
+
+	fee := t.fees.Fee(entity.TransactionTypeWithdrawal, amount)
+	if wallet.Available()-amount-fee < 0 {
+		return entity.Withdrawal{}, ErrInsuficientFound
+	}
+
+	// the debit only shows up once settled, until then pending withdrawals
+	// are counted here
+	pending, err := t.withdrawalRepo.GetByUserID(userID, entity.WithdrawalStatusPending, trx)
+	if err != nil {
+		return entity.Withdrawal{}, err
+	}
+	outgoing := amount
+	for _, w := range pending {
+		if w.Currency == entity.NormalizeCurrency(wallet.Currency) {
+			outgoing += w.Amount
+		}
+	}
+	if err := t.checkOutgoing(trx, wallet, outgoing); err != nil {
+		return entity.Withdrawal{}, err
+	}
+
+	total, err := credit(amount, fee)
+	if err != nil {
+		return entity.Withdrawal{}, err
+	}
+	wallet.Held, err = credit(wallet.Held, total)
+	if err != nil {
+		return entity.Withdrawal{}, err
+	}
+	if err := t.walletRepo.Put(wallet, trx); err != nil {
+		return entity.Withdrawal{}, err
+	}
+
+	now := t.db.Now()
+	withdrawal := entity.Withdrawal{
+		ID:            uuid.New().String(),
+		UserID:        userID,
+		WalletID:      wallet.ID,
+		BankAccountID: account.ID,
+		Amount:        amount,
+		Fee:           fee,
+		Currency:      entity.NormalizeCurrency(wallet.Currency),
+		Status:        entity.WithdrawalStatusPending,
+		HoldID:        uuid.New().String(),
+		Reference:     o.reference,
+		NextSubmitAt:  now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	// kept only when the withdrawal commits
+	trx.Schedule(now, withdrawalPayoutSchedule, t.dispatchPayouts)
+
+	// held until the payout answers, it doesn't expire
+	if err := t.holdRepo.Put(entity.Hold{
+		ID:           withdrawal.HoldID,
+		UserID:       userID,
+		WalletID:     wallet.ID,
+		TargetUserID: entity.SystemUserID,
+		Amount:       amount + fee,
+		Currency:     withdrawal.Currency,
+		Status:       entity.HoldStatusActive,
+		Reference:    o.reference,
+		WithdrawalID: withdrawal.ID,
+		CreatedAt:    now,
+	}, trx); err != nil {
+		return entity.Withdrawal{}, err
+	}
+
+	return withdrawal, t.withdrawalRepo.Put(withdrawal, trx)
+}
+
+// dispatchPayouts runs as a schedule. It claims every withdrawal due for a
+// payout by counting the attempt and pushing NextSubmitAt back, then hands
+// it to the provider outside of the event loop. A claim whose outcome never
+// gets recorded, say the process died, runs out and the payout is submitted
+// again. A withdrawal that ran out of attempts fails.
+func (t Transaction) dispatchPayouts(trx *db.Transaction) error {
+	now := t.db.Now()
+	withdrawals, err := t.withdrawalRepo.GetUnsubmitted(trx)
+	if err != nil {
+		return err
+	}
+
+	var retryAt time.Time
+	for _, withdrawal := range withdrawals {
+		if withdrawal.NextSubmitAt.After(now) {
+			continue
+		}
+
+		if withdrawal.SubmitAttempts >= MaxPayoutAttempts {
+			if err := t.failWithdrawal(trx, &withdrawal, "payout not accepted: "+withdrawal.SubmitError); err != nil {
+				return err
+			}
+			continue
+		}
+
+		withdrawal.SubmitAttempts++
+		withdrawal.NextSubmitAt = now.Add(retryBackoff(PayoutRetryInterval, maxPayoutRetryInterval, withdrawal.SubmitAttempts))
+		withdrawal.UpdatedAt = now
+		if err := t.withdrawalRepo.Put(withdrawal, trx); err != nil {
+			return err
+		}
+
+		if retryAt.IsZero() || withdrawal.NextSubmitAt.Before(retryAt) {
+			retryAt = withdrawal.NextSubmitAt
+		}
+
+		// what submitPayout can't record is retried once the claim runs out
+		go t.submitPayout(withdrawal.ID, withdrawal.SubmitAttempts)
+	}
+
+	// the claims made here are checked again once they run out
+	if !retryAt.IsZero() {
+		trx.Schedule(retryAt, withdrawalPayoutSchedule, t.dispatchPayouts)
+	}
+
+	return nil
+}
+
+func (t Transaction) handleWithdrawalPayout(trx *db.Transaction, _ db.Schedule) error {
+	return t.dispatchPayouts(trx)
+}
+
+// submitPayout hands a claimed withdrawal to the provider and records the
+// outcome. Its first transaction is queued behind the one claiming it, so
+// nothing is submitted when the claim rolled back or was taken over.
+func (t Transaction) submitPayout(withdrawalID string, attempt int) error {
+	var withdrawal entity.Withdrawal
+	var account entity.BankAccount
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		withdrawal, err = t.findWithdrawal(trx, withdrawalID)
+		if err != nil {
+			return err
+		}
+		if withdrawal.Status != entity.WithdrawalStatusPending || withdrawal.ProviderReference != "" || withdrawal.SubmitAttempts != attempt {
+			return errPayoutNotClaimed
+		}
+
+		account, err = t.bankAccountRepo.FindById(withdrawal.BankAccountID, trx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	reference, payoutErr := t.payoutProvider.Payout(withdrawal, account)
+
+	// when even this fails the claim runs out and the payout is submitted
+	// again, providers dedupe it by withdrawal ID
+	for i := 0; ; i++ {
+		err = t.recordPayout(withdrawalID, reference, payoutErr)
+		if err == nil || i == 2 {
+			return err
+		}
+		time.Sleep(time.Duration(i+1) * 100 * time.Millisecond)
+	}
+}
+
+var errPayoutNotClaimed = errors.New("payout is not claimed")
+
+// recordPayout keeps the provider's reference of an accepted payout, or why
+// it wasn't accepted. A rejection fails the withdrawal, other errors are
+// retried by the schedule.
+func (t Transaction) recordPayout(withdrawalID, reference string, payoutErr error) error {
+	return t.db.Transaction(func(trx *db.Transaction) error {
+		withdrawal, err := t.findWithdrawal(trx, withdrawalID)
+		if err != nil {
+			return err
+		}
+
+		if payoutErr == nil {
+			// the provider may have called back already
+			if withdrawal.ProviderReference != "" {
+				return nil
+			}
+			withdrawal.ProviderReference = reference
+			withdrawal.SubmitError = ""
+			withdrawal.UpdatedAt = t.db.Now()
+			return t.withdrawalRepo.Put(withdrawal, trx)
+		}
+
+		if withdrawal.Status != entity.WithdrawalStatusPending {
+			return nil
+		}
+		if errors.Is(payoutErr, ErrPayoutRejected) {
+			return t.failWithdrawal(trx, &withdrawal, payoutErr.Error())
+		}
+
+		withdrawal.SubmitError = payoutErr.Error()
+		withdrawal.UpdatedAt = t.db.Now()
+		return t.withdrawalRepo.Put(withdrawal, trx)
+	})
+}
+
+// SettleWithdrawal applies a successful payout: the held amount and fee
+// leave the wallet for the payout clearing account. Settling a settled
+// withdrawal again is a no-op, so provider retries are safe.
+func (t Transaction) SettleWithdrawal(withdrawalID, providerReference string) (entity.Withdrawal, error) {
+	var withdrawal entity.Withdrawal
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		withdrawal, err = t.findWithdrawal(trx, withdrawalID)
+		if err != nil {
+			return err
+		}
+		if withdrawal.Status == entity.WithdrawalStatusSettled {
+			return nil
+		}
+		if withdrawal.Status != entity.WithdrawalStatusPending {
+			return ErrWithdrawalNotPending
+		}
+
+		hold, err := t.holdRepo.FindById(withdrawal.HoldID, trx)
+		if err != nil {
+			return err
+		}
+
+		wallet, err := t.walletRepo.FindById(hold.WalletID, trx)
+		if err != nil {
+			return err
+		}
+
+		// the hold is the amount and the fee
+		wallet.Held, err = debit(wallet.Held, hold.Amount)
+		if err != nil {
+			return err
+		}
+		wallet.Balance, err = debit(wallet.Balance, hold.Amount)
+		if err != nil {
+			return err
+		}
+		if err := t.walletRepo.Put(wallet, trx); err != nil {
+			return err
+		}
+
+		record := entity.Transaction{
+			ID:             uuid.New().String(),
+			Type:           entity.TransactionTypeWithdrawal,
+			Status:         entity.TransactionStatusCompleted,
+			SourceUserID:   wallet.UserID,
+			SourceWalletID: wallet.ID,
+			Amount:         withdrawal.Amount,
+			Currency:       withdrawal.Currency,
+			TargetAmount:   withdrawal.Amount,
+			TargetCurrency: withdrawal.Currency,
+			Reference:      withdrawal.Reference,
+			Fee:            withdrawal.Fee,
+			CreatedAt:      t.db.Now(),
+		}
+		if err := t.transactionRepo.Put(record, trx); err != nil {
+			return err
+		}
+
+		// money leaves to the bank, the clearing account goes positive
+		legs := []Leg{
+			{AccountID: wallet.ID, Amount: -withdrawal.Amount - withdrawal.Fee},
+			{AccountID: entity.AccountPayoutClearing, Amount: withdrawal.Amount},
+		}
+		if withdrawal.Fee > 0 {
+			leg, err := t.collectFee(trx, record)
+			if err != nil {
+				return err
+			}
+			legs = append(legs, leg)
+		}
+		if err := t.ledger.post(trx, record, legs...); err != nil {
+			return err
+		}
+
+		if err := t.mutationRepo.Put(entity.Mutation{
+			ID:            uuid.New().String(),
+			TransactionID: record.ID,
+			WalletID:      wallet.ID,
+			UserID:        wallet.UserID,
+			Type:          entity.MutationTypeDebit, // withdrawal
+			Amount:        withdrawal.Amount,
+			Fee:           withdrawal.Fee,
+			CreatedAt:     record.CreatedAt,
+		}, trx); err != nil {
+			return err
+		}
+
+		hold.Status = entity.HoldStatusCaptured
+		hold.Captured = hold.Amount
+		hold.TransactionID = record.ID
+		if err := t.holdRepo.Put(hold, trx); err != nil {
+			return err
+		}
+
+		withdrawal.Status = entity.WithdrawalStatusSettled
+		withdrawal.TransactionID = record.ID
+		if providerReference != "" {
+			withdrawal.ProviderReference = providerReference
+		}
+		withdrawal.UpdatedAt = record.CreatedAt
+		return t.withdrawalRepo.Put(withdrawal, trx)
+	})
+	if err != nil {
+		return entity.Withdrawal{}, err
+	}
+
+	return withdrawal, nil
+}
+
+// FailWithdrawal applies a failed payout by releasing the hold. Failing a
+// failed withdrawal again is a no-op.
+func (t Transaction) FailWithdrawal(withdrawalID, reason string) (entity.Withdrawal, error) {
+	var withdrawal entity.Withdrawal
+	err := t.db.Transaction(func(trx *db.Transaction) error {
+		var err error
+		withdrawal, err = t.findWithdrawal(trx, withdrawalID)
+		if err != nil {
+			return err
+		}
+		if withdrawal.Status == entity.WithdrawalStatusFailed {
+			return nil
+		}
+		if withdrawal.Status != entity.WithdrawalStatusPending {
+			return ErrWithdrawalNotPending
+		}
+
+		return t.failWithdrawal(trx, &withdrawal, reason)
+	})
+	if err != nil {
+		return entity.Withdrawal{}, err
+	}
+
+	return withdrawal, nil
+}
+
+// failWithdrawal releases the hold of a pending withdrawal and stores it as
+// failed.
+func (t Transaction) failWithdrawal(trx *db.Transaction, withdrawal *entity.Withdrawal, reason string) error {
+	if _, err := t.releaseHold(trx, withdrawal.HoldID, entity.HoldStatusVoided); err != nil {
+		return err
+	}
+
+	withdrawal.Status = entity.WithdrawalStatusFailed
+	withdrawal.FailureReason = reason
+	withdrawal.UpdatedAt = t.db.Now()
+	return t.withdrawalRepo.Put(*withdrawal, trx)
+}
+
+func (t Transaction) findWithdrawal(trx *db.Transaction, withdrawalID string) (entity.Withdrawal, error) {
+	withdrawal, err := t.withdrawalRepo.FindById(withdrawalID, trx)
+	if err == db.ErrNotFound {
+		return entity.Withdrawal{}, ErrWithdrawalNotFound
+	}
+	return withdrawal, err
+}
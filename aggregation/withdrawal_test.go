@@ -0,0 +1,226 @@
+package aggregation_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithdrawal(t *testing.T) {
+	dbInstance := setupDB()
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	withdrawalRepo := repository.NewWithdrawal(dbInstance)
+	holdRepo := repository.NewHold(dbInstance)
+
+	userID := uuid.New().String()
+	otherID := uuid.New().String()
+	userRepo.Put(entity.User{ID: userID, Email: "user@example.com"})
+	userRepo.Put(entity.User{ID: otherID, Email: "other@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: userID})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: otherID})
+
+	_, err := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance).Withdraw(userID, "any", 10)
+	assert.ErrorIs(t, err, aggregation.ErrPayoutsUnavailable)
+
+	provider := &aggregation.FakePayoutProvider{}
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance,
+		aggregation.WithPayoutProvider(provider),
+		aggregation.WithFees(aggregation.FeeSchedule{
+			entity.TransactionTypeWithdrawal: {Flat: 2},
+		}),
+	)
+
+	_, err = transaction.TopUp(userID, 100)
+	assert.NoError(t, err)
+
+	_, err = transaction.AddBankAccount(userID, "bca", "12-34", "Jane Doe")
+	assert.ErrorIs(t, err, aggregation.ErrInvalidAccountNumber)
+	account, err := transaction.AddBankAccount(userID, "bca", "1234567890", "Jane Doe")
+	assert.NoError(t, err)
+	assert.Equal(t, "BCA", account.BankCode)
+	_, err = transaction.AddBankAccount(userID, "BCA", "1234567890", "Jane Doe")
+	assert.ErrorIs(t, err, aggregation.ErrBankAccountExists)
+
+	_, err = transaction.Withdraw(otherID, account.ID, 10)
+	assert.ErrorIs(t, err, aggregation.ErrBankAccountNotFound)
+	_, err = transaction.Withdraw(userID, account.ID, 99)
+	assert.ErrorIs(t, err, aggregation.ErrInsuficientFound)
+
+	submitted := func(n int) {
+		assert.Eventually(t, func() bool { return len(provider.Payouts()) == n }, time.Second, time.Millisecond)
+	}
+
+	t.Run("Settled", func(t *testing.T) {
+		withdrawal, err := transaction.Withdraw(userID, account.ID, 40, aggregation.WithIdempotencyKey("withdraw-1"))
+		assert.NoError(t, err)
+		assert.Equal(t, entity.WithdrawalStatusPending, withdrawal.Status)
+		assert.Equal(t, 2, withdrawal.Fee)
+
+		replay, err := transaction.Withdraw(userID, account.ID, 40, aggregation.WithIdempotencyKey("withdraw-1"))
+		assert.NoError(t, err)
+		assert.Equal(t, withdrawal.ID, replay.ID)
+
+		submitted(1)
+		assert.Eventually(t, func() bool {
+			stored, _ := withdrawalRepo.FindById(withdrawal.ID)
+			return stored.ProviderReference != ""
+		}, time.Second, time.Millisecond)
+
+		wallet, _ := walletRepo.FindByUserID(userID)
+		assert.Equal(t, 100, wallet.Balance)
+		assert.Equal(t, 58, wallet.Available())
+
+		// only the payout settles its hold
+		_, err = transaction.Void(withdrawal.HoldID)
+		assert.ErrorIs(t, err, aggregation.ErrWithdrawalHold)
+
+		settled, err := transaction.SettleWithdrawal(withdrawal.ID, "")
+		assert.NoError(t, err)
+		assert.Equal(t, entity.WithdrawalStatusSettled, settled.Status)
+		assert.Equal(t, "fake-"+withdrawal.ID, settled.ProviderReference)
+
+		// a provider retry is a no-op
+		_, err = transaction.SettleWithdrawal(withdrawal.ID, "")
+		assert.NoError(t, err)
+		_, err = transaction.FailWithdrawal(withdrawal.ID, "bounced")
+		assert.ErrorIs(t, err, aggregation.ErrWithdrawalNotPending)
+
+		wallet, _ = walletRepo.FindByUserID(userID)
+		assert.Equal(t, 58, wallet.Balance)
+		assert.Equal(t, 0, wallet.Held)
+
+		hold, _ := holdRepo.FindById(withdrawal.HoldID)
+		assert.Equal(t, entity.HoldStatusCaptured, hold.Status)
+		assert.Equal(t, settled.TransactionID, hold.TransactionID)
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		withdrawal, err := transaction.Withdraw(userID, account.ID, 30)
+		assert.NoError(t, err)
+		submitted(2)
+
+		failed, err := transaction.FailWithdrawal(withdrawal.ID, "account closed")
+		assert.NoError(t, err)
+		assert.Equal(t, entity.WithdrawalStatusFailed, failed.Status)
+		assert.Equal(t, "account closed", failed.FailureReason)
+
+		_, err = transaction.SettleWithdrawal(withdrawal.ID, "")
+		assert.ErrorIs(t, err, aggregation.ErrWithdrawalNotPending)
+
+		wallet, _ := walletRepo.FindByUserID(userID)
+		assert.Equal(t, 58, wallet.Balance)
+		assert.Equal(t, 0, wallet.Held)
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		provider.SetErr(fmt.Errorf("%w: account closed", aggregation.ErrPayoutRejected))
+		defer provider.SetErr(nil)
+
+		withdrawal, err := transaction.Withdraw(userID, account.ID, 30)
+		assert.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			stored, _ := withdrawalRepo.FindById(withdrawal.ID)
+			return stored.Status == entity.WithdrawalStatusFailed
+		}, time.Second, time.Millisecond)
+
+		stored, _ := withdrawalRepo.FindById(withdrawal.ID)
+		assert.Equal(t, "payout rejected: account closed", stored.FailureReason)
+
+		wallet, _ := walletRepo.FindByUserID(userID)
+		assert.Equal(t, 0, wallet.Held)
+	})
+
+	discrepancies, err := aggregation.NewLedger(walletRepo, dbInstance).Reconcile()
+	assert.NoError(t, err)
+	assert.Empty(t, discrepancies)
+}
+
+func TestWithdrawalPayoutRetry(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	dbInstance := setupDB(db.WithClock(clock))
+
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	mutationRepo := repository.NewMutation(dbInstance)
+	withdrawalRepo := repository.NewWithdrawal(dbInstance)
+
+	userID := uuid.New().String()
+	userRepo.Put(entity.User{ID: userID, Email: "user@example.com"})
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: userID})
+
+	provider := &aggregation.FakePayoutProvider{}
+	transaction := aggregation.NewTransaction(walletRepo, userRepo, mutationRepo, dbInstance, aggregation.WithPayoutProvider(provider))
+
+	_, err := transaction.TopUp(userID, 100)
+	assert.NoError(t, err)
+	account, err := transaction.AddBankAccount(userID, "BCA", "1234567890", "Jane Doe")
+	assert.NoError(t, err)
+
+	stored := func(id string) entity.Withdrawal {
+		withdrawal, _ := withdrawalRepo.FindById(id)
+		return withdrawal
+	}
+
+	provider.SetErr(errors.New("bank is offline"))
+
+	t.Run("Retried", func(t *testing.T) {
+		withdrawal, err := transaction.Withdraw(userID, account.ID, 30)
+		assert.NoError(t, err)
+
+		_, err = dbInstance.RunDueSchedules()
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool { return stored(withdrawal.ID).SubmitError == "bank is offline" }, time.Second, time.Millisecond)
+		assert.Equal(t, entity.WithdrawalStatusPending, stored(withdrawal.ID).Status)
+		assert.Equal(t, 1, stored(withdrawal.ID).SubmitAttempts)
+
+		// nothing is submitted again before the claim runs out
+		_, err = dbInstance.RunDueSchedules()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, stored(withdrawal.ID).SubmitAttempts)
+
+		provider.SetErr(nil)
+		clock.Advance(aggregation.PayoutRetryInterval)
+		_, err = dbInstance.RunDueSchedules()
+		assert.NoError(t, err)
+
+		assert.Eventually(t, func() bool { return stored(withdrawal.ID).ProviderReference != "" }, time.Second, time.Millisecond)
+		assert.Equal(t, 2, stored(withdrawal.ID).SubmitAttempts)
+		assert.Empty(t, stored(withdrawal.ID).SubmitError)
+	})
+
+	t.Run("Out of attempts", func(t *testing.T) {
+		defer func(max int) { aggregation.MaxPayoutAttempts = max }(aggregation.MaxPayoutAttempts)
+		aggregation.MaxPayoutAttempts = 1
+		provider.SetErr(errors.New("bank is offline"))
+
+		withdrawal, err := transaction.Withdraw(userID, account.ID, 20)
+		assert.NoError(t, err)
+
+		_, err = dbInstance.RunDueSchedules()
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool { return stored(withdrawal.ID).SubmitError != "" }, time.Second, time.Millisecond)
+
+		clock.Advance(aggregation.PayoutRetryInterval)
+		_, err = dbInstance.RunDueSchedules()
+		assert.NoError(t, err)
+
+		failed := stored(withdrawal.ID)
+		assert.Equal(t, entity.WithdrawalStatusFailed, failed.Status)
+		assert.Equal(t, "payout not accepted: bank is offline", failed.FailureReason)
+
+		wallet, _ := walletRepo.FindByUserID(userID)
+		assert.Equal(t, 30, wallet.Held) // the first withdrawal's
+	})
+}
@@ -0,0 +1,57 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/db"
+)
+
+func TestMaxQueueWait(t *testing.T) {
+	inst := db.NewInstance(db.WithMaxQueueWait(10 * time.Millisecond))
+
+	// the loop isn't running yet, nothing can be picked up
+	executed := false
+	err := inst.Transaction(func(_ *db.Transaction) error {
+		executed = true
+		return nil
+	})
+	if err != db.ErrOverloaded {
+		t.Fatal("operation should be shed when the loop is saturated", err)
+	}
+
+	go func() {
+		inst.Start()
+	}()
+	defer inst.Close()
+
+	if err := inst.Transaction(func(_ *db.Transaction) error { return nil }); err != nil {
+		t.Fatal("operation should go through once the loop is running", err)
+	}
+
+	// the shed operation is still in the queue, it must have been skipped
+	if executed {
+		t.Fatal("shed operation should never be executed")
+	}
+
+	if stats := inst.LaneStats()[db.LaneInteractive]; stats.Processed != 1 {
+		t.Fatal("shed operation should not be counted as processed", stats)
+	}
+}
+
+func TestSlowOperationIsNotShedOnceStarted(t *testing.T) {
+	inst := db.NewInstance(db.WithMaxQueueWait(10 * time.Millisecond))
+	go func() {
+		inst.Start()
+	}()
+	defer inst.Close()
+
+	// running longer than the max wait is fine, only waiting in the queue is bounded
+	err := inst.Transaction(func(_ *db.Transaction) error {
+		time.Sleep(30 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("operation picked up in time should finish", err)
+	}
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltStorage keeps every table as a bucket of an embedded B-tree file.
+// Rows are gob encoded, so their concrete types have to be registered with
+// gob before the file is read back.
+type boltStorage struct {
+	db *bolt.DB
+}
+
+// boltRow wraps the value so gob keeps its concrete type.
+type boltRow struct {
+	Value any
+}
+
+func OpenBoltStorage(path string) (Storage, error) {
+	boltDB, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltStorage{db: boltDB}, nil
+}
+
+func (b *boltStorage) CreateTable(table string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte(table))
+		if errors.Is(err, bolt.ErrBucketExists) {
+			return ErrTableAlreadyExists
+		}
+		return err
+	})
+}
+
+func (b *boltStorage) HasTable(table string) bool {
+	found := false
+	_ = b.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket([]byte(table)) != nil
+		return nil
+	})
+	return found
+}
+
+func (b *boltStorage) Get(table, key string) (any, error) {
+	var v any
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(table))
+		if bucket == nil {
+			return ErrTableIsNotFound
+		}
+
+		raw := bucket.Get([]byte(key))
+		if raw == nil {
+			return ErrNotFound
+		}
+
+		var err error
+		v, err = decodeBoltRow(raw)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func (b *boltStorage) Put(table, key string, value any) error {
+	return b.Commit(ChangeSet{table: {key: value}})
+}
+
+func (b *boltStorage) Delete(table, key string) error {
+	return b.Commit(ChangeSet{table: {key: nil}})
+}
+
+func (b *boltStorage) Iterate(table string, f func(key string, value any) bool) error {
+	keys := []string{}
+	values := []any{}
+
+	// decode everything first, f must not run inside the read transaction
+	// otherwise it can't call back into the storage
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(table))
+		if bucket == nil {
+			return ErrTableIsNotFound
+		}
+
+		return bucket.ForEach(func(k, raw []byte) error {
+			v, err := decodeBoltRow(raw)
+			if err != nil {
+				return err
+			}
+
+			keys = append(keys, string(k))
+			values = append(values, v)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for idx := range keys {
+		if !f(keys[idx], values[idx]) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (b *boltStorage) Commit(changes ChangeSet) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for table, change := range changes {
+			bucket := tx.Bucket([]byte(table))
+			if bucket == nil {
+				return ErrTableIsNotFound
+			}
+
+			for key, value := range change {
+				if value == nil {
+					if err := bucket.Delete([]byte(key)); err != nil {
+						return err
+					}
+					continue
+				}
+
+				raw, err := encodeBoltRow(value)
+				if err != nil {
+					return err
+				}
+
+				if err := bucket.Put([]byte(key), raw); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+func (b *boltStorage) Close() error {
+	return b.db.Close()
+}
+
+func encodeBoltRow(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(boltRow{Value: value}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeBoltRow(raw []byte) (any, error) {
+	var row boltRow
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&row); err != nil {
+		return nil, err
+	}
+	return row.Value, nil
+}
@@ -3,6 +3,8 @@ package db_test
 import (
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -12,153 +14,375 @@ import (
 	"github.com/insomnius/wallet-event-loop/entity"
 )
 
-func TestCreateMultiple(t *testing.T) {
-	inst := db.NewInstance()
-	defer inst.Close()
+var engines = []string{db.EngineMemory, db.EngineLog, db.EngineBolt}
 
+func openInstance(t testing.TB, engine, path string, opts ...db.Option) *db.Instance {
+	storage, err := db.OpenStorage(engine, path)
+	if err != nil {
+		t.Fatal("failed to open storage", engine, err)
+	}
+
+	inst := db.NewInstance(append([]db.Option{db.WithStorage(storage)}, opts...)...)
 	go func() {
 		inst.Start()
 	}()
 
-	inst.CreateTable("user")
-	table, _ := inst.GetTable("user")
+	return inst
+}
 
-	table.ReplaceOrStore("xx", entity.User{
-		ID:    "xx",
-		Email: "super@gmail.com",
-	})
-	table.ReplaceOrStore("yy", entity.User{
-		ID:    "yy",
-		Email: "super@gmail.com",
+// forEachEngine runs the same test against every storage engine.
+func forEachEngine(t *testing.T, f func(t *testing.T, inst *db.Instance), opts ...db.Option) {
+	for _, engine := range engines {
+		t.Run(engine, func(t *testing.T) {
+			inst := openInstance(t, engine, filepath.Join(t.TempDir(), "wallet"), opts...)
+			defer inst.Close()
+
+			f(t, inst)
+		})
+	}
+}
+
+func TestCreateMultiple(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, inst *db.Instance) {
+		inst.CreateTable("user")
+		table, _ := inst.GetTable("user")
+
+		table.ReplaceOrStore("xx", entity.User{
+			ID:    "xx",
+			Email: "super@gmail.com",
+		})
+		table.ReplaceOrStore("yy", entity.User{
+			ID:    "yy",
+			Email: "super@gmail.com",
+		})
 	})
 }
 
 func TestPointerWorks(t *testing.T) {
-	inst := db.NewInstance()
-	defer inst.Close()
+	forEachEngine(t, func(t *testing.T, inst *db.Instance) {
+		inst.CreateTable("user")
+		table, _ := inst.GetTable("user")
 
-	go func() {
-		inst.Start()
-	}()
+		user1 := entity.User{
+			ID:    "xx",
+			Email: "super@gmail.com",
+		}
+		table.ReplaceOrStore("xx", user1)
+		table.ReplaceOrStore("yy", entity.User{
+			ID:    "yy",
+			Email: "super@gmail.com",
+		})
 
-	inst.CreateTable("user")
-	table, _ := inst.GetTable("user")
+		user1.Email = "wrong"
 
-	user1 := entity.User{
-		ID:    "xx",
-		Email: "super@gmail.com",
-	}
-	table.ReplaceOrStore("xx", user1)
-	table.ReplaceOrStore("yy", entity.User{
-		ID:    "yy",
-		Email: "super@gmail.com",
+		v, _ := table.FindByID("xx")
+		if v.(entity.User).Email != "super@gmail.com" {
+			t.Fatal("data cannot changed from the pointer itself", v, user1)
+		}
 	})
-
-	user1.Email = "wrong"
-
-	v, _ := table.FindByID("xx")
-	if v.(entity.User).Email != "super@gmail.com" {
-		t.Fatal("data cannot changed from the pointer itself", v, user1)
-	}
 }
 
 func TestTransaction(t *testing.T) {
-	inst := db.NewInstance()
-	defer inst.Close()
+	forEachEngine(t, func(t *testing.T, inst *db.Instance) {
+		inst.CreateTable("users")
 
-	go func() {
-		inst.Start()
-	}()
+		var errCount int32
+		var successCount int32
 
-	inst.CreateTable("users")
+		wg := &sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
 
-	var errCount int32
-	var successCount int32
+				err := inst.Transaction(func(x *db.Transaction) error {
+					userTable, err := x.GetTable("users")
+					if err != nil {
+						return err
+					}
 
-	wg := &sync.WaitGroup{}
-	for i := 0; i < 10; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+					_, err = userTable.FindByID("xx")
+					if err == nil {
+						return errors.New("data dengan id xx sudah ada")
+					}
 
-			err := inst.Transaction(func(x *db.Transaction) error {
-				userTable, err := x.GetTable("users")
+					userTable.ReplaceOrStore("xx", entity.User{
+						ID:    "xx",
+						Email: "super@gmail.com",
+					})
+
+					return nil
+				})
 				if err != nil {
-					return err
+					atomic.AddInt32(&errCount, 1)
+				} else {
+					atomic.AddInt32(&successCount, 1)
 				}
+			}()
+		}
 
-				_, err = userTable.FindByID("xx")
-				if err == nil {
-					return errors.New("data dengan id xx sudah ada")
-				}
+		wg.Wait()
 
-				userTable.ReplaceOrStore("xx", entity.User{
-					ID:    "xx",
-					Email: "super@gmail.com",
-				})
+		if errCount != 9 {
+			t.Fatal("transaction process failed, err count", errCount, successCount)
+		}
 
-				return nil
-			})
+		if successCount != 1 {
+			t.Fatal("transaction process failed, success count", errCount, successCount)
+		}
+	})
+}
+
+func TestTransactionAtomicity(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, inst *db.Instance) {
+		inst.CreateTable("users")
+
+		err := inst.Transaction(func(x *db.Transaction) error {
+			userTable, err := x.GetTable("users")
 			if err != nil {
-				atomic.AddInt32(&errCount, 1)
-			} else {
-				atomic.AddInt32(&successCount, 1)
+				return err
 			}
-		}()
-	}
 
-	wg.Wait()
+			_, err = userTable.FindByID("xx")
+			if err == nil {
+				return errors.New("data dengan id xx sudah ada")
+			}
 
-	if errCount != 9 {
-		t.Fatal("transaction process failed, err count", errCount, successCount)
-	}
+			userTable.ReplaceOrStore("xx", entity.User{
+				ID:    "xx",
+				Email: "super@gmail.com",
+			})
 
-	if successCount != 1 {
-		t.Fatal("transaction process failed, success count", errCount, successCount)
-	}
+			return errors.New("some error, transaction should not stored the data")
+		})
+
+		if err == nil {
+			t.Fatal("error should be not nil, but got nil instead", err)
+		}
+
+		table, _ := inst.GetTable("users")
+		v, err := table.FindByID("xx")
+		if err != db.ErrNotFound {
+			t.Fatal("should be got not found error", err, v)
+		}
+
+		if v != nil {
+			t.Fatal("v should be nil", v, err)
+		}
+	})
 }
 
-func TestTransactionAtomicity(t *testing.T) {
-	inst := db.NewInstance()
-	defer inst.Close()
+func TestSavepoint(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, inst *db.Instance) {
+		inst.CreateTable("users")
 
-	go func() {
-		inst.Start()
-	}()
+		err := inst.Transaction(func(x *db.Transaction) error {
+			userTable, err := x.GetTable("users")
+			if err != nil {
+				return err
+			}
+			userTable.ReplaceOrStore("aa", entity.User{ID: "aa"})
 
-	inst.CreateTable("users")
+			err = x.Savepoint(func(sp *db.Transaction) error {
+				if err := storeUser("bb")(sp); err != nil {
+					return err
+				}
+				return errors.New("rolled back")
+			})
+			if err == nil {
+				t.Fatal("savepoint error should be returned")
+			}
+
+			if _, err := userTable.FindByID("bb"); err != db.ErrNotFound {
+				t.Fatal("failed savepoint should be dropped", err)
+			}
+
+			err = x.Savepoint(func(sp *db.Transaction) error {
+				spTable, err := sp.GetTable("users")
+				if err != nil {
+					return err
+				}
 
-	err := inst.Transaction(func(x *db.Transaction) error {
-		userTable, err := x.GetTable("users")
+				if _, err := spTable.FindByID("aa"); err != nil {
+					t.Fatal("savepoint should see the transaction writes", err)
+				}
+				return storeUser("cc")(sp)
+			})
+			if err != nil {
+				return err
+			}
+
+			if _, err := userTable.FindByID("cc"); err != nil {
+				t.Fatal("savepoint writes should be merged", err)
+			}
+			return nil
+		})
 		if err != nil {
-			return err
+			t.Fatal(err)
 		}
 
-		_, err = userTable.FindByID("xx")
-		if err == nil {
-			return errors.New("data dengan id xx sudah ada")
+		table, _ := inst.GetTable("users")
+		for id, want := range map[string]error{"aa": nil, "bb": db.ErrNotFound, "cc": nil} {
+			if _, err := table.FindByID(id); err != want {
+				t.Fatal("unexpected commit of", id, err)
+			}
 		}
+	})
+}
 
-		userTable.ReplaceOrStore("xx", entity.User{
-			ID:    "xx",
-			Email: "super@gmail.com",
+func TestDelete(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, inst *db.Instance) {
+		inst.CreateTable("users")
+		table, _ := inst.GetTable("users")
+
+		table.ReplaceOrStore("xx", entity.User{ID: "xx"})
+		table.ReplaceOrStore("yy", entity.User{ID: "yy"})
+
+		if err := table.Delete("xx"); err != nil {
+			t.Fatal("delete should not fail", err)
+		}
+
+		if _, err := table.FindByID("xx"); err != db.ErrNotFound {
+			t.Fatal("deleted row should be not found", err)
+		}
+
+		err := inst.Transaction(func(x *db.Transaction) error {
+			userTable, err := x.GetTable("users")
+			if err != nil {
+				return err
+			}
+
+			if err := userTable.Delete("yy"); err != nil {
+				return err
+			}
+
+			if _, err := userTable.FindByID("yy"); err != db.ErrNotFound {
+				return errors.New("row deleted inside the transaction should be not found")
+			}
+
+			if len(userTable.Filter(func(v any) bool { return true })) != 0 {
+				return errors.New("row deleted inside the transaction should be filtered out")
+			}
+			return nil
 		})
+		if err != nil {
+			t.Fatal(err)
+		}
 
-		return errors.New("some error, transaction should not stored the data")
+		if _, err := table.FindByID("yy"); err != db.ErrNotFound {
+			t.Fatal("row deleted by the transaction should be not found", err)
+		}
 	})
+}
 
-	if err == nil {
-		t.Fatal("error should be not nil, but got nil instead", err)
+func TestFilterSeesUncommittedInserts(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, inst *db.Instance) {
+		inst.CreateTable("users")
+
+		err := inst.Transaction(func(x *db.Transaction) error {
+			userTable, err := x.GetTable("users")
+			if err != nil {
+				return err
+			}
+
+			userTable.ReplaceOrStore("xx", entity.User{ID: "xx", Email: "super@gmail.com"})
+
+			found := userTable.Filter(func(v any) bool {
+				return v.(entity.User).Email == "super@gmail.com"
+			})
+			if len(found) != 1 {
+				return fmt.Errorf("expected 1 row, got %d", len(found))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestPersistence(t *testing.T) {
+	for _, engine := range []string{db.EngineLog, db.EngineBolt} {
+		t.Run(engine, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "wallet")
+
+			inst := openInstance(t, engine, path)
+			inst.CreateTable("users")
+			err := inst.Transaction(func(x *db.Transaction) error {
+				userTable, err := x.GetTable("users")
+				if err != nil {
+					return err
+				}
+
+				userTable.ReplaceOrStore("xx", entity.User{ID: "xx", Email: "super@gmail.com"})
+				userTable.ReplaceOrStore("yy", entity.User{ID: "yy", Email: "other@gmail.com"})
+				return userTable.Delete("yy")
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := inst.Close(); err != nil {
+				t.Fatal("close should not fail", err)
+			}
+
+			reopened := openInstance(t, engine, path)
+			defer reopened.Close()
+
+			if err := reopened.CreateTable("users"); err != db.ErrTableAlreadyExists {
+				t.Fatal("table should survive a restart", err)
+			}
+
+			table, err := reopened.GetTable("users")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			v, err := table.FindByID("xx")
+			if err != nil || v.(entity.User).Email != "super@gmail.com" {
+				t.Fatal("committed row should survive a restart", v, err)
+			}
+
+			if _, err := table.FindByID("yy"); err != db.ErrNotFound {
+				t.Fatal("deleted row should stay deleted after a restart", err)
+			}
+		})
 	}
+}
+
+func TestLogStorageTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallet.log")
 
+	inst := openInstance(t, db.EngineLog, path)
+	inst.CreateTable("users")
 	table, _ := inst.GetTable("users")
-	v, err := table.FindByID("xx")
-	if err != db.ErrNotFound {
-		t.Fatal("should be got not found error", err, v)
+	table.ReplaceOrStore("xx", entity.User{ID: "xx"})
+	inst.Close()
+
+	// simulate a crash in the middle of appending a frame
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 1, 2, 3})
+	f.Close()
+
+	reopened := openInstance(t, db.EngineLog, path)
+	defer reopened.Close()
+
+	table, err = reopened.GetTable("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := table.FindByID("xx"); err != nil {
+		t.Fatal("rows before the torn frame should be kept", err)
 	}
 
-	if v != nil {
-		t.Fatal("v should be nil", v, err)
+	// the log should still be appendable after the truncation
+	table.ReplaceOrStore("yy", entity.User{ID: "yy"})
+	if _, err := table.FindByID("yy"); err != nil {
+		t.Fatal("write after recovery should succeed", err)
 	}
 }
 
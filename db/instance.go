@@ -5,44 +5,146 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var ErrTableAlreadyExists = errors.New("table already exists")
 var ErrTableIsNotFound = errors.New("table is not found")
+var ErrInstanceClosed = errors.New("instance is closed")
+
+// ErrOverloaded is returned when an operation couldn't be picked up by the
+// event loop within the max queue wait, the operation is never executed.
+var ErrOverloaded = errors.New("instance is overloaded")
 
 var DefaultOperationLimit = 100
+var DefaultExpiryInterval = time.Minute
 
 type Instance struct {
-	tables                map[string]map[string]any
-	operationChan         chan operationArgument
+	storage               Storage
+	clock                 Clock
+	tables                sync.Map // table name -> tableConfig
+	expiryInterval        time.Duration
+	maxQueueWait          time.Duration
+	lanes                 [laneCount]chan operationArgument
+	laneWeights           [laneCount]int
+	laneMetrics           *laneMetrics
 	operationWg           *sync.WaitGroup
 	operationOpen         atomic.Bool
 	transactionIdentifier string
+	stop                  chan struct{}
+	backgroundWg          *sync.WaitGroup
+	scheduleMu            sync.Mutex
+	schedules             map[string]*scheduled
+	scheduleHandlers      map[string]ScheduleHandler
+	scheduleWake          chan struct{}
 }
 
 type operationArgument struct {
-	op        func(*Instance) error
-	operation string
-	result    chan error
+	op         func(*Instance) error
+	operation  string
+	result     chan error
+	lane       Lane
+	enqueuedAt time.Time
+	state      *atomic.Int32
+}
+
+// An operation is claimed either by the event loop or by its caller giving
+// up, never both, so a shed operation is guaranteed to not run.
+const (
+	operationPending int32 = iota
+	operationRunning
+	operationAbandoned
+)
+
+// Option configures an Instance on creation.
+type Option func(*Instance)
+
+// WithStorage replaces the default in memory storage engine.
+func WithStorage(storage Storage) Option {
+	return func(i *Instance) {
+		i.storage = storage
+	}
+}
+
+// WithClock replaces the wall clock used for expiry and schedules.
+func WithClock(clock Clock) Option {
+	return func(i *Instance) {
+		i.clock = clock
+	}
+}
+
+// WithExpiryInterval sets how often the expiry sweep runs, zero disables it.
+func WithExpiryInterval(interval time.Duration) Option {
+	return func(i *Instance) {
+		i.expiryInterval = interval
+	}
+}
+
+// WithMaxQueueWait bounds how long an operation may wait for the event loop,
+// longer than that the operation is shed with ErrOverloaded. Zero waits
+// forever.
+func WithMaxQueueWait(wait time.Duration) Option {
+	return func(i *Instance) {
+		i.maxQueueWait = wait
+	}
 }
 
-func NewInstance() *Instance {
-	return &Instance{
-		tables:                map[string]map[string]any{},
-		operationChan:         make(chan operationArgument, DefaultOperationLimit), // buffered allocation, faster since the memory is already allocated first instead of dynamically
+func NewInstance(opts ...Option) *Instance {
+	i := &Instance{
+		storage:               NewMemoryStorage(),
+		clock:                 SystemClock{},
+		expiryInterval:        DefaultExpiryInterval,
+		laneMetrics:           &laneMetrics{},
 		operationWg:           &sync.WaitGroup{},
 		operationOpen:         atomic.Bool{},
 		transactionIdentifier: "main",
+		stop:                  make(chan struct{}),
+		backgroundWg:          &sync.WaitGroup{},
+		schedules:             map[string]*scheduled{},
+		scheduleHandlers:      map[string]ScheduleHandler{},
+		scheduleWake:          make(chan struct{}, 1),
+	}
+
+	for lane := Lane(0); lane < laneCount; lane++ {
+		i.lanes[lane] = make(chan operationArgument, DefaultOperationLimit) // buffered allocation, faster since the memory is already allocated first instead of dynamically
+		i.laneWeights[lane] = DefaultLaneWeights[lane]
 	}
+
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	return i
 }
 
 // Start database daemon
 func (i *Instance) Start() {
 	i.operationOpen.Store(true)
+	i.loadSchedules()
+
+	i.backgroundWg.Add(1)
+	go i.runScheduler()
+
+	if i.expiryInterval > 0 {
+		i.backgroundWg.Add(1)
+		go i.runExpirySweep()
+	}
+
+	scheduler := newLaneScheduler(i)
+	for {
+		op, ok := scheduler.next()
+		if !ok {
+			return
+		}
+		if !op.state.CompareAndSwap(operationPending, operationRunning) {
+			// caller already gave up on it
+			continue
+		}
+		i.laneMetrics.observe(op.lane, time.Since(op.enqueuedAt))
 
-	for op := range i.operationChan {
 		if !i.operationOpen.Load() {
 			// operation already close in here
+			op.result <- ErrInstanceClosed
 			continue
 		}
 		i.operationWg.Add(1)
@@ -61,62 +163,111 @@ func (i *Instance) Start() {
 	}
 }
 
-func (i *Instance) enqueueProcess(f func(*Instance) error, operationName string) error {
+func (i *Instance) enqueueProcess(f func(*Instance) error, operationName string, opts ...OperationOption) error {
 	opArgument := operationArgument{
 		op:        f,
 		result:    make(chan error, 1),
 		operation: operationName,
+		lane:      LaneInteractive,
+		state:     &atomic.Int32{},
+	}
+	for _, opt := range opts {
+		opt(&opArgument)
+	}
+
+	opArgument.enqueuedAt = time.Now()
+	if i.maxQueueWait <= 0 {
+		i.lanes[opArgument.lane] <- opArgument
+		return <-opArgument.result
+	}
+
+	deadline := time.NewTimer(i.maxQueueWait)
+	defer deadline.Stop()
+
+	select {
+	case i.lanes[opArgument.lane] <- opArgument:
+	case <-deadline.C:
+		// queue is full
+		return ErrOverloaded
 	}
-	i.operationChan <- opArgument
 
-	return <-opArgument.result
+	select {
+	case err := <-opArgument.result:
+		return err
+	case <-deadline.C:
+		if opArgument.state.CompareAndSwap(operationPending, operationAbandoned) {
+			return ErrOverloaded
+		}
 
+		// the loop picked it up in the meantime, it has to finish
+		return <-opArgument.result
+	}
 }
 
-func (i *Instance) Close() {
+func (i *Instance) Close() error {
 	// Close the booelan, so that wont be upcoming request
 	i.operationOpen.Store(false)
 
+	// Stop background jobs, they are enqueueing to the channel as well
+	close(i.stop)
+	i.backgroundWg.Wait()
+
 	// Wait for in-progress request to finish
 	i.operationWg.Wait()
 
-	// Lastly, close the channel
-	close(i.operationChan)
+	// Lastly, close the channels and the underlying storage
+	for _, lane := range i.lanes {
+		close(lane)
+	}
+	return i.storage.Close()
+}
+
+// Now returns the current time of the instance clock.
+func (i *Instance) Now() time.Time {
+	return i.clock.Now()
 }
 
-func (i *Instance) CreateTable(tableName string) error {
+func (i *Instance) CreateTable(tableName string, opts ...TableOption) error {
+	config := tableConfig{}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	// Table config lives in memory, it is applied again even if the table
+	// already exists in a persistent storage.
+	i.tables.Store(tableName, config)
+
 	// We use lambda function
 	op := func(x *Instance) error {
-		if _, ok := x.tables[tableName]; ok {
-			return ErrTableAlreadyExists
+		if err := x.storage.CreateTable(expiryTableName(tableName)); err != nil && err != ErrTableAlreadyExists {
+			return err
 		}
 
-		// initialize table
-		// x.tables[tableName] = &sync.Map{}
-		x.tables[tableName] = map[string]any{}
-		return nil
+		return x.storage.CreateTable(tableName)
 	}
 
-	return i.enqueueProcess(op, "createTable")
+	return i.enqueueProcess(op, "createTable", OnLane(LaneAdmin))
 }
 
 func (i *Instance) GetTable(tableName string) (*Table, error) {
-	table, found := i.tables[tableName]
-	if !found {
+	if !i.storage.HasTable(tableName) {
 		return nil, ErrTableIsNotFound
 	}
 
 	return &Table{
-		data:           table,
+		name:           tableName,
+		storage:        i.storage,
+		clock:          i.clock,
+		ttl:            i.tableConfig(tableName).ttl,
 		enqueueProcess: i.enqueueProcess,
 	}, nil
 }
 
-func (i *Instance) Transaction(f func(*Transaction) error) error {
+func (i *Instance) Transaction(f func(*Transaction) error, opts ...OperationOption) error {
 	op := func(x *Instance) error {
 		transaction := &Transaction{
-			tables:  x.tables,
-			changes: make(map[string]map[string]any),
+			instance: x,
+			changes:  ChangeSet{},
 		}
 
 		if err := f(transaction); err != nil {
@@ -125,16 +276,16 @@ func (i *Instance) Transaction(f func(*Transaction) error) error {
 		}
 
 		// commit
-		for table, change := range transaction.changes {
-			assertedTable := x.tables[table]
+		return x.commit(transaction)
+	}
 
-			for primaryKey, row := range change {
-				assertedTable[primaryKey] = row
-			}
-		}
+	return i.enqueueProcess(op, "transaction", opts...)
+}
 
-		return nil
+func (i *Instance) tableConfig(tableName string) tableConfig {
+	v, ok := i.tables.Load(tableName)
+	if !ok {
+		return tableConfig{}
 	}
-
-	return i.enqueueProcess(op, "transaction")
+	return v.(tableConfig)
 }
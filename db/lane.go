@@ -0,0 +1,179 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+// Lane is the priority class of an operation. Every lane has its own queue
+// and the event loop serves them by weight, so a burst in one lane can only
+// slow the others down, never starve them.
+type Lane int
+
+const (
+	LaneInteractive Lane = iota // user facing requests
+	LaneBackground              // sweeps, schedules, bulk jobs, reports
+	LaneAdmin                   // schema changes and back office
+	laneCount
+)
+
+// DefaultLaneWeights is how many operations of each lane are served per
+// round when every lane is busy.
+var DefaultLaneWeights = map[Lane]int{
+	LaneInteractive: 8,
+	LaneBackground:  1,
+	LaneAdmin:       2,
+}
+
+func (l Lane) String() string {
+	switch l {
+	case LaneInteractive:
+		return "interactive"
+	case LaneBackground:
+		return "background"
+	case LaneAdmin:
+		return "admin"
+	}
+	return "unknown"
+}
+
+// WithLaneWeights overrides the weight of some lanes, a lane weight is at
+// least 1.
+func WithLaneWeights(weights map[Lane]int) Option {
+	return func(i *Instance) {
+		for lane, weight := range weights {
+			if lane < 0 || lane >= laneCount {
+				continue
+			}
+			if weight < 1 {
+				weight = 1
+			}
+			i.laneWeights[lane] = weight
+		}
+	}
+}
+
+// OperationOption configures a single enqueued operation.
+type OperationOption func(*operationArgument)
+
+// OnLane puts the operation in the given lane instead of the interactive one.
+func OnLane(lane Lane) OperationOption {
+	return func(o *operationArgument) {
+		if lane >= 0 && lane < laneCount {
+			o.lane = lane
+		}
+	}
+}
+
+// LaneStats is the wait time of the operations of a lane, measured from the
+// moment they are enqueued until the event loop picks them up.
+type LaneStats struct {
+	Pending   int           `json:"pending"`
+	Processed int64         `json:"processed"`
+	TotalWait time.Duration `json:"total_wait"`
+	MaxWait   time.Duration `json:"max_wait"`
+}
+
+func (s LaneStats) AverageWait() time.Duration {
+	if s.Processed == 0 {
+		return 0
+	}
+	return s.TotalWait / time.Duration(s.Processed)
+}
+
+type laneMetrics struct {
+	mu    sync.Mutex
+	stats [laneCount]LaneStats
+}
+
+func (m *laneMetrics) observe(lane Lane, wait time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stats[lane].Processed++
+	m.stats[lane].TotalWait += wait
+	if wait > m.stats[lane].MaxWait {
+		m.stats[lane].MaxWait = wait
+	}
+}
+
+// LaneStats returns the wait time metrics of every lane.
+func (i *Instance) LaneStats() map[Lane]LaneStats {
+	i.laneMetrics.mu.Lock()
+	defer i.laneMetrics.mu.Unlock()
+
+	stats := map[Lane]LaneStats{}
+	for lane := Lane(0); lane < laneCount; lane++ {
+		s := i.laneMetrics.stats[lane]
+		s.Pending = len(i.lanes[lane])
+		stats[lane] = s
+	}
+	return stats
+}
+
+// laneScheduler is owned by the event loop goroutine. Closed lanes are set
+// to nil so they block forever in the select.
+type laneScheduler struct {
+	queues  [laneCount]chan operationArgument
+	weights [laneCount]int
+	current Lane
+	served  int
+}
+
+func newLaneScheduler(i *Instance) *laneScheduler {
+	return &laneScheduler{
+		queues:  i.lanes,
+		weights: i.laneWeights,
+	}
+}
+
+// next picks the next operation with a weighted round robin, it blocks while
+// every lane is empty and returns false once every lane is closed.
+func (s *laneScheduler) next() (operationArgument, bool) {
+	for {
+		for attempts := Lane(0); attempts < laneCount; attempts++ {
+			lane := s.current
+			if s.queues[lane] != nil && s.served < s.weights[lane] {
+				select {
+				case op, ok := <-s.queues[lane]:
+					if ok {
+						s.served++
+						return op, true
+					}
+					s.queues[lane] = nil
+				default:
+				}
+			}
+
+			// lane is empty or used up its weight, move on to the next one
+			s.current = (s.current + 1) % laneCount
+			s.served = 0
+		}
+
+		if s.queues[LaneInteractive] == nil && s.queues[LaneBackground] == nil && s.queues[LaneAdmin] == nil {
+			return operationArgument{}, false
+		}
+
+		// every lane is empty, wait for whichever comes first
+		var op operationArgument
+		var ok bool
+		var lane Lane
+		select {
+		case op, ok = <-s.queues[LaneInteractive]:
+			lane = LaneInteractive
+		case op, ok = <-s.queues[LaneBackground]:
+			lane = LaneBackground
+		case op, ok = <-s.queues[LaneAdmin]:
+			lane = LaneAdmin
+		}
+
+		if !ok {
+			s.queues[lane] = nil
+			continue
+		}
+
+		s.current = lane
+		s.served = 1
+		return op, true
+	}
+}
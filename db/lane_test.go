@@ -0,0 +1,112 @@
+package db_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/db"
+)
+
+func TestLanePriority(t *testing.T) {
+	inst := db.NewInstance()
+
+	order := []db.Lane{}
+	record := func(lane db.Lane) func(*db.Transaction) error {
+		return func(_ *db.Transaction) error {
+			// runs on the event loop, no lock needed
+			order = append(order, lane)
+			return nil
+		}
+	}
+
+	wg := &sync.WaitGroup{}
+	enqueue := func(lane db.Lane, count int) {
+		for n := 0; n < count; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				inst.Transaction(record(lane), db.OnLane(lane))
+			}()
+		}
+	}
+
+	// fill the queues before the loop starts, background burst first
+	enqueue(db.LaneBackground, 30)
+	waitPending(t, inst, db.LaneBackground, 30)
+	enqueue(db.LaneInteractive, 5)
+	waitPending(t, inst, db.LaneInteractive, 5)
+
+	go func() {
+		inst.Start()
+	}()
+	defer inst.Close()
+	wg.Wait()
+
+	lastInteractive := 0
+	for idx, lane := range order {
+		if lane == db.LaneInteractive {
+			lastInteractive = idx
+		}
+	}
+
+	if lastInteractive >= 10 {
+		t.Fatal("interactive operations should not wait behind the background burst", order)
+	}
+
+	stats := inst.LaneStats()
+	if stats[db.LaneBackground].Processed != 30 || stats[db.LaneInteractive].Processed != 5 {
+		t.Fatal("every operation should be counted in its lane", stats)
+	}
+
+	if stats[db.LaneBackground].MaxWait < stats[db.LaneInteractive].MaxWait {
+		t.Fatal("background lane should have waited longer", stats)
+	}
+}
+
+func TestLaneWeights(t *testing.T) {
+	inst := db.NewInstance(db.WithLaneWeights(map[db.Lane]int{
+		db.LaneInteractive: 1,
+		db.LaneBackground:  1,
+	}))
+
+	order := []db.Lane{}
+	wg := &sync.WaitGroup{}
+	for _, lane := range []db.Lane{db.LaneInteractive, db.LaneBackground} {
+		for n := 0; n < 4; n++ {
+			wg.Add(1)
+			go func(lane db.Lane) {
+				defer wg.Done()
+				inst.Transaction(func(_ *db.Transaction) error {
+					order = append(order, lane)
+					return nil
+				}, db.OnLane(lane))
+			}(lane)
+		}
+		waitPending(t, inst, lane, 4)
+	}
+
+	go func() {
+		inst.Start()
+	}()
+	defer inst.Close()
+	wg.Wait()
+
+	// equal weights should alternate between the lanes
+	for idx := 1; idx < len(order); idx++ {
+		if order[idx] == order[idx-1] {
+			t.Fatal("lanes with equal weight should be served in turns", order)
+		}
+	}
+}
+
+func waitPending(t *testing.T, inst *db.Instance, lane db.Lane, count int) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if inst.LaneStats()[lane].Pending == count {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("operations were not enqueued in time", lane)
+}
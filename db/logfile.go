@@ -0,0 +1,220 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// logStorage is an append-only log structured file. Every commit is written
+// as a single checksummed frame, the whole log is replayed into an in memory
+// index on open, and reads are served from that index.
+//
+// A torn frame at the tail (crash in the middle of a write) is truncated on
+// open, so a commit is either fully in the log or not at all.
+type logStorage struct {
+	mu    sync.Mutex
+	file  *os.File
+	index *memoryStorage
+}
+
+type logRecord struct {
+	CreateTable string
+	Changes     []logChange
+}
+
+type logChange struct {
+	Table   string
+	Key     string
+	Value   any
+	Deleted bool
+}
+
+const logHeaderSize = 8 // 4 bytes payload length, 4 bytes crc32 of the payload
+
+func OpenLogStorage(path string) (Storage, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &logStorage{
+		file:  file,
+		index: NewMemoryStorage().(*memoryStorage),
+	}
+
+	if err := l.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *logStorage) replay() error {
+	reader := bufio.NewReader(l.file)
+	header := make([]byte, logHeaderSize)
+	var offset int64
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			break
+		}
+
+		size := binary.BigEndian.Uint32(header[0:4])
+		checksum := binary.BigEndian.Uint32(header[4:8])
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			break
+		}
+
+		if crc32.ChecksumIEEE(payload) != checksum {
+			break
+		}
+
+		var record logRecord
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&record); err != nil {
+			return err
+		}
+
+		if err := l.apply(record); err != nil {
+			return err
+		}
+
+		offset += logHeaderSize + int64(size)
+	}
+
+	// drop whatever is after the last complete frame
+	if err := l.file.Truncate(offset); err != nil {
+		return err
+	}
+
+	_, err := l.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+func (l *logStorage) apply(record logRecord) error {
+	if record.CreateTable != "" {
+		return l.index.CreateTable(record.CreateTable)
+	}
+
+	changes := ChangeSet{}
+	for _, c := range record.Changes {
+		if _, ok := changes[c.Table]; !ok {
+			changes[c.Table] = map[string]any{}
+		}
+
+		if c.Deleted {
+			changes[c.Table][c.Key] = nil
+			continue
+		}
+		changes[c.Table][c.Key] = c.Value
+	}
+
+	return l.index.Commit(changes)
+}
+
+func (l *logStorage) append(record logRecord) error {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(record); err != nil {
+		return err
+	}
+
+	frame := make([]byte, logHeaderSize+payload.Len())
+	binary.BigEndian.PutUint32(frame[0:4], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload.Bytes()))
+	copy(frame[logHeaderSize:], payload.Bytes())
+
+	if _, err := l.file.Write(frame); err != nil {
+		return err
+	}
+
+	return l.file.Sync()
+}
+
+func (l *logStorage) CreateTable(table string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.index.HasTable(table) {
+		return ErrTableAlreadyExists
+	}
+
+	record := logRecord{CreateTable: table}
+	if err := l.append(record); err != nil {
+		return err
+	}
+
+	return l.apply(record)
+}
+
+func (l *logStorage) HasTable(table string) bool {
+	return l.index.HasTable(table)
+}
+
+func (l *logStorage) Get(table, key string) (any, error) {
+	return l.index.Get(table, key)
+}
+
+func (l *logStorage) Put(table, key string, value any) error {
+	return l.Commit(ChangeSet{table: {key: value}})
+}
+
+func (l *logStorage) Delete(table, key string) error {
+	return l.Commit(ChangeSet{table: {key: nil}})
+}
+
+func (l *logStorage) Iterate(table string, f func(key string, value any) bool) error {
+	return l.index.Iterate(table, f)
+}
+
+func (l *logStorage) Commit(changes ChangeSet) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	record := logRecord{}
+	for table, change := range changes {
+		if !l.index.HasTable(table) {
+			return ErrTableIsNotFound
+		}
+
+		for key, value := range change {
+			record.Changes = append(record.Changes, logChange{
+				Table:   table,
+				Key:     key,
+				Value:   value,
+				Deleted: value == nil,
+			})
+		}
+	}
+
+	if len(record.Changes) == 0 {
+		return nil
+	}
+
+	if err := l.append(record); err != nil {
+		return err
+	}
+
+	return l.apply(record)
+}
+
+func (l *logStorage) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return errors.New("log storage already closed")
+	}
+
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,114 @@
+package db
+
+import "sync"
+
+// memoryStorage is the original engine, every table is a plain map.
+type memoryStorage struct {
+	mu     sync.RWMutex
+	tables map[string]map[string]any
+}
+
+func NewMemoryStorage() Storage {
+	return &memoryStorage{
+		tables: map[string]map[string]any{},
+	}
+}
+
+func (m *memoryStorage) CreateTable(table string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tables[table]; ok {
+		return ErrTableAlreadyExists
+	}
+
+	m.tables[table] = map[string]any{}
+	return nil
+}
+
+func (m *memoryStorage) HasTable(table string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.tables[table]
+	return ok
+}
+
+func (m *memoryStorage) Get(table, key string) (any, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rows, ok := m.tables[table]
+	if !ok {
+		return nil, ErrTableIsNotFound
+	}
+
+	v, ok := rows[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return v, nil
+}
+
+func (m *memoryStorage) Put(table, key string, value any) error {
+	return m.Commit(ChangeSet{table: {key: value}})
+}
+
+func (m *memoryStorage) Delete(table, key string) error {
+	return m.Commit(ChangeSet{table: {key: nil}})
+}
+
+func (m *memoryStorage) Iterate(table string, f func(key string, value any) bool) error {
+	m.mu.RLock()
+	rows, ok := m.tables[table]
+	if !ok {
+		m.mu.RUnlock()
+		return ErrTableIsNotFound
+	}
+
+	// copy first so f is free to call back into the storage
+	keys := make([]string, 0, len(rows))
+	values := make([]any, 0, len(rows))
+	for k, v := range rows {
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	m.mu.RUnlock()
+
+	for idx := range keys {
+		if !f(keys[idx], values[idx]) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryStorage) Commit(changes ChangeSet) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for table := range changes {
+		if _, ok := m.tables[table]; !ok {
+			return ErrTableIsNotFound
+		}
+	}
+
+	for table, change := range changes {
+		rows := m.tables[table]
+		for primaryKey, row := range change {
+			if row == nil {
+				delete(rows, primaryKey)
+				continue
+			}
+			rows[primaryKey] = row
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,334 @@
+package db
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrScheduleNotFound = errors.New("schedule is not found")
+var ErrScheduleHandlerNotFound = errors.New("schedule handler is not found")
+
+const schedulesTable = "_schedules"
+
+// A schedule whose op fails runs again after ScheduleRetryInterval, doubling
+// with every failed attempt up to ScheduleMaxRetryInterval. It is given up
+// and kept as failed after ScheduleMaxAttempts.
+var ScheduleRetryInterval = time.Minute
+var ScheduleMaxRetryInterval = time.Hour
+var ScheduleMaxAttempts = 10
+
+const (
+	ScheduleStatusPending = "pending"
+	ScheduleStatusFailed  = "failed"
+)
+
+// Schedule is the persisted part of a scheduled operation. Executed
+// schedules are deleted, failed ones are tried again and kept with their
+// last error.
+type Schedule struct {
+	ID       string
+	Name     string
+	At       time.Time
+	Status   string
+	Error    string
+	Attempts int // failed runs so far
+}
+
+// ScheduleHandler runs persisted schedules of a name whose closure is no
+// longer in memory, for example after a restart.
+type ScheduleHandler func(*Transaction, Schedule) error
+
+type scheduled struct {
+	schedule Schedule
+	op       func(*Transaction) error
+}
+
+func init() {
+	gob.Register(Schedule{})
+}
+
+// Schedule runs op on the event loop at the given time, inside its own
+// transaction. The schedule is persisted, the op itself is not, register a
+// handler with HandleSchedule to pick it up again after a restart.
+func (i *Instance) Schedule(at time.Time, name string, op func(*Transaction) error) (string, error) {
+	s := Schedule{
+		ID:     uuid.New().String(),
+		Name:   name,
+		At:     at,
+		Status: ScheduleStatusPending,
+	}
+
+	err := i.enqueueProcess(func(x *Instance) error {
+		if err := x.ensureSchedulesTable(); err != nil {
+			return err
+		}
+
+		if err := x.storage.Put(schedulesTable, s.ID, s); err != nil {
+			return err
+		}
+
+		x.scheduleMu.Lock()
+		x.schedules[s.ID] = &scheduled{schedule: s, op: op}
+		x.scheduleMu.Unlock()
+		return nil
+	}, "schedule")
+	if err != nil {
+		return "", err
+	}
+
+	i.wakeScheduler()
+	return s.ID, nil
+}
+
+// CancelSchedule removes a schedule that hasn't run yet.
+func (i *Instance) CancelSchedule(id string) error {
+	err := i.enqueueProcess(func(x *Instance) error {
+		x.scheduleMu.Lock()
+		_, ok := x.schedules[id]
+		delete(x.schedules, id)
+		x.scheduleMu.Unlock()
+
+		if !ok {
+			return ErrScheduleNotFound
+		}
+
+		return x.storage.Delete(schedulesTable, id)
+	}, "cancelSchedule")
+	if err != nil {
+		return err
+	}
+
+	i.wakeScheduler()
+	return nil
+}
+
+// HandleSchedule registers the handler of persisted schedules by name.
+func (i *Instance) HandleSchedule(name string, handler ScheduleHandler) {
+	i.scheduleMu.Lock()
+	defer i.scheduleMu.Unlock()
+
+	i.scheduleHandlers[name] = handler
+}
+
+// Schedules lists the pending schedules ordered by time.
+func (i *Instance) Schedules() []Schedule {
+	i.scheduleMu.Lock()
+	defer i.scheduleMu.Unlock()
+
+	list := make([]Schedule, 0, len(i.schedules))
+	for _, s := range i.schedules {
+		list = append(list, s.schedule)
+	}
+
+	sort.Slice(list, func(a, b int) bool {
+		return list[a].At.Before(list[b].At)
+	})
+	return list
+}
+
+// RunDueSchedules runs every schedule that is due according to the clock
+// and returns how many ran. The scheduler calls it on its own, tests call it
+// right after moving a fake clock.
+func (i *Instance) RunDueSchedules() (int, error) {
+	ran := 0
+
+	err := i.enqueueProcess(func(x *Instance) error {
+		for _, s := range x.dueSchedules(x.clock.Now()) {
+			x.runSchedule(s)
+			ran++
+		}
+		return nil
+	}, "runDueSchedules", OnLane(LaneBackground))
+	if err != nil {
+		return 0, err
+	}
+
+	return ran, nil
+}
+
+func (i *Instance) dueSchedules(now time.Time) []*scheduled {
+	i.scheduleMu.Lock()
+	defer i.scheduleMu.Unlock()
+
+	due := []*scheduled{}
+	for _, s := range i.schedules {
+		if !s.schedule.At.After(now) {
+			due = append(due, s)
+		}
+	}
+
+	sort.Slice(due, func(a, b int) bool {
+		return due[a].schedule.At.Before(due[b].schedule.At)
+	})
+	return due
+}
+
+// runSchedule must be called from the event loop.
+func (i *Instance) runSchedule(s *scheduled) {
+	i.scheduleMu.Lock()
+	delete(i.schedules, s.schedule.ID)
+	handler := i.scheduleHandlers[s.schedule.Name]
+	i.scheduleMu.Unlock()
+
+	op := s.op
+	if op == nil && handler != nil {
+		op = func(t *Transaction) error {
+			return handler(t, s.schedule)
+		}
+	}
+
+	transaction := &Transaction{
+		instance: i,
+		changes:  ChangeSet{},
+	}
+
+	err := ErrScheduleHandlerNotFound
+	if op != nil {
+		err = func() (err2 error) {
+			defer func() {
+				if v := recover(); v != nil {
+					err2 = fmt.Errorf("error %v", v)
+				}
+			}()
+			return op(transaction)
+		}()
+	}
+
+	if err == nil {
+		transaction.changes[schedulesTable] = map[string]any{s.schedule.ID: nil}
+		err = i.commit(transaction)
+	}
+
+	if err != nil {
+		// the op is rolled back, it runs again later and is kept around for
+		// inspection once it ran out of attempts
+		failed := s.schedule
+		failed.Attempts++
+		failed.Error = err.Error()
+		if failed.Attempts < ScheduleMaxAttempts {
+			failed.At = i.clock.Now().Add(scheduleBackoff(failed.Attempts))
+			i.scheduleMu.Lock()
+			i.schedules[failed.ID] = &scheduled{schedule: failed, op: s.op}
+			i.scheduleMu.Unlock()
+			i.wakeScheduler()
+		} else {
+			failed.Status = ScheduleStatusFailed
+		}
+		_ = i.storage.Put(schedulesTable, failed.ID, failed)
+	}
+}
+
+// scheduleBackoff is the wait before the next run of a schedule that failed
+// attempts times.
+func scheduleBackoff(attempts int) time.Duration {
+	backoff := ScheduleRetryInterval
+	for i := 1; i < attempts && backoff < ScheduleMaxRetryInterval; i++ {
+		backoff *= 2
+	}
+	if backoff > ScheduleMaxRetryInterval {
+		backoff = ScheduleMaxRetryInterval
+	}
+	return backoff
+}
+
+// loadSchedules brings the persisted pending schedules back in memory.
+func (i *Instance) loadSchedules() {
+	if !i.storage.HasTable(schedulesTable) {
+		return
+	}
+
+	i.scheduleMu.Lock()
+	defer i.scheduleMu.Unlock()
+
+	_ = i.storage.Iterate(schedulesTable, func(_ string, value any) bool {
+		s := value.(Schedule)
+		if s.Status == ScheduleStatusPending {
+			if _, ok := i.schedules[s.ID]; !ok {
+				i.schedules[s.ID] = &scheduled{schedule: s}
+			}
+		}
+		return true
+	})
+}
+
+// commit writes a transaction together with the schedules made in it, it
+// must be called from the event loop.
+func (i *Instance) commit(t *Transaction) error {
+	if len(t.schedules) > 0 {
+		if err := i.ensureSchedulesTable(); err != nil {
+			return err
+		}
+
+		rows, ok := t.changes[schedulesTable]
+		if !ok {
+			rows = map[string]any{}
+			t.changes[schedulesTable] = rows
+		}
+		for _, s := range t.schedules {
+			rows[s.schedule.ID] = s.schedule
+		}
+	}
+
+	if err := i.storage.Commit(t.changes); err != nil {
+		return err
+	}
+
+	if len(t.schedules) > 0 {
+		i.scheduleMu.Lock()
+		for _, s := range t.schedules {
+			i.schedules[s.schedule.ID] = s
+		}
+		i.scheduleMu.Unlock()
+
+		i.wakeScheduler()
+	}
+
+	return nil
+}
+
+func (i *Instance) ensureSchedulesTable() error {
+	if err := i.storage.CreateTable(schedulesTable); err != nil && err != ErrTableAlreadyExists {
+		return err
+	}
+	return nil
+}
+
+func (i *Instance) wakeScheduler() {
+	select {
+	case i.scheduleWake <- struct{}{}:
+	default:
+	}
+}
+
+func (i *Instance) runScheduler() {
+	defer i.backgroundWg.Done()
+
+	for i.waitForSchedule() {
+	}
+}
+
+// waitForSchedule sleeps until the next schedule is due or the schedules
+// change, and tells whether the scheduler goes on. The timer is stopped on
+// the way out, whatever woke it up.
+func (i *Instance) waitForSchedule() bool {
+	var due <-chan time.Time
+	if list := i.Schedules(); len(list) > 0 {
+		timer := i.clock.NewTimer(list[0].At.Sub(i.clock.Now()))
+		defer timer.Stop()
+		due = timer.C()
+	}
+
+	select {
+	case <-i.stop:
+		return false
+	case <-i.scheduleWake:
+	case <-due:
+		_, _ = i.RunDueSchedules()
+	}
+	return true
+}
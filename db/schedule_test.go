@@ -0,0 +1,311 @@
+package db_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+func storeUser(id string) func(*db.Transaction) error {
+	return func(x *db.Transaction) error {
+		userTable, err := x.GetTable("users")
+		if err != nil {
+			return err
+		}
+
+		userTable.ReplaceOrStore(id, entity.User{ID: id})
+		return nil
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	forEachEngine(t, func(t *testing.T, inst *db.Instance) {
+		inst.CreateTable("users")
+		table, _ := inst.GetTable("users")
+
+		_, err := inst.Schedule(clock.Now().Add(15*time.Minute), "release", storeUser("xx"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ran, _ := inst.RunDueSchedules(); ran != 0 {
+			t.Fatal("schedule should not run before its time", ran)
+		}
+
+		clock.Advance(15 * time.Minute)
+
+		// the background scheduler may be first, either way the schedule
+		// has run once RunDueSchedules returns
+		if _, err := inst.RunDueSchedules(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := table.FindByID("xx"); err != nil {
+			t.Fatal("scheduled op should be committed", err)
+		}
+
+		if len(inst.Schedules()) != 0 {
+			t.Fatal("executed schedule should be removed", inst.Schedules())
+		}
+	}, db.WithClock(clock))
+}
+
+func TestCancelSchedule(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	forEachEngine(t, func(t *testing.T, inst *db.Instance) {
+		inst.CreateTable("users")
+		table, _ := inst.GetTable("users")
+
+		id, _ := inst.Schedule(clock.Now().Add(time.Minute), "release", storeUser("xx"))
+
+		if err := inst.CancelSchedule(id); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := inst.CancelSchedule(id); err != db.ErrScheduleNotFound {
+			t.Fatal("cancelling twice should fail", err)
+		}
+
+		clock.Advance(time.Minute)
+		inst.RunDueSchedules()
+
+		if _, err := table.FindByID("xx"); err != db.ErrNotFound {
+			t.Fatal("cancelled schedule should not run", err)
+		}
+	}, db.WithClock(clock))
+}
+
+func TestFailedScheduleIsRolledBack(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	forEachEngine(t, func(t *testing.T, inst *db.Instance) {
+		inst.CreateTable("users")
+		table, _ := inst.GetTable("users")
+
+		inst.Schedule(clock.Now(), "failing", func(x *db.Transaction) error {
+			storeUser("xx")(x)
+			return errors.New("something went wrong")
+		})
+
+		inst.RunDueSchedules()
+
+		if _, err := table.FindByID("xx"); err != db.ErrNotFound {
+			t.Fatal("failed schedule should be rolled back", err)
+		}
+	}, db.WithClock(clock))
+}
+
+func TestScheduleInTransaction(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	forEachEngine(t, func(t *testing.T, inst *db.Instance) {
+		inst.CreateTable("users")
+		table, _ := inst.GetTable("users")
+
+		inst.Transaction(func(x *db.Transaction) error {
+			x.Schedule(clock.Now().Add(time.Minute), "rolled-back", storeUser("xx"))
+			return errors.New("something went wrong")
+		})
+
+		if len(inst.Schedules()) != 0 {
+			t.Fatal("schedule of a rolled back transaction should be dropped", inst.Schedules())
+		}
+
+		// a schedule that books the next one
+		var id string
+		inst.Transaction(func(x *db.Transaction) error {
+			id = x.Schedule(clock.Now().Add(time.Minute), "first", func(x *db.Transaction) error {
+				x.Schedule(clock.Now().Add(time.Minute), "second", storeUser("yy"))
+				return storeUser("xx")(x)
+			})
+			return nil
+		})
+
+		if list := inst.Schedules(); len(list) != 1 || list[0].ID != id {
+			t.Fatal("schedule should be kept on commit", list)
+		}
+
+		clock.Advance(time.Minute)
+		inst.RunDueSchedules()
+
+		if _, err := table.FindByID("xx"); err != nil {
+			t.Fatal("scheduled op should be committed", err)
+		}
+		if list := inst.Schedules(); len(list) != 1 || list[0].Name != "second" {
+			t.Fatal("schedule made by a schedule should be kept", list)
+		}
+
+		clock.Advance(time.Minute)
+		inst.RunDueSchedules()
+
+		if _, err := table.FindByID("yy"); err != nil {
+			t.Fatal("second schedule should run", err)
+		}
+	}, db.WithClock(clock))
+}
+
+func TestSchedulePersistence(t *testing.T) {
+	for _, engine := range []string{db.EngineLog, db.EngineBolt} {
+		t.Run(engine, func(t *testing.T) {
+			clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+			path := filepath.Join(t.TempDir(), "wallet")
+
+			inst := openInstance(t, engine, path, db.WithClock(clock))
+			inst.CreateTable("users")
+			id, _ := inst.Schedule(clock.Now().Add(time.Hour), "store-user", storeUser("xx"))
+			inst.Close()
+
+			reopened := openInstance(t, engine, path, db.WithClock(clock))
+			defer reopened.Close()
+
+			var handled db.Schedule
+			reopened.HandleSchedule("store-user", func(x *db.Transaction, s db.Schedule) error {
+				handled = s
+				return storeUser("yy")(x)
+			})
+			reopened.CreateTable("users")
+
+			if list := reopened.Schedules(); len(list) != 1 || list[0].ID != id {
+				t.Fatal("pending schedule should survive a restart", list)
+			}
+
+			clock.Advance(time.Hour)
+			reopened.RunDueSchedules()
+
+			if handled.ID != id {
+				t.Fatal("handler should receive the persisted schedule", handled)
+			}
+
+			table, _ := reopened.GetTable("users")
+			if _, err := table.FindByID("yy"); err != nil {
+				t.Fatal("handler op should be committed", err)
+			}
+		})
+	}
+}
+
+func TestSchedulerRunsOnItsOwn(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	inst := db.NewInstance(db.WithClock(clock))
+	go func() {
+		inst.Start()
+	}()
+	defer inst.Close()
+
+	inst.CreateTable("users")
+	table, _ := inst.GetTable("users")
+	inst.Schedule(clock.Now().Add(time.Minute), "store-user", storeUser("xx"))
+
+	clock.Advance(time.Minute)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := table.FindByID("xx"); err == nil {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("scheduler should run the due schedule without being asked")
+}
+
+func TestFailedScheduleIsRetried(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	forEachEngine(t, func(t *testing.T, inst *db.Instance) {
+		inst.CreateTable("users")
+		table, _ := inst.GetTable("users")
+
+		runs := 0
+		inst.Schedule(clock.Now(), "flaky", func(x *db.Transaction) error {
+			runs++
+			if runs < 3 {
+				return errors.New("something went wrong")
+			}
+			return storeUser("xx")(x)
+		})
+
+		inst.RunDueSchedules()
+		list := inst.Schedules()
+		if len(list) != 1 || list[0].Attempts != 1 || !list[0].At.Equal(clock.Now().Add(db.ScheduleRetryInterval)) {
+			t.Fatal("failed schedule should be tried again after the retry interval", list)
+		}
+
+		// the wait doubles
+		clock.Advance(db.ScheduleRetryInterval)
+		inst.RunDueSchedules()
+		list = inst.Schedules()
+		if len(list) != 1 || list[0].Attempts != 2 || !list[0].At.Equal(clock.Now().Add(2*db.ScheduleRetryInterval)) {
+			t.Fatal("retry interval should double", list)
+		}
+
+		clock.Advance(2 * db.ScheduleRetryInterval)
+		inst.RunDueSchedules()
+		if _, err := table.FindByID("xx"); err != nil {
+			t.Fatal("retried schedule should be committed", err)
+		}
+		if list := inst.Schedules(); len(list) != 0 {
+			t.Fatal("executed schedule should be removed", list)
+		}
+	}, db.WithClock(clock))
+}
+
+func TestFailedScheduleIsGivenUp(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	forEachEngine(t, func(t *testing.T, inst *db.Instance) {
+		runs := 0
+		inst.Schedule(clock.Now(), "failing", func(x *db.Transaction) error {
+			runs++
+			return errors.New("something went wrong")
+		})
+
+		for i := 0; i < db.ScheduleMaxAttempts; i++ {
+			inst.RunDueSchedules()
+			clock.Advance(db.ScheduleMaxRetryInterval)
+		}
+		inst.RunDueSchedules()
+
+		if runs != db.ScheduleMaxAttempts {
+			t.Fatal("failed schedule should run at most the max attempts", runs)
+		}
+		if list := inst.Schedules(); len(list) != 0 {
+			t.Fatal("failed schedule should be given up", list)
+		}
+	}, db.WithClock(clock))
+}
+
+func TestSchedulerStopsItsTimers(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	// no expiry sweep, the scheduler's timer is the only one
+	inst := db.NewInstance(db.WithClock(clock), db.WithExpiryInterval(0))
+	go func() {
+		inst.Start()
+	}()
+	defer inst.Close()
+
+	inst.CreateTable("users")
+
+	// every new schedule wakes the scheduler up before its timer fired
+	for i := 0; i < 10; i++ {
+		inst.Schedule(clock.Now().Add(time.Hour), "store-user", storeUser("xx"))
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if clock.Waiters() == 1 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("scheduler should only wait on its latest timer", clock.Waiters())
+}
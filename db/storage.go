@@ -0,0 +1,49 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	EngineMemory = "memory"
+	EngineLog    = "log"
+	EngineBolt   = "bolt"
+)
+
+var ErrUnknownEngine = errors.New("unknown storage engine")
+
+// Storage is the backend the event loop sits on top of. Every write goes
+// through the loop, so implementations only have to be safe for concurrent
+// reads alongside a single writer.
+type Storage interface {
+	CreateTable(table string) error
+	HasTable(table string) bool
+	Get(table, key string) (any, error)
+	Put(table, key string, value any) error
+	Delete(table, key string) error
+	// Iterate calls f for every row in the table until f returns false.
+	Iterate(table string, f func(key string, value any) bool) error
+	// Commit applies every change of a transaction at once.
+	Commit(changes ChangeSet) error
+	Close() error
+}
+
+// ChangeSet groups the pending writes of a transaction by table then by
+// primary key. A nil value marks the key as deleted.
+type ChangeSet map[string]map[string]any
+
+// OpenStorage selects a storage engine by name, path is ignored by the
+// memory engine.
+func OpenStorage(engine, path string) (Storage, error) {
+	switch engine {
+	case "", EngineMemory:
+		return NewMemoryStorage(), nil
+	case EngineLog:
+		return OpenLogStorage(path)
+	case EngineBolt:
+		return OpenBoltStorage(path)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownEngine, engine)
+}
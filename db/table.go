@@ -2,28 +2,40 @@ package db
 
 import (
 	"errors"
+	"time"
 )
 
 var ErrNotFound = errors.New("not found")
 
 type Table struct {
-	data           map[string]any
+	name           string
+	storage        Storage
+	clock          Clock
+	ttl            time.Duration
 	changes        map[string]any
-	enqueueProcess func(f func(*Instance) error, operationName string) error
+	expiryChanges  map[string]any
+	enqueueProcess func(f func(*Instance) error, operationName string, opts ...OperationOption) error
 }
 
 func (t *Table) FindByID(id string) (any, error) {
 	var v any
-	var found bool
 	if changeV, ok := t.changes[id]; ok {
+		// deleted inside the transaction
+		if changeV == nil {
+			return nil, ErrNotFound
+		}
 		v = changeV
-		found = true
-		return v, nil
+	} else {
+		// read uncommitted
+		var err error
+		v, err = t.storage.Get(t.name, id)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// read uncommitted
-	v, found = t.data[id]
-	if !found {
+	// expired rows are gone even before the sweep removes them
+	if expiresAt, ok := t.expiresAt(id); ok && !expiresAt.After(t.clock.Now()) {
 		return nil, ErrNotFound
 	}
 
@@ -32,15 +44,32 @@ func (t *Table) FindByID(id string) (any, error) {
 
 func (t *Table) Filter(f func(v any) bool) []any {
 	filtered := []any{}
+	now := t.clock.Now()
+
+	expired := map[string]bool{}
+	_ = t.storage.Iterate(expiryTableName(t.name), func(key string, value any) bool {
+		expired[key] = !value.(time.Time).After(now)
+		return true
+	})
+	for key, value := range t.expiryChanges {
+		expired[key] = value != nil && !value.(time.Time).After(now)
+	}
 
-	for key, value := range t.data {
-		// handling read commited
-		if changeV, ok := t.changes[key]; ok {
-			value = changeV
+	_ = t.storage.Iterate(t.name, func(key string, value any) bool {
+		// handling read commited, changed rows are checked below
+		if _, ok := t.changes[key]; ok {
+			return true
 		}
 
 		// read uncommitted
-		if f(value) {
+		if !expired[key] && f(value) {
+			filtered = append(filtered, value)
+		}
+		return true
+	})
+
+	for key, value := range t.changes {
+		if value != nil && !expired[key] && f(value) {
 			filtered = append(filtered, value)
 		}
 	}
@@ -48,21 +77,73 @@ func (t *Table) Filter(f func(v any) bool) []any {
 	return filtered
 }
 
-func (t *Table) ReplaceOrStore(id string, value any) any {
-	var v any
+func (t *Table) ReplaceOrStore(id string, value any) error {
+	return t.ReplaceOrStoreWithTTL(id, value, t.ttl)
+}
+
+// ReplaceOrStoreWithTTL stores the row and expires it after ttl, a zero ttl
+// keeps the row forever.
+func (t *Table) ReplaceOrStoreWithTTL(id string, value any, ttl time.Duration) error {
 	op := func(i *Instance) error {
+		var expiry any
+		if ttl > 0 {
+			expiry = t.clock.Now().Add(ttl)
+		} else if _, ok := t.expiresAt(id); !ok {
+			expiry = noExpiryChange
+		}
 
 		// handling write uncommited
 		if i.transactionIdentifier == "sub" {
 			t.changes[id] = value
+			if expiry != noExpiryChange {
+				t.expiryChanges[id] = expiry
+			}
 			return nil
 		}
 
 		// handling write commited
-		t.data[id] = value
-		return nil
+		changes := ChangeSet{t.name: {id: value}}
+		if expiry != noExpiryChange {
+			changes[expiryTableName(t.name)] = map[string]any{id: expiry}
+		}
+		return t.storage.Commit(changes)
+	}
+
+	return t.enqueueProcess(op, "replaceOrStore")
+}
+
+func (t *Table) Delete(id string) error {
+	op := func(i *Instance) error {
+		if i.transactionIdentifier == "sub" {
+			t.changes[id] = nil
+			t.expiryChanges[id] = nil
+			return nil
+		}
+
+		return t.storage.Commit(ChangeSet{
+			t.name:                  {id: nil},
+			expiryTableName(t.name): {id: nil},
+		})
+	}
+
+	return t.enqueueProcess(op, "delete")
+}
+
+// noExpiryChange marks a write that leaves the expiry table untouched.
+var noExpiryChange = struct{}{}
+
+func (t *Table) expiresAt(id string) (time.Time, bool) {
+	if v, ok := t.expiryChanges[id]; ok {
+		if v == nil {
+			return time.Time{}, false
+		}
+		return v.(time.Time), true
+	}
+
+	v, err := t.storage.Get(expiryTableName(t.name), id)
+	if err != nil {
+		return time.Time{}, false
 	}
 
-	_ = t.enqueueProcess(op, "replaceOrStore")
-	return v
+	return v.(time.Time), true
 }
@@ -1,29 +1,91 @@
 package db
 
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
 type Transaction struct {
-	tables  map[string]map[string]any
-	changes map[string]map[string]any
+	instance  *Instance
+	changes   ChangeSet
+	schedules []*scheduled
 }
 
 func (t *Transaction) GetTable(tableName string) (*Table, error) {
-	table, found := t.tables[tableName]
-	if !found {
+	if !t.instance.storage.HasTable(tableName) {
 		return nil, ErrTableIsNotFound
 	}
 
-	if _, found := t.changes[tableName]; !found {
-		t.changes[tableName] = make(map[string]any)
+	for _, name := range []string{tableName, expiryTableName(tableName)} {
+		if _, found := t.changes[name]; !found {
+			t.changes[name] = make(map[string]any)
+		}
 	}
 
 	// identification use only
-	clonedInstance := NewInstance()
-	clonedInstance.transactionIdentifier = "sub"
+	clonedInstance := &Instance{transactionIdentifier: "sub"}
 
 	return &Table{
-		data: table,
-		enqueueProcess: func(f func(*Instance) error, operationName string) error {
+		name:    tableName,
+		storage: t.instance.storage,
+		clock:   t.instance.clock,
+		ttl:     t.instance.tableConfig(tableName).ttl,
+		enqueueProcess: func(f func(*Instance) error, operationName string, opts ...OperationOption) error {
 			return f(clonedInstance)
 		},
-		changes: t.changes[tableName],
+		changes:       t.changes[tableName],
+		expiryChanges: t.changes[expiryTableName(tableName)],
 	}, nil
 }
+
+// Savepoint runs f on top of the transaction. Its writes are kept when f
+// succeeds and dropped when it fails, the rest of the transaction carries on
+// either way.
+func (t *Transaction) Savepoint(f func(*Transaction) error) error {
+	savepoint := &Transaction{
+		instance: t.instance,
+		changes:  make(ChangeSet, len(t.changes)),
+	}
+	for table, rows := range t.changes {
+		copied := make(map[string]any, len(rows))
+		for key, value := range rows {
+			copied[key] = value
+		}
+		savepoint.changes[table] = copied
+	}
+
+	if err := f(savepoint); err != nil {
+		return err
+	}
+
+	// tables handed out before the savepoint hold the maps of t, merge into
+	// them instead of swapping them
+	for table, rows := range savepoint.changes {
+		parent, ok := t.changes[table]
+		if !ok {
+			t.changes[table] = rows
+			continue
+		}
+		for key, value := range rows {
+			parent[key] = value
+		}
+	}
+	t.schedules = append(t.schedules, savepoint.schedules...)
+
+	return nil
+}
+
+// Schedule is Instance.Schedule from inside a transaction, the schedule is
+// only kept if the transaction commits.
+func (t *Transaction) Schedule(at time.Time, name string, op func(*Transaction) error) string {
+	s := Schedule{
+		ID:     uuid.New().String(),
+		Name:   name,
+		At:     at,
+		Status: ScheduleStatusPending,
+	}
+
+	t.schedules = append(t.schedules, &scheduled{schedule: s, op: op})
+	return s.ID
+}
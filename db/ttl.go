@@ -0,0 +1,90 @@
+package db
+
+import (
+	"encoding/gob"
+	"time"
+)
+
+// TableOption configures a table on CreateTable.
+type TableOption func(*tableConfig)
+
+type tableConfig struct {
+	ttl time.Duration
+}
+
+// WithTTL makes every row written to the table expire after ttl, unless the
+// row is written with its own TTL.
+func WithTTL(ttl time.Duration) TableOption {
+	return func(c *tableConfig) {
+		c.ttl = ttl
+	}
+}
+
+func init() {
+	gob.Register(time.Time{})
+}
+
+// Expiry of each row is kept in a companion table keyed by the same primary
+// key, so a sweep or a scan only touches the expiries of a single table.
+func expiryTableName(tableName string) string {
+	return "_expiry." + tableName
+}
+
+// SweepExpired deletes every expired row of every table. It runs as an
+// operation of the event loop so it is serialized with the writes.
+func (i *Instance) SweepExpired() (int, error) {
+	removed := 0
+
+	op := func(x *Instance) error {
+		now := x.clock.Now()
+		changes := ChangeSet{}
+
+		x.tables.Range(func(key, _ any) bool {
+			tableName := key.(string)
+
+			_ = x.storage.Iterate(expiryTableName(tableName), func(primaryKey string, value any) bool {
+				if value.(time.Time).After(now) {
+					return true
+				}
+
+				if _, ok := changes[tableName]; !ok {
+					changes[tableName] = map[string]any{}
+					changes[expiryTableName(tableName)] = map[string]any{}
+				}
+
+				changes[tableName][primaryKey] = nil
+				changes[expiryTableName(tableName)][primaryKey] = nil
+				removed++
+				return true
+			})
+			return true
+		})
+
+		if len(changes) == 0 {
+			return nil
+		}
+
+		return x.storage.Commit(changes)
+	}
+
+	if err := i.enqueueProcess(op, "sweepExpired", OnLane(LaneBackground)); err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+func (i *Instance) runExpirySweep() {
+	defer i.backgroundWg.Done()
+
+	for {
+		timer := i.clock.NewTimer(i.expiryInterval)
+		select {
+		case <-i.stop:
+			timer.Stop()
+			return
+		case <-timer.C():
+			_, _ = i.SweepExpired()
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package db_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+func TestTableTTL(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	forEachEngine(t, func(t *testing.T, inst *db.Instance) {
+		inst.CreateTable("user_tokens", db.WithTTL(time.Hour))
+		table, _ := inst.GetTable("user_tokens")
+
+		table.ReplaceOrStore("token", entity.UserToken{UserID: "xx", Token: "token"})
+
+		if _, err := table.FindByID("token"); err != nil {
+			t.Fatal("row should be found before it expires", err)
+		}
+
+		clock.Advance(time.Hour)
+
+		if _, err := table.FindByID("token"); err != db.ErrNotFound {
+			t.Fatal("expired row should be not found", err)
+		}
+
+		if rows := table.Filter(func(v any) bool { return true }); len(rows) != 0 {
+			t.Fatal("expired row should be filtered out", rows)
+		}
+
+		removed, err := inst.SweepExpired()
+		if err != nil || removed != 1 {
+			t.Fatal("sweep should remove the expired row", removed, err)
+		}
+
+		removed, _ = inst.SweepExpired()
+		if removed != 0 {
+			t.Fatal("nothing should be left to sweep", removed)
+		}
+	}, db.WithClock(clock), db.WithExpiryInterval(0))
+}
+
+func TestRowTTL(t *testing.T) {
+	clock := db.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	forEachEngine(t, func(t *testing.T, inst *db.Instance) {
+		inst.CreateTable("users")
+
+		err := inst.Transaction(func(x *db.Transaction) error {
+			userTable, err := x.GetTable("users")
+			if err != nil {
+				return err
+			}
+
+			userTable.ReplaceOrStoreWithTTL("xx", entity.User{ID: "xx"}, time.Minute)
+			userTable.ReplaceOrStoreWithTTL("yy", entity.User{ID: "yy"}, time.Minute)
+			userTable.ReplaceOrStore("zz", entity.User{ID: "zz"})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		table, _ := inst.GetTable("users")
+
+		// writing again without a ttl makes the row permanent
+		table.ReplaceOrStore("yy", entity.User{ID: "yy"})
+
+		clock.Advance(time.Minute)
+
+		err = inst.Transaction(func(x *db.Transaction) error {
+			userTable, err := x.GetTable("users")
+			if err != nil {
+				return err
+			}
+
+			if _, err := userTable.FindByID("xx"); err != db.ErrNotFound {
+				return errors.New("expired row should be not found inside a transaction")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, id := range []string{"yy", "zz"} {
+			if _, err := table.FindByID(id); err != nil {
+				t.Fatal("row without ttl should never expire", id, err)
+			}
+		}
+
+		if removed, _ := inst.SweepExpired(); removed != 1 {
+			t.Fatal("only the row with ttl should be swept", removed)
+		}
+	}, db.WithClock(clock), db.WithExpiryInterval(0))
+}
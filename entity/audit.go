@@ -0,0 +1,39 @@
+package entity
+
+import "time"
+
+// Reason codes an admin gives for a status change.
+const (
+	ReasonFraudSuspected   = "fraud_suspected"
+	ReasonComplianceReview = "compliance_review"
+	ReasonLegalOrder       = "legal_order"
+	ReasonCustomerRequest  = "customer_request"
+	ReasonResolved         = "resolved"
+)
+
+func ValidReasonCode(code string) bool {
+	switch code {
+	case ReasonFraudSuspected, ReasonComplianceReview, ReasonLegalOrder, ReasonCustomerRequest, ReasonResolved:
+		return true
+	}
+	return false
+}
+
+const (
+	AuditSubjectWallet = "wallet"
+	AuditSubjectUser   = "user"
+)
+
+// AuditLog records a status change made by an admin, From and To are the
+// statuses before and after.
+type AuditLog struct {
+	ID          string    `json:"id"`
+	ActorID     string    `json:"actor_id"`
+	SubjectType string    `json:"subject_type"`
+	SubjectID   string    `json:"subject_id"`
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	ReasonCode  string    `json:"reason_code"`
+	Note        string    `json:"note,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
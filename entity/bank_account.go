@@ -0,0 +1,13 @@
+package entity
+
+import "time"
+
+// BankAccount is a bank account a user withdraws to.
+type BankAccount struct {
+	ID            string    `json:"id"`
+	UserID        string    `json:"user_id"`
+	BankCode      string    `json:"bank_code"`
+	AccountNumber string    `json:"account_number"`
+	HolderName    string    `json:"holder_name"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -0,0 +1,54 @@
+package entity
+
+import "time"
+
+type BatchMode string
+
+const (
+	BatchModeAtomic     BatchMode = "atomic"      // every item or none
+	BatchModeBestEffort BatchMode = "best_effort" // every item on its own
+)
+
+type BatchStatus string
+
+const (
+	BatchStatusPending   BatchStatus = "pending"   // some items wait for a risk review
+	BatchStatusCompleted BatchStatus = "completed" // every item paid
+	BatchStatusPartial   BatchStatus = "partial"   // some items paid
+	BatchStatusFailed    BatchStatus = "failed"    // no item paid
+)
+
+type BatchItemStatus string
+
+const (
+	BatchItemStatusCompleted BatchItemStatus = "completed"
+	BatchItemStatusHeld      BatchItemStatus = "held" // waiting for a risk review
+	BatchItemStatusFailed    BatchItemStatus = "failed"
+)
+
+// Batch pays many recipients out of one wallet in one request. An atomic
+// batch is only stored once every item is paid, a best effort batch keeps
+// the result of every item.
+type Batch struct {
+	ID             string      `json:"id"`
+	UserID         string      `json:"user_id"`
+	Mode           BatchMode   `json:"mode"`
+	Currency       string      `json:"currency"`
+	SourceWalletID string      `json:"source_wallet_id,omitempty"`
+	Items          []BatchItem `json:"items"`
+	Total          int         `json:"total"` // amount of every item
+	Paid           int         `json:"paid"`  // amount of the completed items
+	Status         BatchStatus `json:"status"`
+	CreatedAt      time.Time   `json:"created_at"`
+}
+
+type BatchItem struct {
+	RecipientID   string          `json:"recipient"`
+	Amount        int             `json:"amount"`
+	Reference     string          `json:"reference,omitempty"`
+	Note          string          `json:"note,omitempty"`
+	Status        BatchItemStatus `json:"status,omitempty"`
+	TransactionID string          `json:"transaction_id,omitempty"`
+	ReviewID      string          `json:"review_id,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
@@ -0,0 +1,50 @@
+package entity
+
+import "time"
+
+type BillStatus string
+
+const (
+	BillStatusOpen       BillStatus = "open"
+	BillStatusSettled    BillStatus = "settled"
+	BillStatusIncomplete BillStatus = "incomplete" // a share was declined or expired, nothing is pending anymore
+	BillStatusCancelled  BillStatus = "cancelled"
+)
+
+// Bill splits an amount the organizer paid among participants. Every share
+// but the organizer's own is a payment request to its participant, the
+// share follows the status of its request.
+type Bill struct {
+	ID          string      `json:"id"`
+	OrganizerID string      `json:"organizer_id"`
+	Amount      int         `json:"amount"`
+	Currency    string      `json:"currency"`
+	Note        string      `json:"note,omitempty"`
+	Shares      []BillShare `json:"shares"`
+	Paid        int         `json:"paid"` // sum of the paid shares
+	Status      BillStatus  `json:"status"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+type BillShare struct {
+	UserID           string               `json:"user_id"`
+	Amount           int                  `json:"amount"`
+	Status           PaymentRequestStatus `json:"status"`
+	PaymentRequestID string               `json:"payment_request_id,omitempty"` // empty for the organizer's share
+	TransactionID    string               `json:"transaction_id,omitempty"`
+}
+
+// HasParticipant tells whether the user organizes the bill or has a share
+// in it.
+func (b Bill) HasParticipant(userID string) bool {
+	if b.OrganizerID == userID {
+		return true
+	}
+	for _, share := range b.Shares {
+		if share.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
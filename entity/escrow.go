@@ -0,0 +1,42 @@
+package entity
+
+import "time"
+
+type EscrowStatus string
+
+const (
+	EscrowStatusHeld     EscrowStatus = "held"
+	EscrowStatusDisputed EscrowStatus = "disputed"
+	EscrowStatusReleased EscrowStatus = "released"
+	EscrowStatusRefunded EscrowStatus = "refunded"
+)
+
+// Escrow keeps the buyer's money in the escrow account until it is released
+// to the seller or refunded to the buyer. Held money is released on its own
+// at ReleaseAt, a dispute stops that clock until it is resolved.
+type Escrow struct {
+	ID                      string        `json:"id"`
+	BuyerID                 string        `json:"buyer_id"`
+	SellerID                string        `json:"seller_id"`
+	Amount                  int           `json:"amount"`
+	Currency                string        `json:"currency"`
+	Reference               string        `json:"reference,omitempty"`
+	Note                    string        `json:"note,omitempty"`
+	Status                  EscrowStatus  `json:"status"`
+	FundingTransactionID    string        `json:"funding_transaction_id"`
+	SettlementTransactionID string        `json:"settlement_transaction_id,omitempty"` // the release or the refund
+	ReleaseAt               time.Time     `json:"release_at,omitempty"`                // zero while disputed
+	Remaining               time.Duration `json:"remaining,omitempty"`                 // left on the clock when the dispute was opened
+	DisputedBy              string        `json:"disputed_by,omitempty"`
+	DisputeReason           string        `json:"dispute_reason,omitempty"`
+	ReleaseAttempts         int           `json:"release_attempts,omitempty"` // automatic releases that failed
+	ReleaseError            string        `json:"release_error,omitempty"`    // why the last one failed
+	RetryAt                 time.Time     `json:"retry_at,omitempty"`         // when the release is tried again
+	CreatedAt               time.Time     `json:"created_at"`
+	UpdatedAt               time.Time     `json:"updated_at"`
+}
+
+// IsParty tells whether the user is the buyer or the seller.
+func (e Escrow) IsParty(userID string) bool {
+	return e.BuyerID == userID || e.SellerID == userID
+}
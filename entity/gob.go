@@ -0,0 +1,28 @@
+package entity
+
+import "encoding/gob"
+
+// Rows are stored as interface values, the file backed storage engines
+// need every concrete entity type registered to read them back.
+func init() {
+	gob.Register(User{})
+	gob.Register(UserToken{})
+	gob.Register(Wallet{})
+	gob.Register(Mutation{})
+	gob.Register(Transaction{})
+	gob.Register(IdempotencyKey{})
+	gob.Register(LedgerEntry{})
+	gob.Register(Hold{})
+	gob.Register(RiskReview{})
+	gob.Register(RiskDecision{})
+	gob.Register(AuditLog{})
+	gob.Register(ScheduledTransfer{})
+	gob.Register(ScheduledTransferRun{})
+	gob.Register(PaymentRequest{})
+	gob.Register(Bill{})
+	gob.Register(Escrow{})
+	gob.Register(Batch{})
+	gob.Register(PaymentIntent{})
+	gob.Register(BankAccount{})
+	gob.Register(Withdrawal{})
+}
@@ -0,0 +1,31 @@
+package entity
+
+import "time"
+
+type HoldStatus string
+
+const (
+	HoldStatusActive   HoldStatus = "active"
+	HoldStatusCaptured HoldStatus = "captured"
+	HoldStatusVoided   HoldStatus = "voided"
+	HoldStatusExpired  HoldStatus = "expired"
+)
+
+// Hold reserves money of a wallet for a payee until it is captured, voided
+// or expires. The reserved amount stays in the wallet balance and is
+// counted in Wallet.Held.
+type Hold struct {
+	ID            string     `json:"id"`
+	UserID        string     `json:"user_id"`
+	WalletID      string     `json:"wallet_id"`
+	TargetUserID  string     `json:"target_user_id"` // the payee, the only one who can capture
+	Amount        int        `json:"amount"`
+	Currency      string     `json:"currency"`
+	Status        HoldStatus `json:"status"`
+	Captured      int        `json:"captured"`                 // settled amount, the rest was released
+	TransactionID string     `json:"transaction_id,omitempty"` // set once captured
+	Reference     string     `json:"reference,omitempty"`
+	WithdrawalID  string     `json:"withdrawal_id,omitempty"` // set on the hold of a withdrawal, only its payout settles it
+	ExpiresAt     time.Time  `json:"expires_at"`              // zero never expires
+	CreatedAt     time.Time  `json:"created_at"`
+}
@@ -0,0 +1,20 @@
+package entity
+
+import "time"
+
+// IdempotencyKey remembers a request that already moved money, so a retry
+// with the same key is answered without moving it again.
+type IdempotencyKey struct {
+	ID            string // scoped by user, see IdempotencyKeyID
+	UserID        string
+	Key           string // Idempotency-Key header sent by the client
+	Fingerprint   string // hash of the operation and its payload
+	TransactionID string // the original result, returned again on replay
+	BatchID       string // the original batch, set instead of TransactionID
+	WithdrawalID  string // the original withdrawal, set instead of TransactionID
+	CreatedAt     time.Time
+}
+
+func IdempotencyKeyID(userID, key string) string {
+	return userID + ":" + key
+}
@@ -0,0 +1,42 @@
+package entity
+
+import "time"
+
+// System accounts are the other side of money entering or leaving the
+// wallets, every wallet is an account identified by its wallet ID.
+const (
+	AccountTopUpClearing  = "system:topup_clearing"
+	AccountFeesRevenue    = "system:fees_revenue"
+	AccountPayoutClearing = "system:payout_clearing"
+)
+
+// SystemUserID owns the system wallets, like the fee revenue wallets.
+const SystemUserID = "system"
+
+// FeeRevenueAccount is the wallet collecting the fees of a currency.
+func FeeRevenueAccount(currency string) string {
+	return AccountFeesRevenue + "_" + NormalizeCurrency(currency)
+}
+
+// EscrowAccount is the wallet holding the escrowed money of a currency.
+func EscrowAccount(currency string) string {
+	return "system:escrow_" + NormalizeCurrency(currency)
+}
+
+// FXAccount is the conversion account of a currency. A cross currency
+// transfer moves the source amount into the FX account of its currency and
+// the converted amount out of the FX account of the target currency.
+func FXAccount(currency string) string {
+	return "system:fx_" + NormalizeCurrency(currency)
+}
+
+// LedgerEntry is one leg of a balanced posting. Amount is signed, money
+// coming into the account is positive and money leaving it is negative, so
+// the entries of a posting always sum to zero.
+type LedgerEntry struct {
+	ID            string    `json:"id"`
+	TransactionID string    `json:"transaction_id"`
+	AccountID     string    `json:"account_id"`
+	Amount        int       `json:"amount"`
+	CreatedAt     time.Time `json:"created_at"`
+}
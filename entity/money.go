@@ -0,0 +1,84 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+var ErrMoneyOverflow = errors.New("money overflow")
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+var ErrInvalidCurrency = errors.New("invalid currency")
+
+// DefaultCurrency is the currency of amounts that don't carry one.
+const DefaultCurrency = "IDR"
+
+// Money is an amount in minor units of a currency, so there is no rounding
+// anywhere. Arithmetic never wraps around, it fails with ErrMoneyOverflow.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: NormalizeCurrency(currency)}
+}
+
+// NormalizeCurrency upper cases an ISO 4217 code, empty is the default
+// currency.
+func NormalizeCurrency(currency string) string {
+	if currency == "" {
+		return DefaultCurrency
+	}
+	return strings.ToUpper(currency)
+}
+
+// ValidateCurrency accepts three letter ISO 4217 codes.
+func ValidateCurrency(currency string) error {
+	currency = NormalizeCurrency(currency)
+	if len(currency) != 3 {
+		return fmt.Errorf("%w: %q", ErrInvalidCurrency, currency)
+	}
+	for _, r := range currency {
+		if r < 'A' || r > 'Z' {
+			return fmt.Errorf("%w: %q", ErrInvalidCurrency, currency)
+		}
+	}
+	return nil
+}
+
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return Money{}, err
+	}
+
+	if (other.Amount > 0 && m.Amount > math.MaxInt64-other.Amount) ||
+		(other.Amount < 0 && m.Amount < math.MinInt64-other.Amount) {
+		return Money{}, ErrMoneyOverflow
+	}
+
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	if other.Amount == math.MinInt64 {
+		return Money{}, ErrMoneyOverflow
+	}
+	return m.Add(Money{Amount: -other.Amount, Currency: other.Currency})
+}
+
+func (m Money) String() string {
+	return fmt.Sprintf("%d %s", m.Amount, m.Currency)
+}
+
+func (m Money) sameCurrency(other Money) error {
+	if m.Currency != other.Currency {
+		return fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package entity_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMoney(t *testing.T) {
+	idr := func(amount int64) entity.Money { return entity.NewMoney(amount, "idr") }
+	usd := func(amount int64) entity.Money { return entity.NewMoney(amount, "USD") }
+
+	for _, tc := range []struct {
+		name string
+		op   func() (entity.Money, error)
+		want entity.Money
+		err  error
+	}{
+		{"add", func() (entity.Money, error) { return idr(150).Add(idr(50)) }, idr(200), nil},
+		{"add negative", func() (entity.Money, error) { return idr(150).Add(idr(-200)) }, idr(-50), nil},
+		{"add up to the max", func() (entity.Money, error) { return idr(math.MaxInt64 - 1).Add(idr(1)) }, idr(math.MaxInt64), nil},
+		{"add over the max", func() (entity.Money, error) { return idr(math.MaxInt64).Add(idr(1)) }, entity.Money{}, entity.ErrMoneyOverflow},
+		{"add under the min", func() (entity.Money, error) { return idr(math.MinInt64).Add(idr(-1)) }, entity.Money{}, entity.ErrMoneyOverflow},
+		{"add another currency", func() (entity.Money, error) { return idr(150).Add(usd(50)) }, entity.Money{}, entity.ErrCurrencyMismatch},
+		{"sub", func() (entity.Money, error) { return idr(150).Sub(idr(50)) }, idr(100), nil},
+		{"sub below zero", func() (entity.Money, error) { return idr(50).Sub(idr(150)) }, idr(-100), nil},
+		{"sub under the min", func() (entity.Money, error) { return idr(math.MinInt64).Sub(idr(1)) }, entity.Money{}, entity.ErrMoneyOverflow},
+		{"sub over the max", func() (entity.Money, error) { return idr(math.MaxInt64).Sub(idr(-1)) }, entity.Money{}, entity.ErrMoneyOverflow},
+		{"sub the min", func() (entity.Money, error) { return idr(0).Sub(idr(math.MinInt64)) }, entity.Money{}, entity.ErrMoneyOverflow},
+		{"sub another currency", func() (entity.Money, error) { return usd(150).Sub(idr(50)) }, entity.Money{}, entity.ErrCurrencyMismatch},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.op()
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -1,14 +1,21 @@
 package entity
 
+import "time"
+
 type MutationType int
 
 const MutationTypeDebit MutationType = 0
 const MutationTypeCredit MutationType = 1
 
 type Mutation struct {
-	ID       string
-	WalletID string       // relation to wallet
-	UserID   string       // denormalize mutation data with userID
-	Type     MutationType // 0 credit 1 debit
-	Amount   int          // amount of money
+	ID                   string
+	TransactionID        string       // relation to transaction
+	WalletID             string       // relation to wallet
+	UserID               string       // denormalize mutation data with userID
+	CounterpartyWalletID string       // the other side of a transfer, empty for top up
+	CounterpartyUserID   string       // denormalize counterparty wallet with userID
+	Type                 MutationType // 0 credit 1 debit
+	Amount               int          // amount of money
+	Fee                  int          // fee paid by this wallet on top of the amount
+	CreatedAt            time.Time
 }
@@ -0,0 +1,36 @@
+package entity
+
+import "time"
+
+type PaymentIntentStatus string
+
+const (
+	PaymentIntentStatusPending   PaymentIntentStatus = "pending"
+	PaymentIntentStatusPaid      PaymentIntentStatus = "paid"
+	PaymentIntentStatusCancelled PaymentIntentStatus = "cancelled"
+	PaymentIntentStatusExpired   PaymentIntentStatus = "expired"
+)
+
+// PaymentIntent is a checkout of a merchant, any customer can pay it once
+// before it expires. Reference is the merchant's own order id, unique per
+// merchant.
+type PaymentIntent struct {
+	ID            string              `json:"id"`
+	MerchantID    string              `json:"merchant_id"`
+	Amount        int                 `json:"amount"`
+	Currency      string              `json:"currency"`
+	Reference     string              `json:"reference,omitempty"`
+	Description   string              `json:"description,omitempty"`
+	Status        PaymentIntentStatus `json:"status"`
+	CustomerID    string              `json:"customer_id,omitempty"`    // set once paid
+	TransactionID string              `json:"transaction_id,omitempty"` // set once paid
+	ExpiresAt     time.Time           `json:"expires_at"`
+	PaidAt        time.Time           `json:"paid_at,omitempty"`
+	CreatedAt     time.Time           `json:"created_at"`
+	UpdatedAt     time.Time           `json:"updated_at"`
+
+	CallbackDeliveredAt time.Time `json:"callback_delivered_at,omitempty"`
+	CallbackError       string    `json:"callback_error,omitempty"` // of the last failed delivery
+	CallbackAttempts    int       `json:"callback_attempts,omitempty"`
+	NextCallbackAt      time.Time `json:"next_callback_at,omitempty"` // set while the callback is queued
+}
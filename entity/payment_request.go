@@ -0,0 +1,32 @@
+package entity
+
+import "time"
+
+type PaymentRequestStatus string
+
+const (
+	PaymentRequestStatusPending   PaymentRequestStatus = "pending"
+	PaymentRequestStatusPaid      PaymentRequestStatus = "paid"
+	PaymentRequestStatusDeclined  PaymentRequestStatus = "declined"
+	PaymentRequestStatusCancelled PaymentRequestStatus = "cancelled"
+	PaymentRequestStatusExpired   PaymentRequestStatus = "expired"
+)
+
+// PaymentRequest asks the payer to send money to the requester. Nothing is
+// moved or reserved until the payer accepts it.
+type PaymentRequest struct {
+	ID            string               `json:"id"`
+	RequesterID   string               `json:"requester_id"` // gets the money
+	PayerID       string               `json:"payer_id"`
+	Amount        int                  `json:"amount"`
+	Currency      string               `json:"currency"`
+	Note          string               `json:"note,omitempty"`
+	Reference     string               `json:"reference,omitempty"`
+	Status        PaymentRequestStatus `json:"status"`
+	TransactionID string               `json:"transaction_id,omitempty"` // set once paid
+	BillID        string               `json:"bill_id,omitempty"`        // the bill the request is a share of
+	DeclineReason string               `json:"decline_reason,omitempty"`
+	ExpiresAt     time.Time            `json:"expires_at"`
+	CreatedAt     time.Time            `json:"created_at"`
+	UpdatedAt     time.Time            `json:"updated_at"`
+}
@@ -0,0 +1,66 @@
+package entity
+
+import "time"
+
+type RiskAction string
+
+const (
+	RiskActionAllow  RiskAction = "allow"
+	RiskActionReview RiskAction = "review"
+	RiskActionBlock  RiskAction = "block"
+)
+
+type RiskReviewStatus string
+
+const (
+	RiskReviewStatusPending  RiskReviewStatus = "pending"
+	RiskReviewStatusApproved RiskReviewStatus = "approved"
+	RiskReviewStatusRejected RiskReviewStatus = "rejected"
+)
+
+// RiskReview is a transfer held back by the risk rules until an admin
+// approves or rejects it. It keeps what is needed to make the transfer on
+// approval, no money is moved or reserved before that.
+type RiskReview struct {
+	ID               string           `json:"id"`
+	UserID           string           `json:"user_id"`
+	TargetUserID     string           `json:"target_user_id"`
+	Amount           int              `json:"amount"`
+	Currency         string           `json:"currency"`
+	TargetCurrency   string           `json:"target_currency,omitempty"`
+	SourceWalletID   string           `json:"source_wallet_id,omitempty"`
+	PaymentRequestID string           `json:"payment_request_id,omitempty"` // paid once approved
+	PaymentIntentID  string           `json:"payment_intent_id,omitempty"`  // paid once approved
+	Reference        string           `json:"reference,omitempty"`
+	Note             string           `json:"note,omitempty"`
+	Score            int              `json:"score"`
+	Reasons          []string         `json:"reasons"`
+	Status           RiskReviewStatus `json:"status"`
+	DecidedBy        string           `json:"decided_by,omitempty"`
+	DecisionReason   string           `json:"decision_reason,omitempty"`
+	TransactionID    string           `json:"transaction_id,omitempty"`   // set once approved
+	BatchID          string           `json:"batch_id,omitempty"`         // of a best effort batch, its item is updated once decided
+	BatchItem        int              `json:"batch_item,omitempty"`       // index of the item in the batch
+	ScheduledRunID   string           `json:"scheduled_run_id,omitempty"` // of a scheduled transfer, the run is updated once decided
+	IdempotencyKey   string           `json:"idempotency_key,omitempty"`  // of the held request, its retries get this review
+	Fingerprint      string           `json:"-"`                          // of the held request's payload
+	CreatedAt        time.Time        `json:"created_at"`
+	DecidedAt        time.Time        `json:"decided_at,omitempty"`
+}
+
+// RiskDecision records every outcome of the risk rules and every admin
+// decision on a review. DecidedBy is empty when the rules decided.
+type RiskDecision struct {
+	ID            string     `json:"id"`
+	UserID        string     `json:"user_id"`
+	TargetUserID  string     `json:"target_user_id"`
+	Amount        int        `json:"amount"`
+	Currency      string     `json:"currency"`
+	Score         int        `json:"score"`
+	Reasons       []string   `json:"reasons"`
+	Action        RiskAction `json:"action"`
+	ReviewID      string     `json:"review_id,omitempty"`
+	TransactionID string     `json:"transaction_id,omitempty"`
+	DecidedBy     string     `json:"decided_by,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
@@ -0,0 +1,65 @@
+package entity
+
+import "time"
+
+type ScheduledTransferStatus string
+
+const (
+	ScheduledTransferStatusActive    ScheduledTransferStatus = "active"
+	ScheduledTransferStatusCompleted ScheduledTransferStatus = "completed"
+	ScheduledTransferStatusCancelled ScheduledTransferStatus = "cancelled"
+)
+
+// What a scheduled transfer does when the wallet can't pay a run.
+const (
+	OnInsufficientFundsSkip  = "skip"
+	OnInsufficientFundsRetry = "retry"
+)
+
+// ScheduledTransfer pays the target once at DueAt, or on every time of Cron
+// until EndAt. DueAt is the run being paid, NextRunAt is when it is tried
+// next, later than DueAt while retrying.
+type ScheduledTransfer struct {
+	ID                  string                  `json:"id"`
+	UserID              string                  `json:"user_id"`
+	TargetUserID        string                  `json:"target_user_id"`
+	Amount              int                     `json:"amount"`
+	Currency            string                  `json:"currency"`
+	SourceWalletID      string                  `json:"source_wallet_id,omitempty"`
+	Reference           string                  `json:"reference,omitempty"`
+	Note                string                  `json:"note,omitempty"`
+	Cron                string                  `json:"cron,omitempty"` // empty runs once
+	EndAt               time.Time               `json:"end_at,omitempty"`
+	OnInsufficientFunds string                  `json:"on_insufficient_funds"`
+	MaxRetries          int                     `json:"max_retries"`
+	Status              ScheduledTransferStatus `json:"status"`
+	DueAt               time.Time               `json:"due_at"`
+	NextRunAt           time.Time               `json:"next_run_at,omitempty"`
+	Attempts            int                     `json:"attempts"` // failed tries of DueAt
+	CreatedAt           time.Time               `json:"created_at"`
+	UpdatedAt           time.Time               `json:"updated_at"`
+}
+
+type ScheduledTransferRunStatus string
+
+const (
+	ScheduledTransferRunSucceeded ScheduledTransferRunStatus = "succeeded"
+	ScheduledTransferRunHeld      ScheduledTransferRunStatus = "held"     // waiting for a risk review, decided by it
+	ScheduledTransferRunRetrying  ScheduledTransferRunStatus = "retrying" // failed, tried again later
+	ScheduledTransferRunSkipped   ScheduledTransferRunStatus = "skipped"  // not enough money, given up
+	ScheduledTransferRunFailed    ScheduledTransferRunStatus = "failed"   // any other error, given up
+)
+
+// ScheduledTransferRun is one try of a scheduled transfer.
+type ScheduledTransferRun struct {
+	ID                  string                     `json:"id"`
+	ScheduledTransferID string                     `json:"scheduled_transfer_id"`
+	UserID              string                     `json:"user_id"`
+	DueAt               time.Time                  `json:"due_at"`
+	RanAt               time.Time                  `json:"ran_at"`
+	Attempt             int                        `json:"attempt"`
+	Status              ScheduledTransferRunStatus `json:"status"`
+	TransactionID       string                     `json:"transaction_id,omitempty"`
+	ReviewID            string                     `json:"review_id,omitempty"`
+	Error               string                     `json:"error,omitempty"`
+}
@@ -1,6 +1,52 @@
 package entity
 
+import "time"
+
+type TransactionType string
+
+const (
+	TransactionTypeTopUp      TransactionType = "topup"
+	TransactionTypeTransfer   TransactionType = "transfer"
+	TransactionTypeMove       TransactionType = "move" // between pockets of the same user
+	TransactionTypeCapture    TransactionType = "capture"
+	TransactionTypeRefund     TransactionType = "refund"
+	TransactionTypeReversal   TransactionType = "reversal"
+	TransactionTypePayment    TransactionType = "payment"    // a customer paying a merchant's payment intent
+	TransactionTypeWithdrawal TransactionType = "withdrawal" // out to a bank account
+
+	TransactionTypeEscrow        TransactionType = "escrow" // into the escrow account
+	TransactionTypeEscrowRelease TransactionType = "escrow_release"
+	TransactionTypeEscrowRefund  TransactionType = "escrow_refund"
+)
+
+type TransactionStatus string
+
+const (
+	TransactionStatusCompleted TransactionStatus = "completed"
+	TransactionStatusRefunded  TransactionStatus = "refunded" // refunded in full, possibly in parts
+	TransactionStatusReversed  TransactionStatus = "reversed"
+)
+
+// Transaction is a single money movement, every mutation it produces
+// points back to it.
 type Transaction struct {
-	Type   int // 0 debit 1 credit
-	Amount int // amount of money
+	ID                    string            `json:"id"`
+	Type                  TransactionType   `json:"type"`
+	Status                TransactionStatus `json:"status"`
+	SourceUserID          string            `json:"source_user_id,omitempty"` // empty for money coming from outside
+	SourceWalletID        string            `json:"source_wallet_id,omitempty"`
+	TargetUserID          string            `json:"target_user_id"`
+	TargetWalletID        string            `json:"target_wallet_id"`
+	Amount                int               `json:"amount"`
+	Currency              string            `json:"currency"`
+	TargetAmount          int               `json:"target_amount"` // what the target wallet received
+	TargetCurrency        string            `json:"target_currency"`
+	Rate                  string            `json:"rate,omitempty"`      // conversion rate, only for cross currency transfers
+	Spread                string            `json:"spread,omitempty"`    // cut taken from the converted amount
+	Reference             string            `json:"reference,omitempty"` // set by the client
+	Note                  string            `json:"note,omitempty"`
+	Fee                   int               `json:"fee,omitempty"`                     // paid on top of Amount, or out of it for top ups
+	Refunded              int               `json:"refunded,omitempty"`                // part of Amount given back so far
+	OriginalTransactionID string            `json:"original_transaction_id,omitempty"` // set on refunds and reversals
+	CreatedAt             time.Time         `json:"created_at"`
 }
@@ -1,9 +1,47 @@
 package entity
 
+import "time"
+
+const UserRoleAdmin = "admin"
+
+// UserTypeMerchant is an account that takes payments through payment
+// intents instead of a personal one.
+const UserTypeMerchant = "merchant"
+
+type UserStatus string
+
+const (
+	UserStatusActive UserStatus = "active"
+	UserStatusClosed UserStatus = "closed"
+)
+
 type User struct {
 	ID       string `json:"id"`
 	Password string `json:"password"`
 	Email    string `json:"email"`
+	Role     string `json:"role,omitempty"` // empty for regular users
+	Tier     string `json:"tier,omitempty"` // limit tier, see aggregation.LimitPolicy
+	Type     string `json:"type,omitempty"` // empty for personal users
+
+	MerchantName string `json:"merchant_name,omitempty"`
+	CallbackURL  string `json:"callback_url,omitempty"` // told about paid payment intents
+
+	PasswordChangedAt time.Time `json:"password_changed_at,omitempty"`
+
+	Status   UserStatus `json:"status,omitempty"` // empty is active
+	ClosedAt time.Time  `json:"closed_at,omitempty"`
+}
+
+func (u User) IsAdmin() bool {
+	return u.Role == UserRoleAdmin
+}
+
+func (u User) IsMerchant() bool {
+	return u.Type == UserTypeMerchant
+}
+
+func (u User) IsClosed() bool {
+	return u.Status == UserStatusClosed
 }
 
 type UserToken struct {
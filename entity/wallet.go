@@ -1,7 +1,52 @@
 package entity
 
+// PrimaryWalletName is the pocket money lands in when no pocket is picked,
+// every currency has one.
+const PrimaryWalletName = "main"
+
+type WalletStatus string
+
+const (
+	WalletStatusActive    WalletStatus = "active"
+	WalletStatusFrozen    WalletStatus = "frozen"    // incoming only
+	WalletStatusSuspended WalletStatus = "suspended" // nothing in or out
+	WalletStatusClosed    WalletStatus = "closed"    // with the account, for good
+)
+
 type Wallet struct {
-	ID      string
-	UserID  string
-	Balance int
+	ID       string `json:"id"`
+	UserID   string `json:"user_id"`
+	Name     string `json:"name"` // pocket name, empty for wallets created before pockets
+	Balance  int    `json:"balance"`
+	Held     int    `json:"held"`     // reserved by holds, see Available
+	Currency string `json:"currency"` // ISO 4217, empty for wallets created before currencies
+
+	Status WalletStatus `json:"status,omitempty"` // empty is active
+}
+
+// InCurrency tells if the wallet holds the given currency.
+func (w Wallet) InCurrency(currency string) bool {
+	return NormalizeCurrency(w.Currency) == NormalizeCurrency(currency)
+}
+
+// IsPrimary tells if the wallet is the main pocket of its currency.
+func (w Wallet) IsPrimary() bool {
+	return w.Name == "" || w.Name == PrimaryWalletName
+}
+
+// Available is what the wallet can spend, held money still counts in the
+// balance until it is captured or released.
+func (w Wallet) Available() int {
+	return w.Balance - w.Held
+}
+
+// CanSend tells if money can leave the wallet.
+func (w Wallet) CanSend() bool {
+	return w.Status == "" || w.Status == WalletStatusActive
+}
+
+// CanReceive tells if money can land in the wallet, frozen wallets still
+// receive.
+func (w Wallet) CanReceive() bool {
+	return w.CanSend() || w.Status == WalletStatusFrozen
 }
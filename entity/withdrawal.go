@@ -0,0 +1,35 @@
+package entity
+
+import "time"
+
+type WithdrawalStatus string
+
+const (
+	WithdrawalStatusPending WithdrawalStatus = "pending" // held, waiting for the payout provider
+	WithdrawalStatusSettled WithdrawalStatus = "settled"
+	WithdrawalStatusFailed  WithdrawalStatus = "failed" // the hold was released
+)
+
+// Withdrawal sends money of a wallet to a bank account. The amount and the
+// fee are held until the payout provider reports the outcome, a settled
+// withdrawal takes them out of the wallet and a failed one releases them.
+type Withdrawal struct {
+	ID                string           `json:"id"`
+	UserID            string           `json:"user_id"`
+	WalletID          string           `json:"wallet_id"`
+	BankAccountID     string           `json:"bank_account_id"`
+	Amount            int              `json:"amount"`
+	Fee               int              `json:"fee,omitempty"` // held and paid on top of Amount
+	Currency          string           `json:"currency"`
+	Status            WithdrawalStatus `json:"status"`
+	HoldID            string           `json:"hold_id"`
+	ProviderReference string           `json:"provider_reference,omitempty"` // set once the provider accepted the payout
+	TransactionID     string           `json:"transaction_id,omitempty"`     // set once settled
+	FailureReason     string           `json:"failure_reason,omitempty"`
+	SubmitAttempts    int              `json:"submit_attempts,omitempty"` // times the payout was handed to the provider
+	SubmitError       string           `json:"submit_error,omitempty"`    // why the last attempt wasn't accepted
+	NextSubmitAt      time.Time        `json:"next_submit_at,omitempty"`  // until then the last attempt is in flight
+	Reference         string           `json:"reference,omitempty"`
+	CreatedAt         time.Time        `json:"created_at"`
+	UpdatedAt         time.Time        `json:"updated_at"`
+}
@@ -7,6 +7,8 @@ require (
 	github.com/kodefluence/aurelia v0.0.0-20220717092613-4dd6082f36c5
 	github.com/labstack/echo/v4 v4.12.0
 	github.com/stretchr/testify v1.8.4
+	go.etcd.io/bbolt v1.3.10
+	golang.org/x/time v0.5.0
 )
 
 require (
@@ -23,6 +25,5 @@ require (
 	golang.org/x/net v0.24.0 // indirect
 	golang.org/x/sys v0.19.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
-	golang.org/x/time v0.5.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+)
+
+type WalletStatusRequest struct {
+	Status     entity.WalletStatus `json:"status"`
+	ReasonCode string              `json:"reason_code"`
+	Note       string              `json:"note"`
+}
+
+type CloseAccountRequest struct {
+	ReasonCode string `json:"reason_code"`
+	Note       string `json:"note"`
+}
+
+// SetWalletStatus freezes, suspends or reactivates a wallet.
+func SetWalletStatus(accountAggregator *aggregation.Account) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		adminID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody WalletStatusRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		wallet, err := accountAggregator.SetWalletStatus(adminID, c.Param("id"), jsonBody.Status, jsonBody.ReasonCode, jsonBody.Note)
+		if err != nil {
+			if errors.Is(err, aggregation.ErrInvalidWalletStatus) {
+				return renderFieldError(c, "status", err.Error())
+			}
+			if errors.Is(err, aggregation.ErrWalletNotFound) {
+				return c.JSON(http.StatusNotFound, H{
+					"errors": []H{
+						{
+							"detail": "wallet not found",
+						},
+					},
+				})
+			}
+			return renderAccountError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": wallet})
+	}
+}
+
+// CloseAccount closes the account of a user with empty wallets.
+func CloseAccount(accountAggregator *aggregation.Account) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		adminID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody CloseAccountRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		user, err := accountAggregator.Close(adminID, c.Param("id"), jsonBody.ReasonCode, jsonBody.Note)
+		if err != nil {
+			if errors.Is(err, aggregation.ErrUserNotFound) {
+				return c.JSON(http.StatusNotFound, H{
+					"errors": []H{
+						{
+							"detail": "user not found",
+						},
+					},
+				})
+			}
+			if errors.Is(err, aggregation.ErrBalanceNotZero) {
+				return c.JSON(http.StatusConflict, H{
+					"errors": []H{
+						{
+							"detail": err.Error(),
+						},
+					},
+				})
+			}
+			return renderAccountError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": H{
+			"id":        user.ID,
+			"email":     user.Email,
+			"status":    user.Status,
+			"closed_at": user.ClosedAt,
+		}})
+	}
+}
+
+// AuditLogs lists the status changes of a wallet or user.
+func AuditLogs(auditLogRepo *repository.AuditLog) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if c.QueryParam("subject_id") == "" {
+			return renderFieldError(c, "subject_id", "subject_id is required")
+		}
+
+		logs, err := auditLogRepo.GetBySubjectID(c.QueryParam("subject_id"))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, H{"data": logs})
+	}
+}
+
+func renderAccountError(c echo.Context, err error) error {
+	if errors.Is(err, aggregation.ErrInvalidReasonCode) {
+		return renderFieldError(c, "reason_code", err.Error())
+	}
+	if errors.Is(err, aggregation.ErrWalletClosed) || errors.Is(err, aggregation.ErrAccountClosed) {
+		return c.JSON(http.StatusConflict, H{
+			"errors": []H{
+				{
+					"detail": err.Error(),
+				},
+			},
+		})
+	}
+	if errors.Is(err, db.ErrOverloaded) {
+		return renderOverloaded(c)
+	}
+	return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+}
@@ -0,0 +1,98 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/handler"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountStatus(t *testing.T) {
+	e, trxAggregator, user1, user2, dbInstance := setupTest()
+
+	accountAggregator := aggregation.NewAccount(
+		repository.NewWallet(dbInstance),
+		repository.NewUser(dbInstance),
+		repository.NewUserToken(dbInstance),
+		dbInstance,
+	)
+
+	setStatus := func(walletID string, body map[string]string) *httptest.ResponseRecorder {
+		payloadBytes, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/admin/wallets/"+walletID+"/status", bytes.NewReader(payloadBytes))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(walletID)
+		c.Set("current_user", entity.UserToken{
+			UserID: "admin-id",
+		})
+		assert.NoError(t, handler.SetWalletStatus(accountAggregator)(c))
+		return rec
+	}
+
+	closeAccount := func(userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/users/"+userID+"/close", bytes.NewReader([]byte(`{"reason_code": "customer_request"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(userID)
+		c.Set("current_user", entity.UserToken{
+			UserID: "admin-id",
+		})
+		assert.NoError(t, handler.CloseAccount(accountAggregator)(c))
+		return rec
+	}
+
+	transfer := func() *httptest.ResponseRecorder {
+		payloadBytes, _ := json.Marshal(map[string]any{"amount": 10, "to": user2.ID})
+		req := httptest.NewRequest(http.MethodPost, "/transactions/transfer", bytes.NewReader(payloadBytes))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+
+		c := e.NewContext(req, rec)
+		c.Set("current_user", entity.UserToken{
+			UserID: user1.ID,
+		})
+		assert.NoError(t, handler.Transfer(trxAggregator)(c))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnprocessableEntity, setStatus("wallet-id-1", map[string]string{"status": "frozen", "reason_code": "because"}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, setStatus("wallet-id-1", map[string]string{"status": "gone", "reason_code": "fraud_suspected"}).Code)
+	assert.Equal(t, http.StatusNotFound, setStatus("unknown", map[string]string{"status": "frozen", "reason_code": "fraud_suspected"}).Code)
+	assert.Equal(t, http.StatusOK, setStatus("wallet-id-1", map[string]string{"status": "frozen", "reason_code": "fraud_suspected"}).Code)
+
+	rec := transfer()
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "wallet is frozen")
+
+	assert.Equal(t, http.StatusOK, setStatus("wallet-id-1", map[string]string{"status": "active", "reason_code": "resolved"}).Code)
+	assert.Equal(t, http.StatusOK, transfer().Code)
+
+	// user2 still holds money
+	assert.Equal(t, http.StatusConflict, closeAccount(user2.ID).Code)
+	assert.Equal(t, http.StatusNotFound, closeAccount("unknown").Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/audit-logs?subject_id=wallet-id-1", nil)
+	rec = httptest.NewRecorder()
+	assert.NoError(t, handler.AuditLogs(repository.NewAuditLog(dbInstance))(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var logs struct {
+		Data []entity.AuditLog `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &logs))
+	assert.Len(t, logs.Data, 2)
+}
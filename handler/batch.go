@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+)
+
+type BatchRequest struct {
+	Mode       string           `json:"mode"` // atomic, the default, or best_effort
+	Currency   string           `json:"currency"`
+	FromWallet string           `json:"from_wallet"`
+	Items      []BatchItemInput `json:"items"`
+}
+
+type BatchItemInput struct {
+	Recipient string `json:"recipient"`
+	Amount    int    `json:"amount"`
+	Reference string `json:"reference"`
+	Note      string `json:"note"`
+}
+
+func BatchTransfer(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody BatchRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		items := make([]entity.BatchItem, 0, len(jsonBody.Items))
+		for _, item := range jsonBody.Items {
+			items = append(items, entity.BatchItem{
+				RecipientID: item.Recipient,
+				Amount:      item.Amount,
+				Reference:   item.Reference,
+				Note:        item.Note,
+			})
+		}
+
+		opts := append(transactionOptions(c),
+			aggregation.WithCurrency(jsonBody.Currency),
+			aggregation.WithSourceWallet(jsonBody.FromWallet),
+		)
+
+		batch, err := transactionAggregator.BatchTransfer(userID, entity.BatchMode(jsonBody.Mode), items, opts...)
+		if err != nil {
+			return renderBatchError(c, err)
+		}
+
+		return c.JSON(http.StatusCreated, H{"data": batch})
+	}
+}
+
+func GetBatch(batchRepo *repository.Batch) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		// only the user who paid it out can see it
+		batch, err := batchRepo.FindById(c.Param("id"))
+		if err == db.ErrNotFound || (err == nil && batch.UserID != userID) {
+			err = aggregation.ErrBatchNotFound
+		}
+		if err != nil {
+			return renderBatchError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": batch})
+	}
+}
+
+func renderBatchError(c echo.Context, err error) error {
+	if errors.Is(err, aggregation.ErrBatchNotFound) {
+		return c.JSON(http.StatusNotFound, H{
+			"errors": []H{
+				{
+					"detail": "batch not found",
+				},
+			},
+		})
+	}
+	if errors.Is(err, aggregation.ErrInvalidBatchMode) {
+		return renderFieldError(c, "mode", err.Error())
+	}
+	if errors.Is(err, aggregation.ErrEmptyBatch) || errors.Is(err, aggregation.ErrBatchTooLarge) {
+		return renderFieldError(c, "items", err.Error())
+	}
+
+	var itemErr *aggregation.BatchItemError
+	if errors.As(err, &itemErr) && itemErr.Field != "" {
+		return renderFieldError(c, fmt.Sprintf("items[%d].%s", itemErr.Index, itemErr.Field), itemErr.Err.Error())
+	}
+	// an atomic batch failed on this item, nothing was paid
+	if errors.As(err, &itemErr) {
+		return c.JSON(http.StatusUnprocessableEntity, H{
+			"errors": []H{
+				{
+					"item":   itemErr.Index,
+					"detail": itemErr.Err.Error(),
+				},
+			},
+		})
+	}
+
+	return renderTransferError(c, err)
+}
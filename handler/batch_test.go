@@ -0,0 +1,89 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/handler"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchHandlers(t *testing.T) {
+	e, trxAggregator, user1, user2, dbInstance := setupTest()
+	batchRepo := repository.NewBatch(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+
+	user3 := entity.User{ID: uuid.New().String(), Email: "user3@example.com"}
+	repository.NewUser(dbInstance).Put(user3)
+	walletRepo.Put(entity.Wallet{ID: uuid.New().String(), UserID: user3.ID})
+
+	call := func(h echo.HandlerFunc, userID, id, idempotencyKey string, payload any) *httptest.ResponseRecorder {
+		payloadBytes, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "/transactions/batch", bytes.NewReader(payloadBytes))
+		req.Header.Set("Content-Type", "application/json")
+		if idempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+		rec := httptest.NewRecorder()
+
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		c.Set("current_user", entity.UserToken{
+			UserID: userID,
+		})
+		assert.NoError(t, h(c))
+		return rec
+	}
+
+	batchTransfer := handler.BatchTransfer(trxAggregator)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(batchTransfer, user1.ID, "", "", map[string]any{"items": []any{}}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(batchTransfer, user1.ID, "", "", map[string]any{"mode": "maybe", "items": []map[string]any{{"recipient": user2.ID, "amount": 10}}}).Code)
+
+	rec := call(batchTransfer, user1.ID, "", "", map[string]any{"items": []map[string]any{{"recipient": user2.ID, "amount": 10}, {"recipient": "nobody", "amount": 10}}})
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "items[1].recipient")
+
+	// the second item fails the whole atomic batch
+	rec = call(batchTransfer, user1.ID, "", "", map[string]any{"items": []map[string]any{{"recipient": user2.ID, "amount": 10}, {"recipient": user3.ID, "amount": 95}}})
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"item":1`)
+
+	payload := map[string]any{"mode": "best_effort", "items": []map[string]any{
+		{"recipient": user2.ID, "amount": 30, "reference": "payout-1"},
+		{"recipient": user3.ID, "amount": 95},
+		{"recipient": user3.ID, "amount": 20},
+	}}
+	rec = call(batchTransfer, user1.ID, "", "payout", payload)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	var created map[string]entity.Batch
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	batch := created["data"]
+	assert.Equal(t, entity.BatchStatusPartial, batch.Status)
+	assert.Equal(t, entity.BatchItemStatusFailed, batch.Items[1].Status)
+
+	// the retry gets the same batch
+	rec = call(batchTransfer, user1.ID, "", "payout", payload)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	assert.Equal(t, batch.ID, created["data"].ID)
+
+	wallet, _ := walletRepo.FindByUserID(user1.ID)
+	assert.Equal(t, 50, wallet.Balance)
+
+	assert.Equal(t, http.StatusNotFound, call(handler.GetBatch(batchRepo), user2.ID, batch.ID, "", nil).Code)
+
+	rec = call(handler.GetBatch(batchRepo), user1.ID, batch.ID, "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var stored map[string]entity.Batch
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&stored))
+	assert.Equal(t, 50, stored["data"].Paid)
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+)
+
+// BillRequest splits the amount equally among participants, or by the
+// custom shares when they are given.
+type BillRequest struct {
+	Amount       int              `json:"amount"`
+	Currency     string           `json:"currency"`
+	Note         string           `json:"note"`
+	Participants []string         `json:"participants"`
+	Shares       []BillShareInput `json:"shares"`
+	ExpiresIn    int              `json:"expires_in"` // seconds
+}
+
+type BillShareInput struct {
+	UserID string `json:"user_id"`
+	Amount int    `json:"amount"`
+}
+
+type PayBillRequest struct {
+	FromWallet string `json:"from_wallet"` // pocket to pay from, the main wallet when empty
+}
+
+func CreateBill(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody BillRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		if len(jsonBody.Participants) > 0 && len(jsonBody.Shares) > 0 {
+			return renderFieldError(c, "shares", "either participants or shares, not both")
+		}
+
+		shares := aggregation.SplitEqually(jsonBody.Amount, jsonBody.Participants)
+		for _, share := range jsonBody.Shares {
+			shares = append(shares, entity.BillShare{UserID: share.UserID, Amount: share.Amount})
+		}
+
+		expiry := DefaultPaymentRequestExpiry
+		if jsonBody.ExpiresIn > 0 {
+			expiry = time.Duration(jsonBody.ExpiresIn) * time.Second
+		}
+
+		bill, err := transactionAggregator.CreateBill(userID, jsonBody.Amount, shares, expiry,
+			aggregation.WithCurrency(jsonBody.Currency),
+			aggregation.WithNote(jsonBody.Note),
+		)
+		if err != nil {
+			if errors.Is(err, aggregation.ErrInvalidShares) || errors.Is(err, aggregation.ErrUserNotFound) {
+				return renderFieldError(c, "shares", err.Error())
+			}
+			return renderBillError(c, err)
+		}
+
+		return c.JSON(http.StatusCreated, H{"data": bill})
+	}
+}
+
+// ListBills lists the bills the user organizes or has a share in.
+func ListBills(billRepo *repository.Bill) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		bills, err := billRepo.GetByUserID(userID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, H{"data": bills})
+	}
+}
+
+func GetBill(billRepo *repository.Bill) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		// only the organizer and the participants can see it
+		bill, err := billRepo.FindById(c.Param("id"))
+		if err == db.ErrNotFound || (err == nil && !bill.HasParticipant(userID)) {
+			err = aggregation.ErrBillNotFound
+		}
+		if err != nil {
+			return renderBillError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": bill})
+	}
+}
+
+func PayBill(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody PayBillRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		trx, err := transactionAggregator.PayBillShare(userID, c.Param("id"), aggregation.WithSourceWallet(jsonBody.FromWallet))
+		if err != nil {
+			if errors.Is(err, aggregation.ErrBillNotFound) || errors.Is(err, aggregation.ErrShareNotFound) {
+				return renderBillError(c, err)
+			}
+			if errors.Is(err, aggregation.ErrPaymentRequestNotPending) {
+				return renderPaymentRequestError(c, err)
+			}
+			return renderTransferError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"message": "Transfer successful", "data": trx})
+	}
+}
+
+func CancelBill(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		bill, err := transactionAggregator.CancelBill(userID, c.Param("id"))
+		if err != nil {
+			return renderBillError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": bill})
+	}
+}
+
+func renderBillError(c echo.Context, err error) error {
+	if errors.Is(err, aggregation.ErrBillNotFound) {
+		return c.JSON(http.StatusNotFound, H{
+			"errors": []H{
+				{
+					"detail": "bill not found",
+				},
+			},
+		})
+	}
+	if errors.Is(err, aggregation.ErrBillNotOpen) || errors.Is(err, aggregation.ErrShareNotFound) {
+		return c.JSON(http.StatusConflict, H{
+			"errors": []H{
+				{
+					"detail": err.Error(),
+				},
+			},
+		})
+	}
+	return renderPaymentRequestError(c, err)
+}
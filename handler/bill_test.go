@@ -0,0 +1,64 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/handler"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBillHandlers(t *testing.T) {
+	e, trxAggregator, user1, user2, dbInstance := setupTest()
+	billRepo := repository.NewBill(dbInstance)
+
+	call := func(h echo.HandlerFunc, userID, id string, payload any) *httptest.ResponseRecorder {
+		payloadBytes, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "/bills", bytes.NewReader(payloadBytes))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		c.Set("current_user", entity.UserToken{
+			UserID: userID,
+		})
+		assert.NoError(t, h(c))
+		return rec
+	}
+
+	create := handler.CreateBill(trxAggregator)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(create, user2.ID, "", map[string]any{"amount": 60, "shares": []map[string]any{{"user_id": user1.ID, "amount": 50}}}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(create, user2.ID, "", map[string]any{"amount": 60, "participants": []string{user1.ID}, "shares": []map[string]any{{"user_id": user1.ID, "amount": 60}}}).Code)
+
+	rec := call(create, user2.ID, "", map[string]any{"amount": 60, "participants": []string{user1.ID, user2.ID}, "note": "groceries"})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	var created map[string]entity.Bill
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	id := created["data"].ID
+	assert.Equal(t, 30, created["data"].Paid)
+
+	assert.Equal(t, http.StatusNotFound, call(handler.GetBill(billRepo), "stranger", id, nil).Code)
+	assert.Equal(t, http.StatusConflict, call(handler.PayBill(trxAggregator), user2.ID, id, map[string]any{}).Code)
+	assert.Equal(t, http.StatusOK, call(handler.PayBill(trxAggregator), user1.ID, id, map[string]any{}).Code)
+
+	rec = call(handler.GetBill(billRepo), user1.ID, id, nil)
+	var stored map[string]entity.Bill
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&stored))
+	assert.Equal(t, entity.BillStatusSettled, stored["data"].Status)
+
+	rec = call(handler.ListBills(billRepo), user1.ID, "", nil)
+	var listed map[string][]entity.Bill
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&listed))
+	assert.Len(t, listed["data"], 1)
+
+	assert.Equal(t, http.StatusConflict, call(handler.CancelBill(trxAggregator), user2.ID, id, nil).Code)
+}
@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultEscrowTimeout is used when an escrow request doesn't set
+// release_in.
+var DefaultEscrowTimeout = 14 * 24 * time.Hour
+
+type EscrowRequest struct {
+	Seller     string `json:"seller"`
+	Amount     int    `json:"amount"`
+	Currency   string `json:"currency"`
+	FromWallet string `json:"from_wallet"`
+	Reference  string `json:"reference"`
+	Note       string `json:"note"`
+	ReleaseIn  int    `json:"release_in"` // seconds
+}
+
+type DisputeRequest struct {
+	Reason string `json:"reason"`
+}
+
+type ResolveEscrowRequest struct {
+	Outcome string `json:"outcome"` // release or refund
+}
+
+func OpenEscrow(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody EscrowRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		timeout := DefaultEscrowTimeout
+		if jsonBody.ReleaseIn > 0 {
+			timeout = time.Duration(jsonBody.ReleaseIn) * time.Second
+		}
+
+		escrow, err := transactionAggregator.OpenEscrow(userID, jsonBody.Seller, jsonBody.Amount, timeout,
+			aggregation.WithCurrency(jsonBody.Currency),
+			aggregation.WithSourceWallet(jsonBody.FromWallet),
+			aggregation.WithReference(jsonBody.Reference),
+			aggregation.WithNote(jsonBody.Note),
+		)
+		if err != nil {
+			if errors.Is(err, aggregation.ErrUserNotFound) || errors.Is(err, aggregation.ErrSameWallet) {
+				return renderFieldError(c, "seller", err.Error())
+			}
+			if errors.Is(err, aggregation.ErrAccountClosed) {
+				return renderEscrowError(c, err)
+			}
+			return renderTransferError(c, err)
+		}
+
+		return c.JSON(http.StatusCreated, H{"data": escrow})
+	}
+}
+
+// ListEscrows lists the escrows the user buys or sells in.
+func ListEscrows(escrowRepo *repository.Escrow) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		escrows, err := escrowRepo.GetByUserID(userID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, H{"data": escrows})
+	}
+}
+
+func GetEscrow(escrowRepo *repository.Escrow) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		// only the buyer and the seller can see it
+		escrow, err := escrowRepo.FindById(c.Param("id"))
+		if err == db.ErrNotFound || (err == nil && !escrow.IsParty(userID)) {
+			err = aggregation.ErrEscrowNotFound
+		}
+		if err != nil {
+			return renderEscrowError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": escrow})
+	}
+}
+
+func ConfirmEscrow(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		escrow, err := transactionAggregator.ConfirmEscrow(userID, c.Param("id"))
+		if err != nil {
+			return renderEscrowError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": escrow})
+	}
+}
+
+func CancelEscrow(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		escrow, err := transactionAggregator.CancelEscrow(userID, c.Param("id"))
+		if err != nil {
+			return renderEscrowError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": escrow})
+	}
+}
+
+func DisputeEscrow(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody DisputeRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		escrow, err := transactionAggregator.DisputeEscrow(userID, c.Param("id"), jsonBody.Reason)
+		if err != nil {
+			return renderEscrowError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": escrow})
+	}
+}
+
+func WithdrawDispute(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		escrow, err := transactionAggregator.WithdrawDispute(userID, c.Param("id"))
+		if err != nil {
+			return renderEscrowError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": escrow})
+	}
+}
+
+// DisputedEscrows lists the escrows waiting for an admin.
+func DisputedEscrows(escrowRepo *repository.Escrow) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		escrows, err := escrowRepo.GetByStatus(entity.EscrowStatusDisputed)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, H{"data": escrows})
+	}
+}
+
+func ResolveEscrow(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var jsonBody ResolveEscrowRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		if jsonBody.Outcome != "release" && jsonBody.Outcome != "refund" {
+			return renderFieldError(c, "outcome", "outcome is release or refund")
+		}
+
+		escrow, err := transactionAggregator.ResolveEscrow(c.Param("id"), jsonBody.Outcome == "release")
+		if err != nil {
+			return renderEscrowError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": escrow})
+	}
+}
+
+func renderEscrowError(c echo.Context, err error) error {
+	if errors.Is(err, aggregation.ErrEscrowNotFound) {
+		return c.JSON(http.StatusNotFound, H{
+			"errors": []H{
+				{
+					"detail": "escrow not found",
+				},
+			},
+		})
+	}
+	if errors.Is(err, aggregation.ErrEscrowNotHeld) || errors.Is(err, aggregation.ErrEscrowNotDisputed) || errors.Is(err, aggregation.ErrAccountClosed) {
+		return c.JSON(http.StatusConflict, H{
+			"errors": []H{
+				{
+					"detail": err.Error(),
+				},
+			},
+		})
+	}
+	return renderTransferError(c, err)
+}
@@ -0,0 +1,76 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/handler"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscrowHandlers(t *testing.T) {
+	e, trxAggregator, user1, user2, dbInstance := setupTest()
+	escrowRepo := repository.NewEscrow(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+
+	call := func(h echo.HandlerFunc, userID, id string, payload any) *httptest.ResponseRecorder {
+		payloadBytes, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "/escrows", bytes.NewReader(payloadBytes))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		c.Set("current_user", entity.UserToken{
+			UserID: userID,
+		})
+		assert.NoError(t, h(c))
+		return rec
+	}
+
+	open := handler.OpenEscrow(trxAggregator)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(open, user1.ID, "", map[string]any{"seller": "nobody", "amount": 10}).Code)
+	assert.Equal(t, http.StatusBadRequest, call(open, user1.ID, "", map[string]any{"seller": user2.ID, "amount": 500}).Code)
+
+	rec := call(open, user1.ID, "", map[string]any{"seller": user2.ID, "amount": 40, "reference": "order-1"})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	var created map[string]entity.Escrow
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	id := created["data"].ID
+	assert.Equal(t, entity.EscrowStatusHeld, created["data"].Status)
+
+	assert.Equal(t, http.StatusNotFound, call(handler.GetEscrow(escrowRepo), "stranger", id, nil).Code)
+	assert.Equal(t, http.StatusOK, call(handler.GetEscrow(escrowRepo), user2.ID, id, nil).Code)
+
+	// the seller can't confirm for the buyer
+	assert.Equal(t, http.StatusNotFound, call(handler.ConfirmEscrow(trxAggregator), user2.ID, id, nil).Code)
+
+	assert.Equal(t, http.StatusOK, call(handler.DisputeEscrow(trxAggregator), user2.ID, id, map[string]any{"reason": "buyer won't confirm"}).Code)
+	assert.Equal(t, http.StatusConflict, call(handler.DisputeEscrow(trxAggregator), user1.ID, id, map[string]any{"reason": "again"}).Code)
+
+	rec = call(handler.DisputedEscrows(escrowRepo), "admin", "", nil)
+	var disputed map[string][]entity.Escrow
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&disputed))
+	assert.Len(t, disputed["data"], 1)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, call(handler.ResolveEscrow(trxAggregator), "admin", id, map[string]any{"outcome": "split"}).Code)
+	assert.Equal(t, http.StatusOK, call(handler.ResolveEscrow(trxAggregator), "admin", id, map[string]any{"outcome": "release"}).Code)
+	assert.Equal(t, http.StatusConflict, call(handler.CancelEscrow(trxAggregator), user2.ID, id, nil).Code)
+
+	seller, _ := walletRepo.FindByUserID(user2.ID)
+	assert.Equal(t, 90, seller.Balance)
+
+	rec = call(handler.ListEscrows(escrowRepo), user1.ID, "", nil)
+	var listed map[string][]entity.Escrow
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&listed))
+	assert.Len(t, listed["data"], 1)
+	assert.Equal(t, entity.EscrowStatusReleased, listed["data"][0].Status)
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultHoldExpiry is used when a hold request doesn't set expires_in.
+var DefaultHoldExpiry = 7 * 24 * time.Hour
+
+type HoldRequest struct {
+	Amount     int    `json:"amount"`
+	To         string `json:"to"`
+	FromWallet string `json:"from_wallet"`
+	ExpiresIn  int    `json:"expires_in"` // seconds
+	Reference  string `json:"reference"`
+}
+
+type CaptureRequest struct {
+	Amount int    `json:"amount"` // zero captures the whole hold
+	Note   string `json:"note"`
+}
+
+func CreateHold(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody HoldRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		expiry := DefaultHoldExpiry
+		if jsonBody.ExpiresIn > 0 {
+			expiry = time.Duration(jsonBody.ExpiresIn) * time.Second
+		}
+
+		hold, err := transactionAggregator.Hold(userID, jsonBody.Amount, expiry,
+			aggregation.WithHoldTarget(jsonBody.To),
+			aggregation.WithSourceWallet(jsonBody.FromWallet),
+			aggregation.WithReference(jsonBody.Reference),
+		)
+		if err != nil {
+			if errors.Is(err, aggregation.ErrHoldTargetRequired) {
+				return renderFieldError(c, "to", err.Error())
+			}
+			if errors.Is(err, aggregation.ErrWalletNotFound) {
+				return renderFieldError(c, "from_wallet", err.Error())
+			}
+			return renderHoldError(c, err)
+		}
+
+		return c.JSON(http.StatusCreated, H{"data": hold})
+	}
+}
+
+func GetHold(holdRepo *repository.Hold) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		hold, err := participantHold(holdRepo, c.Param("id"), userID)
+		if err != nil {
+			return renderHoldError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": hold})
+	}
+}
+
+func CaptureHold(transactionAggregator *aggregation.Transaction, holdRepo *repository.Hold) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody CaptureRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		// only the payee captures
+		hold, err := participantHold(holdRepo, c.Param("id"), userID)
+		if err == nil && hold.TargetUserID != userID {
+			err = aggregation.ErrHoldNotFound
+		}
+		if err != nil {
+			return renderHoldError(c, err)
+		}
+
+		amount := jsonBody.Amount
+		if amount == 0 {
+			amount = hold.Amount
+		}
+
+		trx, err := transactionAggregator.Capture(hold.ID, amount, aggregation.WithNote(jsonBody.Note))
+		if err != nil {
+			return renderHoldError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"message": "Capture successful", "data": trx})
+	}
+}
+
+func VoidHold(transactionAggregator *aggregation.Transaction, holdRepo *repository.Hold) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		if _, err := participantHold(holdRepo, c.Param("id"), userID); err != nil {
+			return renderHoldError(c, err)
+		}
+
+		hold, err := transactionAggregator.Void(c.Param("id"))
+		if err != nil {
+			return renderHoldError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": hold})
+	}
+}
+
+// participantHold hides holds from everyone but the payer and the payee.
+func participantHold(holdRepo *repository.Hold, holdID, userID string) (entity.Hold, error) {
+	hold, err := holdRepo.FindById(holdID)
+	if err == db.ErrNotFound || (err == nil && hold.UserID != userID && hold.TargetUserID != userID) {
+		return entity.Hold{}, aggregation.ErrHoldNotFound
+	}
+	return hold, err
+}
+
+func renderHoldError(c echo.Context, err error) error {
+	if errors.Is(err, aggregation.ErrHoldNotFound) {
+		return c.JSON(http.StatusNotFound, H{
+			"errors": []H{
+				{
+					"detail": "hold not found",
+				},
+			},
+		})
+	}
+	if errors.Is(err, aggregation.ErrHoldNotActive) || errors.Is(err, aggregation.ErrWithdrawalHold) {
+		return c.JSON(http.StatusConflict, H{
+			"errors": []H{
+				{
+					"detail": "hold is no longer active",
+				},
+			},
+		})
+	}
+	if err == aggregation.ErrInsuficientFound {
+		return c.JSON(http.StatusBadRequest, H{"error": "Insufficient funds"})
+	}
+	if walletUnavailable(err) {
+		return renderWalletUnavailable(c, err)
+	}
+	var limitErr *aggregation.LimitError
+	if errors.As(err, &limitErr) {
+		return renderLimitExceeded(c, limitErr)
+	}
+	var amountErr *aggregation.AmountError
+	if errors.As(err, &amountErr) {
+		return renderFieldError(c, amountErr.Field, amountErr.Error())
+	}
+	if errors.Is(err, db.ErrOverloaded) {
+		return renderOverloaded(c)
+	}
+	return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+}
@@ -0,0 +1,55 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/handler"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHoldHandlers(t *testing.T) {
+	e, trxAggregator, user1, user2, dbInstance := setupTest()
+	holdRepo := repository.NewHold(dbInstance)
+
+	call := func(h echo.HandlerFunc, userID, id string, payload any) *httptest.ResponseRecorder {
+		payloadBytes, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "/holds", bytes.NewReader(payloadBytes))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		c.Set("current_user", entity.UserToken{
+			UserID: userID,
+		})
+		assert.NoError(t, h(c))
+		return rec
+	}
+
+	rec := call(handler.CreateHold(trxAggregator), user1.ID, "", map[string]any{"amount": 60, "to": user2.ID})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	var created map[string]entity.Hold
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	holdID := created["data"].ID
+
+	// only the payee captures, strangers don't even see the hold
+	capture := handler.CaptureHold(trxAggregator, holdRepo)
+	assert.Equal(t, http.StatusNotFound, call(capture, user1.ID, holdID, map[string]int{}).Code)
+	assert.Equal(t, http.StatusNotFound, call(handler.GetHold(holdRepo), "stranger", holdID, nil).Code)
+
+	assert.Equal(t, http.StatusOK, call(capture, user2.ID, holdID, map[string]int{"amount": 20}).Code)
+	assert.Equal(t, http.StatusConflict, call(handler.VoidHold(trxAggregator, holdRepo), user1.ID, holdID, nil).Code)
+
+	payer, _ := repository.NewWallet(dbInstance).FindByUserID(user1.ID)
+	assert.Equal(t, 80, payer.Balance)
+	assert.Equal(t, 0, payer.Held)
+}
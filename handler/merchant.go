@@ -0,0 +1,276 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultPaymentIntentExpiry is used when a payment intent doesn't set
+// expires_in.
+var DefaultPaymentIntentExpiry = 30 * time.Minute
+
+type MerchantRegisterRequest struct {
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+	Name        string `json:"name"`
+	CallbackURL string `json:"callback_url"`
+}
+
+type PaymentIntentRequest struct {
+	Amount      int    `json:"amount"`
+	Currency    string `json:"currency"`
+	Reference   string `json:"reference"`
+	Description string `json:"description"`
+	ExpiresIn   int    `json:"expires_in"` // seconds
+}
+
+type PayPaymentIntentRequest struct {
+	FromWallet string `json:"from_wallet"`
+}
+
+func MerchantRegister(authAggregator *aggregation.Authorization) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var jsonBody MerchantRegisterRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		if jsonBody.Name == "" {
+			return renderFieldError(c, "name", "name is required")
+		}
+
+		err := authAggregator.RegisterMerchant(jsonBody.Email, jsonBody.Password, jsonBody.Name, jsonBody.CallbackURL)
+		if errors.Is(err, aggregation.ErrInvalidCallbackURL) {
+			return renderFieldError(c, "callback_url", err.Error())
+		}
+		if errors.Is(err, aggregation.ErrUserAlreadyExists) {
+			return c.JSON(http.StatusUnprocessableEntity, H{
+				"errors": []H{
+					{
+						"detail": "user already registered",
+					},
+				},
+			})
+		}
+		if errors.Is(err, db.ErrOverloaded) {
+			return renderOverloaded(c)
+		}
+		if err != nil {
+			renderInternalServerError(c)
+			return err
+		}
+
+		return c.JSON(http.StatusOK, H{
+			"data": H{
+				"email":        jsonBody.Email,
+				"name":         jsonBody.Name,
+				"callback_url": jsonBody.CallbackURL,
+			},
+		})
+	}
+}
+
+func CreatePaymentIntent(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody PaymentIntentRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		expiry := DefaultPaymentIntentExpiry
+		if jsonBody.ExpiresIn > 0 {
+			expiry = time.Duration(jsonBody.ExpiresIn) * time.Second
+		}
+
+		intent, err := transactionAggregator.CreatePaymentIntent(userID, jsonBody.Amount, expiry,
+			aggregation.WithCurrency(jsonBody.Currency),
+			aggregation.WithReference(jsonBody.Reference),
+			aggregation.WithNote(jsonBody.Description),
+		)
+		if err != nil {
+			if errors.Is(err, aggregation.ErrDuplicateReference) {
+				return renderFieldError(c, "reference", err.Error())
+			}
+			return renderPaymentIntentError(c, err)
+		}
+
+		return c.JSON(http.StatusCreated, H{"data": intent})
+	}
+}
+
+// MerchantPayments lists the payment intents of the merchant, the pending
+// ones unless ?status= asks for paid, cancelled, expired or all.
+func MerchantPayments(paymentIntentRepo *repository.PaymentIntent) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		status := entity.PaymentIntentStatus(c.QueryParam("status"))
+		switch status {
+		case "":
+			status = entity.PaymentIntentStatusPending
+		case "all":
+			status = ""
+		}
+
+		intents, err := paymentIntentRepo.GetByMerchantID(userID, status)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, H{"data": intents})
+	}
+}
+
+func MerchantSettlement(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		settlements, err := transactionAggregator.MerchantSettlement(userID)
+		if err != nil {
+			return renderPaymentIntentError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": settlements})
+	}
+}
+
+func CancelPaymentIntent(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		intent, err := transactionAggregator.CancelPaymentIntent(userID, c.Param("id"))
+		if err != nil {
+			return renderPaymentIntentError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": intent})
+	}
+}
+
+func ResendPaymentCallback(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		intent, err := transactionAggregator.ResendPaymentCallback(userID, c.Param("id"))
+		if err != nil {
+			return renderPaymentIntentError(c, err)
+		}
+
+		// queued, the intent tells when it was delivered
+		return c.JSON(http.StatusAccepted, H{"data": intent})
+	}
+}
+
+// GetPaymentIntent shows a checkout to the customer about to pay it, and
+// to its merchant. Who paid it is only shown to the merchant and the
+// customer.
+func GetPaymentIntent(paymentIntentRepo *repository.PaymentIntent) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		intent, err := paymentIntentRepo.FindById(c.Param("id"))
+		if err == db.ErrNotFound {
+			err = aggregation.ErrPaymentIntentNotFound
+		}
+		if err != nil {
+			return renderPaymentIntentError(c, err)
+		}
+
+		if intent.MerchantID != userID && intent.CustomerID != userID {
+			intent.CustomerID = ""
+			intent.TransactionID = ""
+			intent.CallbackDeliveredAt = time.Time{}
+			intent.CallbackError = ""
+			intent.CallbackAttempts = 0
+			intent.NextCallbackAt = time.Time{}
+		}
+
+		return c.JSON(http.StatusOK, H{"data": intent})
+	}
+}
+
+func PayPaymentIntent(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody PayPaymentIntentRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		trx, err := transactionAggregator.PayPaymentIntent(userID, c.Param("id"), aggregation.WithSourceWallet(jsonBody.FromWallet))
+		if err != nil {
+			return renderPaymentIntentError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"message": "Payment successful", "data": trx})
+	}
+}
+
+func renderPaymentIntentError(c echo.Context, err error) error {
+	if errors.Is(err, aggregation.ErrPaymentIntentNotFound) {
+		return c.JSON(http.StatusNotFound, H{
+			"errors": []H{
+				{
+					"detail": "payment intent not found",
+				},
+			},
+		})
+	}
+	if errors.Is(err, aggregation.ErrNotMerchant) {
+		return c.JSON(http.StatusForbidden, H{
+			"errors": []H{
+				{
+					"detail": err.Error(),
+				},
+			},
+		})
+	}
+	if errors.Is(err, aggregation.ErrPaymentIntentNotPending) || errors.Is(err, aggregation.ErrAccountClosed) {
+		return c.JSON(http.StatusConflict, H{
+			"errors": []H{
+				{
+					"detail": err.Error(),
+				},
+			},
+		})
+	}
+	return renderTransferError(c, err)
+}
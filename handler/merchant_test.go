@@ -0,0 +1,89 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/handler"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerchantHandlers(t *testing.T) {
+	e, trxAggregator, user1, user2, dbInstance := setupTest()
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	paymentIntentRepo := repository.NewPaymentIntent(dbInstance)
+	authAggregator := aggregation.NewAuthorization(walletRepo, userRepo, repository.NewUserToken(dbInstance), dbInstance)
+
+	call := func(h echo.HandlerFunc, userID, id, query string, payload any) *httptest.ResponseRecorder {
+		payloadBytes, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "/merchant/payment-intents"+query, bytes.NewReader(payloadBytes))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		c.Set("current_user", entity.UserToken{
+			UserID: userID,
+		})
+		assert.NoError(t, h(c))
+		return rec
+	}
+
+	register := handler.MerchantRegister(authAggregator)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(register, "", "", "", map[string]any{"email": "shop@example.com", "password": "secret"}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(register, "", "", "", map[string]any{"email": "shop@example.com", "password": "secret", "name": "Corner Shop", "callback_url": "http://169.254.169.254/latest"}).Code)
+	assert.Equal(t, http.StatusOK, call(register, "", "", "", map[string]any{"email": "shop@example.com", "password": "secret", "name": "Corner Shop"}).Code)
+
+	merchant, err := userRepo.FindByEmail("shop@example.com")
+	assert.NoError(t, err)
+	assert.True(t, merchant.IsMerchant())
+
+	create := handler.CreatePaymentIntent(trxAggregator)
+	assert.Equal(t, http.StatusForbidden, call(create, user1.ID, "", "", map[string]any{"amount": 40}).Code)
+
+	rec := call(create, merchant.ID, "", "", map[string]any{"amount": 40, "reference": "order-1", "description": "coffee"})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(create, merchant.ID, "", "", map[string]any{"amount": 40, "reference": "order-1"}).Code)
+
+	var created map[string]entity.PaymentIntent
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	id := created["data"].ID
+
+	assert.Equal(t, http.StatusOK, call(handler.GetPaymentIntent(paymentIntentRepo), user1.ID, id, "", nil).Code)
+	assert.Equal(t, http.StatusNotFound, call(handler.GetPaymentIntent(paymentIntentRepo), user1.ID, "unknown", "", nil).Code)
+
+	pay := handler.PayPaymentIntent(trxAggregator)
+	assert.Equal(t, http.StatusOK, call(pay, user1.ID, id, "", map[string]any{}).Code)
+	assert.Equal(t, http.StatusConflict, call(pay, user2.ID, id, "", map[string]any{}).Code)
+
+	// someone else doesn't see who paid
+	rec = call(handler.GetPaymentIntent(paymentIntentRepo), user2.ID, id, "", nil)
+	var seen map[string]entity.PaymentIntent
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&seen))
+	assert.Equal(t, entity.PaymentIntentStatusPaid, seen["data"].Status)
+	assert.Empty(t, seen["data"].CustomerID)
+
+	rec = call(handler.MerchantPayments(paymentIntentRepo), merchant.ID, "", "?status=paid", nil)
+	var listed map[string][]entity.PaymentIntent
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&listed))
+	assert.Len(t, listed["data"], 1)
+	assert.Equal(t, user1.ID, listed["data"][0].CustomerID)
+
+	rec = call(handler.MerchantSettlement(trxAggregator), merchant.ID, "", "", nil)
+	var settlements map[string][]aggregation.Settlement
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&settlements))
+	assert.Equal(t, 40, settlements["data"][0].Balance)
+	assert.Equal(t, 1, settlements["data"][0].Payments)
+
+	assert.Equal(t, http.StatusForbidden, call(handler.MerchantSettlement(trxAggregator), user1.ID, "", "", nil).Code)
+	assert.Equal(t, http.StatusConflict, call(handler.CancelPaymentIntent(trxAggregator), merchant.ID, id, "", nil).Code)
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/labstack/echo/v4"
+)
+
+func LaneMetrics(dbInstance *db.Instance) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		lanes := H{}
+		for lane, stats := range dbInstance.LaneStats() {
+			lanes[lane.String()] = H{
+				"pending":         stats.Pending,
+				"processed":       stats.Processed,
+				"average_wait_ms": float64(stats.AverageWait().Microseconds()) / 1000,
+				"max_wait_ms":     float64(stats.MaxWait.Microseconds()) / 1000,
+			}
+		}
+
+		return c.JSON(http.StatusOK, H{
+			"data": H{
+				"lanes": lanes,
+			},
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/insomnius/wallet-event-loop/handler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLaneMetrics(t *testing.T) {
+	e, trxAggregator, user1, _, dbInstance := setupTest()
+
+	// at least one interactive operation
+	_, err := trxAggregator.TopUp(user1.ID, 10)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics/lanes", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	assert.NoError(t, handler.LaneMetrics(dbInstance)(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var jsonResponse map[string]map[string]map[string]map[string]float64
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&jsonResponse))
+
+	lanes := jsonResponse["data"]["lanes"]
+	assert.Contains(t, lanes, "interactive")
+	assert.Contains(t, lanes, "background")
+	assert.Contains(t, lanes, "admin")
+	assert.GreaterOrEqual(t, lanes["interactive"]["processed"], float64(1))
+}
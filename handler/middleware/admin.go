@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AdminValidator tells if the signed in user is an admin.
+type AdminValidator func(c echo.Context) (bool, error)
+
+// Admin lets only admins through, it has to run after Oauth.
+func Admin(isAdmin AdminValidator) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			admin, err := isAdmin(c)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Error validating role").SetInternal(err)
+			}
+			if !admin {
+				return echo.NewHTTPError(http.StatusForbidden, "Admin only")
+			}
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/insomnius/wallet-event-loop/handler/middleware"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmin(t *testing.T) {
+	e := echo.New()
+
+	request := func(admin bool) error {
+		h := middleware.Admin(func(c echo.Context) (bool, error) {
+			return admin, nil
+		})(func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/admin/transactions/id/reverse", nil)
+		return h(e.NewContext(req, httptest.NewRecorder()))
+	}
+
+	assert.NoError(t, request(true))
+
+	err := request(false)
+	var httpErr *echo.HTTPError
+	assert.ErrorAs(t, err, &httpErr)
+	assert.Equal(t, http.StatusForbidden, httpErr.Code)
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/labstack/echo/v4"
+	echoMiddleware "github.com/labstack/echo/v4/middleware"
+	"golang.org/x/time/rate"
+)
+
+// UserRateLimit is a token bucket per signed in user, so a single client
+// can't monopolize the single writer. It has to run after Oauth.
+func UserRateLimit(ratePerSecond float64, burst int) echo.MiddlewareFunc {
+	retryAfter := strconv.Itoa(int(math.Ceil(1 / ratePerSecond)))
+
+	return echoMiddleware.RateLimiterWithConfig(echoMiddleware.RateLimiterConfig{
+		Store: echoMiddleware.NewRateLimiterMemoryStoreWithConfig(echoMiddleware.RateLimiterMemoryStoreConfig{
+			Rate:      rate.Limit(ratePerSecond),
+			Burst:     burst,
+			ExpiresIn: 10 * time.Minute,
+		}),
+		IdentifierExtractor: func(c echo.Context) (string, error) {
+			token, ok := c.Get("current_user").(entity.UserToken)
+			if !ok {
+				return "", echo.ErrUnauthorized
+			}
+			return token.UserID, nil
+		},
+		ErrorHandler: func(c echo.Context, err error) error {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Missing current user").SetInternal(err)
+		},
+		DenyHandler: func(c echo.Context, identifier string, err error) error {
+			c.Response().Header().Set("Retry-After", retryAfter)
+			return echo.NewHTTPError(http.StatusTooManyRequests, "Too many requests").SetInternal(err)
+		},
+	})
+}
@@ -0,0 +1,44 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/handler/middleware"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserRateLimit(t *testing.T) {
+	e := echo.New()
+	limiter := middleware.UserRateLimit(1, 2)(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	request := func(userID string) (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest(http.MethodPost, "/transactions/transfer", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.Set("current_user", entity.UserToken{UserID: userID})
+		return rec, limiter(c)
+	}
+
+	for n := 0; n < 2; n++ {
+		rec, err := request("user-id-1")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	// the limiter renders the denial itself
+	rec, err := request("user-id-1")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	// other users have their own bucket
+	rec, err = request("user-id-2")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
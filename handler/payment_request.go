@@ -0,0 +1,227 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultPaymentRequestExpiry is used when a payment request doesn't set
+// expires_in.
+var DefaultPaymentRequestExpiry = 7 * 24 * time.Hour
+
+type PaymentRequestRequest struct {
+	From      string `json:"from"` // the payer
+	Amount    int    `json:"amount"`
+	Currency  string `json:"currency"`
+	Note      string `json:"note"`
+	Reference string `json:"reference"`
+	ExpiresIn int    `json:"expires_in"` // seconds
+}
+
+type AcceptPaymentRequestRequest struct {
+	FromWallet string `json:"from_wallet"` // pocket to pay from, the main wallet when empty
+}
+
+type DeclinePaymentRequestRequest struct {
+	Reason string `json:"reason"`
+}
+
+func CreatePaymentRequest(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody PaymentRequestRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		expiry := DefaultPaymentRequestExpiry
+		if jsonBody.ExpiresIn > 0 {
+			expiry = time.Duration(jsonBody.ExpiresIn) * time.Second
+		}
+
+		request, err := transactionAggregator.RequestPayment(userID, jsonBody.From, jsonBody.Amount, expiry,
+			aggregation.WithCurrency(jsonBody.Currency),
+			aggregation.WithReference(jsonBody.Reference),
+			aggregation.WithNote(jsonBody.Note),
+		)
+		if err != nil {
+			if errors.Is(err, aggregation.ErrUserNotFound) || errors.Is(err, aggregation.ErrSameWallet) {
+				return renderFieldError(c, "from", err.Error())
+			}
+			return renderPaymentRequestError(c, err)
+		}
+
+		return c.JSON(http.StatusCreated, H{"data": request})
+	}
+}
+
+// IncomingPaymentRequests lists the requests the user was asked to pay,
+// pending ones unless ?status= says otherwise, "all" lists every request.
+func IncomingPaymentRequests(paymentRequestRepo *repository.PaymentRequest) echo.HandlerFunc {
+	return listPaymentRequests(paymentRequestRepo.GetByPayerID)
+}
+
+// OutgoingPaymentRequests lists the requests the user sent, filtered like
+// IncomingPaymentRequests.
+func OutgoingPaymentRequests(paymentRequestRepo *repository.PaymentRequest) echo.HandlerFunc {
+	return listPaymentRequests(paymentRequestRepo.GetByRequesterID)
+}
+
+func listPaymentRequests(get func(string, entity.PaymentRequestStatus, ...*db.Transaction) ([]entity.PaymentRequest, error)) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		status := entity.PaymentRequestStatus(c.QueryParam("status"))
+		switch status {
+		case "":
+			status = entity.PaymentRequestStatusPending
+		case "all":
+			status = ""
+		case entity.PaymentRequestStatusPending, entity.PaymentRequestStatusPaid, entity.PaymentRequestStatusDeclined,
+			entity.PaymentRequestStatusCancelled, entity.PaymentRequestStatusExpired:
+		default:
+			return renderFieldError(c, "status", "unknown payment request status")
+		}
+
+		requests, err := get(userID, status)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, H{"data": requests})
+	}
+}
+
+func GetPaymentRequest(paymentRequestRepo *repository.PaymentRequest) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		// only both sides of the request can see it
+		request, err := paymentRequestRepo.FindById(c.Param("id"))
+		if err == db.ErrNotFound || (err == nil && request.RequesterID != userID && request.PayerID != userID) {
+			err = aggregation.ErrPaymentRequestNotFound
+		}
+		if err != nil {
+			return renderPaymentRequestError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": request})
+	}
+}
+
+func AcceptPaymentRequest(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody AcceptPaymentRequestRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		trx, err := transactionAggregator.AcceptPaymentRequest(userID, c.Param("id"), aggregation.WithSourceWallet(jsonBody.FromWallet))
+		if err != nil {
+			if errors.Is(err, aggregation.ErrPaymentRequestNotFound) || errors.Is(err, aggregation.ErrPaymentRequestNotPending) {
+				return renderPaymentRequestError(c, err)
+			}
+			return renderTransferError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"message": "Transfer successful", "data": trx})
+	}
+}
+
+func DeclinePaymentRequest(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody DeclinePaymentRequestRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		request, err := transactionAggregator.DeclinePaymentRequest(userID, c.Param("id"), jsonBody.Reason)
+		if err != nil {
+			return renderPaymentRequestError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": request})
+	}
+}
+
+func CancelPaymentRequest(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		request, err := transactionAggregator.CancelPaymentRequest(userID, c.Param("id"))
+		if err != nil {
+			return renderPaymentRequestError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": request})
+	}
+}
+
+func renderPaymentRequestError(c echo.Context, err error) error {
+	if errors.Is(err, aggregation.ErrPaymentRequestNotFound) {
+		return c.JSON(http.StatusNotFound, H{
+			"errors": []H{
+				{
+					"detail": "payment request not found",
+				},
+			},
+		})
+	}
+	if errors.Is(err, aggregation.ErrPaymentRequestNotPending) || errors.Is(err, aggregation.ErrAccountClosed) {
+		return c.JSON(http.StatusConflict, H{
+			"errors": []H{
+				{
+					"detail": err.Error(),
+				},
+			},
+		})
+	}
+	var amountErr *aggregation.AmountError
+	if errors.As(err, &amountErr) {
+		return renderFieldError(c, amountErr.Field, amountErr.Error())
+	}
+	if errors.Is(err, db.ErrOverloaded) {
+		return renderOverloaded(c)
+	}
+	return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+}
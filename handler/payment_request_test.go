@@ -0,0 +1,68 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/handler"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentRequestHandlers(t *testing.T) {
+	e, trxAggregator, user1, user2, dbInstance := setupTest()
+	requestRepo := repository.NewPaymentRequest(dbInstance)
+
+	call := func(h echo.HandlerFunc, userID, id, query string, payload any) *httptest.ResponseRecorder {
+		payloadBytes, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "/payment-requests"+query, bytes.NewReader(payloadBytes))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		c.Set("current_user", entity.UserToken{
+			UserID: userID,
+		})
+		assert.NoError(t, h(c))
+		return rec
+	}
+
+	create := handler.CreatePaymentRequest(trxAggregator)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(create, user2.ID, "", "", map[string]any{"from": user2.ID, "amount": 10}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(create, user2.ID, "", "", map[string]any{"from": user1.ID, "amount": 0}).Code)
+
+	rec := call(create, user2.ID, "", "", map[string]any{"from": user1.ID, "amount": 40, "note": "tickets"})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	var created map[string]entity.PaymentRequest
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	id := created["data"].ID
+
+	rec = call(handler.IncomingPaymentRequests(requestRepo), user1.ID, "", "", nil)
+	var incoming map[string][]entity.PaymentRequest
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&incoming))
+	assert.Len(t, incoming["data"], 1)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, call(handler.OutgoingPaymentRequests(requestRepo), user2.ID, "", "?status=unknown", nil).Code)
+	assert.Equal(t, http.StatusNotFound, call(handler.GetPaymentRequest(requestRepo), "stranger", id, "", nil).Code)
+	assert.Equal(t, http.StatusNotFound, call(handler.AcceptPaymentRequest(trxAggregator), user2.ID, id, "", map[string]any{}).Code)
+
+	assert.Equal(t, http.StatusOK, call(handler.AcceptPaymentRequest(trxAggregator), user1.ID, id, "", map[string]any{}).Code)
+	assert.Equal(t, http.StatusConflict, call(handler.AcceptPaymentRequest(trxAggregator), user1.ID, id, "", map[string]any{}).Code)
+	assert.Equal(t, http.StatusConflict, call(handler.DeclinePaymentRequest(trxAggregator), user1.ID, id, "", map[string]any{}).Code)
+
+	rec = call(handler.OutgoingPaymentRequests(requestRepo), user2.ID, "", "?status=paid", nil)
+	var outgoing map[string][]entity.PaymentRequest
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&outgoing))
+	assert.Len(t, outgoing["data"], 1)
+
+	payee, _ := repository.NewWallet(dbInstance).FindByUserID(user2.ID)
+	assert.Equal(t, 90, payee.Balance)
+}
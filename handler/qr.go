@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/labstack/echo/v4"
+)
+
+type QRRequest struct {
+	Payload    string `json:"payload"`
+	Amount     int    `json:"amount"` // for codes without one
+	FromWallet string `json:"from_wallet"`
+}
+
+// MerchantQR renders the merchant's static code, or one for a fixed
+// ?amount= in ?currency=.
+func MerchantQR(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		amount := 0
+		if v := c.QueryParam("amount"); v != "" {
+			var err error
+			amount, err = strconv.Atoi(v)
+			if err != nil {
+				return renderFieldError(c, "amount", "amount is a number")
+			}
+		}
+
+		payload, err := transactionAggregator.MerchantQR(userID, amount, c.QueryParam("currency"))
+		if err != nil {
+			return renderQRError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": H{"payload": payload}})
+	}
+}
+
+func PaymentIntentQR(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		payload, err := transactionAggregator.PaymentIntentQR(userID, c.Param("id"))
+		if err != nil {
+			return renderQRError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": H{"payload": payload}})
+	}
+}
+
+// DecodeQR shows what a scanned code pays before the customer confirms.
+func DecodeQR() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var jsonBody QRRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		payload, err := aggregation.ParseQR(jsonBody.Payload)
+		if err != nil {
+			return renderQRError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": payload})
+	}
+}
+
+func PayQR(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody QRRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		opts := append(transactionOptions(c), aggregation.WithSourceWallet(jsonBody.FromWallet))
+
+		trx, err := transactionAggregator.PayQR(userID, jsonBody.Payload, jsonBody.Amount, opts...)
+		if err != nil {
+			// the code points at someone who can't be paid
+			if errors.Is(err, aggregation.ErrUserNotFound) || errors.Is(err, aggregation.ErrNotMerchant) {
+				return renderFieldError(c, "payload", err.Error())
+			}
+			return renderQRError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"message": "Payment successful", "data": trx})
+	}
+}
+
+func renderQRError(c echo.Context, err error) error {
+	if errors.Is(err, aggregation.ErrQRChecksum) || errors.Is(err, aggregation.ErrQRMalformed) || errors.Is(err, aggregation.ErrQRUnsupported) {
+		return renderFieldError(c, "payload", err.Error())
+	}
+	if errors.Is(err, aggregation.ErrQRAmountMismatch) {
+		return renderFieldError(c, "amount", err.Error())
+	}
+	return renderPaymentIntentError(c, err)
+}
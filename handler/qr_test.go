@@ -0,0 +1,87 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/handler"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQRHandlers(t *testing.T) {
+	e, trxAggregator, user1, _, dbInstance := setupTest()
+	userRepo := repository.NewUser(dbInstance)
+	walletRepo := repository.NewWallet(dbInstance)
+	authAggregator := aggregation.NewAuthorization(walletRepo, userRepo, repository.NewUserToken(dbInstance), dbInstance)
+
+	call := func(h echo.HandlerFunc, userID, id, query string, payload any) *httptest.ResponseRecorder {
+		payloadBytes, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "/payments/qr"+query, bytes.NewReader(payloadBytes))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		c.Set("current_user", entity.UserToken{
+			UserID: userID,
+		})
+		assert.NoError(t, h(c))
+		return rec
+	}
+
+	assert.NoError(t, authAggregator.RegisterMerchant("shop@example.com", "secret", "Corner Shop", ""))
+	merchant, err := userRepo.FindByEmail("shop@example.com")
+	assert.NoError(t, err)
+
+	code := func(rec *httptest.ResponseRecorder) string {
+		var body map[string]map[string]string
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+		return body["data"]["payload"]
+	}
+
+	merchantQR := handler.MerchantQR(trxAggregator)
+	assert.Equal(t, http.StatusForbidden, call(merchantQR, user1.ID, "", "", nil).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(merchantQR, merchant.ID, "", "?amount=ten", nil).Code)
+
+	rec := call(merchantQR, merchant.ID, "", "?amount=30", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	fixed := code(rec)
+
+	rec = call(handler.DecodeQR(), user1.ID, "", "", map[string]any{"payload": fixed})
+	var decoded map[string]aggregation.QRPayload
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&decoded))
+	assert.Equal(t, merchant.ID, decoded["data"].MerchantID)
+	assert.Equal(t, 30, decoded["data"].Amount)
+
+	pay := handler.PayQR(trxAggregator)
+	tampered := strings.Replace(fixed, "54040.30", "54040.90", 1)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(pay, user1.ID, "", "", map[string]any{"payload": tampered}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(pay, user1.ID, "", "", map[string]any{"payload": fixed, "amount": 20}).Code)
+	assert.Equal(t, http.StatusOK, call(pay, user1.ID, "", "", map[string]any{"payload": fixed}).Code)
+
+	static := code(call(merchantQR, merchant.ID, "", "", nil))
+	assert.Equal(t, http.StatusBadRequest, call(pay, user1.ID, "", "", map[string]any{"payload": static, "amount": 500}).Code)
+	assert.Equal(t, http.StatusOK, call(pay, user1.ID, "", "", map[string]any{"payload": static, "amount": 20}).Code)
+
+	intent, err := trxAggregator.CreatePaymentIntent(merchant.ID, 40, handler.DefaultPaymentIntentExpiry)
+	assert.NoError(t, err)
+
+	intentQR := handler.PaymentIntentQR(trxAggregator)
+	assert.Equal(t, http.StatusNotFound, call(intentQR, merchant.ID, "unknown", "", nil).Code)
+	checkout := code(call(intentQR, merchant.ID, intent.ID, "", nil))
+
+	assert.Equal(t, http.StatusOK, call(pay, user1.ID, "", "", map[string]any{"payload": checkout}).Code)
+	assert.Equal(t, http.StatusConflict, call(pay, user1.ID, "", "", map[string]any{"payload": checkout}).Code)
+
+	wallet, _ := walletRepo.FindByUserID(user1.ID)
+	assert.Equal(t, 10, wallet.Balance)
+}
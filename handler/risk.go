@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+)
+
+type RejectReviewRequest struct {
+	Reason string `json:"reason"`
+}
+
+// RiskReviews lists the reviews for admins, pending ones unless ?status=
+// says otherwise, "all" lists every review.
+func RiskReviews(riskReviewRepo *repository.RiskReview) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		status := entity.RiskReviewStatus(c.QueryParam("status"))
+		switch status {
+		case "":
+			status = entity.RiskReviewStatusPending
+		case "all":
+			status = ""
+		case entity.RiskReviewStatusPending, entity.RiskReviewStatusApproved, entity.RiskReviewStatusRejected:
+		default:
+			return renderFieldError(c, "status", "unknown review status")
+		}
+
+		reviews, err := riskReviewRepo.GetByStatus(status)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, H{"data": reviews})
+	}
+}
+
+// RiskDecisions lists every decision taken on the transfers of a user.
+func RiskDecisions(riskDecisionRepo *repository.RiskDecision) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if c.QueryParam("user_id") == "" {
+			return renderFieldError(c, "user_id", "user_id is required")
+		}
+
+		decisions, err := riskDecisionRepo.GetByUserID(c.QueryParam("user_id"))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, H{"data": decisions})
+	}
+}
+
+func ApproveReview(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		adminID := c.Get("current_user").(entity.UserToken).UserID
+
+		trx, err := transactionAggregator.ApproveReview(c.Param("id"), adminID)
+		if err != nil {
+			if errors.Is(err, aggregation.ErrReviewNotFound) || errors.Is(err, aggregation.ErrReviewDecided) {
+				return renderReviewError(c, err)
+			}
+			return renderTransferError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"message": "Transfer successful", "data": trx})
+	}
+}
+
+func RejectReview(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		adminID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody RejectReviewRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		review, err := transactionAggregator.RejectReview(c.Param("id"), adminID, jsonBody.Reason)
+		if err != nil {
+			return renderReviewError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"message": "Review rejected", "data": review})
+	}
+}
+
+func renderReviewError(c echo.Context, err error) error {
+	if errors.Is(err, aggregation.ErrReviewNotFound) {
+		return c.JSON(http.StatusNotFound, H{
+			"errors": []H{
+				{
+					"detail": "review not found",
+				},
+			},
+		})
+	}
+	if errors.Is(err, aggregation.ErrReviewDecided) {
+		return c.JSON(http.StatusConflict, H{
+			"errors": []H{
+				{
+					"detail": err.Error(),
+				},
+			},
+		})
+	}
+	if errors.Is(err, db.ErrOverloaded) {
+		return renderOverloaded(c)
+	}
+	return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+}
+
+// renderRiskDecision doesn't tell the user which rules scored, that stays
+// with the admins.
+func renderRiskDecision(c echo.Context, err *aggregation.RiskError) error {
+	if err.Action == entity.RiskActionReview {
+		return c.JSON(http.StatusAccepted, H{
+			"message": "Transfer held for review",
+			"data": H{
+				"review_id": err.Review.ID,
+				"status":    err.Review.Status,
+			},
+		})
+	}
+
+	return c.JSON(http.StatusUnprocessableEntity, H{
+		"errors": []H{
+			{
+				"detail": aggregation.ErrTransferBlocked.Error(),
+			},
+		},
+	})
+}
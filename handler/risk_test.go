@@ -0,0 +1,104 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/handler"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRiskReview(t *testing.T) {
+	e, _, user1, user2, dbInstance := setupTest()
+
+	trxAggregator := aggregation.NewTransaction(
+		repository.NewWallet(dbInstance),
+		repository.NewUser(dbInstance),
+		repository.NewMutation(dbInstance),
+		dbInstance,
+		aggregation.WithRisk(aggregation.RiskPolicy{
+			Rules:       []aggregation.RiskRule{aggregation.NewRecipientRule{Amount: 20, Points: 10}},
+			ReviewScore: 10,
+		}),
+	)
+
+	transfer := func(amount int) *httptest.ResponseRecorder {
+		payloadBytes, _ := json.Marshal(map[string]any{"amount": amount, "to": user2.ID})
+		req := httptest.NewRequest(http.MethodPost, "/transactions/transfer", bytes.NewReader(payloadBytes))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+
+		c := e.NewContext(req, rec)
+		c.Set("current_user", entity.UserToken{
+			UserID: user1.ID,
+		})
+		assert.NoError(t, handler.Transfer(trxAggregator)(c))
+		return rec
+	}
+
+	decide := func(action, reviewID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/risk/reviews/"+reviewID+"/"+action, bytes.NewReader([]byte(`{"reason": "checked"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(reviewID)
+		c.Set("current_user", entity.UserToken{
+			UserID: "admin-id",
+		})
+		if action == "approve" {
+			assert.NoError(t, handler.ApproveReview(trxAggregator)(c))
+		} else {
+			assert.NoError(t, handler.RejectReview(trxAggregator)(c))
+		}
+		return rec
+	}
+
+	rec := transfer(30)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "new_recipient")
+
+	var held struct {
+		Data struct {
+			ReviewID string `json:"review_id"`
+			Status   string `json:"status"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &held))
+	assert.Equal(t, "pending", held.Data.Status)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/risk/reviews", nil)
+	rec = httptest.NewRecorder()
+	assert.NoError(t, handler.RiskReviews(repository.NewRiskReview(dbInstance))(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), held.Data.ReviewID)
+	assert.Contains(t, rec.Body.String(), "new_recipient")
+
+	assert.Equal(t, http.StatusOK, decide("approve", held.Data.ReviewID).Code)
+	assert.Equal(t, http.StatusConflict, decide("reject", held.Data.ReviewID).Code)
+	assert.Equal(t, http.StatusNotFound, decide("approve", "unknown").Code)
+
+	wallet, _ := repository.NewWallet(dbInstance).FindByUserID(user1.ID)
+	assert.Equal(t, 70, wallet.Balance)
+
+	// a known recipient now
+	assert.Equal(t, http.StatusOK, transfer(30).Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/risk/decisions?user_id="+user1.ID, nil)
+	rec = httptest.NewRecorder()
+	assert.NoError(t, handler.RiskDecisions(repository.NewRiskDecision(dbInstance))(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var decisions struct {
+		Data []entity.RiskDecision `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &decisions))
+	assert.Len(t, decisions.Data, 3)
+}
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+)
+
+type ScheduledTransferRequest struct {
+	To                  string    `json:"to"`
+	Amount              int       `json:"amount"`
+	Currency            string    `json:"currency"`
+	FromWallet          string    `json:"from_wallet"`
+	Reference           string    `json:"reference"`
+	Note                string    `json:"note"`
+	StartAt             time.Time `json:"start_at"` // the run of a one off
+	Cron                string    `json:"cron"`     // recurring when set
+	EndAt               time.Time `json:"end_at"`
+	OnInsufficientFunds string    `json:"on_insufficient_funds"` // skip or retry
+	MaxRetries          int       `json:"max_retries"`
+}
+
+// UpdateScheduledTransferRequest leaves out the fields that don't change.
+type UpdateScheduledTransferRequest struct {
+	Amount              *int       `json:"amount"`
+	Reference           *string    `json:"reference"`
+	Note                *string    `json:"note"`
+	Cron                *string    `json:"cron"`
+	EndAt               *time.Time `json:"end_at"`
+	OnInsufficientFunds *string    `json:"on_insufficient_funds"`
+	MaxRetries          *int       `json:"max_retries"`
+}
+
+func CreateScheduledTransfer(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody ScheduledTransferRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		scheduled, err := transactionAggregator.ScheduleTransfer(entity.ScheduledTransfer{
+			UserID:              userID,
+			TargetUserID:        jsonBody.To,
+			Amount:              jsonBody.Amount,
+			Currency:            jsonBody.Currency,
+			SourceWalletID:      jsonBody.FromWallet,
+			Reference:           jsonBody.Reference,
+			Note:                jsonBody.Note,
+			Cron:                jsonBody.Cron,
+			EndAt:               jsonBody.EndAt,
+			OnInsufficientFunds: jsonBody.OnInsufficientFunds,
+			MaxRetries:          jsonBody.MaxRetries,
+		}, jsonBody.StartAt)
+		if err != nil {
+			if errors.Is(err, aggregation.ErrUserNotFound) || errors.Is(err, aggregation.ErrSameWallet) {
+				return renderFieldError(c, "to", err.Error())
+			}
+			if errors.Is(err, aggregation.ErrWalletNotFound) {
+				return renderFieldError(c, "from_wallet", err.Error())
+			}
+			return renderScheduledTransferError(c, err)
+		}
+
+		return c.JSON(http.StatusCreated, H{"data": scheduled})
+	}
+}
+
+func ListScheduledTransfers(scheduledTransferRepo *repository.ScheduledTransfer) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		scheduled, err := scheduledTransferRepo.GetByUserID(userID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, H{"data": scheduled})
+	}
+}
+
+func GetScheduledTransfer(scheduledTransferRepo *repository.ScheduledTransfer) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		scheduled, err := ownScheduledTransfer(scheduledTransferRepo, c.Param("id"), userID)
+		if err != nil {
+			return renderScheduledTransferError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": scheduled})
+	}
+}
+
+// ScheduledTransferRuns lists every run of a scheduled transfer, paid or
+// not, oldest first.
+func ScheduledTransferRuns(scheduledTransferRepo *repository.ScheduledTransfer, scheduledTransferRunRepo *repository.ScheduledTransferRun) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		scheduled, err := ownScheduledTransfer(scheduledTransferRepo, c.Param("id"), userID)
+		if err != nil {
+			return renderScheduledTransferError(c, err)
+		}
+
+		runs, err := scheduledTransferRunRepo.GetByScheduledTransferID(scheduled.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, H{"data": runs})
+	}
+}
+
+func UpdateScheduledTransfer(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody UpdateScheduledTransferRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		scheduled, err := transactionAggregator.UpdateScheduledTransfer(userID, c.Param("id"), aggregation.ScheduledTransferUpdate{
+			Amount:              jsonBody.Amount,
+			Reference:           jsonBody.Reference,
+			Note:                jsonBody.Note,
+			Cron:                jsonBody.Cron,
+			EndAt:               jsonBody.EndAt,
+			OnInsufficientFunds: jsonBody.OnInsufficientFunds,
+			MaxRetries:          jsonBody.MaxRetries,
+		})
+		if err != nil {
+			return renderScheduledTransferError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": scheduled})
+	}
+}
+
+func CancelScheduledTransfer(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		scheduled, err := transactionAggregator.CancelScheduledTransfer(userID, c.Param("id"))
+		if err != nil {
+			return renderScheduledTransferError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": scheduled})
+	}
+}
+
+// ownScheduledTransfer hides scheduled transfers from everyone but their
+// owner.
+func ownScheduledTransfer(scheduledTransferRepo *repository.ScheduledTransfer, id, userID string) (entity.ScheduledTransfer, error) {
+	scheduled, err := scheduledTransferRepo.FindById(id)
+	if err == db.ErrNotFound || (err == nil && scheduled.UserID != userID) {
+		return entity.ScheduledTransfer{}, aggregation.ErrScheduledTransferNotFound
+	}
+	return scheduled, err
+}
+
+func renderScheduledTransferError(c echo.Context, err error) error {
+	if errors.Is(err, aggregation.ErrScheduledTransferNotFound) {
+		return c.JSON(http.StatusNotFound, H{
+			"errors": []H{
+				{
+					"detail": "scheduled transfer not found",
+				},
+			},
+		})
+	}
+	if errors.Is(err, aggregation.ErrScheduledTransferNotActive) || errors.Is(err, aggregation.ErrAccountClosed) {
+		return c.JSON(http.StatusConflict, H{
+			"errors": []H{
+				{
+					"detail": err.Error(),
+				},
+			},
+		})
+	}
+	if errors.Is(err, aggregation.ErrInvalidCron) {
+		return renderFieldError(c, "cron", err.Error())
+	}
+	if errors.Is(err, aggregation.ErrInvalidSchedule) {
+		return renderFieldError(c, "schedule", err.Error())
+	}
+	var amountErr *aggregation.AmountError
+	if errors.As(err, &amountErr) {
+		return renderFieldError(c, amountErr.Field, amountErr.Error())
+	}
+	if errors.Is(err, db.ErrOverloaded) {
+		return renderOverloaded(c)
+	}
+	return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+}
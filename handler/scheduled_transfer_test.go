@@ -0,0 +1,72 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/handler"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduledTransferHandlers(t *testing.T) {
+	e, trxAggregator, user1, user2, dbInstance := setupTest()
+	scheduledRepo := repository.NewScheduledTransfer(dbInstance)
+	runRepo := repository.NewScheduledTransferRun(dbInstance)
+
+	call := func(h echo.HandlerFunc, userID, id string, payload any) *httptest.ResponseRecorder {
+		payloadBytes, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "/scheduled-transfers", bytes.NewReader(payloadBytes))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		c.Set("current_user", entity.UserToken{
+			UserID: userID,
+		})
+		assert.NoError(t, h(c))
+		return rec
+	}
+
+	create := handler.CreateScheduledTransfer(trxAggregator)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(create, user1.ID, "", map[string]any{"amount": 10, "to": user2.ID, "cron": "every day"}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(create, user1.ID, "", map[string]any{"amount": 10, "to": "nobody", "cron": "@daily"}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(create, user1.ID, "", map[string]any{"amount": 10, "to": user2.ID, "start_at": time.Now().Add(-time.Hour)}).Code)
+
+	rec := call(create, user1.ID, "", map[string]any{"amount": 10, "to": user2.ID, "cron": "0 9 1 * *", "reference": "rent"})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	var created map[string]entity.ScheduledTransfer
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	id := created["data"].ID
+	assert.Equal(t, entity.ScheduledTransferStatusActive, created["data"].Status)
+
+	// only the owner sees and changes it
+	assert.Equal(t, http.StatusNotFound, call(handler.GetScheduledTransfer(scheduledRepo), user2.ID, id, nil).Code)
+	assert.Equal(t, http.StatusNotFound, call(handler.CancelScheduledTransfer(trxAggregator), user2.ID, id, nil).Code)
+	assert.Equal(t, http.StatusOK, call(handler.GetScheduledTransfer(scheduledRepo), user1.ID, id, nil).Code)
+	assert.Equal(t, http.StatusOK, call(handler.ScheduledTransferRuns(scheduledRepo, runRepo), user1.ID, id, nil).Code)
+
+	rec = call(handler.UpdateScheduledTransfer(trxAggregator), user1.ID, id, map[string]any{"amount": 15})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var updated map[string]entity.ScheduledTransfer
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&updated))
+	assert.Equal(t, 15, updated["data"].Amount)
+	assert.Equal(t, "rent", updated["data"].Reference)
+
+	rec = call(handler.ListScheduledTransfers(scheduledRepo), user1.ID, "", nil)
+	var listed map[string][]entity.ScheduledTransfer
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&listed))
+	assert.Len(t, listed["data"], 1)
+
+	assert.Equal(t, http.StatusOK, call(handler.CancelScheduledTransfer(trxAggregator), user1.ID, id, nil).Code)
+	assert.Equal(t, http.StatusConflict, call(handler.CancelScheduledTransfer(trxAggregator), user1.ID, id, nil).Code)
+}
@@ -2,17 +2,21 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"sort"
 
 	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
 	"github.com/insomnius/wallet-event-loop/entity"
 	"github.com/insomnius/wallet-event-loop/repository"
 	"github.com/labstack/echo/v4"
 )
 
 type TopUpRequest struct {
-	Amount int `json:"amount"`
+	Amount    int    `json:"amount"`
+	Currency  string `json:"currency"`
+	Reference string `json:"reference"`
 }
 
 func TopUp(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
@@ -33,11 +37,34 @@ func TopUp(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
 			return err
 		}
 
-		if err := transactionAggregator.TopUp(userID, jsonBody.Amount); err != nil {
+		opts := append(transactionOptions(c),
+			aggregation.WithCurrency(jsonBody.Currency),
+			aggregation.WithReference(jsonBody.Reference),
+		)
+
+		trx, err := transactionAggregator.TopUp(userID, jsonBody.Amount, opts...)
+		if err != nil {
+			if errors.Is(err, db.ErrOverloaded) {
+				return renderOverloaded(c)
+			}
+			if errors.Is(err, aggregation.ErrIdempotencyKeyReused) {
+				return renderIdempotencyKeyReused(c)
+			}
+			if walletUnavailable(err) {
+				return renderWalletUnavailable(c, err)
+			}
+			var limitErr *aggregation.LimitError
+			if errors.As(err, &limitErr) {
+				return renderLimitExceeded(c, limitErr)
+			}
+			var amountErr *aggregation.AmountError
+			if errors.As(err, &amountErr) {
+				return renderFieldError(c, amountErr.Field, amountErr.Error())
+			}
 			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
 		}
 
-		return c.JSON(http.StatusOK, H{"message": "TopUp successful"})
+		return c.JSON(http.StatusOK, H{"message": "TopUp successful", "data": trx})
 	}
 }
 
@@ -51,18 +78,39 @@ func CheckBalance(walletRepo *repository.Wallet) echo.HandlerFunc {
 			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
 		}
 
+		wallets, err := walletRepo.GetByUserID(userID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+		}
+
+		// pockets only add up within a currency
+		totals := map[string]int{}
+		for _, wallet := range wallets {
+			totals[entity.NormalizeCurrency(wallet.Currency)] += wallet.Balance
+		}
+
 		return c.JSON(http.StatusOK, H{
 			"data": H{
-				"user_id": userID,
-				"balance": userWallet.Balance,
+				"user_id":   userID,
+				"balance":   userWallet.Balance,
+				"available": userWallet.Available(),
+				"held":      userWallet.Held,
+				"currency":  entity.NormalizeCurrency(userWallet.Currency),
+				"wallets":   wallets,
+				"totals":    totals,
 			},
 		})
 	}
 }
 
 type TransferRequest struct {
-	Amount int    `json:"amount"`
-	To     string `json:"to"`
+	Amount     int    `json:"amount"`
+	Currency   string `json:"currency"`
+	To         string `json:"to"`
+	ToCurrency string `json:"to_currency"`
+	FromWallet string `json:"from_wallet"` // pocket to pay from, the main wallet when empty
+	Reference  string `json:"reference"`
+	Note       string `json:"note"`
 }
 
 func Transfer(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
@@ -83,14 +131,112 @@ func Transfer(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
 			return err
 		}
 
-		if err := transactionAggregator.Transfer(userID, jsonBody.To, jsonBody.Amount); err != nil {
-			if err == aggregation.ErrInsuficientFound {
-				return c.JSON(http.StatusBadRequest, H{"error": "Insufficient funds"})
-			}
+		trx, err := transactionAggregator.Transfer(userID, jsonBody.To, jsonBody.Amount, transferOptions(c, jsonBody)...)
+		if err != nil {
+			return renderTransferError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"message": "Transfer successful", "data": trx})
+	}
+}
+
+// QuoteTransfer shows what a transfer would cost, fee included, without
+// making it.
+func QuoteTransfer(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody TransferRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		quote, err := transactionAggregator.QuoteTransfer(userID, jsonBody.To, jsonBody.Amount, transferOptions(c, jsonBody)...)
+		if err != nil {
+			return renderTransferError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": quote})
+	}
+}
+
+func transferOptions(c echo.Context, jsonBody TransferRequest) []aggregation.TransactionOption {
+	return append(transactionOptions(c),
+		aggregation.WithCurrency(jsonBody.Currency),
+		aggregation.WithTargetCurrency(jsonBody.ToCurrency),
+		aggregation.WithSourceWallet(jsonBody.FromWallet),
+		aggregation.WithReference(jsonBody.Reference),
+		aggregation.WithNote(jsonBody.Note),
+	)
+}
+
+func renderTransferError(c echo.Context, err error) error {
+	if err == aggregation.ErrInsuficientFound {
+		return c.JSON(http.StatusBadRequest, H{"error": "Insufficient funds"})
+	}
+	if errors.Is(err, db.ErrOverloaded) {
+		return renderOverloaded(c)
+	}
+	if errors.Is(err, aggregation.ErrIdempotencyKeyReused) {
+		return renderIdempotencyKeyReused(c)
+	}
+	if walletUnavailable(err) {
+		return renderWalletUnavailable(c, err)
+	}
+	var limitErr *aggregation.LimitError
+	if errors.As(err, &limitErr) {
+		return renderLimitExceeded(c, limitErr)
+	}
+	var riskErr *aggregation.RiskError
+	if errors.As(err, &riskErr) {
+		return renderRiskDecision(c, riskErr)
+	}
+	var amountErr *aggregation.AmountError
+	if errors.As(err, &amountErr) {
+		return renderFieldError(c, amountErr.Field, amountErr.Error())
+	}
+	if errors.Is(err, aggregation.ErrRateNotFound) {
+		return renderFieldError(c, "to_currency", err.Error())
+	}
+	if errors.Is(err, aggregation.ErrWalletNotFound) {
+		return renderFieldError(c, "from_wallet", err.Error())
+	}
+	if errors.Is(err, aggregation.ErrSameWallet) {
+		return renderFieldError(c, "to", err.Error())
+	}
+	return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+}
+
+func GetTransaction(transactionRepo *repository.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		trx, err := transactionRepo.FindById(c.Param("id"))
+		if err != nil && err != db.ErrNotFound {
 			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
 		}
 
-		return c.JSON(http.StatusOK, H{"message": "Transfer successful"})
+		// only both sides of the transaction can see it
+		if err == db.ErrNotFound || (trx.SourceUserID != userID && trx.TargetUserID != userID) {
+			return c.JSON(http.StatusNotFound, H{
+				"errors": []H{
+					{
+						"detail": "transaction not found",
+					},
+				},
+			})
+		}
+
+		return c.JSON(http.StatusOK, H{"data": trx})
 	}
 }
 
@@ -113,6 +259,11 @@ func TopTransfer(mutationRepo *repository.Mutation) echo.HandlerFunc {
 		top5Outgoing := make([]entity.Mutation, 0, 5)
 
 		for _, mu := range mutations {
+			// moves between own pockets are not transfers
+			if mu.CounterpartyUserID == mu.UserID {
+				continue
+			}
+
 			if mu.Type == entity.MutationTypeCredit && len(top5Incoming) < 5 {
 				top5Incoming = append(top5Incoming, mu)
 			} else if mu.Type == entity.MutationTypeDebit && len(top5Outgoing) < 5 {
@@ -128,3 +279,140 @@ func TopTransfer(mutationRepo *repository.Mutation) echo.HandlerFunc {
 		})
 	}
 }
+
+// transactionOptions reads the per request options out of the headers.
+func transactionOptions(c echo.Context) []aggregation.TransactionOption {
+	opts := []aggregation.TransactionOption{}
+
+	if key := c.Request().Header.Get("Idempotency-Key"); key != "" {
+		opts = append(opts, aggregation.WithIdempotencyKey(key))
+	}
+
+	return opts
+}
+
+func renderIdempotencyKeyReused(c echo.Context) error {
+	return c.JSON(http.StatusConflict, H{
+		"errors": []H{
+			{
+				"detail": "idempotency key already used with a different payload",
+			},
+		},
+	})
+}
+
+type RefundRequest struct {
+	Amount int    `json:"amount"` // zero refunds whatever is left
+	Reason string `json:"reason"`
+}
+
+type ReverseRequest struct {
+	Reason string `json:"reason"`
+}
+
+// Refund lets the recipient of a transaction give part of it back.
+func Refund(transactionAggregator *aggregation.Transaction, transactionRepo *repository.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody RefundRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		original, err := transactionRepo.FindById(c.Param("id"))
+		if err == db.ErrNotFound || (err == nil && original.TargetUserID != userID) {
+			err = aggregation.ErrTransactionNotFound
+		}
+		if err != nil {
+			return renderRefundError(c, err)
+		}
+
+		amount := jsonBody.Amount
+		if amount == 0 {
+			amount = original.Amount - original.Refunded
+		}
+
+		trx, err := transactionAggregator.Refund(original.ID, amount,
+			append(transactionOptions(c), aggregation.WithNote(jsonBody.Reason))...,
+		)
+		if err != nil {
+			return renderRefundError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"message": "Refund successful", "data": trx})
+	}
+}
+
+// Reverse undoes any transaction, it is mounted behind the admin middleware.
+func Reverse(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var jsonBody ReverseRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		trx, err := transactionAggregator.Reverse(c.Param("id"), jsonBody.Reason)
+		if err != nil {
+			return renderRefundError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"message": "Reverse successful", "data": trx})
+	}
+}
+
+func renderRefundError(c echo.Context, err error) error {
+	if errors.Is(err, aggregation.ErrTransactionNotFound) {
+		return c.JSON(http.StatusNotFound, H{
+			"errors": []H{
+				{
+					"detail": "transaction not found",
+				},
+			},
+		})
+	}
+	if errors.Is(err, aggregation.ErrNotRefundable) || errors.Is(err, aggregation.ErrAlreadyReversed) {
+		return c.JSON(http.StatusConflict, H{
+			"errors": []H{
+				{
+					"detail": err.Error(),
+				},
+			},
+		})
+	}
+	if err == aggregation.ErrInsuficientFound {
+		return c.JSON(http.StatusBadRequest, H{"error": "Insufficient funds"})
+	}
+	if walletUnavailable(err) {
+		return renderWalletUnavailable(c, err)
+	}
+	var amountErr *aggregation.AmountError
+	if errors.As(err, &amountErr) {
+		return renderFieldError(c, amountErr.Field, amountErr.Error())
+	}
+	if errors.Is(err, aggregation.ErrIdempotencyKeyReused) {
+		return renderIdempotencyKeyReused(c)
+	}
+	if errors.Is(err, db.ErrOverloaded) {
+		return renderOverloaded(c)
+	}
+	return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/insomnius/wallet-event-loop/aggregation"
 	"github.com/insomnius/wallet-event-loop/db"
@@ -29,6 +30,21 @@ func setupTest() (*echo.Echo, *aggregation.Transaction, entity.User, entity.User
 	dbInstance.CreateTable("wallets")
 	dbInstance.CreateTable("transactions")
 	dbInstance.CreateTable("mutations")
+	dbInstance.CreateTable("idempotency_keys")
+	dbInstance.CreateTable("ledger_entries")
+	dbInstance.CreateTable("holds")
+	dbInstance.CreateTable("risk_reviews")
+	dbInstance.CreateTable("risk_decisions")
+	dbInstance.CreateTable("audit_logs")
+	dbInstance.CreateTable("scheduled_transfers")
+	dbInstance.CreateTable("scheduled_transfer_runs")
+	dbInstance.CreateTable("payment_requests")
+	dbInstance.CreateTable("bills")
+	dbInstance.CreateTable("escrows")
+	dbInstance.CreateTable("batches")
+	dbInstance.CreateTable("payment_intents")
+	dbInstance.CreateTable("bank_accounts")
+	dbInstance.CreateTable("withdrawals")
 
 	userRepo := repository.NewUser(dbInstance)
 	walletRepo := repository.NewWallet(dbInstance)
@@ -227,3 +243,195 @@ func TestTopTransfer(t *testing.T) {
 	assert.Equal(t, 20, jsonResponse["data"]["outgoing"][0].Amount, jsonResponse)
 	assert.Equal(t, 10, jsonResponse["data"]["outgoing"][1].Amount, jsonResponse)
 }
+
+func TestTransferOverloaded(t *testing.T) {
+	// the loop is never started, every operation is shed
+	dbInstance := db.NewInstance(db.WithMaxQueueWait(time.Millisecond))
+	trxAggregator := aggregation.NewTransaction(
+		repository.NewWallet(dbInstance),
+		repository.NewUser(dbInstance),
+		repository.NewMutation(dbInstance),
+		dbInstance,
+	)
+
+	payloadBytes, _ := json.Marshal(map[string]any{"amount": 10, "to": "user-id-2"})
+	req := httptest.NewRequest(http.MethodPost, "/transactions/transfer", bytes.NewReader(payloadBytes))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+
+	c := echo.New().NewContext(req, rec)
+	c.Set("current_user", entity.UserToken{
+		UserID: "user-id-1",
+	})
+	assert.NoError(t, handler.Transfer(trxAggregator)(c))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+}
+
+func TestTransferIdempotencyKey(t *testing.T) {
+	e, trxAggregator, user1, user2, dbInstance := setupTest()
+
+	transfer := func(key string, amount int) *httptest.ResponseRecorder {
+		payloadBytes, _ := json.Marshal(map[string]any{"amount": amount, "to": user2.ID})
+		req := httptest.NewRequest(http.MethodPost, "/transactions/transfer", bytes.NewReader(payloadBytes))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", key)
+		rec := httptest.NewRecorder()
+
+		c := e.NewContext(req, rec)
+		c.Set("current_user", entity.UserToken{
+			UserID: user1.ID,
+		})
+		assert.NoError(t, handler.Transfer(trxAggregator)(c))
+		return rec
+	}
+
+	first := transfer("retry-me", 30)
+	assert.Equal(t, http.StatusOK, first.Code)
+
+	// client retry after a timeout
+	replay := transfer("retry-me", 30)
+	assert.Equal(t, http.StatusOK, replay.Code)
+	assert.Equal(t, first.Body.String(), replay.Body.String())
+
+	wallet, err := repository.NewWallet(dbInstance).FindByUserID(user1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 70, wallet.Balance, "money should only move once")
+
+	// same key, different payload
+	conflict := transfer("retry-me", 10)
+	assert.Equal(t, http.StatusConflict, conflict.Code)
+
+	wallet, _ = repository.NewWallet(dbInstance).FindByUserID(user1.ID)
+	assert.Equal(t, 70, wallet.Balance)
+}
+
+func TestTransferInvalidAmount(t *testing.T) {
+	e, trxAggregator, user1, user2, dbInstance := setupTest()
+
+	payloadBytes, _ := json.Marshal(map[string]any{"amount": -50, "to": user2.ID})
+	req := httptest.NewRequest(http.MethodPost, "/transactions/transfer", bytes.NewReader(payloadBytes))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+
+	c := e.NewContext(req, rec)
+	c.Set("current_user", entity.UserToken{
+		UserID: user1.ID,
+	})
+	assert.NoError(t, handler.Transfer(trxAggregator)(c))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	var body struct {
+		Errors []map[string]string `json:"errors"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "amount", body.Errors[0]["field"])
+
+	wallet, _ := repository.NewWallet(dbInstance).FindByUserID(user2.ID)
+	assert.Equal(t, 50, wallet.Balance)
+}
+
+func TestGetTransaction(t *testing.T) {
+	e, trxAggregator, user1, user2, dbInstance := setupTest()
+
+	trx, err := trxAggregator.Transfer(user1.ID, user2.ID, 10)
+	assert.NoError(t, err)
+
+	get := func(userID, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/transactions/"+id, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		c.Set("current_user", entity.UserToken{
+			UserID: userID,
+		})
+		assert.NoError(t, handler.GetTransaction(repository.NewTransaction(dbInstance))(c))
+		return rec
+	}
+
+	for _, userID := range []string{user1.ID, user2.ID} {
+		rec := get(userID, trx.ID)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var jsonResponse map[string]entity.Transaction
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&jsonResponse))
+		assert.Equal(t, trx.ID, jsonResponse["data"].ID)
+		assert.Equal(t, "wallet-id-1", jsonResponse["data"].SourceWalletID)
+		assert.Equal(t, "wallet-id-2", jsonResponse["data"].TargetWalletID)
+	}
+
+	assert.Equal(t, http.StatusNotFound, get("stranger", trx.ID).Code)
+	assert.Equal(t, http.StatusNotFound, get(user1.ID, "unknown").Code)
+}
+
+func TestRefund(t *testing.T) {
+	e, trxAggregator, user1, user2, dbInstance := setupTest()
+
+	trx, err := trxAggregator.Transfer(user1.ID, user2.ID, 40)
+	assert.NoError(t, err)
+
+	refund := func(userID string, amount int) *httptest.ResponseRecorder {
+		payloadBytes, _ := json.Marshal(map[string]any{"amount": amount, "reason": "out of stock"})
+		req := httptest.NewRequest(http.MethodPost, "/transactions/"+trx.ID+"/refund", bytes.NewReader(payloadBytes))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(trx.ID)
+		c.Set("current_user", entity.UserToken{
+			UserID: userID,
+		})
+		assert.NoError(t, handler.Refund(trxAggregator, repository.NewTransaction(dbInstance))(c))
+		return rec
+	}
+
+	// only the recipient refunds
+	assert.Equal(t, http.StatusNotFound, refund(user1.ID, 10).Code)
+	assert.Equal(t, http.StatusOK, refund(user2.ID, 10).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, refund(user2.ID, 50).Code)
+
+	// the rest
+	assert.Equal(t, http.StatusOK, refund(user2.ID, 0).Code)
+	assert.Equal(t, http.StatusConflict, refund(user2.ID, 0).Code)
+
+	wallet, _ := repository.NewWallet(dbInstance).FindByUserID(user1.ID)
+	assert.Equal(t, 100, wallet.Balance)
+}
+
+func TestQuoteTransfer(t *testing.T) {
+	e, _, user1, user2, dbInstance := setupTest()
+
+	trxAggregator := aggregation.NewTransaction(
+		repository.NewWallet(dbInstance),
+		repository.NewUser(dbInstance),
+		repository.NewMutation(dbInstance),
+		dbInstance,
+		aggregation.WithFees(aggregation.FeeSchedule{
+			entity.TransactionTypeTransfer: {Flat: 3},
+		}),
+	)
+
+	payloadBytes, _ := json.Marshal(map[string]any{"amount": 20, "to": user2.ID})
+	req := httptest.NewRequest(http.MethodPost, "/transactions/transfer/quote", bytes.NewReader(payloadBytes))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+
+	c := e.NewContext(req, rec)
+	c.Set("current_user", entity.UserToken{
+		UserID: user1.ID,
+	})
+	assert.NoError(t, handler.QuoteTransfer(trxAggregator)(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var jsonResponse map[string]aggregation.Quote
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&jsonResponse))
+	assert.Equal(t, 3, jsonResponse["data"].Fee)
+	assert.Equal(t, 23, jsonResponse["data"].Total)
+
+	wallet, _ := repository.NewWallet(dbInstance).FindByUserID(user1.ID)
+	assert.Equal(t, 100, wallet.Balance)
+}
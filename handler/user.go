@@ -6,6 +6,8 @@ import (
 	"net/http"
 
 	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
 	"github.com/labstack/echo/v4"
 )
 
@@ -16,6 +18,11 @@ type UserRegisterRequest struct {
 
 type UserSigninRequest UserRegisterRequest
 
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
 func UserRegister(authAggregator *aggregation.Authorization) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var jsonBody UserRegisterRequest
@@ -45,6 +52,11 @@ func UserRegister(authAggregator *aggregation.Authorization) echo.HandlerFunc {
 				return err
 			}
 
+			if errors.Is(err, db.ErrOverloaded) {
+				renderOverloaded(c)
+				return err
+			}
+
 			renderInternalServerError(c)
 
 			return err
@@ -97,6 +109,22 @@ func UserSignin(authAggregator *aggregation.Authorization) echo.HandlerFunc {
 				return err
 			}
 
+			if errors.Is(err, aggregation.ErrAccountClosed) {
+				c.JSON(http.StatusForbidden, H{
+					"errors": []H{
+						{
+							"detail": "account is closed",
+						},
+					},
+				})
+				return err
+			}
+
+			if errors.Is(err, db.ErrOverloaded) {
+				renderOverloaded(c)
+				return err
+			}
+
 			renderInternalServerError(c)
 
 			return err
@@ -109,3 +137,43 @@ func UserSignin(authAggregator *aggregation.Authorization) echo.HandlerFunc {
 		})
 	}
 }
+
+func ChangePassword(authAggregator *aggregation.Authorization) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody ChangePasswordRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		if jsonBody.NewPassword == "" {
+			return renderFieldError(c, "new_password", "new password is required")
+		}
+
+		if err := authAggregator.ChangePassword(userID, jsonBody.CurrentPassword, jsonBody.NewPassword); err != nil {
+			if errors.Is(err, aggregation.ErrAuthFailed) {
+				return renderFieldError(c, "current_password", "current password doesn't match")
+			}
+
+			if errors.Is(err, db.ErrOverloaded) {
+				return renderOverloaded(c)
+			}
+
+			renderInternalServerError(c)
+
+			return err
+		}
+
+		return c.JSON(http.StatusOK, H{"message": "Password changed"})
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/insomnius/wallet-event-loop/aggregation"
 	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
 	"github.com/insomnius/wallet-event-loop/handler"
 	"github.com/insomnius/wallet-event-loop/repository"
 	"github.com/labstack/echo/v4"
@@ -143,4 +144,34 @@ func TestUserHandlers(t *testing.T) {
 		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
 		assert.Contains(t, rec.Body.String(), "email and password doesn't match")
 	})
+
+	t.Run("Change Password", func(t *testing.T) {
+		user, err := userRepo.FindByEmail("test@example.com")
+		assert.NoError(t, err)
+
+		changePassword := func(current string) *httptest.ResponseRecorder {
+			body, _ := json.Marshal(map[string]string{
+				"current_password": current,
+				"new_password":     "password456",
+			})
+
+			req := httptest.NewRequest(http.MethodPut, "/users/password", bytes.NewBuffer(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			c := e.NewContext(req, rec)
+			c.Set("current_user", entity.UserToken{UserID: user.ID})
+			handler.ChangePassword(authAggregator)(c)
+			return rec
+		}
+
+		assert.Equal(t, http.StatusUnprocessableEntity, changePassword("wrongpassword").Code)
+		assert.Equal(t, http.StatusOK, changePassword("password123").Code)
+
+		user, _ = userRepo.FindByEmail("test@example.com")
+		assert.False(t, user.PasswordChangedAt.IsZero())
+
+		_, err = authAggregator.SignIn("test@example.com", "password456")
+		assert.NoError(t, err)
+	})
 }
@@ -1,13 +1,21 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/insomnius/wallet-event-loop/aggregation"
 	"github.com/labstack/echo/v4"
 )
 
 type H map[string]any
 
+// OverloadRetryAfter is the Retry-After hint sent when the database sheds a
+// request.
+var OverloadRetryAfter = time.Second
+
 func renderInternalServerError(c echo.Context) {
 	c.JSON(http.StatusInternalServerError, map[string]any{
 		"errors": []map[string]any{
@@ -17,3 +25,60 @@ func renderInternalServerError(c echo.Context) {
 		},
 	})
 }
+
+func renderOverloaded(c echo.Context) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(OverloadRetryAfter.Seconds())))
+	return c.JSON(http.StatusServiceUnavailable, H{
+		"errors": []H{
+			{
+				"detail": "service is overloaded, please retry later",
+			},
+		},
+	})
+}
+
+// renderFieldError answers a request whose field can't be processed.
+func renderFieldError(c echo.Context, field, detail string) error {
+	return c.JSON(http.StatusUnprocessableEntity, H{
+		"errors": []H{
+			{
+				"field":  field,
+				"detail": detail,
+			},
+		},
+	})
+}
+
+func renderLimitExceeded(c echo.Context, err *aggregation.LimitError) error {
+	detail := H{
+		"detail": err.Error(),
+		"limit":  err.Limit,
+		"max":    err.Max,
+	}
+	if !err.ResetsAt.IsZero() {
+		detail["resets_at"] = err.ResetsAt
+	}
+
+	return c.JSON(http.StatusUnprocessableEntity, H{
+		"errors": []H{detail},
+	})
+}
+
+// walletUnavailable tells if err comes from the status of a wallet or an
+// account, see aggregation.Account.
+func walletUnavailable(err error) bool {
+	return errors.Is(err, aggregation.ErrWalletFrozen) ||
+		errors.Is(err, aggregation.ErrWalletSuspended) ||
+		errors.Is(err, aggregation.ErrWalletClosed) ||
+		errors.Is(err, aggregation.ErrAccountClosed)
+}
+
+func renderWalletUnavailable(c echo.Context, err error) error {
+	return c.JSON(http.StatusForbidden, H{
+		"errors": []H{
+			{
+				"detail": err.Error(),
+			},
+		},
+	})
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+)
+
+type OpenWalletRequest struct {
+	Currency string `json:"currency"`
+	Name     string `json:"name"` // creates a pocket, empty opens the main wallet of the currency
+}
+
+func OpenWallet(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody OpenWalletRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		var wallet entity.Wallet
+		var err error
+		if jsonBody.Name == "" {
+			wallet, err = transactionAggregator.OpenWallet(userID, jsonBody.Currency)
+		} else {
+			wallet, err = transactionAggregator.CreatePocket(userID, jsonBody.Name, jsonBody.Currency)
+		}
+		if err != nil {
+			if errors.Is(err, entity.ErrInvalidCurrency) {
+				return renderFieldError(c, "currency", err.Error())
+			}
+			if errors.Is(err, aggregation.ErrInvalidWalletName) {
+				return renderFieldError(c, "name", err.Error())
+			}
+			if walletUnavailable(err) {
+				return renderWalletUnavailable(c, err)
+			}
+			if errors.Is(err, aggregation.ErrWalletAlreadyExists) {
+				return c.JSON(http.StatusConflict, H{
+					"errors": []H{
+						{
+							"detail": "wallet already exists",
+						},
+					},
+				})
+			}
+			if errors.Is(err, db.ErrOverloaded) {
+				return renderOverloaded(c)
+			}
+			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusCreated, H{"data": wallet})
+	}
+}
+
+func ListWallets(walletRepo *repository.Wallet) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		wallets, err := walletRepo.GetByUserID(userID)
+		if err != nil && err != db.ErrNotFound {
+			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+		}
+		if wallets == nil {
+			wallets = []entity.Wallet{}
+		}
+
+		return c.JSON(http.StatusOK, H{"data": wallets})
+	}
+}
+
+type MoveRequest struct {
+	Amount    int    `json:"amount"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Reference string `json:"reference"`
+	Note      string `json:"note"`
+}
+
+func Move(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody MoveRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		opts := append(transactionOptions(c),
+			aggregation.WithReference(jsonBody.Reference),
+			aggregation.WithNote(jsonBody.Note),
+		)
+
+		trx, err := transactionAggregator.Move(userID, jsonBody.From, jsonBody.To, jsonBody.Amount, opts...)
+		if err != nil {
+			if err == aggregation.ErrInsuficientFound {
+				return c.JSON(http.StatusBadRequest, H{"error": "Insufficient funds"})
+			}
+			if errors.Is(err, aggregation.ErrWalletNotFound) {
+				return c.JSON(http.StatusNotFound, H{
+					"errors": []H{
+						{
+							"detail": "wallet not found",
+						},
+					},
+				})
+			}
+			if errors.Is(err, aggregation.ErrSameWallet) {
+				return renderFieldError(c, "to", err.Error())
+			}
+			if errors.Is(err, db.ErrOverloaded) {
+				return renderOverloaded(c)
+			}
+			if errors.Is(err, aggregation.ErrIdempotencyKeyReused) {
+				return renderIdempotencyKeyReused(c)
+			}
+			if walletUnavailable(err) {
+				return renderWalletUnavailable(c, err)
+			}
+			var limitErr *aggregation.LimitError
+			if errors.As(err, &limitErr) {
+				return renderLimitExceeded(c, limitErr)
+			}
+			var amountErr *aggregation.AmountError
+			if errors.As(err, &amountErr) {
+				return renderFieldError(c, amountErr.Field, amountErr.Error())
+			}
+			if errors.Is(err, aggregation.ErrRateNotFound) {
+				return renderFieldError(c, "to", err.Error())
+			}
+			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, H{"message": "Move successful", "data": trx})
+	}
+}
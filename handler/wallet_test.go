@@ -0,0 +1,85 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/handler"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenWallet(t *testing.T) {
+	e, trxAggregator, user1, _, _ := setupTest()
+
+	open := func(currency string) *httptest.ResponseRecorder {
+		payloadBytes, _ := json.Marshal(map[string]string{"currency": currency})
+		req := httptest.NewRequest(http.MethodPost, "/wallets", bytes.NewReader(payloadBytes))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+
+		c := e.NewContext(req, rec)
+		c.Set("current_user", entity.UserToken{
+			UserID: user1.ID,
+		})
+		assert.NoError(t, handler.OpenWallet(trxAggregator)(c))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusCreated, open("USD").Code)
+	assert.Equal(t, http.StatusConflict, open("usd").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, open("US").Code)
+}
+
+func TestListWalletsAndMove(t *testing.T) {
+	e, trxAggregator, user1, _, dbInstance := setupTest()
+
+	savings, err := trxAggregator.CreatePocket(user1.ID, "savings", "IDR")
+	assert.NoError(t, err)
+
+	payloadBytes, _ := json.Marshal(map[string]any{"amount": 40, "from": "wallet-id-1", "to": savings.ID})
+	req := httptest.NewRequest(http.MethodPost, "/wallets/move", bytes.NewReader(payloadBytes))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+
+	c := e.NewContext(req, rec)
+	c.Set("current_user", entity.UserToken{
+		UserID: user1.ID,
+	})
+	assert.NoError(t, handler.Move(trxAggregator)(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/wallet", nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.Set("current_user", entity.UserToken{
+		UserID: user1.ID,
+	})
+	assert.NoError(t, handler.CheckBalance(repository.NewWallet(dbInstance))(c))
+
+	var body struct {
+		Data struct {
+			Balance int             `json:"balance"`
+			Wallets []entity.Wallet `json:"wallets"`
+			Totals  map[string]int  `json:"totals"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, 60, body.Data.Balance)
+	assert.Len(t, body.Data.Wallets, 2)
+	assert.Equal(t, 100, body.Data.Totals["IDR"])
+
+	req = httptest.NewRequest(http.MethodGet, "/wallets", nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.Set("current_user", entity.UserToken{
+		UserID: user1.ID,
+	})
+	assert.NoError(t, handler.ListWallets(repository.NewWallet(dbInstance))(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "savings")
+}
@@ -0,0 +1,233 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+)
+
+// PayoutSecretHeader carries the secret shared with the payout provider on
+// its callbacks.
+const PayoutSecretHeader = "X-Payout-Secret"
+
+type BankAccountRequest struct {
+	BankCode      string `json:"bank_code"`
+	AccountNumber string `json:"account_number"`
+	HolderName    string `json:"holder_name"`
+}
+
+type WithdrawalRequest struct {
+	BankAccount string `json:"bank_account"`
+	Amount      int    `json:"amount"`
+	FromWallet  string `json:"from_wallet"`
+	Reference   string `json:"reference"`
+}
+
+type PayoutCallbackRequest struct {
+	WithdrawalID      string `json:"withdrawal_id"`
+	Status            string `json:"status"` // settled or failed
+	ProviderReference string `json:"provider_reference"`
+	Reason            string `json:"reason"`
+}
+
+func AddBankAccount(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody BankAccountRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		account, err := transactionAggregator.AddBankAccount(userID, jsonBody.BankCode, jsonBody.AccountNumber, jsonBody.HolderName)
+		if err != nil {
+			switch {
+			case errors.Is(err, aggregation.ErrBankCodeRequired):
+				return renderFieldError(c, "bank_code", err.Error())
+			case errors.Is(err, aggregation.ErrInvalidAccountNumber), errors.Is(err, aggregation.ErrBankAccountExists):
+				return renderFieldError(c, "account_number", err.Error())
+			case errors.Is(err, aggregation.ErrHolderNameRequired):
+				return renderFieldError(c, "holder_name", err.Error())
+			}
+			return renderWithdrawalError(c, err)
+		}
+
+		return c.JSON(http.StatusCreated, H{"data": account})
+	}
+}
+
+func BankAccounts(bankAccountRepo *repository.BankAccount) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		accounts, err := bankAccountRepo.GetByUserID(userID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, H{"data": accounts})
+	}
+}
+
+// Withdraw answers 202, the withdrawal stays pending until the payout
+// provider reports the outcome.
+func Withdraw(transactionAggregator *aggregation.Transaction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		var jsonBody WithdrawalRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		opts := append(transactionOptions(c),
+			aggregation.WithSourceWallet(jsonBody.FromWallet),
+			aggregation.WithReference(jsonBody.Reference),
+		)
+
+		withdrawal, err := transactionAggregator.Withdraw(userID, jsonBody.BankAccount, jsonBody.Amount, opts...)
+		if err != nil {
+			if errors.Is(err, aggregation.ErrBankAccountNotFound) {
+				return renderFieldError(c, "bank_account", err.Error())
+			}
+			return renderWithdrawalError(c, err)
+		}
+
+		return c.JSON(http.StatusAccepted, H{"data": withdrawal})
+	}
+}
+
+// Withdrawals lists the withdrawals of the user, all of them unless
+// ?status= asks for pending, settled or failed.
+func Withdrawals(withdrawalRepo *repository.Withdrawal) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		withdrawals, err := withdrawalRepo.GetByUserID(userID, entity.WithdrawalStatus(c.QueryParam("status")))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, H{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, H{"data": withdrawals})
+	}
+}
+
+func GetWithdrawal(withdrawalRepo *repository.Withdrawal) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("current_user").(entity.UserToken).UserID
+
+		withdrawal, err := withdrawalRepo.FindById(c.Param("id"))
+		if err == db.ErrNotFound || (err == nil && withdrawal.UserID != userID) {
+			err = aggregation.ErrWithdrawalNotFound
+		}
+		if err != nil {
+			return renderWithdrawalError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": withdrawal})
+	}
+}
+
+// PayoutCallback is called by the payout provider with the outcome of a
+// payout. Callbacks without the shared secret are refused, and all of them
+// are when no secret is configured.
+func PayoutCallback(transactionAggregator *aggregation.Transaction, secret string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		given := c.Request().Header.Get(PayoutSecretHeader)
+		if secret == "" || subtle.ConstantTimeCompare([]byte(given), []byte(secret)) != 1 {
+			return c.JSON(http.StatusUnauthorized, H{
+				"errors": []H{
+					{
+						"detail": "unauthorized",
+					},
+				},
+			})
+		}
+
+		var jsonBody PayoutCallbackRequest
+
+		if err := json.NewDecoder(c.Request().Body).Decode((&jsonBody)); err != nil {
+			c.JSON(http.StatusBadRequest, H{
+				"errors": []H{
+					{
+						"detail": "bad json request",
+					},
+				},
+			})
+
+			return err
+		}
+
+		var withdrawal entity.Withdrawal
+		var err error
+		switch entity.WithdrawalStatus(jsonBody.Status) {
+		case entity.WithdrawalStatusSettled:
+			withdrawal, err = transactionAggregator.SettleWithdrawal(jsonBody.WithdrawalID, jsonBody.ProviderReference)
+		case entity.WithdrawalStatusFailed:
+			withdrawal, err = transactionAggregator.FailWithdrawal(jsonBody.WithdrawalID, jsonBody.Reason)
+		default:
+			return renderFieldError(c, "status", "status is settled or failed")
+		}
+		if err != nil {
+			return renderWithdrawalError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, H{"data": withdrawal})
+	}
+}
+
+func renderWithdrawalError(c echo.Context, err error) error {
+	if errors.Is(err, aggregation.ErrWithdrawalNotFound) {
+		return c.JSON(http.StatusNotFound, H{
+			"errors": []H{
+				{
+					"detail": "withdrawal not found",
+				},
+			},
+		})
+	}
+	if errors.Is(err, aggregation.ErrWithdrawalNotPending) {
+		return c.JSON(http.StatusConflict, H{
+			"errors": []H{
+				{
+					"detail": err.Error(),
+				},
+			},
+		})
+	}
+	if errors.Is(err, aggregation.ErrPayoutsUnavailable) {
+		return c.JSON(http.StatusServiceUnavailable, H{
+			"errors": []H{
+				{
+					"detail": err.Error(),
+				},
+			},
+		})
+	}
+	return renderTransferError(c, err)
+}
@@ -0,0 +1,91 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/aggregation"
+	"github.com/insomnius/wallet-event-loop/entity"
+	"github.com/insomnius/wallet-event-loop/handler"
+	"github.com/insomnius/wallet-event-loop/repository"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithdrawalHandlers(t *testing.T) {
+	e, trxAggregator, user1, user2, dbInstance := setupTest()
+	walletRepo := repository.NewWallet(dbInstance)
+	withdrawalRepo := repository.NewWithdrawal(dbInstance)
+
+	call := func(h echo.HandlerFunc, userID, id string, payload any) *httptest.ResponseRecorder {
+		payloadBytes, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "/withdrawals", bytes.NewReader(payloadBytes))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(handler.PayoutSecretHeader, "secret")
+		rec := httptest.NewRecorder()
+
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		c.Set("current_user", entity.UserToken{
+			UserID: userID,
+		})
+		assert.NoError(t, h(c))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, call(handler.Withdraw(trxAggregator), user1.ID, "", map[string]any{"amount": 10}).Code)
+
+	provider := &aggregation.FakePayoutProvider{}
+	withPayouts := aggregation.NewTransaction(walletRepo, repository.NewUser(dbInstance), repository.NewMutation(dbInstance), dbInstance,
+		aggregation.WithPayoutProvider(provider),
+	)
+
+	addAccount := handler.AddBankAccount(withPayouts)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(addAccount, user1.ID, "", map[string]any{"bank_code": "BCA", "account_number": "abc", "holder_name": "One"}).Code)
+
+	rec := call(addAccount, user1.ID, "", map[string]any{"bank_code": "BCA", "account_number": "1234567890", "holder_name": "One"})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var account map[string]entity.BankAccount
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&account))
+
+	withdraw := handler.Withdraw(withPayouts)
+	assert.Equal(t, http.StatusUnprocessableEntity, call(withdraw, user2.ID, "", map[string]any{"bank_account": account["data"].ID, "amount": 10}).Code)
+	assert.Equal(t, http.StatusBadRequest, call(withdraw, user1.ID, "", map[string]any{"bank_account": account["data"].ID, "amount": 500}).Code)
+
+	rec = call(withdraw, user1.ID, "", map[string]any{"bank_account": account["data"].ID, "amount": 60})
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	var created map[string]entity.Withdrawal
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	id := created["data"].ID
+	assert.Equal(t, entity.WithdrawalStatusPending, created["data"].Status)
+
+	assert.Eventually(t, func() bool { return len(provider.Payouts()) == 1 }, time.Second, time.Millisecond)
+
+	assert.Equal(t, http.StatusOK, call(handler.GetWithdrawal(withdrawalRepo), user1.ID, id, nil).Code)
+	assert.Equal(t, http.StatusNotFound, call(handler.GetWithdrawal(withdrawalRepo), user2.ID, id, nil).Code)
+
+	assert.Equal(t, http.StatusUnauthorized, call(handler.PayoutCallback(withPayouts, ""), "", "", map[string]any{"withdrawal_id": id, "status": "settled"}).Code)
+	assert.Equal(t, http.StatusUnauthorized, call(handler.PayoutCallback(withPayouts, "other"), "", "", map[string]any{"withdrawal_id": id, "status": "settled"}).Code)
+
+	callback := handler.PayoutCallback(withPayouts, "secret")
+	assert.Equal(t, http.StatusUnprocessableEntity, call(callback, "", "", map[string]any{"withdrawal_id": id, "status": "done"}).Code)
+	assert.Equal(t, http.StatusNotFound, call(callback, "", "", map[string]any{"withdrawal_id": "unknown", "status": "settled"}).Code)
+	assert.Equal(t, http.StatusOK, call(callback, "", "", map[string]any{"withdrawal_id": id, "status": "settled", "provider_reference": "bank-1"}).Code)
+	assert.Equal(t, http.StatusConflict, call(callback, "", "", map[string]any{"withdrawal_id": id, "status": "failed"}).Code)
+
+	rec = call(handler.Withdrawals(withdrawalRepo), user1.ID, "", nil)
+	var listed map[string][]entity.Withdrawal
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&listed))
+	assert.Len(t, listed["data"], 1)
+	assert.Equal(t, entity.WithdrawalStatusSettled, listed["data"][0].Status)
+	assert.Equal(t, "bank-1", listed["data"][0].ProviderReference)
+
+	wallet, _ := walletRepo.FindByUserID(user1.ID)
+	assert.Equal(t, 40, wallet.Balance)
+	assert.Equal(t, 0, wallet.Held)
+}
@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math/big"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/insomnius/wallet-event-loop/aggregation"
 	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
 	"github.com/insomnius/wallet-event-loop/handler"
 	"github.com/insomnius/wallet-event-loop/handler/middleware"
 	"github.com/insomnius/wallet-event-loop/repository"
@@ -22,7 +27,17 @@ func main() {
 	fmt.Println("Starting e-wallet services...")
 
 	fmt.Println("Starting e-wallet databases...")
-	dbInstance := db.NewInstance()
+
+	// DB_ENGINE is one of memory (default), log or bolt
+	storage, err := db.OpenStorage(os.Getenv("DB_ENGINE"), dbPath())
+	if err != nil {
+		fmt.Println("Error opening e-wallet database. Error:", err)
+		os.Exit(1)
+	}
+	dbInstance := db.NewInstance(
+		db.WithStorage(storage),
+		db.WithMaxQueueWait(maxQueueWait()),
+	)
 
 	// Starting database instance
 	go func() {
@@ -30,10 +45,25 @@ func main() {
 	}()
 
 	dbInstance.CreateTable("users")
-	dbInstance.CreateTable("user_tokens")
+	dbInstance.CreateTable("user_tokens", db.WithTTL(24*time.Hour))
 	dbInstance.CreateTable("wallets")
 	dbInstance.CreateTable("transactions")
 	dbInstance.CreateTable("mutations")
+	dbInstance.CreateTable("idempotency_keys", db.WithTTL(24*time.Hour))
+	dbInstance.CreateTable("ledger_entries")
+	dbInstance.CreateTable("holds")
+	dbInstance.CreateTable("risk_reviews")
+	dbInstance.CreateTable("risk_decisions")
+	dbInstance.CreateTable("audit_logs")
+	dbInstance.CreateTable("scheduled_transfers")
+	dbInstance.CreateTable("scheduled_transfer_runs")
+	dbInstance.CreateTable("payment_requests")
+	dbInstance.CreateTable("bills")
+	dbInstance.CreateTable("escrows")
+	dbInstance.CreateTable("batches")
+	dbInstance.CreateTable("payment_intents")
+	dbInstance.CreateTable("bank_accounts")
+	dbInstance.CreateTable("withdrawals")
 
 	e := echo.New()
 	e.Use(echoMiddleware.Logger())
@@ -43,6 +73,20 @@ func main() {
 	userRepo := repository.NewUser(dbInstance)
 	userTokenRepo := repository.NewUserToken(dbInstance)
 	mutationRepo := repository.NewMutation(dbInstance)
+	transactionRepo := repository.NewTransaction(dbInstance)
+	holdRepo := repository.NewHold(dbInstance)
+	riskReviewRepo := repository.NewRiskReview(dbInstance)
+	riskDecisionRepo := repository.NewRiskDecision(dbInstance)
+	auditLogRepo := repository.NewAuditLog(dbInstance)
+	scheduledTransferRepo := repository.NewScheduledTransfer(dbInstance)
+	scheduledTransferRunRepo := repository.NewScheduledTransferRun(dbInstance)
+	paymentRequestRepo := repository.NewPaymentRequest(dbInstance)
+	billRepo := repository.NewBill(dbInstance)
+	escrowRepo := repository.NewEscrow(dbInstance)
+	batchRepo := repository.NewBatch(dbInstance)
+	paymentIntentRepo := repository.NewPaymentIntent(dbInstance)
+	bankAccountRepo := repository.NewBankAccount(dbInstance)
+	withdrawalRepo := repository.NewWithdrawal(dbInstance)
 
 	authAggregator := aggregation.NewAuthorization(
 		walletRepo,
@@ -51,18 +95,37 @@ func main() {
 		dbInstance,
 	)
 
+	accountAggregator := aggregation.NewAccount(
+		walletRepo,
+		userRepo,
+		userTokenRepo,
+		dbInstance,
+	)
+
 	trxAggregator := aggregation.NewTransaction(
 		walletRepo,
 		userRepo,
 		mutationRepo,
 		dbInstance,
+		aggregation.WithRateProvider(fxRates()),
+		aggregation.WithFXSpread(fxSpread()),
+		aggregation.WithFees(fees()),
+		aggregation.WithLimits(limits()),
+		aggregation.WithRisk(risk()),
+		aggregation.WithPaymentCallback(aggregation.HTTPPaymentCallback{}),
+		aggregation.WithPayoutProvider(payoutProvider()),
 	)
 
 	e.POST("/users", handler.UserRegister(authAggregator))
 	e.POST("/users/signin", handler.UserSignin(authAggregator))
+	e.POST("/merchants", handler.MerchantRegister(authAggregator))
 
 	oauthMiddleware := middleware.Oauth(func(c echo.Context, token string) (bool, error) {
 		t, err := userTokenRepo.FindByToken(token)
+		if err == db.ErrNotFound {
+			// unknown or expired token
+			return false, nil
+		}
 		if err != nil {
 			return false, err
 		}
@@ -71,10 +134,109 @@ func main() {
 		return true, nil
 	})
 
-	e.GET("/wallet", handler.CheckBalance(walletRepo), oauthMiddleware)
-	e.GET("/wallet/top-transfer", handler.TopTransfer(mutationRepo), oauthMiddleware)
-	e.POST("/transactions/topup", handler.TopUp(trxAggregator), oauthMiddleware)
-	e.POST("/transactions/transfer", handler.Transfer(trxAggregator), oauthMiddleware)
+	authenticated := []echo.MiddlewareFunc{oauthMiddleware}
+	if limit, err := strconv.ParseFloat(os.Getenv("RATE_LIMIT_PER_USER"), 64); err == nil && limit > 0 {
+		burst, _ := strconv.Atoi(os.Getenv("RATE_LIMIT_BURST"))
+		authenticated = append(authenticated, middleware.UserRateLimit(limit, max(burst, 1)))
+	}
+
+	e.PUT("/users/password", handler.ChangePassword(authAggregator), authenticated...)
+	e.GET("/wallet", handler.CheckBalance(walletRepo), authenticated...)
+	e.GET("/wallets", handler.ListWallets(walletRepo), authenticated...)
+	e.POST("/wallets", handler.OpenWallet(trxAggregator), authenticated...)
+	e.POST("/wallets/move", handler.Move(trxAggregator), authenticated...)
+	e.GET("/wallet/top-transfer", handler.TopTransfer(mutationRepo), authenticated...)
+	e.POST("/transactions/topup", handler.TopUp(trxAggregator), authenticated...)
+	e.POST("/transactions/transfer", handler.Transfer(trxAggregator), authenticated...)
+	e.POST("/transactions/transfer/quote", handler.QuoteTransfer(trxAggregator), authenticated...)
+	e.POST("/transactions/batch", handler.BatchTransfer(trxAggregator), authenticated...)
+	e.GET("/transactions/batch/:id", handler.GetBatch(batchRepo), authenticated...)
+	e.GET("/transactions/:id", handler.GetTransaction(transactionRepo), authenticated...)
+	e.POST("/transactions/:id/refund", handler.Refund(trxAggregator, transactionRepo), authenticated...)
+	e.POST("/holds", handler.CreateHold(trxAggregator), authenticated...)
+	e.GET("/holds/:id", handler.GetHold(holdRepo), authenticated...)
+	e.POST("/holds/:id/capture", handler.CaptureHold(trxAggregator, holdRepo), authenticated...)
+	e.POST("/holds/:id/void", handler.VoidHold(trxAggregator, holdRepo), authenticated...)
+	e.POST("/scheduled-transfers", handler.CreateScheduledTransfer(trxAggregator), authenticated...)
+	e.GET("/scheduled-transfers", handler.ListScheduledTransfers(scheduledTransferRepo), authenticated...)
+	e.GET("/scheduled-transfers/:id", handler.GetScheduledTransfer(scheduledTransferRepo), authenticated...)
+	e.GET("/scheduled-transfers/:id/runs", handler.ScheduledTransferRuns(scheduledTransferRepo, scheduledTransferRunRepo), authenticated...)
+	e.PUT("/scheduled-transfers/:id", handler.UpdateScheduledTransfer(trxAggregator), authenticated...)
+	e.DELETE("/scheduled-transfers/:id", handler.CancelScheduledTransfer(trxAggregator), authenticated...)
+	e.POST("/payment-requests", handler.CreatePaymentRequest(trxAggregator), authenticated...)
+	e.GET("/payment-requests/incoming", handler.IncomingPaymentRequests(paymentRequestRepo), authenticated...)
+	e.GET("/payment-requests/outgoing", handler.OutgoingPaymentRequests(paymentRequestRepo), authenticated...)
+	e.GET("/payment-requests/:id", handler.GetPaymentRequest(paymentRequestRepo), authenticated...)
+	e.POST("/payment-requests/:id/accept", handler.AcceptPaymentRequest(trxAggregator), authenticated...)
+	e.POST("/payment-requests/:id/decline", handler.DeclinePaymentRequest(trxAggregator), authenticated...)
+	e.POST("/payment-requests/:id/cancel", handler.CancelPaymentRequest(trxAggregator), authenticated...)
+	e.POST("/bills", handler.CreateBill(trxAggregator), authenticated...)
+	e.GET("/bills", handler.ListBills(billRepo), authenticated...)
+	e.GET("/bills/:id", handler.GetBill(billRepo), authenticated...)
+	e.POST("/bills/:id/pay", handler.PayBill(trxAggregator), authenticated...)
+	e.POST("/bills/:id/cancel", handler.CancelBill(trxAggregator), authenticated...)
+	e.POST("/merchant/payment-intents", handler.CreatePaymentIntent(trxAggregator), authenticated...)
+	e.GET("/merchant/payment-intents", handler.MerchantPayments(paymentIntentRepo), authenticated...)
+	e.POST("/merchant/payment-intents/:id/cancel", handler.CancelPaymentIntent(trxAggregator), authenticated...)
+	e.POST("/merchant/payment-intents/:id/callback", handler.ResendPaymentCallback(trxAggregator), authenticated...)
+	e.GET("/merchant/settlement", handler.MerchantSettlement(trxAggregator), authenticated...)
+	e.GET("/merchant/qr", handler.MerchantQR(trxAggregator), authenticated...)
+	e.GET("/merchant/payment-intents/:id/qr", handler.PaymentIntentQR(trxAggregator), authenticated...)
+	e.GET("/payment-intents/:id", handler.GetPaymentIntent(paymentIntentRepo), authenticated...)
+	e.POST("/payment-intents/:id/pay", handler.PayPaymentIntent(trxAggregator), authenticated...)
+	e.POST("/payments/qr", handler.PayQR(trxAggregator), authenticated...)
+	e.POST("/payments/qr/decode", handler.DecodeQR(), authenticated...)
+	e.POST("/escrows", handler.OpenEscrow(trxAggregator), authenticated...)
+	e.GET("/escrows", handler.ListEscrows(escrowRepo), authenticated...)
+	e.GET("/escrows/:id", handler.GetEscrow(escrowRepo), authenticated...)
+	e.POST("/escrows/:id/confirm", handler.ConfirmEscrow(trxAggregator), authenticated...)
+	e.POST("/escrows/:id/cancel", handler.CancelEscrow(trxAggregator), authenticated...)
+	e.POST("/escrows/:id/dispute", handler.DisputeEscrow(trxAggregator), authenticated...)
+	e.POST("/escrows/:id/dispute/withdraw", handler.WithdrawDispute(trxAggregator), authenticated...)
+	e.POST("/bank-accounts", handler.AddBankAccount(trxAggregator), authenticated...)
+	e.GET("/bank-accounts", handler.BankAccounts(bankAccountRepo), authenticated...)
+	e.POST("/withdrawals", handler.Withdraw(trxAggregator), authenticated...)
+	e.GET("/withdrawals", handler.Withdrawals(withdrawalRepo), authenticated...)
+	e.GET("/withdrawals/:id", handler.GetWithdrawal(withdrawalRepo), authenticated...)
+
+	// PAYOUT_CALLBACK_SECRET is shared with the payout provider, callbacks
+	// are refused without it
+	e.POST("/payouts/callback", handler.PayoutCallback(trxAggregator, os.Getenv("PAYOUT_CALLBACK_SECRET")))
+
+	// ADMIN_USER_IDS is a comma separated list of users promoted to the
+	// admin role at startup, admins are only ever recognised by their role
+	for _, userID := range strings.Split(os.Getenv("ADMIN_USER_IDS"), ",") {
+		if userID = strings.TrimSpace(userID); userID == "" {
+			continue
+		}
+		if _, err := accountAggregator.Promote("system", userID, "ADMIN_USER_IDS"); err != nil {
+			fmt.Println("Error promoting admin", userID, "Error:", err)
+		}
+	}
+	adminMiddleware := middleware.Admin(func(c echo.Context) (bool, error) {
+		user, err := userRepo.FindById(c.Get("current_user").(entity.UserToken).UserID)
+		if err == db.ErrNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		return user.IsAdmin(), nil
+	})
+	admin := append(append([]echo.MiddlewareFunc{}, authenticated...), adminMiddleware)
+
+	e.POST("/admin/transactions/:id/reverse", handler.Reverse(trxAggregator), admin...)
+	e.GET("/admin/risk/reviews", handler.RiskReviews(riskReviewRepo), admin...)
+	e.POST("/admin/risk/reviews/:id/approve", handler.ApproveReview(trxAggregator), admin...)
+	e.POST("/admin/risk/reviews/:id/reject", handler.RejectReview(trxAggregator), admin...)
+	e.GET("/admin/risk/decisions", handler.RiskDecisions(riskDecisionRepo), admin...)
+	e.POST("/admin/wallets/:id/status", handler.SetWalletStatus(accountAggregator), admin...)
+	e.POST("/admin/users/:id/close", handler.CloseAccount(accountAggregator), admin...)
+	e.GET("/admin/audit-logs", handler.AuditLogs(auditLogRepo), admin...)
+	e.GET("/admin/escrows/disputed", handler.DisputedEscrows(escrowRepo), admin...)
+	e.POST("/admin/escrows/:id/resolve", handler.ResolveEscrow(trxAggregator), admin...)
+	e.GET("/metrics/lanes", handler.LaneMetrics(dbInstance), admin...)
 
 	go func() {
 		port := "8000"
@@ -101,6 +263,157 @@ func main() {
 		fmt.Println("Error shutting down the server. Error:", err)
 	}
 
-	dbInstance.Close()
 	fmt.Println("Closing e-wallet database...")
+	if err := dbInstance.Close(); err != nil {
+		fmt.Println("Error closing the database. Error:", err)
+	}
+}
+
+func dbPath() string {
+	if os.Getenv("DB_PATH") != "" {
+		return os.Getenv("DB_PATH")
+	}
+
+	switch os.Getenv("DB_ENGINE") {
+	case db.EngineLog:
+		return "wallet.log"
+	case db.EngineBolt:
+		return "wallet.db"
+	}
+	return ""
+}
+
+// DB_MAX_QUEUE_WAIT is a duration like 500ms, 0 waits forever.
+func maxQueueWait() time.Duration {
+	if wait, err := time.ParseDuration(os.Getenv("DB_MAX_QUEUE_WAIT")); err == nil {
+		return wait
+	}
+	return 5 * time.Second
+}
+
+// FX_RATES is a comma separated list like USD/IDR=15500,SGD/IDR=11500, rates
+// are per minor unit.
+func fxRates() aggregation.StaticRateProvider {
+	rates := aggregation.StaticRateProvider{}
+	for _, pair := range strings.Split(os.Getenv("FX_RATES"), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+
+		rate, ok := new(big.Rat).SetString(value)
+		if !ok {
+			fmt.Println("Ignoring invalid FX rate:", pair)
+			continue
+		}
+		rates[strings.ToUpper(name)] = rate
+	}
+	return rates
+}
+
+// FEES is a JSON object of fee rules by transaction type, like
+// {"transfer": {"flat": 1000, "basis_points": 50, "max": 25000}}.
+func fees() aggregation.FeeSchedule {
+	schedule := aggregation.FeeSchedule{}
+	if os.Getenv("FEES") == "" {
+		return schedule
+	}
+
+	if err := json.Unmarshal([]byte(os.Getenv("FEES")), &schedule); err != nil {
+		fmt.Println("Ignoring invalid FEES. Error:", err)
+		return aggregation.FeeSchedule{}
+	}
+	return schedule
+}
+
+// LIMITS is a JSON limit policy, like
+// {"default": {"per_transaction": 10000000, "daily_outgoing": 20000000}, "tiers": {"verified": {...}}}.
+func limits() aggregation.LimitPolicy {
+	policy := aggregation.LimitPolicy{}
+	if os.Getenv("LIMITS") == "" {
+		return policy
+	}
+
+	if err := json.Unmarshal([]byte(os.Getenv("LIMITS")), &policy); err != nil {
+		fmt.Println("Ignoring invalid LIMITS. Error:", err)
+		return aggregation.LimitPolicy{}
+	}
+	return policy
+}
+
+// RISK is a JSON object with the thresholds and the built-in rules to turn
+// on, like {"review_score": 50, "block_score": 100, "new_recipient":
+// {"amount": 5000000, "points": 30}, "round_trip": {"window": "1h", "points": 30}}.
+func risk() aggregation.RiskPolicy {
+	type ruleConfig struct {
+		Window     string `json:"window"`
+		Points     int    `json:"points"`
+		Amount     int    `json:"amount"`
+		Recipients int    `json:"recipients"`
+	}
+	var config struct {
+		ReviewScore          int         `json:"review_score"`
+		BlockScore           int         `json:"block_score"`
+		NewRecipient         *ruleConfig `json:"new_recipient"`
+		ManyRecipients       *ruleConfig `json:"many_recipients"`
+		RecentPasswordChange *ruleConfig `json:"recent_password_change"`
+		RoundTrip            *ruleConfig `json:"round_trip"`
+	}
+	if os.Getenv("RISK") == "" {
+		return aggregation.RiskPolicy{}
+	}
+
+	if err := json.Unmarshal([]byte(os.Getenv("RISK")), &config); err != nil {
+		fmt.Println("Ignoring invalid RISK. Error:", err)
+		return aggregation.RiskPolicy{}
+	}
+
+	window := func(rule *ruleConfig) time.Duration {
+		d, err := time.ParseDuration(rule.Window)
+		if err != nil {
+			fmt.Println("Invalid RISK window, using 1h:", rule.Window)
+			return time.Hour
+		}
+		return d
+	}
+
+	policy := aggregation.RiskPolicy{ReviewScore: config.ReviewScore, BlockScore: config.BlockScore}
+	if rule := config.NewRecipient; rule != nil {
+		policy.Rules = append(policy.Rules, aggregation.NewRecipientRule{Amount: rule.Amount, Points: rule.Points})
+	}
+	if rule := config.ManyRecipients; rule != nil {
+		policy.Rules = append(policy.Rules, aggregation.ManyRecipientsRule{Recipients: rule.Recipients, Window: window(rule), Points: rule.Points})
+	}
+	if rule := config.RecentPasswordChange; rule != nil {
+		policy.Rules = append(policy.Rules, aggregation.PasswordChangeRule{Window: window(rule), Points: rule.Points})
+	}
+	if rule := config.RoundTrip; rule != nil {
+		policy.Rules = append(policy.Rules, aggregation.RoundTripRule{Window: window(rule), Points: rule.Points})
+	}
+	return policy
+}
+
+// FX_SPREAD is the cut kept from converted amounts, like 0.005.
+func fxSpread() *big.Rat {
+	if spread, ok := new(big.Rat).SetString(os.Getenv("FX_SPREAD")); ok {
+		return spread
+	}
+	return new(big.Rat)
+}
+
+// PAYOUT_PROVIDER picks where withdrawals are paid out. No bank is wired
+// yet, "fake" accepts every payout and leaves it pending until
+// POST /payouts/callback, for local runs only. Without it withdrawals are
+// unavailable.
+func payoutProvider() aggregation.PayoutProvider {
+	switch os.Getenv("PAYOUT_PROVIDER") {
+	case "":
+		return nil
+	case "fake":
+		fmt.Println("Using the fake payout provider, no money leaves the wallets")
+		return &aggregation.FakePayoutProvider{}
+	}
+
+	fmt.Println("Ignoring unknown PAYOUT_PROVIDER:", os.Getenv("PAYOUT_PROVIDER"))
+	return nil
 }
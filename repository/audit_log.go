@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"sort"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+type AuditLog struct {
+	db *db.Instance
+}
+
+func NewAuditLog(db *db.Instance) *AuditLog {
+	return &AuditLog{
+		db: db,
+	}
+}
+
+func (u *AuditLog) Put(log entity.AuditLog, txs ...*db.Transaction) error {
+	t, err := u.table(txs...)
+	if err != nil {
+		return err
+	}
+
+	return t.ReplaceOrStore(log.ID, log)
+}
+
+// GetBySubjectID returns the logs of a wallet or user, oldest first.
+func (u *AuditLog) GetBySubjectID(subjectID string, txs ...*db.Transaction) ([]entity.AuditLog, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := t.Filter(func(v any) bool {
+		return v.(entity.AuditLog).SubjectID == subjectID
+	})
+
+	converted := []entity.AuditLog{}
+	for _, v := range filtered {
+		converted = append(converted, v.(entity.AuditLog))
+	}
+
+	sort.Slice(converted, func(i, j int) bool {
+		return converted[i].CreatedAt.Before(converted[j].CreatedAt)
+	})
+
+	return converted, nil
+}
+
+func (u *AuditLog) table(txs ...*db.Transaction) (*db.Table, error) {
+	// if there is open transactions
+	// then the db will use transactions instead of default db connections
+	if len(txs) > 0 {
+		return txs[0].GetTable("audit_logs")
+	}
+	return u.db.GetTable("audit_logs")
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"sort"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+type BankAccount struct {
+	db *db.Instance
+}
+
+func NewBankAccount(db *db.Instance) *BankAccount {
+	return &BankAccount{
+		db: db,
+	}
+}
+
+func (u *BankAccount) FindById(id string, txs ...*db.Transaction) (entity.BankAccount, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return entity.BankAccount{}, err
+	}
+
+	v, err := t.FindByID(id)
+	if err != nil {
+		return entity.BankAccount{}, err
+	}
+
+	return v.(entity.BankAccount), nil
+}
+
+func (u *BankAccount) Put(bankAccount entity.BankAccount, txs ...*db.Transaction) error {
+	t, err := u.table(txs...)
+	if err != nil {
+		return err
+	}
+
+	return t.ReplaceOrStore(bankAccount.ID, bankAccount)
+}
+
+// GetByUserID returns the bank accounts of the user, oldest first.
+func (u *BankAccount) GetByUserID(userID string, txs ...*db.Transaction) ([]entity.BankAccount, error) {
+	return u.filter(func(account entity.BankAccount) bool {
+		return account.UserID == userID
+	}, txs...)
+}
+
+func (u *BankAccount) filter(f func(entity.BankAccount) bool, txs ...*db.Transaction) ([]entity.BankAccount, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := t.Filter(func(v any) bool {
+		return f(v.(entity.BankAccount))
+	})
+
+	converted := []entity.BankAccount{}
+	for _, v := range filtered {
+		converted = append(converted, v.(entity.BankAccount))
+	}
+
+	sort.Slice(converted, func(i, j int) bool {
+		return converted[i].CreatedAt.Before(converted[j].CreatedAt)
+	})
+
+	return converted, nil
+}
+
+func (u *BankAccount) table(txs ...*db.Transaction) (*db.Table, error) {
+	// if there is open transactions
+	// then the db will use transactions instead of default db connections
+	if len(txs) > 0 {
+		return txs[0].GetTable("bank_accounts")
+	}
+	return u.db.GetTable("bank_accounts")
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"sort"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+type Batch struct {
+	db *db.Instance
+}
+
+func NewBatch(db *db.Instance) *Batch {
+	return &Batch{
+		db: db,
+	}
+}
+
+func (u *Batch) FindById(id string, txs ...*db.Transaction) (entity.Batch, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return entity.Batch{}, err
+	}
+
+	v, err := t.FindByID(id)
+	if err != nil {
+		return entity.Batch{}, err
+	}
+
+	return v.(entity.Batch), nil
+}
+
+func (u *Batch) Put(batch entity.Batch, txs ...*db.Transaction) error {
+	t, err := u.table(txs...)
+	if err != nil {
+		return err
+	}
+
+	return t.ReplaceOrStore(batch.ID, batch)
+}
+
+// GetByUserID returns the batches the user paid out, oldest first.
+func (u *Batch) GetByUserID(userID string, txs ...*db.Transaction) ([]entity.Batch, error) {
+	return u.filter(func(batch entity.Batch) bool {
+		return batch.UserID == userID
+	}, txs...)
+}
+
+func (u *Batch) filter(f func(entity.Batch) bool, txs ...*db.Transaction) ([]entity.Batch, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := t.Filter(func(v any) bool {
+		return f(v.(entity.Batch))
+	})
+
+	converted := []entity.Batch{}
+	for _, v := range filtered {
+		converted = append(converted, v.(entity.Batch))
+	}
+
+	sort.Slice(converted, func(i, j int) bool {
+		return converted[i].CreatedAt.Before(converted[j].CreatedAt)
+	})
+
+	return converted, nil
+}
+
+func (u *Batch) table(txs ...*db.Transaction) (*db.Table, error) {
+	// if there is open transactions
+	// then the db will use transactions instead of default db connections
+	if len(txs) > 0 {
+		return txs[0].GetTable("batches")
+	}
+	return u.db.GetTable("batches")
+}
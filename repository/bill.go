@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"sort"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+type Bill struct {
+	db *db.Instance
+}
+
+func NewBill(db *db.Instance) *Bill {
+	return &Bill{
+		db: db,
+	}
+}
+
+func (u *Bill) FindById(id string, txs ...*db.Transaction) (entity.Bill, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return entity.Bill{}, err
+	}
+
+	v, err := t.FindByID(id)
+	if err != nil {
+		return entity.Bill{}, err
+	}
+
+	return v.(entity.Bill), nil
+}
+
+func (u *Bill) Put(bill entity.Bill, txs ...*db.Transaction) error {
+	t, err := u.table(txs...)
+	if err != nil {
+		return err
+	}
+
+	return t.ReplaceOrStore(bill.ID, bill)
+}
+
+// GetByUserID returns the bills the user organizes or has a share in,
+// oldest first.
+func (u *Bill) GetByUserID(userID string, txs ...*db.Transaction) ([]entity.Bill, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := t.Filter(func(v any) bool {
+		return v.(entity.Bill).HasParticipant(userID)
+	})
+
+	converted := []entity.Bill{}
+	for _, v := range filtered {
+		converted = append(converted, v.(entity.Bill))
+	}
+
+	sort.Slice(converted, func(i, j int) bool {
+		return converted[i].CreatedAt.Before(converted[j].CreatedAt)
+	})
+
+	return converted, nil
+}
+
+func (u *Bill) table(txs ...*db.Transaction) (*db.Table, error) {
+	// if there is open transactions
+	// then the db will use transactions instead of default db connections
+	if len(txs) > 0 {
+		return txs[0].GetTable("bills")
+	}
+	return u.db.GetTable("bills")
+}
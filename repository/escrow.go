@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"sort"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+type Escrow struct {
+	db *db.Instance
+}
+
+func NewEscrow(db *db.Instance) *Escrow {
+	return &Escrow{
+		db: db,
+	}
+}
+
+func (u *Escrow) FindById(id string, txs ...*db.Transaction) (entity.Escrow, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return entity.Escrow{}, err
+	}
+
+	v, err := t.FindByID(id)
+	if err != nil {
+		return entity.Escrow{}, err
+	}
+
+	return v.(entity.Escrow), nil
+}
+
+func (u *Escrow) Put(escrow entity.Escrow, txs ...*db.Transaction) error {
+	t, err := u.table(txs...)
+	if err != nil {
+		return err
+	}
+
+	return t.ReplaceOrStore(escrow.ID, escrow)
+}
+
+// GetByUserID returns the escrows the user buys or sells in, oldest first.
+func (u *Escrow) GetByUserID(userID string, txs ...*db.Transaction) ([]entity.Escrow, error) {
+	return u.filter(func(escrow entity.Escrow) bool {
+		return escrow.IsParty(userID)
+	}, txs...)
+}
+
+// GetByStatus returns the escrows with the status, oldest first.
+func (u *Escrow) GetByStatus(status entity.EscrowStatus, txs ...*db.Transaction) ([]entity.Escrow, error) {
+	return u.filter(func(escrow entity.Escrow) bool {
+		return escrow.Status == status
+	}, txs...)
+}
+
+// GetDue returns the held escrows whose release time passed at the given
+// time.
+func (u *Escrow) GetDue(now time.Time, txs ...*db.Transaction) ([]entity.Escrow, error) {
+	return u.filter(func(escrow entity.Escrow) bool {
+		return escrow.Status == entity.EscrowStatusHeld && !escrow.ReleaseAt.After(now)
+	}, txs...)
+}
+
+func (u *Escrow) filter(f func(entity.Escrow) bool, txs ...*db.Transaction) ([]entity.Escrow, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := t.Filter(func(v any) bool {
+		return f(v.(entity.Escrow))
+	})
+
+	converted := []entity.Escrow{}
+	for _, v := range filtered {
+		converted = append(converted, v.(entity.Escrow))
+	}
+
+	sort.Slice(converted, func(i, j int) bool {
+		return converted[i].CreatedAt.Before(converted[j].CreatedAt)
+	})
+
+	return converted, nil
+}
+
+func (u *Escrow) table(txs ...*db.Transaction) (*db.Table, error) {
+	// if there is open transactions
+	// then the db will use transactions instead of default db connections
+	if len(txs) > 0 {
+		return txs[0].GetTable("escrows")
+	}
+	return u.db.GetTable("escrows")
+}
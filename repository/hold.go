@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+type Hold struct {
+	db *db.Instance
+}
+
+func NewHold(db *db.Instance) *Hold {
+	return &Hold{
+		db: db,
+	}
+}
+
+func (u *Hold) FindById(id string, txs ...*db.Transaction) (entity.Hold, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return entity.Hold{}, err
+	}
+
+	v, err := t.FindByID(id)
+	if err != nil {
+		return entity.Hold{}, err
+	}
+
+	return v.(entity.Hold), nil
+}
+
+func (u *Hold) Put(hold entity.Hold, txs ...*db.Transaction) error {
+	t, err := u.table(txs...)
+	if err != nil {
+		return err
+	}
+
+	return t.ReplaceOrStore(hold.ID, hold)
+}
+
+// GetExpired returns the active holds that expired at the given time, holds
+// without an expiry never do.
+func (u *Hold) GetExpired(now time.Time, txs ...*db.Transaction) ([]entity.Hold, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := t.Filter(func(v any) bool {
+		hold := v.(entity.Hold)
+		return hold.Status == entity.HoldStatusActive && !hold.ExpiresAt.IsZero() && !hold.ExpiresAt.After(now)
+	})
+
+	converted := []entity.Hold{}
+	for _, v := range filtered {
+		converted = append(converted, v.(entity.Hold))
+	}
+
+	return converted, nil
+}
+
+func (u *Hold) table(txs ...*db.Transaction) (*db.Table, error) {
+	// if there is open transactions
+	// then the db will use transactions instead of default db connections
+	if len(txs) > 0 {
+		return txs[0].GetTable("holds")
+	}
+	return u.db.GetTable("holds")
+}
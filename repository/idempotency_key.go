@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+type IdempotencyKey struct {
+	db *db.Instance
+}
+
+func NewIdempotencyKey(db *db.Instance) *IdempotencyKey {
+	return &IdempotencyKey{
+		db: db,
+	}
+}
+
+func (u *IdempotencyKey) FindByKey(userID, key string, txs ...*db.Transaction) (entity.IdempotencyKey, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return entity.IdempotencyKey{}, err
+	}
+
+	v, err := t.FindByID(entity.IdempotencyKeyID(userID, key))
+	if err != nil {
+		return entity.IdempotencyKey{}, err
+	}
+
+	return v.(entity.IdempotencyKey), nil
+}
+
+func (u *IdempotencyKey) Put(idempotencyKey entity.IdempotencyKey, txs ...*db.Transaction) error {
+	t, err := u.table(txs...)
+	if err != nil {
+		return err
+	}
+
+	return t.ReplaceOrStore(idempotencyKey.ID, idempotencyKey)
+}
+
+func (u *IdempotencyKey) table(txs ...*db.Transaction) (*db.Table, error) {
+	// if there is open transactions
+	// then the db will use transactions instead of default db connections
+	if len(txs) > 0 {
+		return txs[0].GetTable("idempotency_keys")
+	}
+	return u.db.GetTable("idempotency_keys")
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+type LedgerEntry struct {
+	db *db.Instance
+}
+
+func NewLedgerEntry(db *db.Instance) *LedgerEntry {
+	return &LedgerEntry{
+		db: db,
+	}
+}
+
+func (u *LedgerEntry) Put(entry entity.LedgerEntry, txs ...*db.Transaction) error {
+	t, err := u.table(txs...)
+	if err != nil {
+		return err
+	}
+
+	return t.ReplaceOrStore(entry.ID, entry)
+}
+
+func (u *LedgerEntry) GetByAccountID(accountID string, txs ...*db.Transaction) ([]entity.LedgerEntry, error) {
+	return u.filter(func(entry entity.LedgerEntry) bool {
+		return entry.AccountID == accountID
+	}, txs...)
+}
+
+func (u *LedgerEntry) GetByTransactionID(transactionID string, txs ...*db.Transaction) ([]entity.LedgerEntry, error) {
+	return u.filter(func(entry entity.LedgerEntry) bool {
+		return entry.TransactionID == transactionID
+	}, txs...)
+}
+
+func (u *LedgerEntry) All(txs ...*db.Transaction) ([]entity.LedgerEntry, error) {
+	return u.filter(func(entry entity.LedgerEntry) bool {
+		return true
+	}, txs...)
+}
+
+func (u *LedgerEntry) filter(f func(entity.LedgerEntry) bool, txs ...*db.Transaction) ([]entity.LedgerEntry, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := t.Filter(func(v any) bool {
+		return f(v.(entity.LedgerEntry))
+	})
+
+	converted := []entity.LedgerEntry{}
+	for _, v := range filtered {
+		converted = append(converted, v.(entity.LedgerEntry))
+	}
+
+	return converted, nil
+}
+
+func (u *LedgerEntry) table(txs ...*db.Transaction) (*db.Table, error) {
+	// if there is open transactions
+	// then the db will use transactions instead of default db connections
+	if len(txs) > 0 {
+		return txs[0].GetTable("ledger_entries")
+	}
+	return u.db.GetTable("ledger_entries")
+}
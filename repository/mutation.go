@@ -35,9 +35,7 @@ func (u *Mutation) Put(mutation entity.Mutation, txs ...*db.Transaction) error {
 		return err
 	}
 
-	_ = t.ReplaceOrStore(mutation.ID, mutation)
-
-	return nil
+	return t.ReplaceOrStore(mutation.ID, mutation)
 }
 
 func (u *Mutation) GetByUserID(userID string, txs ...*db.Transaction) ([]entity.Mutation, error) {
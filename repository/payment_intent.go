@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"sort"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+type PaymentIntent struct {
+	db *db.Instance
+}
+
+func NewPaymentIntent(db *db.Instance) *PaymentIntent {
+	return &PaymentIntent{
+		db: db,
+	}
+}
+
+func (u *PaymentIntent) FindById(id string, txs ...*db.Transaction) (entity.PaymentIntent, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return entity.PaymentIntent{}, err
+	}
+
+	v, err := t.FindByID(id)
+	if err != nil {
+		return entity.PaymentIntent{}, err
+	}
+
+	return v.(entity.PaymentIntent), nil
+}
+
+func (u *PaymentIntent) Put(intent entity.PaymentIntent, txs ...*db.Transaction) error {
+	t, err := u.table(txs...)
+	if err != nil {
+		return err
+	}
+
+	return t.ReplaceOrStore(intent.ID, intent)
+}
+
+// GetByMerchantID returns the intents of the merchant with the status, all
+// of them when status is empty, oldest first.
+func (u *PaymentIntent) GetByMerchantID(merchantID string, status entity.PaymentIntentStatus, txs ...*db.Transaction) ([]entity.PaymentIntent, error) {
+	return u.filter(func(intent entity.PaymentIntent) bool {
+		return intent.MerchantID == merchantID && (status == "" || intent.Status == status)
+	}, txs...)
+}
+
+// GetExpired returns the pending intents that expired at the given time.
+func (u *PaymentIntent) GetExpired(now time.Time, txs ...*db.Transaction) ([]entity.PaymentIntent, error) {
+	return u.filter(func(intent entity.PaymentIntent) bool {
+		return intent.Status == entity.PaymentIntentStatusPending && !intent.ExpiresAt.After(now)
+	}, txs...)
+}
+
+// GetCallbackQueued returns the paid intents whose merchant is still to be
+// told.
+func (u *PaymentIntent) GetCallbackQueued(txs ...*db.Transaction) ([]entity.PaymentIntent, error) {
+	return u.filter(func(intent entity.PaymentIntent) bool {
+		return !intent.NextCallbackAt.IsZero()
+	}, txs...)
+}
+
+func (u *PaymentIntent) filter(f func(entity.PaymentIntent) bool, txs ...*db.Transaction) ([]entity.PaymentIntent, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := t.Filter(func(v any) bool {
+		return f(v.(entity.PaymentIntent))
+	})
+
+	converted := []entity.PaymentIntent{}
+	for _, v := range filtered {
+		converted = append(converted, v.(entity.PaymentIntent))
+	}
+
+	sort.Slice(converted, func(i, j int) bool {
+		return converted[i].CreatedAt.Before(converted[j].CreatedAt)
+	})
+
+	return converted, nil
+}
+
+func (u *PaymentIntent) table(txs ...*db.Transaction) (*db.Table, error) {
+	// if there is open transactions
+	// then the db will use transactions instead of default db connections
+	if len(txs) > 0 {
+		return txs[0].GetTable("payment_intents")
+	}
+	return u.db.GetTable("payment_intents")
+}
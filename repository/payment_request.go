@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"sort"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+type PaymentRequest struct {
+	db *db.Instance
+}
+
+func NewPaymentRequest(db *db.Instance) *PaymentRequest {
+	return &PaymentRequest{
+		db: db,
+	}
+}
+
+func (u *PaymentRequest) FindById(id string, txs ...*db.Transaction) (entity.PaymentRequest, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return entity.PaymentRequest{}, err
+	}
+
+	v, err := t.FindByID(id)
+	if err != nil {
+		return entity.PaymentRequest{}, err
+	}
+
+	return v.(entity.PaymentRequest), nil
+}
+
+func (u *PaymentRequest) Put(request entity.PaymentRequest, txs ...*db.Transaction) error {
+	t, err := u.table(txs...)
+	if err != nil {
+		return err
+	}
+
+	return t.ReplaceOrStore(request.ID, request)
+}
+
+// GetByPayerID returns the requests the user was asked to pay with the
+// status, all of them when status is empty, oldest first.
+func (u *PaymentRequest) GetByPayerID(payerID string, status entity.PaymentRequestStatus, txs ...*db.Transaction) ([]entity.PaymentRequest, error) {
+	return u.filter(func(request entity.PaymentRequest) bool {
+		return request.PayerID == payerID && (status == "" || request.Status == status)
+	}, txs...)
+}
+
+// GetByRequesterID returns the requests the user sent with the status, all
+// of them when status is empty, oldest first.
+func (u *PaymentRequest) GetByRequesterID(requesterID string, status entity.PaymentRequestStatus, txs ...*db.Transaction) ([]entity.PaymentRequest, error) {
+	return u.filter(func(request entity.PaymentRequest) bool {
+		return request.RequesterID == requesterID && (status == "" || request.Status == status)
+	}, txs...)
+}
+
+// GetExpired returns the pending requests that expired at the given time.
+func (u *PaymentRequest) GetExpired(now time.Time, txs ...*db.Transaction) ([]entity.PaymentRequest, error) {
+	return u.filter(func(request entity.PaymentRequest) bool {
+		return request.Status == entity.PaymentRequestStatusPending && !request.ExpiresAt.After(now)
+	}, txs...)
+}
+
+func (u *PaymentRequest) filter(f func(entity.PaymentRequest) bool, txs ...*db.Transaction) ([]entity.PaymentRequest, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := t.Filter(func(v any) bool {
+		return f(v.(entity.PaymentRequest))
+	})
+
+	converted := []entity.PaymentRequest{}
+	for _, v := range filtered {
+		converted = append(converted, v.(entity.PaymentRequest))
+	}
+
+	sort.Slice(converted, func(i, j int) bool {
+		return converted[i].CreatedAt.Before(converted[j].CreatedAt)
+	})
+
+	return converted, nil
+}
+
+func (u *PaymentRequest) table(txs ...*db.Transaction) (*db.Table, error) {
+	// if there is open transactions
+	// then the db will use transactions instead of default db connections
+	if len(txs) > 0 {
+		return txs[0].GetTable("payment_requests")
+	}
+	return u.db.GetTable("payment_requests")
+}
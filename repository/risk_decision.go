@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"sort"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+type RiskDecision struct {
+	db *db.Instance
+}
+
+func NewRiskDecision(db *db.Instance) *RiskDecision {
+	return &RiskDecision{
+		db: db,
+	}
+}
+
+func (u *RiskDecision) Put(decision entity.RiskDecision, txs ...*db.Transaction) error {
+	t, err := u.table(txs...)
+	if err != nil {
+		return err
+	}
+
+	return t.ReplaceOrStore(decision.ID, decision)
+}
+
+// GetByUserID returns the decisions on the user's transfers, oldest first.
+func (u *RiskDecision) GetByUserID(userID string, txs ...*db.Transaction) ([]entity.RiskDecision, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := t.Filter(func(v any) bool {
+		return v.(entity.RiskDecision).UserID == userID
+	})
+
+	converted := []entity.RiskDecision{}
+	for _, v := range filtered {
+		converted = append(converted, v.(entity.RiskDecision))
+	}
+
+	sort.Slice(converted, func(i, j int) bool {
+		return converted[i].CreatedAt.Before(converted[j].CreatedAt)
+	})
+
+	return converted, nil
+}
+
+func (u *RiskDecision) table(txs ...*db.Transaction) (*db.Table, error) {
+	// if there is open transactions
+	// then the db will use transactions instead of default db connections
+	if len(txs) > 0 {
+		return txs[0].GetTable("risk_decisions")
+	}
+	return u.db.GetTable("risk_decisions")
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"sort"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+type RiskReview struct {
+	db *db.Instance
+}
+
+func NewRiskReview(db *db.Instance) *RiskReview {
+	return &RiskReview{
+		db: db,
+	}
+}
+
+func (u *RiskReview) FindById(id string, txs ...*db.Transaction) (entity.RiskReview, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return entity.RiskReview{}, err
+	}
+
+	v, err := t.FindByID(id)
+	if err != nil {
+		return entity.RiskReview{}, err
+	}
+
+	return v.(entity.RiskReview), nil
+}
+
+func (u *RiskReview) Put(review entity.RiskReview, txs ...*db.Transaction) error {
+	t, err := u.table(txs...)
+	if err != nil {
+		return err
+	}
+
+	return t.ReplaceOrStore(review.ID, review)
+}
+
+// FindByIdempotencyKey returns the review of the request the user sent with
+// the Idempotency-Key.
+func (u *RiskReview) FindByIdempotencyKey(userID, key string, txs ...*db.Transaction) (entity.RiskReview, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return entity.RiskReview{}, err
+	}
+
+	filtered := t.Filter(func(v any) bool {
+		review := v.(entity.RiskReview)
+		return review.UserID == userID && review.IdempotencyKey == key
+	})
+	if len(filtered) == 0 {
+		return entity.RiskReview{}, db.ErrNotFound
+	}
+
+	return filtered[0].(entity.RiskReview), nil
+}
+
+// GetByStatus returns the reviews with the status, all of them when status
+// is empty, oldest first.
+func (u *RiskReview) GetByStatus(status entity.RiskReviewStatus, txs ...*db.Transaction) ([]entity.RiskReview, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := t.Filter(func(v any) bool {
+		return status == "" || v.(entity.RiskReview).Status == status
+	})
+
+	converted := []entity.RiskReview{}
+	for _, v := range filtered {
+		converted = append(converted, v.(entity.RiskReview))
+	}
+
+	sort.Slice(converted, func(i, j int) bool {
+		return converted[i].CreatedAt.Before(converted[j].CreatedAt)
+	})
+
+	return converted, nil
+}
+
+func (u *RiskReview) table(txs ...*db.Transaction) (*db.Table, error) {
+	// if there is open transactions
+	// then the db will use transactions instead of default db connections
+	if len(txs) > 0 {
+		return txs[0].GetTable("risk_reviews")
+	}
+	return u.db.GetTable("risk_reviews")
+}
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"sort"
+	"time"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+type ScheduledTransfer struct {
+	db *db.Instance
+}
+
+func NewScheduledTransfer(db *db.Instance) *ScheduledTransfer {
+	return &ScheduledTransfer{
+		db: db,
+	}
+}
+
+func (u *ScheduledTransfer) FindById(id string, txs ...*db.Transaction) (entity.ScheduledTransfer, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return entity.ScheduledTransfer{}, err
+	}
+
+	v, err := t.FindByID(id)
+	if err != nil {
+		return entity.ScheduledTransfer{}, err
+	}
+
+	return v.(entity.ScheduledTransfer), nil
+}
+
+func (u *ScheduledTransfer) Put(scheduledTransfer entity.ScheduledTransfer, txs ...*db.Transaction) error {
+	t, err := u.table(txs...)
+	if err != nil {
+		return err
+	}
+
+	return t.ReplaceOrStore(scheduledTransfer.ID, scheduledTransfer)
+}
+
+// GetByUserID returns the scheduled transfers the user pays, oldest first.
+func (u *ScheduledTransfer) GetByUserID(userID string, txs ...*db.Transaction) ([]entity.ScheduledTransfer, error) {
+	converted, err := u.filter(func(s entity.ScheduledTransfer) bool {
+		return s.UserID == userID
+	}, txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(converted, func(i, j int) bool {
+		return converted[i].CreatedAt.Before(converted[j].CreatedAt)
+	})
+	return converted, nil
+}
+
+// GetDue returns the active scheduled transfers to run at the given time,
+// the most overdue first.
+func (u *ScheduledTransfer) GetDue(now time.Time, txs ...*db.Transaction) ([]entity.ScheduledTransfer, error) {
+	converted, err := u.filter(func(s entity.ScheduledTransfer) bool {
+		return s.Status == entity.ScheduledTransferStatusActive && !s.NextRunAt.After(now)
+	}, txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(converted, func(i, j int) bool {
+		return converted[i].NextRunAt.Before(converted[j].NextRunAt)
+	})
+	return converted, nil
+}
+
+func (u *ScheduledTransfer) filter(f func(entity.ScheduledTransfer) bool, txs ...*db.Transaction) ([]entity.ScheduledTransfer, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := t.Filter(func(v any) bool {
+		return f(v.(entity.ScheduledTransfer))
+	})
+
+	converted := []entity.ScheduledTransfer{}
+	for _, v := range filtered {
+		converted = append(converted, v.(entity.ScheduledTransfer))
+	}
+
+	return converted, nil
+}
+
+func (u *ScheduledTransfer) table(txs ...*db.Transaction) (*db.Table, error) {
+	// if there is open transactions
+	// then the db will use transactions instead of default db connections
+	if len(txs) > 0 {
+		return txs[0].GetTable("scheduled_transfers")
+	}
+	return u.db.GetTable("scheduled_transfers")
+}
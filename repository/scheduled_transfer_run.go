@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"sort"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+type ScheduledTransferRun struct {
+	db *db.Instance
+}
+
+func NewScheduledTransferRun(db *db.Instance) *ScheduledTransferRun {
+	return &ScheduledTransferRun{
+		db: db,
+	}
+}
+
+func (u *ScheduledTransferRun) FindById(id string, txs ...*db.Transaction) (entity.ScheduledTransferRun, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return entity.ScheduledTransferRun{}, err
+	}
+
+	v, err := t.FindByID(id)
+	if err != nil {
+		return entity.ScheduledTransferRun{}, err
+	}
+
+	return v.(entity.ScheduledTransferRun), nil
+}
+
+func (u *ScheduledTransferRun) Put(run entity.ScheduledTransferRun, txs ...*db.Transaction) error {
+	t, err := u.table(txs...)
+	if err != nil {
+		return err
+	}
+
+	return t.ReplaceOrStore(run.ID, run)
+}
+
+// GetByScheduledTransferID returns the runs of a scheduled transfer, oldest
+// first.
+func (u *ScheduledTransferRun) GetByScheduledTransferID(scheduledTransferID string, txs ...*db.Transaction) ([]entity.ScheduledTransferRun, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := t.Filter(func(v any) bool {
+		return v.(entity.ScheduledTransferRun).ScheduledTransferID == scheduledTransferID
+	})
+
+	converted := []entity.ScheduledTransferRun{}
+	for _, v := range filtered {
+		converted = append(converted, v.(entity.ScheduledTransferRun))
+	}
+
+	sort.Slice(converted, func(i, j int) bool {
+		if converted[i].RanAt.Equal(converted[j].RanAt) {
+			return converted[i].Attempt < converted[j].Attempt
+		}
+		return converted[i].RanAt.Before(converted[j].RanAt)
+	})
+
+	return converted, nil
+}
+
+func (u *ScheduledTransferRun) table(txs ...*db.Transaction) (*db.Table, error) {
+	// if there is open transactions
+	// then the db will use transactions instead of default db connections
+	if len(txs) > 0 {
+		return txs[0].GetTable("scheduled_transfer_runs")
+	}
+	return u.db.GetTable("scheduled_transfer_runs")
+}
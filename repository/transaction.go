@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+type Transaction struct {
+	db *db.Instance
+}
+
+func NewTransaction(db *db.Instance) *Transaction {
+	return &Transaction{
+		db: db,
+	}
+}
+
+func (u *Transaction) FindById(id string, txs ...*db.Transaction) (entity.Transaction, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	v, err := t.FindByID(id)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	return v.(entity.Transaction), nil
+}
+
+func (u *Transaction) Put(transaction entity.Transaction, txs ...*db.Transaction) error {
+	t, err := u.table(txs...)
+	if err != nil {
+		return err
+	}
+
+	return t.ReplaceOrStore(transaction.ID, transaction)
+}
+
+func (u *Transaction) GetByUserID(userID string, txs ...*db.Transaction) ([]entity.Transaction, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := t.Filter(func(v any) bool {
+		trx := v.(entity.Transaction)
+		return trx.SourceUserID == userID || trx.TargetUserID == userID
+	})
+
+	if len(filtered) == 0 {
+		return nil, db.ErrNotFound
+	}
+
+	converted := []entity.Transaction{}
+
+	for _, v := range filtered {
+		converted = append(converted, v.(entity.Transaction))
+	}
+
+	return converted, nil
+}
+
+func (u *Transaction) table(txs ...*db.Transaction) (*db.Table, error) {
+	// if there is open transactions
+	// then the db will use transactions instead of default db connections
+	if len(txs) > 0 {
+		return txs[0].GetTable("transactions")
+	}
+	return u.db.GetTable("transactions")
+}
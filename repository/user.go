@@ -51,9 +51,7 @@ func (u *User) Put(user entity.User, txs ...*db.Transaction) error {
 		return err
 	}
 
-	_ = t.ReplaceOrStore(user.ID, user)
-
-	return nil
+	return t.ReplaceOrStore(user.ID, user)
 }
 
 func (u *User) table(txs ...*db.Transaction) (*db.Table, error) {
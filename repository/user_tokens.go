@@ -35,7 +35,25 @@ func (u *UserToken) Put(userToken entity.UserToken, txs ...*db.Transaction) erro
 		return err
 	}
 
-	_ = t.ReplaceOrStore(userToken.Token, userToken)
+	return t.ReplaceOrStore(userToken.Token, userToken)
+}
+
+// DeleteByUserID revokes every token of the user.
+func (u *UserToken) DeleteByUserID(userID string, txs ...*db.Transaction) error {
+	t, err := u.table(txs...)
+	if err != nil {
+		return err
+	}
+
+	tokens := t.Filter(func(v any) bool {
+		return v.(entity.UserToken).UserID == userID
+	})
+
+	for _, v := range tokens {
+		if err := t.Delete(v.(entity.UserToken).Token); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
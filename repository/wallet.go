@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"sort"
+
 	"github.com/insomnius/wallet-event-loop/db"
 	"github.com/insomnius/wallet-event-loop/entity"
 )
@@ -29,21 +31,89 @@ func (u *Wallet) FindById(id string, txs ...*db.Transaction) (entity.Wallet, err
 	return v.(entity.Wallet), nil
 }
 
+// FindByUserID returns the main pocket of the user in the default currency,
+// or their first wallet when they don't have one.
 func (u *Wallet) FindByUserID(userID string, txs ...*db.Transaction) (entity.Wallet, error) {
-	t, err := u.table(txs...)
+	wallets, err := u.GetByUserID(userID, txs...)
+	if err != nil {
+		return entity.Wallet{}, err
+	}
+
+	if wallet, ok := primary(wallets, entity.DefaultCurrency); ok {
+		return wallet, nil
+	}
+
+	return wallets[0], nil
+}
+
+// FindByUserIDAndCurrency returns the main pocket of the user in a currency.
+func (u *Wallet) FindByUserIDAndCurrency(userID, currency string, txs ...*db.Transaction) (entity.Wallet, error) {
+	wallets, err := u.GetByUserID(userID, txs...)
 	if err != nil {
 		return entity.Wallet{}, err
 	}
 
+	if wallet, ok := primary(wallets, currency); ok {
+		return wallet, nil
+	}
+
+	return entity.Wallet{}, db.ErrNotFound
+}
+
+func (u *Wallet) FindByUserIDAndName(userID, name, currency string, txs ...*db.Transaction) (entity.Wallet, error) {
+	wallets, err := u.GetByUserID(userID, txs...)
+	if err != nil {
+		return entity.Wallet{}, err
+	}
+
+	for _, wallet := range wallets {
+		if wallet.InCurrency(currency) && (wallet.Name == name || (wallet.IsPrimary() && name == entity.PrimaryWalletName)) {
+			return wallet, nil
+		}
+	}
+
+	return entity.Wallet{}, db.ErrNotFound
+}
+
+// GetByUserID returns every wallet of the user ordered by ID.
+func (u *Wallet) GetByUserID(userID string, txs ...*db.Transaction) ([]entity.Wallet, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return nil, err
+	}
+
 	filtered := t.Filter(func(v any) bool {
 		return v.(entity.Wallet).UserID == userID
 	})
 
 	if len(filtered) == 0 {
-		return entity.Wallet{}, db.ErrNotFound
+		return nil, db.ErrNotFound
+	}
+
+	converted := make([]entity.Wallet, 0, len(filtered))
+	for _, v := range filtered {
+		converted = append(converted, v.(entity.Wallet))
+	}
+
+	sort.Slice(converted, func(i, j int) bool {
+		return converted[i].ID < converted[j].ID
+	})
+
+	return converted, nil
+}
+
+func (u *Wallet) All(txs ...*db.Transaction) ([]entity.Wallet, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	converted := []entity.Wallet{}
+	for _, v := range t.Filter(func(v any) bool { return true }) {
+		converted = append(converted, v.(entity.Wallet))
 	}
 
-	return filtered[0].(entity.Wallet), nil
+	return converted, nil
 }
 
 func (u *Wallet) Put(wallet entity.Wallet, txs ...*db.Transaction) error {
@@ -52,9 +122,17 @@ func (u *Wallet) Put(wallet entity.Wallet, txs ...*db.Transaction) error {
 		return err
 	}
 
-	_ = t.ReplaceOrStore(wallet.ID, wallet)
+	return t.ReplaceOrStore(wallet.ID, wallet)
+}
 
-	return nil
+// primary picks the main pocket of a currency out of wallets ordered by ID.
+func primary(wallets []entity.Wallet, currency string) (entity.Wallet, bool) {
+	for _, wallet := range wallets {
+		if wallet.InCurrency(currency) && wallet.IsPrimary() {
+			return wallet, true
+		}
+	}
+	return entity.Wallet{}, false
 }
 
 func (u *Wallet) table(txs ...*db.Transaction) (*db.Table, error) {
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"sort"
+
+	"github.com/insomnius/wallet-event-loop/db"
+	"github.com/insomnius/wallet-event-loop/entity"
+)
+
+type Withdrawal struct {
+	db *db.Instance
+}
+
+func NewWithdrawal(db *db.Instance) *Withdrawal {
+	return &Withdrawal{
+		db: db,
+	}
+}
+
+func (u *Withdrawal) FindById(id string, txs ...*db.Transaction) (entity.Withdrawal, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return entity.Withdrawal{}, err
+	}
+
+	v, err := t.FindByID(id)
+	if err != nil {
+		return entity.Withdrawal{}, err
+	}
+
+	return v.(entity.Withdrawal), nil
+}
+
+func (u *Withdrawal) Put(withdrawal entity.Withdrawal, txs ...*db.Transaction) error {
+	t, err := u.table(txs...)
+	if err != nil {
+		return err
+	}
+
+	return t.ReplaceOrStore(withdrawal.ID, withdrawal)
+}
+
+// GetByUserID returns the withdrawals of the user, oldest first, only the
+// ones in status unless it is empty.
+func (u *Withdrawal) GetByUserID(userID string, status entity.WithdrawalStatus, txs ...*db.Transaction) ([]entity.Withdrawal, error) {
+	return u.filter(func(withdrawal entity.Withdrawal) bool {
+		return withdrawal.UserID == userID && (status == "" || withdrawal.Status == status)
+	}, txs...)
+}
+
+// GetUnsubmitted returns the pending withdrawals the payout provider hasn't
+// accepted yet, oldest first.
+func (u *Withdrawal) GetUnsubmitted(txs ...*db.Transaction) ([]entity.Withdrawal, error) {
+	return u.filter(func(withdrawal entity.Withdrawal) bool {
+		return withdrawal.Status == entity.WithdrawalStatusPending && withdrawal.ProviderReference == ""
+	}, txs...)
+}
+
+func (u *Withdrawal) filter(f func(entity.Withdrawal) bool, txs ...*db.Transaction) ([]entity.Withdrawal, error) {
+	t, err := u.table(txs...)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := t.Filter(func(v any) bool {
+		return f(v.(entity.Withdrawal))
+	})
+
+	converted := []entity.Withdrawal{}
+	for _, v := range filtered {
+		converted = append(converted, v.(entity.Withdrawal))
+	}
+
+	sort.Slice(converted, func(i, j int) bool {
+		return converted[i].CreatedAt.Before(converted[j].CreatedAt)
+	})
+
+	return converted, nil
+}
+
+func (u *Withdrawal) table(txs ...*db.Transaction) (*db.Table, error) {
+	// if there is open transactions
+	// then the db will use transactions instead of default db connections
+	if len(txs) > 0 {
+		return txs[0].GetTable("withdrawals")
+	}
+	return u.db.GetTable("withdrawals")
+}